@@ -0,0 +1,42 @@
+// Archive info related functions.
+//
+// The archive info describes the stack, the format version (model.Version) and the encryption of the archive,
+// the cache-pull step checks it before extracting the archive.
+package cachecommon
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// EncodeArchiveInfo returns the archive info file content.
+func EncodeArchiveInfo(info model.ArchiveInfo) ([]byte, error) {
+	stackData, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data, error: %s", err)
+	}
+	return stackData, nil
+}
+
+// ReadArchiveInfo reads the archive info stored in the previous cache archive, nil if it does not exist.
+func ReadArchiveInfo(pth string) (*model.ArchiveInfo, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	data, err := ReadMetadataFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var info model.ArchiveInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,31 @@
+package cachecommon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadArchiveInfo(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "stack_info")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	pth := filepath.Join(tmpDir, "archive_info.json")
+	info, err := ReadArchiveInfo(pth)
+	require.NoError(t, err)
+	require.Nil(t, info)
+
+	want := model.ArchiveInfo{Version: model.Version, StackID: "osx-xcode-15", OS: "darwin", Architecture: "arm64"}
+	data, err := EncodeArchiveInfo(want)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(pth, data, 0600))
+
+	info, err = ReadArchiveInfo(pth)
+	require.NoError(t, err)
+	require.Equal(t, &want, info)
+}
@@ -0,0 +1,38 @@
+// Cache meta related functions.
+//
+// The cache meta records when each cached file first entered the cache and when it was last read.
+package cachecommon
+
+import (
+	"sort"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// ReadCacheMeta reads the cache meta of the previous cache from pth if exists.
+func ReadCacheMeta(pth string) (model.CacheMeta, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	var meta model.CacheMeta
+	if err := DecodeMetadataFile(pth, &meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// EncodeCacheMeta returns the gzip compressed json of the cache meta.
+func EncodeCacheMeta(meta model.CacheMeta) ([]byte, error) {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return EncodeCompressedJSONObject(keys, func(key string) interface{} { return meta[key] })
+}
@@ -0,0 +1,43 @@
+// Package cachecommon implements the cache archive format shared by the cache-push and the cache-pull steps:
+// the names of the metadata files stored in the archive, the cache descriptor, the archive info,
+// the cache meta, the metadata checksums and the cached file checksums.
+//
+// The cache-pull step restores the metadata files to their paths stored in the archive,
+// so the cache-push step of the next build finds them at the same paths.
+// Both steps should import this package, so the format (and model.Version) can not drift between them.
+package cachecommon
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The names of the metadata files stored in the cache archive, relative to the working directory.
+const (
+	CacheInfoFileName         = "cache-info.json"
+	CacheLayersFileName       = "cache-layers.json"
+	ContentHashesFileName     = "cache-content-hashes.json"
+	CacheMetaFileName         = "cache-meta.json"
+	MetadataChecksumsFileName = "cache-metadata-checksums.json"
+	FileChecksumsFileName     = "cache-file-checksums.json"
+	StackVersionsFileName     = "archive_info.json"
+)
+
+// GroupPath adds the group's name to the file name of the path, the path is returned as is for the default (unnamed) group.
+func GroupPath(pth, group string) string {
+	if group == "" {
+		return pth
+	}
+	ext := filepath.Ext(pth)
+	return strings.TrimSuffix(pth, ext) + "-" + group + ext
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,13 @@
+package cachecommon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GroupPath(t *testing.T) {
+	require.Equal(t, "/tmp/cache-info.json", GroupPath("/tmp/cache-info.json", ""))
+	require.Equal(t, "/tmp/cache-info-gradle.json", GroupPath("/tmp/cache-info.json", "gradle"))
+	require.Equal(t, "/tmp/cache-gradle", GroupPath("/tmp/cache", "gradle"))
+}
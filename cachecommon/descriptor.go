@@ -0,0 +1,96 @@
+// Cache descriptor related functions.
+//
+// The cache descriptor is stored with canonical keys (forward slashes, paths under the home directory relative to `~`),
+// so a descriptor generated on macOS can be compared to one generated on Linux and vice versa.
+// Descriptors stored before the normalization contain native, absolute paths, these are read unchanged.
+package cachecommon
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// HomeRoot is the canonical form of the home directory in the descriptor keys.
+const HomeRoot = "~"
+
+// CanonicalDescriptorPath returns the platform independent form of a descriptor key.
+func CanonicalDescriptorPath(pth, home string) string {
+	pth = filepath.ToSlash(pth)
+	home = strings.TrimSuffix(filepath.ToSlash(home), "/")
+	if home == "" {
+		return pth
+	}
+
+	if pth == home {
+		return HomeRoot
+	}
+	if strings.HasPrefix(pth, home+"/") {
+		return HomeRoot + strings.TrimPrefix(pth, home)
+	}
+	return pth
+}
+
+// LocalDescriptorPath returns the native path of a canonical descriptor key.
+func LocalDescriptorPath(pth, home string) string {
+	if pth == HomeRoot {
+		return home
+	}
+	if strings.HasPrefix(pth, HomeRoot+"/") && home != "" {
+		return filepath.Join(home, filepath.FromSlash(strings.TrimPrefix(pth, HomeRoot+"/")))
+	}
+	return filepath.FromSlash(pth)
+}
+
+// CanonicalDescriptor returns the descriptor with canonical keys.
+func CanonicalDescriptor(descriptor map[string]string, home string) map[string]string {
+	canonical := make(map[string]string, len(descriptor))
+	for pth, indicator := range descriptor {
+		canonical[CanonicalDescriptorPath(pth, home)] = indicator
+	}
+	return canonical
+}
+
+// EncodeCanonicalDescriptor returns the gzip compressed json of the descriptor with canonical keys.
+// Unlike CanonicalDescriptor, it does not copy the descriptor, only its keys, which matters for huge caches.
+func EncodeCanonicalDescriptor(descriptor map[string]string, home string) ([]byte, error) {
+	type entry struct {
+		canonical, local string
+	}
+	entries := make([]entry, 0, len(descriptor))
+	for pth := range descriptor {
+		entries = append(entries, entry{canonical: CanonicalDescriptorPath(pth, home), local: pth})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].canonical < entries[j].canonical })
+
+	return encodeCompressedJSONMembers(len(entries), func(i int) (string, interface{}) {
+		return entries[i].canonical, descriptor[entries[i].local]
+	})
+}
+
+// LocalDescriptor returns the descriptor with native keys.
+func LocalDescriptor(descriptor map[string]string, home string) map[string]string {
+	local := make(map[string]string, len(descriptor))
+	for pth, indicator := range descriptor {
+		local[LocalDescriptorPath(pth, home)] = indicator
+	}
+	return local
+}
+
+// ReadDescriptor reads the cache descriptor from pth if exists, nil if it does not.
+func ReadDescriptor(pth string) (map[string]string, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	var previousFilePathMap map[string]string
+	if err := DecodeMetadataFile(pth, &previousFilePathMap); err != nil {
+		return nil, err
+	}
+
+	return previousFilePathMap, nil
+}
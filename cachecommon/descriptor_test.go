@@ -0,0 +1,137 @@
+package cachecommon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_CanonicalDescriptorPath(t *testing.T) {
+	tests := []struct {
+		name string
+		pth  string
+		home string
+		want string
+	}{
+		{name: "under home", pth: "/Users/vagrant/.gradle/caches", home: "/Users/vagrant", want: "~/.gradle/caches"},
+		{name: "home with trailing separator", pth: "/root/.gradle", home: "/root/", want: "~/.gradle"},
+		{name: "home itself", pth: "/home/user", home: "/home/user", want: "~"},
+		{name: "home prefix of another dir", pth: "/home/user2/file", home: "/home/user", want: "/home/user2/file"},
+		{name: "outside home", pth: "/tmp/file", home: "/home/user", want: "/tmp/file"},
+		{name: "no home", pth: "/tmp/file", home: "", want: "/tmp/file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalDescriptorPath(tt.pth, tt.home); got != tt.want {
+				t.Errorf("CanonicalDescriptorPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_LocalDescriptor(t *testing.T) {
+	macOS := map[string]string{
+		"/Users/vagrant/.gradle/caches/file": "indicator",
+		"/tmp/file":                          "-",
+	}
+	canonical := CanonicalDescriptor(macOS, "/Users/vagrant")
+
+	want := map[string]string{
+		"/home/linux/.gradle/caches/file": "indicator",
+		"/tmp/file":                       "-",
+	}
+	if got := LocalDescriptor(canonical, "/home/linux"); !reflect.DeepEqual(got, want) {
+		t.Errorf("LocalDescriptor() = %v, want %v", got, want)
+	}
+
+	// descriptors stored before the normalization are read unchanged
+	if got := LocalDescriptor(want, "/home/linux"); !reflect.DeepEqual(got, want) {
+		t.Errorf("LocalDescriptor() = %v, want %v", got, want)
+	}
+}
+
+func Test_EncodeCanonicalDescriptor(t *testing.T) {
+	macOS := map[string]string{
+		"/Users/vagrant/.gradle/caches/file": "indicator",
+		"/Users/vagrant":                     "-",
+		"/tmp/file":                          "-",
+	}
+	data, err := EncodeCanonicalDescriptor(macOS, "/Users/vagrant")
+	if err != nil {
+		t.Fatalf("EncodeCanonicalDescriptor() error = %s", err)
+	}
+
+	canonical := CanonicalDescriptor(macOS, "/Users/vagrant")
+	want, err := EncodeCompressedJSONObject(sortedKeys(canonical), func(key string) interface{} { return canonical[key] })
+	if err != nil {
+		t.Fatalf("EncodeCompressedJSONObject() error = %s", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("EncodeCanonicalDescriptor() differs from the encoded CanonicalDescriptor()")
+	}
+}
+
+func Test_ReadDescriptor(t *testing.T) {
+	desired := map[string]string{
+		"path/to/cache": "indicator",
+	}
+
+	content, err := json.Marshal(desired)
+	if err != nil {
+		t.Fatalf("Failed to create descriptor: %s", err)
+	}
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+	pth := filepath.Join(tmpDir, "descriptor")
+
+	if err := ioutil.WriteFile(pth, content, 0600); err != nil {
+		t.Fatalf("failed to write descriptor: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		pth        string
+		descriptor map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "No path provided",
+			pth:        "",
+			descriptor: nil,
+			wantErr:    true,
+		},
+		{
+			name:       "Not existing path",
+			pth:        "/not/existing/path",
+			descriptor: nil,
+			wantErr:    false,
+		},
+		{
+			name:       "Existing descriptor",
+			pth:        pth,
+			descriptor: desired,
+			wantErr:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			descriptor, err := ReadDescriptor(tt.pth)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadDescriptor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(descriptor, tt.descriptor) {
+				t.Errorf("ReadDescriptor() descriptor = %v, want %v", descriptor, tt.descriptor)
+			}
+		})
+	}
+}
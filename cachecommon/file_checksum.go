@@ -0,0 +1,46 @@
+// Cached file checksum related functions.
+//
+// The CRC-32C checksum of every archived regular file is stored in the FileChecksumsFileName file,
+// written after the cached files. Its own sha256 checksum is part of the metadata checksums,
+// so a reader can detect a corrupted file (or a corrupted checksums file) while unpacking the cache.
+package cachecommon
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewFileHash returns the hash computing the checksum of a cached file.
+func NewFileHash() hash.Hash32 {
+	return crc32.New(castagnoliTable)
+}
+
+// FileChecksum returns the hex encoded checksum of the content written into the hash.
+func FileChecksum(h hash.Hash32) string {
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// EncodeFileChecksums returns the gzip compressed json of the file checksums, keyed by the archived paths.
+func EncodeFileChecksums(checksums map[string]string) ([]byte, error) {
+	return EncodeCompressedJSONObject(sortedKeys(checksums), func(key string) interface{} { return checksums[key] })
+}
+
+// ReadFileChecksums reads the file checksums from pth if exists, archives of previous step versions have none.
+func ReadFileChecksums(pth string) (map[string]string, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	var checksums map[string]string
+	if err := DecodeMetadataFile(pth, &checksums); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
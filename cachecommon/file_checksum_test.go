@@ -0,0 +1,41 @@
+package cachecommon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileChecksum(t *testing.T) {
+	h := NewFileHash()
+	_, err := h.Write([]byte("123456789"))
+	require.NoError(t, err)
+	// the CRC-32C check value
+	require.Equal(t, "e3069283", FileChecksum(h))
+
+	require.Equal(t, "00000000", FileChecksum(NewFileHash()))
+}
+
+func TestFileChecksums(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "file_checksum")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	pth := filepath.Join(tmpDir, FileChecksumsFileName)
+	checksums, err := ReadFileChecksums(pth)
+	require.NoError(t, err)
+	require.Nil(t, checksums)
+
+	want := map[string]string{"/cache/b": "e3069283", "/cache/a": "00000000"}
+	data, err := EncodeFileChecksums(want)
+	require.NoError(t, err)
+	require.Equal(t, GzipMagic, data[:2])
+	require.NoError(t, ioutil.WriteFile(pth, data, 0600))
+
+	checksums, err = ReadFileChecksums(pth)
+	require.NoError(t, err)
+	require.Equal(t, want, checksums)
+}
@@ -0,0 +1,140 @@
+// Archive format version related functions.
+//
+// The archive info records the format version of the writer (model.Version), the features a reader needs to support
+// to restore the archive, and the minimum reader version supporting all of them.
+// A reader can restore an archive written by a newer writer as long as it supports the required features,
+// so a version bump only breaks the older readers if the archive actually uses the new features.
+// Archives written before the negotiation have no min reader version, their writer version is required instead.
+package cachecommon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// Feature is an archive format feature a reader needs to support to restore the archive.
+type Feature string
+
+const (
+	// FeatureCompressedMetadata: the metadata files are gzip compressed json.
+	FeatureCompressedMetadata Feature = "compressed-metadata"
+	// FeatureCanonicalPaths: the cache descriptor keys are canonical (home relative, forward slashes).
+	FeatureCanonicalPaths Feature = "canonical-paths"
+	// FeatureEncryption: the archive stream is encrypted.
+	FeatureEncryption Feature = "encryption"
+	// FeatureLayers: the archive is a layer of a layered cache.
+	FeatureLayers Feature = "layers"
+)
+
+// featureVersions maps the features to the format version introducing them.
+var featureVersions = map[Feature]uint64{
+	FeatureCompressedMetadata: 2,
+	FeatureCanonicalPaths:     2,
+	FeatureEncryption:         2,
+	FeatureLayers:             2,
+}
+
+// featureHints tells how to push an archive without the feature, if it is optional.
+var featureHints = map[Feature]string{
+	FeatureEncryption: "remove the encryption_key input of the cache-push step",
+	FeatureLayers:     "set the archive_mode input of the cache-push step to full",
+}
+
+// SupportedFeatures returns the features supported by the current format version.
+func SupportedFeatures() []Feature {
+	features := make([]Feature, 0, len(featureVersions))
+	for feature := range featureVersions {
+		features = append(features, feature)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i] < features[j] })
+	return features
+}
+
+// MinReaderVersion returns the lowest format version supporting all the features, 1 if there is none.
+func MinReaderVersion(features []Feature) uint64 {
+	version := uint64(1)
+	for _, feature := range features {
+		if v, ok := featureVersions[feature]; ok && v > version {
+			version = v
+		}
+	}
+	return version
+}
+
+// NewArchiveInfo returns the archive info of an archive using the given features, written by the current format version.
+func NewArchiveInfo(features []Feature) model.ArchiveInfo {
+	info := model.ArchiveInfo{Version: model.Version, MinReaderVersion: MinReaderVersion(features)}
+	for _, feature := range features {
+		info.Features = append(info.Features, string(feature))
+	}
+	return info
+}
+
+// FormatError is returned if a reader can not restore an archive.
+type FormatError struct {
+	// Reader is the name of the step reading the archive.
+	Reader string
+	// Version is the min reader version of the archive.
+	Version uint64
+	// ReaderVersion is the format version of the reader.
+	ReaderVersion uint64
+	// Unsupported are the required features the reader does not support.
+	Unsupported []string
+}
+
+// Error returns the reason and the possible ways to resolve it.
+func (e *FormatError) Error() string {
+	var hints []string
+	if e.Version > e.ReaderVersion {
+		hints = append(hints, "update the "+e.Reader+" step")
+	}
+	for _, feature := range e.Unsupported {
+		if hint, ok := featureHints[Feature(feature)]; ok {
+			hints = append(hints, hint)
+		}
+	}
+
+	var reasons []string
+	if e.Version > e.ReaderVersion {
+		reasons = append(reasons, fmt.Sprintf("the archive requires format version %d, the reader supports version %d", e.Version, e.ReaderVersion))
+	}
+	if len(e.Unsupported) > 0 {
+		reasons = append(reasons, fmt.Sprintf("the archive requires unsupported features: %s", strings.Join(e.Unsupported, ", ")))
+	}
+	if len(hints) == 0 {
+		hints = append(hints, "update the "+e.Reader+" step")
+	}
+	return fmt.Sprintf("%s (to resolve it: %s)", strings.Join(reasons, ", "), strings.Join(hints, " or "))
+}
+
+// CheckCompatibility returns a FormatError if the reader (step) of the given format version, supporting the given features,
+// can not restore the archive. A nil archive info (no previous archive) is compatible.
+func CheckCompatibility(info *model.ArchiveInfo, reader string, readerVersion uint64, supported []Feature) error {
+	if info == nil {
+		return nil
+	}
+
+	version := info.MinReaderVersion
+	if version == 0 {
+		version = info.Version
+	}
+
+	supportedSet := map[string]bool{}
+	for _, feature := range supported {
+		supportedSet[string(feature)] = true
+	}
+	var unsupported []string
+	for _, feature := range info.Features {
+		if !supportedSet[feature] {
+			unsupported = append(unsupported, feature)
+		}
+	}
+
+	if version <= readerVersion && len(unsupported) == 0 {
+		return nil
+	}
+	return &FormatError{Reader: reader, Version: version, ReaderVersion: readerVersion, Unsupported: unsupported}
+}
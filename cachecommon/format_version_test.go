@@ -0,0 +1,40 @@
+package cachecommon
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MinReaderVersion(t *testing.T) {
+	require.Equal(t, uint64(1), MinReaderVersion(nil))
+	require.Equal(t, uint64(2), MinReaderVersion([]Feature{FeatureCompressedMetadata}))
+	require.Equal(t, uint64(1), MinReaderVersion([]Feature{"unknown"}))
+}
+
+func Test_NewArchiveInfo(t *testing.T) {
+	info := NewArchiveInfo([]Feature{FeatureCompressedMetadata, FeatureEncryption})
+	require.Equal(t, model.ArchiveInfo{Version: model.Version, MinReaderVersion: 2, Features: []string{"compressed-metadata", "encryption"}}, info)
+	require.NoError(t, CheckCompatibility(&info, "cache-pull", model.Version, SupportedFeatures()))
+}
+
+func Test_CheckCompatibility(t *testing.T) {
+	require.NoError(t, CheckCompatibility(nil, "cache-pull", 1, nil))
+
+	// archives written before the negotiation require their writer version
+	require.NoError(t, CheckCompatibility(&model.ArchiveInfo{Version: 2}, "cache-pull", 2, nil))
+	require.EqualError(t, CheckCompatibility(&model.ArchiveInfo{Version: 2}, "cache-pull", 1, nil),
+		"the archive requires format version 2, the reader supports version 1 (to resolve it: update the cache-pull step)")
+
+	// a newer writer's archive is readable if it does not use the new features
+	require.NoError(t, CheckCompatibility(&model.ArchiveInfo{Version: 3, MinReaderVersion: 2}, "cache-pull", 2, nil))
+
+	err := CheckCompatibility(&model.ArchiveInfo{Version: 3, MinReaderVersion: 3, Features: []string{"encryption", "layers", "future"}}, "cache-push", 2, []Feature{FeatureEncryption})
+	require.EqualError(t, err, "the archive requires format version 3, the reader supports version 2, the archive requires unsupported features: layers, future "+
+		"(to resolve it: update the cache-push step or set the archive_mode input of the cache-push step to full)")
+
+	var formatErr *FormatError
+	require.ErrorAs(t, err, &formatErr)
+	require.Equal(t, []string{"layers", "future"}, formatErr.Unsupported)
+}
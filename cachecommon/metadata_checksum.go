@@ -0,0 +1,26 @@
+// Archive metadata checksum related functions.
+//
+// The sha256 checksum of every metadata file written into the archive is stored as the last archive entry
+// (in the MetadataChecksumsFileName file), so the metadata can be verified after a round trip.
+package cachecommon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MetadataChecksum returns the hex encoded sha256 checksum of a metadata file's content.
+func MetadataChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// EncodeMetadataChecksums returns the checksums file content.
+func EncodeMetadataChecksums(checksums map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteJSONObject(&buf, sortedKeys(checksums), func(key string) interface{} { return checksums[key] }); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
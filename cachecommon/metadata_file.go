@@ -0,0 +1,129 @@
+// Compressed metadata file related functions.
+//
+// The cache descriptor and the cache meta of huge caches can be tens of megabytes,
+// so they are streamed as gzip compressed json into the archive.
+// Readers detect the compression, so plain json files of previous caches are still readable.
+package cachecommon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// GzipMagic is the header of the gzip compressed metadata files.
+var GzipMagic = []byte{0x1f, 0x8b}
+
+// EncodeCompressedJSONObject writes the json object with the given members, in the order of the keys, into a gzip compressed buffer.
+// The members are marshaled one by one (in the format of json.MarshalIndent with single space indentation),
+// so the whole json is never held in memory.
+func EncodeCompressedJSONObject(keys []string, value func(key string) interface{}) ([]byte, error) {
+	return encodeCompressedJSONMembers(len(keys), func(i int) (string, interface{}) { return keys[i], value(keys[i]) })
+}
+
+// WriteJSONObject writes the json object with the given members, in the order of the keys.
+func WriteJSONObject(w io.Writer, keys []string, value func(key string) interface{}) error {
+	return writeJSONMembers(w, len(keys), func(i int) (string, interface{}) { return keys[i], value(keys[i]) })
+}
+
+// encodeCompressedJSONMembers writes the json object with the n members returned by member into a gzip compressed buffer.
+func encodeCompressedJSONMembers(n int, member func(i int) (string, interface{})) ([]byte, error) {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+
+	if err := writeJSONMembers(gzipWriter, n, member); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSONMembers writes the json object with the n members returned by member, in order.
+func writeJSONMembers(w io.Writer, n int, member func(i int) (string, interface{})) error {
+	if n == 0 {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+
+	if _, err := io.WriteString(w, "{\n"); err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		key, value := member(i)
+		keyData, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		valueData, err := json.MarshalIndent(value, " ", " ")
+		if err != nil {
+			return err
+		}
+
+		separator := ",\n"
+		if i == n-1 {
+			separator = "\n"
+		}
+
+		if _, err := io.WriteString(w, " "+string(keyData)+": "+string(valueData)+separator); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "}")
+	return err
+}
+
+// DecodeMetadataFile decodes the json content of a metadata file into v, decompressing it if it is gzip compressed.
+// The file is decoded as a stream, so the decompressed json is never held in memory.
+func DecodeMetadataFile(pth string, v interface{}) error {
+	file, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close metadata file (%s): %s", pth, err)
+		}
+	}()
+
+	reader := bufio.NewReader(file)
+	var content io.Reader = reader
+	if magic, err := reader.Peek(len(GzipMagic)); err == nil && bytes.Equal(magic, GzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		content = gzipReader
+	}
+
+	return json.NewDecoder(content).Decode(v)
+}
+
+// ReadMetadataFile reads a metadata file, decompressing it if it is gzip compressed.
+func ReadMetadataFile(pth string) ([]byte, error) {
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, GzipMagic) {
+		return data, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(gzipReader)
+}
@@ -0,0 +1,90 @@
+package cachecommon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_encodeCompressedJSONObject(t *testing.T) {
+	for _, descriptor := range []map[string]string{
+		{},
+		{"b/path": "indicator", "a/path": "-", "c/<html>": "\"quoted\""},
+	} {
+		data, err := EncodeCompressedJSONObject(sortedKeys(descriptor), func(key string) interface{} { return descriptor[key] })
+		require.NoError(t, err)
+
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		got, err := ioutil.ReadAll(gzipReader)
+		require.NoError(t, err)
+
+		want, err := json.MarshalIndent(descriptor, "", " ")
+		require.NoError(t, err)
+		require.Equal(t, string(want), string(got))
+	}
+
+	meta := model.CacheMeta{"b": {FirstSeenAt: 2}, "a": {FirstSeenBuild: "slug", FirstSeenAt: 1, AccessTime: 3}}
+	data, err := EncodeCacheMeta(meta)
+	require.NoError(t, err)
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	pth := filepath.Join(tmpDir, "cache-meta.json")
+	require.NoError(t, ioutil.WriteFile(pth, data, 0600))
+
+	got, err := ReadCacheMeta(pth)
+	require.NoError(t, err)
+	require.Equal(t, meta, got)
+}
+
+func Test_readMetadataFile(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	plain := filepath.Join(tmpDir, "plain.json")
+	require.NoError(t, ioutil.WriteFile(plain, []byte(`{"a": "b"}`), 0600))
+
+	compressed := filepath.Join(tmpDir, "compressed.json")
+	data, err := EncodeCompressedJSONObject([]string{"a"}, func(string) interface{} { return "b" })
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(compressed, data, 0600))
+
+	for _, pth := range []string{plain, compressed} {
+		descriptor, err := ReadDescriptor(pth)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"a": "b"}, descriptor)
+	}
+}
+
+func Test_DecodeMetadataFile(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	compressed := filepath.Join(tmpDir, "compressed.json")
+	data, err := EncodeCompressedJSONObject([]string{"a", "b"}, func(key string) interface{} { return key + "-value" })
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(compressed, data, 0600))
+
+	var got map[string]string
+	require.NoError(t, DecodeMetadataFile(compressed, &got))
+	require.Equal(t, map[string]string{"a": "a-value", "b": "b-value"}, got)
+
+	// files shorter than the gzip header are read as plain json
+	short := filepath.Join(tmpDir, "short.json")
+	require.NoError(t, ioutil.WriteFile(short, []byte("1"), 0600))
+	var number int
+	require.NoError(t, DecodeMetadataFile(short, &number))
+	require.Equal(t, 1, number)
+
+	corrupted := filepath.Join(tmpDir, "corrupted.json")
+	require.NoError(t, ioutil.WriteFile(corrupted, data[:len(data)/2], 0600))
+	require.Error(t, DecodeMetadataFile(corrupted, &got))
+}
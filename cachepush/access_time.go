@@ -0,0 +1,46 @@
+// Access time provider related functions.
+//
+// The eviction of the cached files depends on when they were last read.
+// If the file system does not update the access times (noatime), the configured fallback is used instead:
+// the mod time, the status change time or no eviction at all.
+package cachepush
+
+import (
+	"os"
+	"time"
+)
+
+// atimeFallback is the last use time source used if the access times are not updated.
+type atimeFallback string
+
+const (
+	atimeFallbackMtime   atimeFallback = "mtime"
+	atimeFallbackCtime   atimeFallback = "ctime"
+	atimeFallbackDisable atimeFallback = "disable"
+)
+
+// accessTimeProvider returns when the file was last used, false if it is unknown.
+type accessTimeProvider func(info os.FileInfo) (time.Time, bool)
+
+// newAccessTimeProvider returns the provider of the last use times for the atime mode,
+// nil if the eviction needs to be disabled.
+func newAccessTimeProvider(mode atimeMode, fallback atimeFallback) accessTimeProvider {
+	if mode != atimeNone {
+		return accessTimeOf
+	}
+
+	switch fallback {
+	case atimeFallbackMtime:
+		return func(info os.FileInfo) (time.Time, bool) { return info.ModTime(), true }
+	case atimeFallbackCtime:
+		return func(info os.FileInfo) (time.Time, bool) { return fileChangeTime(info), true }
+	default:
+		return nil
+	}
+}
+
+// accessTimeOf returns the file's access time if it was read after it was written.
+func accessTimeOf(info os.FileInfo) (time.Time, bool) {
+	accessTime := fileAccessTime(info)
+	return accessTime, accessTime.After(info.ModTime())
+}
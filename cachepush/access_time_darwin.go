@@ -0,0 +1,28 @@
+//go:build darwin
+// +build darwin
+
+package cachepush
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the file's last access time, or its mod time if not available.
+func fileAccessTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
+}
+
+// fileChangeTime returns the file's last status change time, or its mod time if not available.
+func fileChangeTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec)
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package cachepush
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the file's last access time, or its mod time if not available.
+func fileAccessTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+}
+
+// fileChangeTime returns the file's last status change time, or its mod time if not available.
+func fileChangeTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+}
@@ -0,0 +1,58 @@
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newAccessTimeProvider(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	pth := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{pth: "content"})
+	mtime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	atime := mtime.Add(time.Hour)
+	require.NoError(t, os.Chtimes(pth, atime, mtime))
+
+	info, err := os.Lstat(pth)
+	require.NoError(t, err)
+
+	for _, mode := range []atimeMode{atimeStrict, atimeRelative} {
+		lastUsed, ok := newAccessTimeProvider(mode, atimeFallbackDisable)(info)
+		require.True(t, ok)
+		require.True(t, lastUsed.Equal(atime), mode)
+	}
+
+	require.Nil(t, newAccessTimeProvider(atimeNone, atimeFallbackDisable))
+	require.Nil(t, newAccessTimeProvider(atimeNone, ""))
+
+	lastUsed, ok := newAccessTimeProvider(atimeNone, atimeFallbackMtime)(info)
+	require.True(t, ok)
+	require.True(t, lastUsed.Equal(mtime))
+
+	// the status change time is updated by the chtimes call
+	lastUsed, ok = newAccessTimeProvider(atimeNone, atimeFallbackCtime)(info)
+	require.True(t, ok)
+	require.True(t, lastUsed.After(atime))
+}
+
+func Test_accessTimeOf(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	pth := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{pth: "content"})
+	written := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(pth, written, written))
+
+	info, err := os.Lstat(pth)
+	require.NoError(t, err)
+	_, ok := accessTimeOf(info)
+	require.False(t, ok)
+}
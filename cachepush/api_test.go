@@ -0,0 +1,77 @@
+package cachepush_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachepush"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRun_embedded pushes a cache through the exported API only, like other tools embedding the cache push do.
+func TestRun_embedded(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("embedded")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	cached := filepath.Join(tmpDir, "cached")
+	require.NoError(t, os.MkdirAll(cached, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cached, "file"), []byte("content"), 0644))
+
+	store := filepath.Join(tmpDir, "store")
+	report, err := cachepush.Run(context.Background(), cachepush.Config{
+		Paths:               cached,
+		CacheAPIURL:         "file://" + store + "/",
+		FingerprintMethodID: "file-content-hash",
+		CompressArchive:     "false",
+	})
+	require.NoError(t, err)
+	require.True(t, report.Pushed)
+	require.FileExists(t, filepath.Join(store, "cache", "latest.tar"))
+	require.Equal(t, cachepush.ExitCodeSuccess, cachepush.ExitCodeOf(err))
+}
+
+// TestRun_embeddedConfig checks that the embedded push uses its config's environment
+// and leaves the settings of the embedding process as they were.
+func TestRun_embeddedConfig(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("embedded")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	cached := filepath.Join(tmpDir, "cached")
+	require.NoError(t, os.MkdirAll(cached, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cached, "file"), []byte("content"), 0644))
+
+	var logs bytes.Buffer
+	cachepush.SetLogOutput(&logs)
+	defer cachepush.SetLogOutput(os.Stdout)
+
+	defer debug.SetGCPercent(debug.SetGCPercent(80))
+
+	workDir := filepath.Join(tmpDir, "work")
+	report, err := cachepush.Run(context.Background(), cachepush.Config{
+		Paths:               cached,
+		CacheAPIURL:         "file://" + filepath.Join(tmpDir, "store") + "/",
+		CacheAPIHeaders:     "X-Tenant-Secret: embedded-tenant-secret",
+		FingerprintMethodID: "file-content-hash",
+		CompressArchive:     "false",
+		WorkingDirectory:    workDir,
+		MaxMemoryMB:         256,
+	})
+	require.NoError(t, err)
+	require.True(t, report.Pushed)
+	require.FileExists(t, filepath.Join(workDir, "cache-archive.tar"))
+
+	require.Equal(t, 80, debug.SetGCPercent(80))
+
+	log.Printf("tenant: embedded-tenant-secret")
+	require.NotContains(t, logs.String(), "embedded-tenant-secret")
+	require.Contains(t, logs.String(), "tenant: [REDACTED]")
+}
@@ -0,0 +1,15 @@
+//go:build darwin
+// +build darwin
+
+package cachepush
+
+import (
+	"syscall"
+)
+
+// isTranslated reports whether the process runs translated by Rosetta on Apple Silicon.
+// The sysctl does not exist on Intel Macs.
+func isTranslated() bool {
+	translated, err := syscall.SysctlUint32("sysctl.proc_translated")
+	return err == nil && translated == 1
+}
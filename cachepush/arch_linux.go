@@ -0,0 +1,9 @@
+//go:build linux
+// +build linux
+
+package cachepush
+
+// isTranslated reports whether the process runs translated by Rosetta on Apple Silicon, it never does on Linux.
+func isTranslated() bool {
+	return false
+}
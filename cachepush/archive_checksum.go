@@ -0,0 +1,75 @@
+// Cache archive checksum related functions.
+//
+// The SHA-256 checksum of the generated archive is sent with the upload, so cache-pull can verify
+// that the downloaded archive was not truncated or corrupted. The checksum can not be stored inside the archive itself,
+// it is sent to the cache API with the archive info, signed into the S3 upload, or written next to a local archive.
+// The MD5 digest of the archive is sent as the Content-MD5 header of the upload, calculated in the same pass as the checksum.
+package cachepush
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// checksumFileExt is the extension of the checksum file written next to a local archive.
+const checksumFileExt = ".sha256"
+
+// fileSHA256 returns the hex encoded SHA-256 checksum of the file.
+func fileSHA256(pth string) (string, error) {
+	checksum, _, err := fileDigests(pth)
+	return checksum, err
+}
+
+// fileDigests returns the hex encoded SHA-256 checksum and the base64 encoded MD5 digest (the Content-MD5 header value) of the file,
+// both calculated while reading the file once.
+func fileDigests(pth string) (string, string, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	sha, md := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha, md), file); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(sha.Sum(nil)), base64.StdEncoding.EncodeToString(md.Sum(nil)), nil
+}
+
+// sectionContentMD5 returns the Content-MD5 header value of the file's content from the offset to the given size,
+// it is used when a resumed upload sends only the end of the archive.
+func sectionContentMD5(file io.ReaderAt, offset, size int64) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, offset, size-offset)); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksumFile writes the checksum next to the archive in the format of `sha256sum`,
+// so the archive can be verified by `sha256sum -c`.
+func writeChecksumFile(archivePth, checksum string) error {
+	return fileutil.WriteStringToFile(archivePth+checksumFileExt, fmt.Sprintf("%s  %s\n", checksum, filepath.Base(archivePth)))
+}
+
+// base64Checksum converts the hex encoded checksum to base64, as expected by the S3 API.
+func base64Checksum(checksum string) (string, error) {
+	b, err := hex.DecodeString(checksum)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
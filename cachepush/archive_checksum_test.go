@@ -0,0 +1,53 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fileSHA256(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	pth := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{pth: "content"})
+
+	checksum, err := fileSHA256(pth)
+	require.NoError(t, err)
+	require.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", checksum)
+
+	require.NoError(t, writeChecksumFile(pth, checksum))
+	content, err := fileutil.ReadStringFromFile(pth + checksumFileExt)
+	require.NoError(t, err)
+	require.Equal(t, checksum+"  cache.tar\n", content)
+
+	encoded, err := base64Checksum(checksum)
+	require.NoError(t, err)
+	require.Equal(t, "7XACtDnprIRfIjV9giusFERzD722AW0+yUMil7nsn3M=", encoded)
+}
+
+func Test_fileDigests(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	pth := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{pth: "content"})
+
+	checksum, contentMD5, err := fileDigests(pth)
+	require.NoError(t, err)
+	require.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", checksum)
+	require.Equal(t, "mgNkuembtIDdJeHwKEyFVQ==", contentMD5)
+
+	sectionMD5, err := sectionContentMD5(strings.NewReader("content"), 0, 7)
+	require.NoError(t, err)
+	require.Equal(t, contentMD5, sectionMD5)
+
+	sectionMD5, err = sectionContentMD5(strings.NewReader("content"), 4, 7)
+	require.NoError(t, err)
+	require.Equal(t, "ZF7HnyK+xe/pcAYdOVz3xA==", sectionMD5)
+}
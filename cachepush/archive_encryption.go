@@ -0,0 +1,326 @@
+// Cache archive encryption related functions.
+//
+// The archive stream is encrypted with AES-256-GCM in fixed size chunks, so archives of any size can be
+// encrypted and decrypted while streaming. The encrypted archive starts with a magic, the length of the plaintext header
+// and the JSON encoded plaintext header (format version, algorithm, chunk size, KDF, salt and key ID),
+// so tools and the pre-checks of the next build can read the metadata without the key.
+// The chunks are encrypted with a per archive key derived from the key and the header's random salt with HKDF-SHA256,
+// the whole header is authenticated as the associated data of every chunk, so a modified header fails the decryption.
+// Every chunk's nonce is the chunk's index and a flag marking the last chunk,
+// so reordered, duplicated or truncated chunks fail the authentication.
+package cachepush
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+const (
+	encryptionAlgorithm     = "AES-256-GCM-STREAM"
+	encryptionChunkSize     = 64 * 1024
+	encryptionKDF           = "HKDF-SHA256"
+	encryptionFormatVersion = 2
+	encryptionSaltSize      = 32
+	// maxEncryptionHeaderSize limits the header read before it is authenticated.
+	maxEncryptionHeaderSize = 4 * 1024
+	nonceSize               = 12
+)
+
+var (
+	encryptedArchiveMagic = []byte("BRCACHE-ENC\n")
+	// encryptionKeyInfo is the HKDF info of the per archive key.
+	encryptionKeyInfo = []byte("steps-cache-push archive key")
+)
+
+// encryptionHeader is the plaintext header of the encrypted archive.
+type encryptionHeader struct {
+	Version int `json:"version"`
+	model.EncryptionInfo
+	// Salt is the random salt of the per archive key's derivation.
+	Salt []byte `json:"salt"`
+}
+
+// parseEncryptionKey decodes the base64 encoded 256 bit key, returns nil if the key is empty.
+func parseEncryptionKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64 encoded: %s", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key is %d bytes long, a 32 bytes (256 bit) key is required", len(key))
+	}
+	return key, nil
+}
+
+// encryptionInfo returns the encryption metadata stored in the archive info, nil if the archive is not encrypted.
+// The key is identified by its truncated sha256 hash, so cache-pull can tell if a different key is used.
+func encryptionInfo(key []byte) *model.EncryptionInfo {
+	if key == nil {
+		return nil
+	}
+	sum := sha256.Sum256(key)
+	return &model.EncryptionInfo{
+		Algorithm: encryptionAlgorithm,
+		ChunkSize: encryptionChunkSize,
+		KDF:       encryptionKDF,
+		KeyID:     hex.EncodeToString(sum[:8]),
+	}
+}
+
+// marshalEncryptionHeader returns the encoded header: the magic, the big endian uint16 length of the JSON and the JSON.
+func marshalEncryptionHeader(header encryptionHeader) ([]byte, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxEncryptionHeaderSize {
+		return nil, fmt.Errorf("encryption header is too large: %d bytes", len(data))
+	}
+
+	encoded := append([]byte{}, encryptedArchiveMagic...)
+	encoded = append(encoded, 0, 0)
+	binary.BigEndian.PutUint16(encoded[len(encryptedArchiveMagic):], uint16(len(data)))
+	return append(encoded, data...), nil
+}
+
+// readEncryptionHeader reads the plaintext header of the encrypted archive, it does not need the key.
+// It returns the encoded header too, which is the associated data of the chunks.
+// The header is only authenticated by the chunks' decryption.
+func readEncryptionHeader(input *bufio.Reader) (encryptionHeader, []byte, error) {
+	prefix := make([]byte, len(encryptedArchiveMagic)+2)
+	if _, err := io.ReadFull(input, prefix); err != nil {
+		return encryptionHeader{}, nil, fmt.Errorf("failed to read encryption header: %s", err)
+	}
+	if !bytes.Equal(prefix[:len(encryptedArchiveMagic)], encryptedArchiveMagic) {
+		return encryptionHeader{}, nil, errors.New("archive is not encrypted")
+	}
+
+	size := int(binary.BigEndian.Uint16(prefix[len(encryptedArchiveMagic):]))
+	if size > maxEncryptionHeaderSize {
+		return encryptionHeader{}, nil, fmt.Errorf("encryption header is too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(input, data); err != nil {
+		return encryptionHeader{}, nil, fmt.Errorf("failed to read encryption header: %s", err)
+	}
+
+	var header encryptionHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return encryptionHeader{}, nil, fmt.Errorf("failed to parse encryption header: %s", err)
+	}
+	if header.Version != encryptionFormatVersion || header.Algorithm != encryptionAlgorithm || header.KDF != encryptionKDF {
+		return encryptionHeader{}, nil, fmt.Errorf("unsupported archive encryption: version %d, %s with %s", header.Version, header.Algorithm, header.KDF)
+	}
+	if header.ChunkSize <= 0 || header.ChunkSize > 16*encryptionChunkSize {
+		return encryptionHeader{}, nil, fmt.Errorf("invalid encryption chunk size: %d", header.ChunkSize)
+	}
+	return header, append(prefix, data...), nil
+}
+
+// deriveArchiveKey derives the per archive key from the key and the salt with HKDF-SHA256 (RFC 5869),
+// a single HMAC block of the expand step is enough for the 32 bytes key.
+func deriveArchiveKey(key, salt []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(key)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(encryptionKeyInfo)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func chunkNonce(index uint32, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint32(nonce[nonceSize-5:], index)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptingWriter encrypts the written data into the underlying writer.
+type encryptingWriter struct {
+	output io.WriteCloser
+	aead   cipher.AEAD
+	header []byte
+	index  uint32
+	buf    []byte
+}
+
+// encryptOutput returns the output wrapped into an encrypting writer, or the output itself if the key is nil.
+func encryptOutput(output io.WriteCloser, key []byte) (io.WriteCloser, error) {
+	if key == nil {
+		return output, nil
+	}
+	return newEncryptingWriter(output, key)
+}
+
+// newEncryptingWriter returns a writer encrypting into the output with the given key, closing it closes the output.
+func newEncryptingWriter(output io.WriteCloser, key []byte) (io.WriteCloser, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(deriveArchiveKey(key, salt))
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := marshalEncryptionHeader(encryptionHeader{Version: encryptionFormatVersion, EncryptionInfo: *encryptionInfo(key), Salt: salt})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := output.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{output: output, aead: aead, header: header, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+// Write buffers the data and encrypts the full chunks. The last chunk is only known on Close,
+// so a full chunk is kept in the buffer until more data arrives.
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == encryptionChunkSize {
+			if err := w.seal(false); err != nil {
+				return 0, err
+			}
+		}
+
+		n := encryptionChunkSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *encryptingWriter) seal(last bool) error {
+	if w.index == ^uint32(0) {
+		return errors.New("archive too large to encrypt")
+	}
+	sealed := w.aead.Seal(nil, chunkNonce(w.index, last), w.buf, w.header)
+	w.index++
+	w.buf = w.buf[:0]
+
+	_, err := w.output.Write(sealed)
+	return err
+}
+
+// Close encrypts the last chunk and closes the output.
+func (w *encryptingWriter) Close() error {
+	if err := w.seal(true); err != nil {
+		return err
+	}
+	return w.output.Close()
+}
+
+// decryptingReader decrypts an encrypted archive stream.
+type decryptingReader struct {
+	input     *bufio.Reader
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	index     uint32
+	plain     []byte
+	done      bool
+}
+
+// isEncryptedArchive reports whether the stream starts with the encrypted archive header.
+func isEncryptedArchive(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(encryptedArchiveMagic))
+	return err == nil && bytes.Equal(magic, encryptedArchiveMagic)
+}
+
+// newDecryptingReader returns a reader decrypting the encrypted archive stream with the given key.
+func newDecryptingReader(input *bufio.Reader, key []byte) (io.Reader, error) {
+	if key == nil {
+		return nil, errors.New("archive is encrypted, but no encryption key is provided")
+	}
+
+	header, encoded, err := readEncryptionHeader(input)
+	if err != nil {
+		return nil, err
+	}
+	if keyID := encryptionInfo(key).KeyID; header.KeyID != keyID {
+		return nil, fmt.Errorf("archive is encrypted with key %s, but the encryption key is %s", header.KeyID, keyID)
+	}
+
+	aead, err := newGCM(deriveArchiveKey(key, header.Salt))
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{input: input, aead: aead, header: encoded, chunkSize: header.ChunkSize}, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) open() error {
+	sealed := make([]byte, r.chunkSize+r.aead.Overhead())
+	n, err := io.ReadFull(r.input, sealed)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err == io.EOF:
+		return errors.New("encrypted archive is truncated")
+	case err != nil:
+		return err
+	default:
+		if _, err := r.input.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := r.aead.Open(nil, chunkNonce(r.index, last), sealed[:n], r.header)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt archive chunk %d (wrong key or corrupted archive): %s", r.index, err)
+	}
+	r.index++
+	r.plain = plain
+	r.done = last
+	return nil
+}
@@ -0,0 +1,151 @@
+package cachepush
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := newEncryptingWriter(nopWriteCloser{&buf}, key)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decrypt(key, encrypted []byte) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(encrypted))
+	d, err := newDecryptingReader(r, key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(d)
+}
+
+func Test_parseEncryptionKey(t *testing.T) {
+	key, err := parseEncryptionKey("")
+	require.NoError(t, err)
+	require.Nil(t, key)
+
+	key, err = parseEncryptionKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	_, err = parseEncryptionKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	require.Error(t, err)
+
+	_, err = parseEncryptionKey("not base64!")
+	require.Error(t, err)
+}
+
+func Test_archiveEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	for _, size := range []int{0, 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize} {
+		plain := bytes.Repeat([]byte{'a'}, size)
+		encrypted := encrypt(t, key, plain)
+		require.True(t, isEncryptedArchive(bufio.NewReader(bytes.NewReader(encrypted))))
+
+		decrypted, err := decrypt(key, encrypted)
+		require.NoError(t, err)
+		require.Equal(t, plain, decrypted, "size: %d", size)
+	}
+
+	encrypted := encrypt(t, key, bytes.Repeat([]byte{'a'}, 2*encryptionChunkSize+10))
+
+	_, err := decrypt(bytes.Repeat([]byte{2}, 32), encrypted)
+	require.Error(t, err, "wrong key")
+	require.Contains(t, err.Error(), "archive is encrypted with key")
+
+	// truncated at a chunk boundary: the last remaining chunk was not sealed as the last one
+	header, encodedHeader, err := readEncryptionHeader(bufio.NewReader(bytes.NewReader(encrypted)))
+	require.NoError(t, err)
+	truncated := encrypted[:len(encodedHeader)+header.ChunkSize+16]
+	_, err = decrypt(key, truncated)
+	require.Error(t, err, "truncated")
+
+	_, err = decrypt(nil, encrypted)
+	require.Error(t, err, "no key")
+}
+
+func Test_readEncryptionHeader(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	encrypted := encrypt(t, key, []byte("content"))
+
+	header, encoded, err := readEncryptionHeader(bufio.NewReader(bytes.NewReader(encrypted)))
+	require.NoError(t, err)
+	require.Equal(t, encryptionFormatVersion, header.Version)
+	require.Equal(t, *encryptionInfo(key), header.EncryptionInfo)
+	require.Len(t, header.Salt, encryptionSaltSize)
+	require.Equal(t, encrypted[:len(encoded)], encoded)
+
+	t.Log("every archive has its own salt")
+	{
+		other, _, err := readEncryptionHeader(bufio.NewReader(bytes.NewReader(encrypt(t, key, []byte("content")))))
+		require.NoError(t, err)
+		require.NotEqual(t, header.Salt, other.Salt)
+	}
+
+	t.Log("the header is authenticated")
+	{
+		data := append([]byte("{ "), encoded[len(encryptedArchiveMagic)+2+1:]...)
+		tampered := append(append([]byte{}, encryptedArchiveMagic...), 0, byte(len(data)))
+		tampered = append(append(tampered, data...), encrypted[len(encoded):]...)
+
+		_, _, err := readEncryptionHeader(bufio.NewReader(bytes.NewReader(tampered)))
+		require.NoError(t, err)
+		_, err = decrypt(key, tampered)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to decrypt archive chunk 0")
+	}
+
+	t.Log("unsupported version")
+	{
+		unsupported, err := marshalEncryptionHeader(encryptionHeader{Version: encryptionFormatVersion + 1, EncryptionInfo: header.EncryptionInfo, Salt: header.Salt})
+		require.NoError(t, err)
+		_, _, err = readEncryptionHeader(bufio.NewReader(bytes.NewReader(unsupported)))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported archive encryption: version 3")
+	}
+}
+
+func Test_createArchive_encrypted(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	fileToArchive := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{fileToArchive: "content"})
+
+	key := bytes.Repeat([]byte{1}, 32)
+	pth := filepath.Join(tmpDir, "cache.tar")
+	_, err = createArchive(context.Background(), pth, true, memoryBudget{}, archiveContent{
+		stackData:           []byte("stack"),
+		pathToIndicatorPath: map[string]string{fileToArchive: ""},
+		descriptor:          map[string]string{fileToArchive: "-"},
+		encryptionKey:       key,
+	})
+	require.NoError(t, err)
+
+	mismatching, err := simulatePull(pth, []string{fileToArchive}, newHashCache(), key)
+	require.NoError(t, err)
+	require.Empty(t, mismatching)
+
+	_, err = simulatePull(pth, []string{fileToArchive}, newHashCache(), nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,227 @@
+// Transient filesystem error handling during cache archive creation.
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+)
+
+const (
+	maxEntryRetryCount = 3
+	entryRetryWait     = 500 * time.Millisecond
+)
+
+// transientErrnos are the errors which are expected to go away if the operation is repeated
+// (for example a network filesystem hiccup).
+var transientErrnos = []syscall.Errno{
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.EAGAIN,
+	syscall.EINTR,
+	syscall.ETIMEDOUT,
+	syscall.EBUSY,
+}
+
+// transientArchiveError is returned when a transient error happened after the entry's header
+// was written into the archive, so the whole archive needs to be recreated.
+type transientArchiveError struct {
+	path string
+	err  error
+}
+
+func (e *transientArchiveError) Error() string {
+	return fmt.Sprintf("transient error while archiving %s: %s", e.path, e.err)
+}
+
+func (e *transientArchiveError) Unwrap() error {
+	return e.err
+}
+
+// isTransientFSError reports whether the error is a filesystem error worth retrying.
+func isTransientFSError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+
+	for _, transient := range transientErrnos {
+		if errno == transient {
+			return true
+		}
+	}
+	return false
+}
+
+func appendIfMissing(list []string, item string) []string {
+	for _, i := range list {
+		if i == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+// archiveMetadata is a generated file written into the cache archive.
+type archiveMetadata struct {
+	path string
+	data []byte
+}
+
+// archiveContent describes what gets written into a cache archive.
+type archiveContent struct {
+	stackData           []byte
+	metadata            []archiveMetadata
+	pathToIndicatorPath map[string]string
+	descriptor          map[string]string
+	// encryptionKey is the key encrypting the archive, nil if the archive is not encrypted.
+	encryptionKey []byte
+	// group is the name of the cache group, empty for the default cache.
+	group string
+	// workDir is the working directory of the metadata files, the default one if empty.
+	workDir string
+	// trailer generates a metadata file written after the cached files, if set
+	// (for example data depending on the archiving itself).
+	trailer func() (archiveMetadata, error)
+	// reproducible makes the archive of the same content identical.
+	reproducible bool
+	// archiver selects how the archive is written, archiverSettings tunes its parallelism.
+	archiver         archiverKind
+	archiverSettings archiverSettings
+}
+
+// metadataPath returns the path of the group's metadata file in the working directory, which is its name in the archive.
+func (c archiveContent) metadataPath(name string) string {
+	dir := c.workDir
+	if dir == "" {
+		dir = defaultRunEnv.workDir
+	}
+	return cachecommon.GroupPath(filepath.Join(dir, name), c.group)
+}
+
+// createArchive creates the cache archive at the given path.
+// It returns the paths which were retried due to transient filesystem errors.
+func createArchive(ctx context.Context, pth string, compress bool, budget memoryBudget, content archiveContent) (retried []string, err error) {
+	file, err := os.Create(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	output, err := encryptOutput(file, content.encryptionKey)
+	if err != nil {
+		if cerr := file.Close(); cerr != nil {
+			log.Debugf("Failed to close archive file (%s): %s", pth, cerr)
+		}
+		return nil, fmt.Errorf("failed to create archive encryption: %w", err)
+	}
+
+	archive, err := newArchive(output, compress, budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cerr := archive.output.Close(); cerr != nil {
+			log.Debugf("Failed to close archive file (%s): %s", pth, cerr)
+		}
+	}()
+
+	if err = writeArchive(ctx, archive, content); err != nil {
+		return archive.Retried(), err
+	}
+
+	if err = verifyArchiveMetadata(pth, content.metadataPath(metadataChecksumsFileName), archive.checksums, archive.fileChecksums, content.encryptionKey); err != nil {
+		return archive.Retried(), fmt.Errorf("archive metadata integrity check failed: %w", err)
+	}
+	return archive.Retried(), nil
+}
+
+// writeArchive writes the stack data as the first file,
+// followed by the additional metadata files, the files to cache, the trailer, the file checksums,
+// the cache descriptor and the metadata checksums, then closes the archive.
+// It fails before writing the cache descriptor if any of the cached files was modified while it was archived.
+func writeArchive(ctx context.Context, archive *Archive, content archiveContent) error {
+	archive.reproducible = content.reproducible
+	content.archiverSettings.apply(archive)
+	if err := archive.setCompressionLevel(archiverCompressionLevel(content.archiver)); err != nil {
+		return fmt.Errorf("failed to set archive compression level: %w", err)
+	}
+
+	// This is the first file written, to speed up reading it in subsequent builds
+	if err := archive.writeData(content.stackData, content.metadataPath(stackVersionsFileName)); err != nil {
+		return fmt.Errorf("failed to write cache info to archive, error: %w", err)
+	}
+
+	for _, metadata := range content.metadata {
+		if err := archive.writeData(metadata.data, metadata.path); err != nil {
+			return fmt.Errorf("failed to write %s to archive, error: %w", metadata.path, err)
+		}
+	}
+
+	if err := archive.Write(ctx, content.pathToIndicatorPath); err != nil {
+		return fmt.Errorf("failed to populate archive: %w", err)
+	}
+
+	if content.trailer != nil {
+		metadata, err := content.trailer()
+		if err != nil {
+			return fmt.Errorf("failed to generate archive trailer: %w", err)
+		}
+		if err := archive.writeData(metadata.data, metadata.path); err != nil {
+			return fmt.Errorf("failed to write %s to archive, error: %w", metadata.path, err)
+		}
+	}
+
+	if modified := archive.modifiedFiles(); len(modified) > 0 {
+		return &modifiedFilesError{paths: modified}
+	}
+
+	if err := archive.WriteFileChecksums(content.metadataPath(fileChecksumsFileName)); err != nil {
+		return fmt.Errorf("failed to write file checksums: %w", err)
+	}
+
+	if err := archive.WriteHeader(content.descriptor, content.metadataPath(cacheInfoFileName)); err != nil {
+		return fmt.Errorf("failed to write archive header: %w", err)
+	}
+
+	if err := archive.WriteChecksums(content.metadataPath(metadataChecksumsFileName)); err != nil {
+		return fmt.Errorf("failed to write metadata checksums: %w", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	return nil
+}
+
+// createArchiveWithRetry creates the cache archive and recreates it once
+// if a transient filesystem error made the first archive unusable.
+func createArchiveWithRetry(ctx context.Context, pth string, compress bool, budget memoryBudget, content archiveContent) ([]string, error) {
+	retried, err := createArchive(ctx, pth, compress, budget, content)
+
+	var transientErr *transientArchiveError
+	if errors.As(err, &transientErr) {
+		log.Warnf("%s", err)
+		log.Warnf("Recreating the archive...")
+
+		var retriedAgain []string
+		retriedAgain, err = createArchive(ctx, pth, compress, budget, content)
+		retried = appendIfMissing(retried, transientErr.path)
+		for _, pth := range retriedAgain {
+			retried = appendIfMissing(retried, pth)
+		}
+	}
+
+	return retried, err
+}
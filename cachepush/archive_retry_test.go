@@ -0,0 +1,84 @@
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_isTransientFSError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "nil error",
+			err:  nil,
+			want: false,
+		},
+		{
+			name: "generic error",
+			err:  errors.New("some error"),
+			want: false,
+		},
+		{
+			name: "io error",
+			err:  syscall.EIO,
+			want: true,
+		},
+		{
+			name: "wrapped stale nfs handle",
+			err:  fmt.Errorf("failed to open file, error: %w", &os.PathError{Op: "open", Path: "file", Err: syscall.ESTALE}),
+			want: true,
+		},
+		{
+			name: "wrapped not exist",
+			err:  fmt.Errorf("failed to open file, error: %w", &os.PathError{Op: "open", Path: "file", Err: syscall.ENOENT}),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientFSError(tt.err); got != tt.want {
+				t.Errorf("isTransientFSError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createArchive(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	pth := filepath.Join(tmpDir, "cache.tar")
+
+	fileToArchive := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{fileToArchive: "content"})
+
+	retried, err := createArchiveWithRetry(context.Background(), pth, false, memoryBudget{}, archiveContent{
+		stackData:           []byte("{}"),
+		pathToIndicatorPath: map[string]string{fileToArchive: ""},
+		descriptor:          map[string]string{fileToArchive: "indicator"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create archive: %s", err)
+	}
+	if len(retried) != 0 {
+		t.Errorf("want no retried files, got: %v", retried)
+	}
+
+	if _, err := createArchive(context.Background(), pth, false, memoryBudget{}, archiveContent{
+		stackData:           []byte("{}"),
+		pathToIndicatorPath: map[string]string{filepath.Join(tmpDir, "missing"): ""},
+	}); err == nil {
+		t.Errorf("want error for missing file, got none")
+	}
+}
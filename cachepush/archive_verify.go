@@ -0,0 +1,100 @@
+// Archive verification related functions.
+//
+// A disk-full or interrupted writer might leave a truncated archive behind, which is only noticed by the next build's cache-pull.
+// If enabled, the finished archive is re-read before the upload and its entries are checked against the archived content.
+package cachepush
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// archiveFixedMetadataCount is the number of metadata entries written into every archive:
+// the stack data, the file checksums, the cache descriptor and the metadata checksums.
+const archiveFixedMetadataCount = 4
+
+// archiveVerification summarizes the verified archive.
+type archiveVerification struct {
+	// entries is the number of archive entries.
+	entries int
+	// size is the total size of the entries' content in bytes.
+	size int64
+}
+
+// expectedArchiveEntries returns the number of entries the archive of the given content consists of.
+func expectedArchiveEntries(content archiveContent) int {
+	count := len(content.pathToIndicatorPath) + len(content.metadata) + archiveFixedMetadataCount
+	if content.trailer != nil {
+		count++
+	}
+	return count
+}
+
+// verifyArchive re-reads the finished archive at pth and checks it against the archived content:
+// every cached path has to be archived exactly once and described by the cache descriptor,
+// the archive has to consist of the expected number of entries, and the content of every entry has to be readable to its full size.
+func verifyArchive(pth string, content archiveContent) (archiveVerification, error) {
+	tarReader, closeArchive, err := openArchiveReader(pth, content.encryptionKey)
+	if err != nil {
+		return archiveVerification{}, err
+	}
+	defer closeArchive()
+
+	var verification archiveVerification
+	archived := map[string]bool{}
+	var duplicated, undescribed []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return verification, fmt.Errorf("failed to read archive after %d entries: %w", verification.entries, err)
+		}
+
+		n, err := io.Copy(ioutil.Discard, tarReader)
+		if err != nil {
+			return verification, fmt.Errorf("failed to read %s from archive: %w", header.Name, err)
+		}
+		if n != header.Size {
+			return verification, fmt.Errorf("%s is truncated in the archive: %d of %d bytes", header.Name, n, header.Size)
+		}
+		verification.entries++
+		verification.size += n
+
+		if _, ok := content.pathToIndicatorPath[header.Name]; !ok {
+			continue
+		}
+		if archived[header.Name] {
+			duplicated = append(duplicated, header.Name)
+		}
+		archived[header.Name] = true
+		if _, ok := content.descriptor[header.Name]; !ok {
+			undescribed = append(undescribed, header.Name)
+		}
+	}
+
+	var missing []string
+	for pth := range content.pathToIndicatorPath {
+		if !archived[pth] {
+			missing = append(missing, pth)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return verification, fmt.Errorf("%d cached paths are missing from the archive: %s", len(missing), strings.Join(missing, ", "))
+	}
+	if len(duplicated) > 0 {
+		return verification, fmt.Errorf("paths archived more than once: %s", strings.Join(duplicated, ", "))
+	}
+	if len(undescribed) > 0 {
+		return verification, fmt.Errorf("archived paths missing from the cache descriptor: %s", strings.Join(undescribed, ", "))
+	}
+	if expected := expectedArchiveEntries(content); verification.entries != expected {
+		return verification, fmt.Errorf("archive has %d entries, expected %d", verification.entries, expected)
+	}
+	return verification, nil
+}
@@ -0,0 +1,57 @@
+package cachepush
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_verifyArchive(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	dir := filepath.Join(tmpDir, "dir")
+	file := filepath.Join(dir, "file")
+	createDirStruct(t, map[string]string{file: "content"})
+
+	for _, compress := range []bool{false, true} {
+		pth := filepath.Join(tmpDir, "cache.tar")
+		content := archiveContent{
+			stackData:           []byte("stack"),
+			metadata:            []archiveMetadata{{path: "meta", data: []byte("data")}},
+			pathToIndicatorPath: map[string]string{dir: "", file: ""},
+			descriptor:          map[string]string{dir: "-", file: "-"},
+		}
+		_, err = createArchive(context.Background(), pth, compress, memoryBudget{}, content)
+		require.NoError(t, err)
+
+		verification, err := verifyArchive(pth, content)
+		require.NoError(t, err)
+		require.Equal(t, 7, verification.entries)
+
+		missing := content
+		missing.pathToIndicatorPath = map[string]string{dir: "", file: "", filepath.Join(dir, "missing"): ""}
+		_, err = verifyArchive(pth, missing)
+		require.EqualError(t, err, "1 cached paths are missing from the archive: "+filepath.Join(dir, "missing"))
+
+		undescribed := content
+		undescribed.descriptor = map[string]string{dir: "-"}
+		_, err = verifyArchive(pth, undescribed)
+		require.EqualError(t, err, "archived paths missing from the cache descriptor: "+file)
+
+		extra := content
+		extra.metadata = nil
+		_, err = verifyArchive(pth, extra)
+		require.EqualError(t, err, "archive has 7 entries, expected 6")
+
+		info, err := os.Stat(pth)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(pth, info.Size()/2))
+		_, err = verifyArchive(pth, content)
+		require.Error(t, err, "compress: %t", compress)
+	}
+}
@@ -0,0 +1,109 @@
+// AWS session related functions.
+//
+// The s3:// destinations use the AWS SDK's session with the shared config enabled, so the credentials are resolved
+// like in the AWS CLI and the other SDKs:
+//  1. the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables,
+//  2. web identity federation (AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, like on EKS or a CI with OIDC tokens),
+//  3. the profile (AWS_PROFILE, default: default) of the shared credentials and config files
+//     (~/.aws/credentials and ~/.aws/config, or AWS_SHARED_CREDENTIALS_FILE and AWS_CONFIG_FILE),
+//     with static keys, a role assumed with a source profile or a web identity token, a credential process or an SSO session,
+//  4. the ECS container credentials (AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI),
+//  5. the EC2 instance profile (IMDSv2), unless AWS_EC2_METADATA_DISABLED is true.
+//
+// The SDK's requests use the transport of the push, so the proxy and CA bundle settings apply to them too.
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	defaultAWSRegion = "us-east-1"
+	// awsCredentialsTimeout limits the credential requests (STS, the container and the instance metadata endpoints).
+	awsCredentialsTimeout = 10 * time.Second
+	// containerCredentialsService is the service name of the SDK's container credentials requests.
+	containerCredentialsService = "CredentialsEndpoint"
+)
+
+// newAWSSession returns the AWS session of the push, its credentials are resolved upfront,
+// so missing or invalid credentials fail before the upload starts.
+// The region defaults to us-east-1 if neither the environment nor the shared config file sets it.
+func newAWSSession(ctx context.Context) (*session.Session, error) {
+	handlers := defaults.Handlers()
+	handlers.Build.PushBack(containerAuthorizationTokenFile)
+
+	opts := session.Options{
+		Config: aws.Config{
+			HTTPClient:                    newHTTPClient(ctx, awsCredentialsTimeout),
+			EndpointResolver:              endpoints.ResolverFunc(resolveAWSEndpoint),
+			CredentialsChainVerboseErrors: aws.Bool(true),
+		},
+		SharedConfigState: session.SharedConfigEnable,
+		Handlers:          handlers,
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err == nil && aws.StringValue(sess.Config.Region) == "" {
+		opts.Config.Region = aws.String(defaultAWSRegion)
+		sess, err = session.NewSessionWithOptions(opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %s", err)
+	}
+
+	if _, err := sess.Config.Credentials.GetWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: %s", err)
+	}
+	return sess, nil
+}
+
+// awsEndpoint returns the custom endpoint of the service (AWS_ENDPOINT_URL_<SERVICE> or AWS_ENDPOINT_URL),
+// empty if the AWS endpoint is used.
+func awsEndpoint(service string) string {
+	for _, key := range []string{"AWS_ENDPOINT_URL_" + strings.ToUpper(service), "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(key); endpoint != "" {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// resolveAWSEndpoint resolves the custom S3 and STS endpoints, like a MinIO server and its STS API,
+// the other services (and S3 and STS without a custom endpoint) use the AWS endpoints.
+func resolveAWSEndpoint(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	if service == s3.EndpointsID || service == sts.EndpointsID {
+		if endpoint := awsEndpoint(service); endpoint != "" {
+			return endpoints.ResolvedEndpoint{URL: endpoint, SigningRegion: region}, nil
+		}
+	}
+	return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+}
+
+// containerAuthorizationTokenFile authorizes the container credentials request with the token of
+// AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE (like on EKS Pod Identity), the SDK only reads AWS_CONTAINER_AUTHORIZATION_TOKEN.
+func containerAuthorizationTokenFile(r *request.Request) {
+	tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE")
+	if r.ClientInfo.ServiceName != containerCredentialsService || tokenFile == "" || r.HTTPRequest.Header.Get("Authorization") != "" {
+		return
+	}
+
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		r.Error = fmt.Errorf("failed to read container authorization token: %s", err)
+		return
+	}
+	r.HTTPRequest.Header.Set("Authorization", strings.TrimSpace(string(token)))
+}
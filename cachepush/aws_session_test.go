@@ -0,0 +1,205 @@
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+// isolateAWSEnvForTest clears the credential related environment, so only the test's configuration is used.
+func isolateAWSEnvForTest(t *testing.T, dir string) {
+	for _, key := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+		"AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+		"AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS", "AWS_ENDPOINT_URL_S3",
+	} {
+		setenvForTest(t, key, "")
+	}
+	setenvForTest(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	setenvForTest(t, "AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	setenvForTest(t, "AWS_EC2_METADATA_DISABLED", "true")
+}
+
+// sessionCredentialsForTest returns the credentials and the region of a new session.
+func sessionCredentialsForTest(t *testing.T) (credentials.Value, string) {
+	sess, err := newAWSSession(context.Background())
+	require.NoError(t, err)
+	creds, err := sess.Config.Credentials.Get()
+	require.NoError(t, err)
+	creds.ProviderName = ""
+	return creds, aws.StringValue(sess.Config.Region)
+}
+
+// newSTSServerForTest returns an STS server returning the credentials of the role for the assume role calls.
+func newSTSServerForTest(t *testing.T, roleARN, accessKeyID string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, roleARN, r.Form.Get("RoleArn"))
+
+		action := r.Form.Get("Action")
+		switch action {
+		case "AssumeRoleWithWebIdentity":
+			require.Equal(t, "oidc-token", r.Form.Get("WebIdentityToken"))
+		case "AssumeRole":
+			require.Contains(t, r.Header.Get("Authorization"), "Credential=DEFAULT/")
+		default:
+			t.Errorf("unexpected STS action: %s", action)
+		}
+
+		_, err := fmt.Fprintf(w, `<%[1]sResponse><%[1]sResult><Credentials>
+<AccessKeyId>%[2]s</AccessKeyId><SecretAccessKey>sts-secret</SecretAccessKey><SessionToken>sts-token</SessionToken>
+<Expiration>2100-01-01T00:00:00Z</Expiration>
+</Credentials></%[1]sResult></%[1]sResponse>`, action, accessKeyID)
+		require.NoError(t, err)
+	}))
+}
+
+func Test_newAWSSession(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("aws")
+	require.NoError(t, err)
+	isolateAWSEnvForTest(t, tmpDir)
+
+	t.Log("no credentials")
+	{
+		_, err := newAWSSession(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get AWS credentials: NoCredentialProviders")
+	}
+
+	createDirStruct(t, map[string]string{
+		filepath.Join(tmpDir, "credentials"): "[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = default-secret\n\n[ci]\n# comment\naws_access_key_id=CI\naws_secret_access_key=ci-secret\naws_session_token=ci-token\n",
+		filepath.Join(tmpDir, "config"):      "[default]\nregion = eu-west-1\n\n[profile config-only]\naws_access_key_id = CONFIG\naws_secret_access_key = config-secret\nregion = eu-central-1\n",
+	})
+
+	t.Log("shared credentials file")
+	{
+		creds, region := sessionCredentialsForTest(t)
+		require.Equal(t, credentials.Value{AccessKeyID: "DEFAULT", SecretAccessKey: "default-secret"}, creds)
+		require.Equal(t, "eu-west-1", region)
+
+		setenvForTest(t, "AWS_PROFILE", "ci")
+		creds, region = sessionCredentialsForTest(t)
+		require.Equal(t, credentials.Value{AccessKeyID: "CI", SecretAccessKey: "ci-secret", SessionToken: "ci-token"}, creds)
+		require.Equal(t, defaultAWSRegion, region)
+
+		setenvForTest(t, "AWS_PROFILE", "config-only")
+		creds, region = sessionCredentialsForTest(t)
+		require.Equal(t, credentials.Value{AccessKeyID: "CONFIG", SecretAccessKey: "config-secret"}, creds)
+		require.Equal(t, "eu-central-1", region)
+	}
+
+	t.Log("environment takes precedence")
+	{
+		setenvForTest(t, "AWS_ACCESS_KEY_ID", "ENV")
+		setenvForTest(t, "AWS_SECRET_ACCESS_KEY", "env-secret")
+		setenvForTest(t, "AWS_REGION", "ap-south-1")
+		creds, region := sessionCredentialsForTest(t)
+		require.Equal(t, credentials.Value{AccessKeyID: "ENV", SecretAccessKey: "env-secret"}, creds)
+		require.Equal(t, "ap-south-1", region)
+	}
+}
+
+func Test_newAWSSession_webIdentity(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("aws")
+	require.NoError(t, err)
+	isolateAWSEnvForTest(t, tmpDir)
+
+	tokenFile := filepath.Join(tmpDir, "token")
+	createDirStruct(t, map[string]string{tokenFile: "oidc-token"})
+
+	server := newSTSServerForTest(t, "arn:aws:iam::123456789012:role/cache", "ASIA")
+	defer server.Close()
+
+	setenvForTest(t, "AWS_ENDPOINT_URL_STS", server.URL)
+	setenvForTest(t, "AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	setenvForTest(t, "AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/cache")
+
+	creds, _ := sessionCredentialsForTest(t)
+	require.Equal(t, credentials.Value{AccessKeyID: "ASIA", SecretAccessKey: "sts-secret", SessionToken: "sts-token"}, creds)
+}
+
+func Test_newAWSSession_sourceProfile(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("aws")
+	require.NoError(t, err)
+	isolateAWSEnvForTest(t, tmpDir)
+
+	server := newSTSServerForTest(t, "arn:aws:iam::123456789012:role/cache", "ROLE")
+	defer server.Close()
+
+	createDirStruct(t, map[string]string{
+		filepath.Join(tmpDir, "credentials"): "[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = default-secret\n",
+		filepath.Join(tmpDir, "config"):      "[profile cache]\nrole_arn = arn:aws:iam::123456789012:role/cache\nsource_profile = default\n",
+	})
+	setenvForTest(t, "AWS_ENDPOINT_URL_STS", server.URL)
+	setenvForTest(t, "AWS_PROFILE", "cache")
+
+	creds, _ := sessionCredentialsForTest(t)
+	require.Equal(t, credentials.Value{AccessKeyID: "ROLE", SecretAccessKey: "sts-secret", SessionToken: "sts-token"}, creds)
+}
+
+func Test_newAWSSession_credentialProcess(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("aws")
+	require.NoError(t, err)
+	isolateAWSEnvForTest(t, tmpDir)
+
+	process := filepath.Join(tmpDir, "credential-process.sh")
+	createDirStruct(t, map[string]string{
+		process:                         "#!/bin/sh\necho '{\"Version\": 1, \"AccessKeyId\": \"PROCESS\", \"SecretAccessKey\": \"process-secret\"}'\n",
+		filepath.Join(tmpDir, "config"): "[default]\ncredential_process = " + process + "\n",
+	})
+	require.NoError(t, os.Chmod(process, 0755))
+
+	creds, _ := sessionCredentialsForTest(t)
+	require.Equal(t, credentials.Value{AccessKeyID: "PROCESS", SecretAccessKey: "process-secret"}, creds)
+}
+
+func Test_newAWSSession_container(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("aws")
+	require.NoError(t, err)
+	isolateAWSEnvForTest(t, tmpDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "container-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, err := w.Write([]byte(`{"AccessKeyId": "ECS", "SecretAccessKey": "ecs-secret", "Token": "ecs-token", "Expiration": "2100-01-01T00:00:00Z"}`))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	setenvForTest(t, "AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/creds")
+
+	_, err = newAWSSession(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to get AWS credentials")
+
+	t.Log("authorization token")
+	{
+		setenvForTest(t, "AWS_CONTAINER_AUTHORIZATION_TOKEN", "container-token")
+		creds, _ := sessionCredentialsForTest(t)
+		require.Equal(t, credentials.Value{AccessKeyID: "ECS", SecretAccessKey: "ecs-secret", SessionToken: "ecs-token"}, creds)
+	}
+
+	t.Log("authorization token file")
+	{
+		tokenFile := filepath.Join(tmpDir, "container-token")
+		createDirStruct(t, map[string]string{tokenFile: "container-token\n"})
+		setenvForTest(t, "AWS_CONTAINER_AUTHORIZATION_TOKEN", "")
+		setenvForTest(t, "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+		creds, _ := sessionCredentialsForTest(t)
+		require.Equal(t, credentials.Value{AccessKeyID: "ECS", SecretAccessKey: "ecs-secret", SessionToken: "ecs-token"}, creds)
+	}
+}
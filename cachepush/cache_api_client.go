@@ -0,0 +1,140 @@
+// Cache API client related functions.
+//
+// Self-hosted cache API implementations might require authentication headers.
+// The headers are sent with the cache API requests only (upload url, chunk and usage requests),
+// not with the uploads to the returned upload urls, which usually point to a storage authenticated by the url itself.
+package cachepush
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCacheAPITokenHeader = "Authorization"
+	cacheAPIRequestTimeout     = 20 * time.Second
+)
+
+// cacheAPIConfig holds the headers of the cache API requests.
+type cacheAPIConfig struct {
+	headers http.Header
+}
+
+// newCacheAPIConfig returns the cache API configuration.
+// The token is sent in the tokenHeader, as a bearer token if the header is Authorization.
+// The extra headers are newline separated `Name: value` pairs.
+func newCacheAPIConfig(token, tokenHeader, extraHeaders string) (cacheAPIConfig, error) {
+	headers := http.Header{}
+	for _, line := range strings.Split(extraHeaders, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		split := strings.SplitN(line, ":", 2)
+		name := strings.TrimSpace(split[0])
+		if len(split) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return cacheAPIConfig{}, fmt.Errorf("invalid cache API header (%s), expected format: Name: value", strings.TrimSpace(line))
+		}
+		headers.Add(name, strings.TrimSpace(split[1]))
+	}
+
+	if token != "" {
+		if tokenHeader == "" {
+			tokenHeader = defaultCacheAPITokenHeader
+		}
+		value := token
+		if textproto.CanonicalMIMEHeaderKey(tokenHeader) == defaultCacheAPITokenHeader && !strings.Contains(token, " ") {
+			value = "Bearer " + token
+		}
+		headers.Set(tokenHeader, value)
+	}
+
+	return cacheAPIConfig{headers: headers}, nil
+}
+
+// authorize adds the configured headers to the cache API request.
+func (c cacheAPIConfig) authorize(req *http.Request) {
+	for name, values := range c.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
+
+// maxRetryAfter limits how long a Retry-After header of the cache API can delay the next attempt.
+const maxRetryAfter = 5 * time.Minute
+
+// cacheAPIStatusError is returned when the cache API rejects a request with an unexpected status code.
+type cacheAPIStatusError struct {
+	message    string
+	statusCode int
+	retryAfter time.Duration
+}
+
+// newCacheAPIStatusError returns the error of the rejected request, with the delay asked by its Retry-After header.
+func newCacheAPIStatusError(message string, resp *http.Response) *cacheAPIStatusError {
+	return &cacheAPIStatusError{
+		message:    message,
+		statusCode: resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *cacheAPIStatusError) Error() string {
+	if e.retryAfter > 0 {
+		return fmt.Sprintf("%s with status code: %d (retry after %s)", e.message, e.statusCode, e.retryAfter)
+	}
+	return fmt.Sprintf("%s with status code: %d", e.message, e.statusCode)
+}
+
+// isRetryableCacheAPIError reports whether the failed cache API request is worth retrying:
+// client errors are not, except the timed out and the rate limited requests.
+func isRetryableCacheAPIError(err error) bool {
+	var statusErr *cacheAPIStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch statusErr.statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return statusErr.statusCode < 400 || statusErr.statusCode >= 500
+}
+
+// retryAfterDelay returns the wait time before retrying the failed request:
+// the delay of the retry policy, or the Retry-After delay of the cache API if that is longer.
+func retryAfterDelay(err error, delay time.Duration) time.Duration {
+	var statusErr *cacheAPIStatusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > delay {
+		return statusErr.retryAfter
+	}
+	return delay
+}
+
+// parseRetryAfter parses the Retry-After header value, which is either a number of seconds or an HTTP date.
+// Invalid and past values result in 0, too long ones are limited to maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = t.Sub(now)
+	}
+
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
@@ -0,0 +1,137 @@
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newCacheAPIConfig(t *testing.T) {
+	config, err := newCacheAPIConfig("token", "", "X-Tenant-ID: team\n\n  X-Extra:  a: b  \n")
+	require.NoError(t, err)
+	require.Equal(t, http.Header{
+		"Authorization": {"Bearer token"},
+		"X-Tenant-Id":   {"team"},
+		"X-Extra":       {"a: b"},
+	}, config.headers)
+
+	config, err = newCacheAPIConfig("Basic dXNlcjpwYXNz", "authorization", "")
+	require.NoError(t, err)
+	require.Equal(t, http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}, config.headers)
+
+	config, err = newCacheAPIConfig("token", "X-Api-Key", "")
+	require.NoError(t, err)
+	require.Equal(t, http.Header{"X-Api-Key": {"token"}}, config.headers)
+
+	config, err = newCacheAPIConfig("", "", "")
+	require.NoError(t, err)
+	require.Empty(t, config.headers)
+
+	for _, headers := range []string{"no separator", ": value", "Bad Name: value"} {
+		_, err := newCacheAPIConfig("", "", headers)
+		require.Error(t, err, headers)
+	}
+}
+
+func Test_getCacheUploadURL_headers(t *testing.T) {
+	api, err := newCacheAPIConfig("token", "", "X-Tenant-ID: team")
+	require.NoError(t, err)
+	ctx := withRunEnv(context.Background(), &runEnv{workDir: defaultRunEnv.workDir, transport: defaultRunEnv.transport, cacheAPI: api})
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		_, err := w.Write([]byte(`{"upload_url": "https://storage/upload"}`))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	uploadURL, err := getCacheUploadURL(ctx, server.URL, 1, "", nil, uploadRetry{})
+	require.NoError(t, err)
+	require.Equal(t, "https://storage/upload", uploadURL)
+	require.Equal(t, "Bearer token", header.Get("Authorization"))
+	require.Equal(t, "team", header.Get("X-Tenant-ID"))
+}
+
+func Test_getCacheUploadURL_retry(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, err := w.Write([]byte(`{"upload_url": "https://storage/upload"}`))
+			require.NoError(t, err)
+		}
+	}))
+	defer server.Close()
+
+	startTime := time.Now()
+	uploadURL, err := getCacheUploadURL(context.Background(), server.URL, 1, "", nil, uploadRetry{count: 2, backoff: time.Millisecond})
+	require.NoError(t, err)
+	require.Equal(t, "https://storage/upload", uploadURL)
+	require.Equal(t, 3, requests)
+	require.True(t, time.Since(startTime) >= time.Second, "Retry-After is not respected")
+
+	requests = 0
+	_, err = getCacheUploadURL(context.Background(), server.URL, 1, "", nil, uploadRetry{count: 0, backoff: time.Millisecond})
+	require.EqualError(t, err, "upload url was rejected with status code: 502")
+	require.Equal(t, 1, requests)
+}
+
+func Test_getCacheUploadURL_clientError(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	_, err := getCacheUploadURL(context.Background(), server.URL, 1, "", nil, uploadRetry{count: 3, backoff: time.Millisecond})
+	require.EqualError(t, err, "upload url was rejected with status code: 401")
+	require.Equal(t, 1, requests)
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "30", want: 30 * time.Second},
+		{value: " 5 ", want: 5 * time.Second},
+		{value: "-1", want: 0},
+		{value: "3600", want: maxRetryAfter},
+		{value: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, parseRetryAfter(tt.value, now), tt.value)
+	}
+}
+
+func Test_isRetryableCacheAPIError(t *testing.T) {
+	require.True(t, isRetryableCacheAPIError(errors.New("connection reset")))
+	for statusCode, want := range map[int]bool{
+		http.StatusBadGateway:         true,
+		http.StatusTooManyRequests:    true,
+		http.StatusRequestTimeout:     true,
+		http.StatusUnauthorized:       false,
+		http.StatusBadRequest:         false,
+		http.StatusPaymentRequired:    false,
+		http.StatusServiceUnavailable: true,
+	} {
+		require.Equal(t, want, isRetryableCacheAPIError(&cacheAPIStatusError{statusCode: statusCode}), statusCode)
+	}
+}
@@ -0,0 +1,750 @@
+// Cache archive related models and functions.
+package cachepush
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// Archive represents a cache archive.
+type Archive struct {
+	output io.WriteCloser
+	buffer *bufio.Writer
+	tar    *tar.Writer
+	gzip   *gzip.Writer
+
+	copyBuffer []byte
+	retried    []string
+	// checksums stores the checksum of every metadata file written into the archive.
+	checksums map[string]string
+	// fileChecksums stores the checksum of every archived regular file, computed while copying it.
+	fileChecksums map[string]string
+	// linkTargets maps the hard linked files to the first path archived of them.
+	linkTargets map[fileID]string
+	// archived holds the state of the archived regular files, modified holds the ones modified while copying them.
+	archived map[string]archivedState
+	modified []string
+	// reproducible zeroes the entry metadata which is not needed to restore the cache (owners, access and change times).
+	reproducible bool
+	// workers is the number of parallel readers prefetching the files, queueSize is the number of entries
+	// opened ahead and blockSize is the size of the largest prefetched file, see writeParallel.
+	workers   int
+	queueSize int
+	blockSize int
+}
+
+// NewArchive creates a instance of Archive.
+// The archive's buffers are sized to fit into the given memory budget.
+func NewArchive(pth string, compress bool, budget memoryBudget) (*Archive, error) {
+	file, err := os.Create(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchive(file, compress, budget)
+}
+
+// newArchive creates an Archive writing into the given output.
+func newArchive(output io.WriteCloser, compress bool, budget memoryBudget) (*Archive, error) {
+	buffer := bufio.NewWriterSize(output, budget.copyBufferSize())
+
+	var tarWriter *tar.Writer
+	var gzipWriter *gzip.Writer
+	if compress {
+		var err error
+		gzipWriter, err = gzip.NewWriterLevel(buffer, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+
+		tarWriter = tar.NewWriter(gzipWriter)
+	} else {
+		tarWriter = tar.NewWriter(buffer)
+	}
+	return &Archive{
+		output:        output,
+		buffer:        buffer,
+		tar:           tarWriter,
+		gzip:          gzipWriter,
+		copyBuffer:    make([]byte, budget.copyBufferSize()),
+		checksums:     map[string]string{},
+		fileChecksums: map[string]string{},
+		linkTargets:   map[fileID]string{},
+		archived:      map[string]archivedState{},
+	}, nil
+}
+
+// openArchiveReader opens the archive at the given path for reading, decrypting it with the given key if it is encrypted
+// and decompressing it if it is gzip compressed. The returned function closes the archive file.
+func openArchiveReader(pth string, key []byte) (*tar.Reader, func(), error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeFile := func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close archive file (%s): %s", pth, err)
+		}
+	}
+
+	reader := bufio.NewReader(file)
+	if isEncryptedArchive(reader) {
+		decrypted, err := newDecryptingReader(reader, key)
+		if err != nil {
+			closeFile()
+			return nil, nil, err
+		}
+		reader = bufio.NewReader(decrypted)
+	}
+
+	var archiveReader io.Reader = reader
+	if magic, err := reader.Peek(len(cachecommon.GzipMagic)); err == nil && bytes.Equal(magic, cachecommon.GzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			closeFile()
+			return nil, nil, err
+		}
+		archiveReader = gzipReader
+	}
+
+	return tar.NewReader(archiveReader), closeFile, nil
+}
+
+// Write writes the given files in the cache archive, in order.
+func (a *Archive) Write(ctx context.Context, pathToIndicator map[string]string) error {
+	if a.workers > 1 {
+		return a.writeParallel(ctx, sortedKeys(pathToIndicator))
+	}
+
+	for _, pth := range sortedKeys(pathToIndicator) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := a.writeOne(pth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveEntry holds the tar header and the opened file (in case of regular files) of an item to archive.
+type archiveEntry struct {
+	header *tar.Header
+	file   *os.File
+	// linkID identifies the file if it is hard linked.
+	linkID   fileID
+	isLinked bool
+	// data is the prefetched content of the file, nil if it is copied from the file.
+	data []byte
+}
+
+// openArchiveEntry collects everything needed to write the given path into the archive.
+// Nothing is written to the archive yet, so failing calls can be safely retried.
+func openArchiveEntry(pth string) (archiveEntry, error) {
+	info, err := os.Lstat(pth)
+	if err != nil {
+		return archiveEntry{}, fmt.Errorf("failed to lstat(%s), error: %w", pth, err)
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(pth)
+		if err != nil {
+			return archiveEntry{}, fmt.Errorf("failed to read link(%s), error: %w", pth, err)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return archiveEntry{}, fmt.Errorf("failed to get tar file header(%s), error: %w", link, err)
+	}
+
+	header.Name = pth
+	header.ModTime = info.ModTime()
+
+	// Calling Write on special types like TypeLink, TypeSymlink, TypeChar, TypeBlock, TypeDir, and TypeFifo returns (0, ErrWriteTooLong) regardless of what the Header.Size claims.
+	if !info.Mode().IsRegular() {
+		return archiveEntry{header: header}, nil
+	}
+
+	file, err := os.Open(pth)
+	if err != nil {
+		return archiveEntry{}, fmt.Errorf("failed to open file(%s), error: %w", pth, err)
+	}
+
+	linkID, isLinked := hardLinkID(info)
+	return archiveEntry{header: header, file: file, linkID: linkID, isLinked: isLinked}, nil
+}
+
+func (a *Archive) writeOne(pth string) error {
+	entry, retried, err := openArchiveEntryWithRetry(pth)
+	if retried {
+		a.retried = appendIfMissing(a.retried, pth)
+	}
+	if err != nil {
+		return err
+	}
+	return a.writeEntry(pth, entry)
+}
+
+// openArchiveEntryWithRetry opens the archive entry, retrying transient filesystem errors.
+// It reports whether the opening was retried.
+func openArchiveEntryWithRetry(pth string) (archiveEntry, bool, error) {
+	retried := false
+	entry, err := openArchiveEntry(pth)
+	for attempt := 1; err != nil; attempt++ {
+		if attempt > maxEntryRetryCount || !isTransientFSError(err) {
+			return archiveEntry{}, retried, err
+		}
+
+		log.Warnf("Transient error while reading %s (attempt %d/%d): %s", pth, attempt, maxEntryRetryCount, err)
+		retried = true
+		time.Sleep(entryRetryWait)
+
+		entry, err = openArchiveEntry(pth)
+	}
+	return entry, retried, nil
+}
+
+// writeEntry writes the opened entry into the archive and closes its file.
+func (a *Archive) writeEntry(pth string, entry archiveEntry) error {
+	if a.reproducible {
+		normalizeHeader(entry.header)
+	}
+
+	if target, ok := a.linkTargets[entry.linkID]; ok && entry.isLinked {
+		if err := entry.file.Close(); err != nil {
+			log.Warnf("Failed to close file (%s): %s", pth, err)
+		}
+		entry.file = nil
+		entry.data = nil
+		entry.header.Typeflag = tar.TypeLink
+		entry.header.Linkname = target
+		entry.header.Size = 0
+	}
+
+	if entry.file != nil {
+		defer func() {
+			if err := entry.file.Close(); err != nil {
+				log.Warnf("Failed to close file (%s): %s", pth, err)
+			}
+		}()
+	}
+
+	if err := a.tar.WriteHeader(entry.header); err != nil {
+		return fmt.Errorf("failed to write header(%v), error: %s", entry.header, err)
+	}
+
+	if entry.file == nil {
+		return nil
+	}
+	if entry.isLinked {
+		a.linkTargets[entry.linkID] = pth
+	}
+
+	// Write writes to the current file in the tar archive. Write returns the error ErrWriteTooLong if more than Header.Size bytes are written after WriteHeader.
+	var content io.Reader = entry.file
+	if entry.data != nil {
+		content = bytes.NewReader(entry.data)
+	}
+	h := cachecommon.NewFileHash()
+	written, err := io.CopyBuffer(io.MultiWriter(a.tar, h), io.LimitReader(content, entry.header.Size), a.copyBuffer)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("failed to copy, error: %w, file: %s, size: %d for header: %v", err, entry.file.Name(), entry.header.Size, entry.header)
+		if isTransientFSError(err) {
+			// the header is already written, the archive can not be fixed by retrying this file only
+			return &transientArchiveError{path: pth, err: err}
+		}
+		return err
+	}
+
+	if written < entry.header.Size {
+		// the file shrank while copying it, the entry is padded to keep the archive readable
+		if err := a.writeZeros(entry.header.Size - written); err != nil {
+			return fmt.Errorf("failed to pad %s, error: %w", pth, err)
+		}
+		a.modified = append(a.modified, pth)
+		return nil
+	}
+
+	state := archivedState{size: entry.header.Size, modTime: entry.header.ModTime}
+	if info, err := entry.file.Stat(); err != nil || state.differs(info) {
+		a.modified = append(a.modified, pth)
+		return nil
+	}
+	a.archived[pth] = state
+	a.fileChecksums[pth] = cachecommon.FileChecksum(h)
+
+	return nil
+}
+
+// normalizeHeader zeroes the header fields which are not needed to restore the file.
+func normalizeHeader(header *tar.Header) {
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+}
+
+// writeZeros writes n zero bytes to the current file in the tar archive.
+func (a *Archive) writeZeros(n int64) error {
+	zeros := make([]byte, 32*1024)
+	for n > 0 {
+		chunk := int64(len(zeros))
+		if n < chunk {
+			chunk = n
+		}
+		if _, err := a.tar.Write(zeros[:chunk]); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// WriteHeader writes the cache descriptor file into the archive as a gzip compressed json, with canonical keys.
+func (a *Archive) WriteHeader(descriptor map[string]string, descriptorPth string) error {
+	b, err := cachecommon.EncodeCanonicalDescriptor(descriptor, pathutil.UserHomeDir())
+	if err != nil {
+		return err
+	}
+
+	return a.writeData(b, descriptorPth)
+}
+
+// WriteChecksums writes the checksums of the metadata files written so far into the archive, to the given path.
+func (a *Archive) WriteChecksums(pth string) error {
+	b, err := cachecommon.EncodeMetadataChecksums(a.checksums)
+	if err != nil {
+		return err
+	}
+
+	if err := a.writeData(b, pth); err != nil {
+		return err
+	}
+	// the checksums file does not describe itself
+	delete(a.checksums, pth)
+	return nil
+}
+
+// WriteFileChecksums writes the checksums of the regular files archived so far into the archive, to the given path.
+// The checksums file is a metadata file, so its own checksum is stored in the metadata checksums.
+func (a *Archive) WriteFileChecksums(pth string) error {
+	b, err := cachecommon.EncodeFileChecksums(a.fileChecksums)
+	if err != nil {
+		return err
+	}
+	return a.writeData(b, pth)
+}
+
+// metadataModTime is the modification time of the generated metadata files,
+// it is fixed so the same metadata results in the same archive entry.
+var metadataModTime = time.Unix(0, 0)
+
+// writeData writes the byte array into the archive and records its checksum.
+func (a *Archive) writeData(data []byte, descriptorPth string) error {
+	header := &tar.Header{
+		Name:     descriptorPth,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		ModTime:  metadataModTime,
+	}
+
+	if err := a.tar.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(a.tar, bytes.NewReader(data)); err != nil && err != io.EOF {
+		return err
+	}
+
+	a.checksums[descriptorPth] = cachecommon.MetadataChecksum(data)
+	return nil
+}
+
+// Close closes the archive.
+func (a *Archive) Close() error {
+	if err := a.tar.Close(); err != nil {
+		return err
+	}
+
+	if a.gzip != nil {
+		if err := a.gzip.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := a.buffer.Flush(); err != nil {
+		return err
+	}
+
+	return a.output.Close()
+}
+
+// Retried returns the paths which were retried because of a transient filesystem error.
+func (a *Archive) Retried() []string {
+	return a.retried
+}
+
+// uploadArchive uploads the archive file to a given destination.
+// If the destination is a local file path (url has a file:// scheme) this function copies the cache archive file to the destination,
+// if it is a local directory (the url ends with a slash) the archive is stored as a new archive of the cache key, see writeLocalArchive.
+// If the destination is an S3 object (url has an s3:// scheme) the archive is uploaded to the S3 compatible storage,
+// if it is a remote file (url has an ssh:// scheme) the archive is uploaded over ssh.
+// Otherwise destination should point to the Bitrise cache API server, in this case failed uploads are retried based on the retry policy,
+// the retried attempt continues the upload from the last byte received by the storage.
+// If the archive info is Chunked, only the archive's chunks missing on the server and the chunk manifest are uploaded.
+// In layered mode layerID identifies the uploaded layer, it is empty otherwise.
+// The archive info (including the archive's checksum) is sent with the upload.
+func uploadArchive(ctx context.Context, pth, url string, buildSlug string, layerID string, info model.ArchiveInfo, retry uploadRetry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if isLocalDirectory(url) {
+		return copyLocalArchive(pth, url, buildSlug, layerID, info, envOf(ctx).localCacheRetention)
+	}
+
+	if strings.HasPrefix(url, "file://") {
+		dst := fileDestination(url, layerID)
+		dir := filepath.Dir(dst)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := command.CopyFile(pth, dst); err != nil {
+			return err
+		}
+		if info.Checksum != "" {
+			return writeChecksumFile(dst, info.Checksum)
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(url, s3Scheme) {
+		return uploadArchiveToS3(ctx, pth, url, layerID, info.Checksum, retry)
+	}
+
+	if strings.HasPrefix(url, sshScheme) {
+		return uploadArchiveToSSH(ctx, pth, url, layerID, info.Checksum, retry)
+	}
+
+	if info.Chunked {
+		return uploadArchiveChunked(ctx, pth, url, layerID, info, retry)
+	}
+
+	fi, err := os.Stat(pth)
+	if err != nil {
+		return fmt.Errorf("failed to get file info (%s): %s", pth, err)
+	}
+	sizeInBytes := fi.Size()
+	log.Printf("Archive file size: %d bytes / %f MB", sizeInBytes, (float64(sizeInBytes) / 1024.0 / 1024.0))
+	data := map[string]interface{}{
+		"cache_archive_size": sizeInBytes,
+		"build_slug":         buildSlug,
+	}
+	log.RInfof(stepID, "cache_archive_size", data, "Size of cache archive: %d Bytes", sizeInBytes)
+
+	progressPth := cachecommon.GroupPath(envOf(ctx).workPath(uploadProgressFileName), info.Group)
+	progress, err := readUploadProgress(progressPth)
+	if err != nil {
+		log.Warnf("Failed to read upload progress: %s", err)
+	}
+
+	if progress != nil && progress.resumes(url, layerID, fi) {
+		log.Printf("Resuming the previous upload of the archive")
+		if offset, ok, err := queryUploadOffset(ctx, progress.UploadURL, sizeInBytes); err != nil {
+			log.Warnf("Failed to query upload status: %s", err)
+			progress = nil
+		} else if ok {
+			progress.Offset = offset
+		} else {
+			progress = nil
+		}
+	} else {
+		progress = nil
+	}
+
+	if progress == nil {
+		target, err := getCacheUploadTarget(ctx, url, sizeInBytes, layerID, &info, retry)
+		if err != nil {
+			return fmt.Errorf("failed to generate upload url: %s", err)
+		}
+		progress = &uploadProgress{
+			CacheAPIURL:    url,
+			LayerID:        layerID,
+			UploadURL:      target.url,
+			ArchiveSize:    sizeInBytes,
+			ArchiveModTime: fi.ModTime().UnixNano(),
+		}
+		if target.sessionURL != "" {
+			progress.UploadURL = target.sessionURL
+			progress.Resumable = true
+		}
+		if err := progress.save(progressPth); err != nil {
+			log.Warnf("Failed to save upload progress: %s", err)
+		}
+	}
+
+	err = tryToUploadArchive(ctx, progress.UploadURL, pth, progress.Offset, info.ContentMD5)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for attempt := 1; err != nil && attempt <= retry.count; attempt++ {
+		delay := retry.delay(attempt, rnd)
+		fmt.Println()
+		log.Warnf("Upload attempt %d/%d failed: %s, retrying in %s...", attempt, retry.count+1, err, delay)
+		fmt.Println()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		// without a resumable session (or a confirmed offset) the whole archive is uploaded again
+		progress.Offset = 0
+		if progress.Resumable {
+			if offset, ok, err := queryUploadOffset(ctx, progress.UploadURL, sizeInBytes); err != nil {
+				log.Warnf("Failed to query upload status: %s", err)
+			} else if ok {
+				progress.Offset = offset
+			}
+			if err := progress.save(progressPth); err != nil {
+				log.Warnf("Failed to save upload progress: %s", err)
+			}
+		}
+
+		err = tryToUploadArchive(ctx, progress.UploadURL, pth, progress.Offset, info.ContentMD5)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(progressPth); err != nil {
+		log.Warnf("Failed to remove upload progress: %s", err)
+	}
+	return nil
+}
+
+// uploadRetry describes how failed upload attempts are retried.
+type uploadRetry struct {
+	count   int
+	backoff time.Duration
+}
+
+// delay returns the wait time before the given retry attempt (starting from 1):
+// the backoff is doubled for every attempt, and a random jitter of up to its half is subtracted,
+// so the retries of parallel builds are spread out.
+func (r uploadRetry) delay(attempt int, rnd *rand.Rand) time.Duration {
+	d := r.backoff << uint(attempt-1)
+	if half := int64(d / 2); half > 0 {
+		d -= time.Duration(rnd.Int63n(half + 1))
+	}
+	return d
+}
+
+// fileDestination returns the local path of a file:// destination,
+// in layered mode the layer's ID is added to the file name.
+func fileDestination(url string, layerID string) string {
+	return withLayerID(strings.TrimPrefix(url, "file://"), layerID)
+}
+
+// withLayerID adds the layer's ID to the file name of the path, if set.
+func withLayerID(pth string, layerID string) string {
+	if layerID == "" {
+		return pth
+	}
+	ext := filepath.Ext(pth)
+	return strings.TrimSuffix(pth, ext) + "." + layerID + ext
+}
+
+// getCacheUploadURL requests an upload url from the Bitrise cache API server, see getCacheUploadTarget.
+func getCacheUploadURL(ctx context.Context, cacheAPIURL string, fileSizeInBytes int64, layerID string, info *model.ArchiveInfo, retry uploadRetry) (string, error) {
+	target, err := getCacheUploadTarget(ctx, cacheAPIURL, fileSizeInBytes, layerID, info, retry)
+	return target.url, err
+}
+
+// getCacheUploadTarget requests an upload url (and a resumable upload session url, if the storage supports it)
+// from the Bitrise cache API server.
+// The archive info is sent if known, its checksum is also sent as a separate field.
+// Failed requests are retried based on the retry policy, except the ones rejected with a client error,
+// a rate limited request is retried no sooner than its Retry-After header asks for.
+func getCacheUploadTarget(ctx context.Context, cacheAPIURL string, fileSizeInBytes int64, layerID string, info *model.ArchiveInfo, retry uploadRetry) (uploadTarget, error) {
+	return requestCacheUploadTarget(ctx, cacheAPIURL, uploadURLRequestBody(fileSizeInBytes, layerID, info), retry)
+}
+
+// uploadURLRequestBody returns the body of the upload url request.
+func uploadURLRequestBody(fileSizeInBytes int64, layerID string, info *model.ArchiveInfo) map[string]interface{} {
+	reqBody := map[string]interface{}{"file_size_in_bytes": fileSizeInBytes}
+	if layerID != "" {
+		reqBody["cache_layer"] = layerID
+	}
+	if info != nil {
+		reqBody["archive_info"] = info
+		if info.Checksum != "" {
+			reqBody["checksum_sha256"] = info.Checksum
+		}
+		if info.CacheKey != "" {
+			reqBody["cache_key"] = info.CacheKey
+		}
+		if info.Group != "" {
+			reqBody["cache_group"] = info.Group
+		}
+	}
+	return reqBody
+}
+
+// requestCacheUploadTarget sends the upload url request, retrying it based on the retry policy.
+func requestCacheUploadTarget(ctx context.Context, cacheAPIURL string, reqBody map[string]interface{}, retry uploadRetry) (uploadTarget, error) {
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return uploadTarget{}, fmt.Errorf("failed to marshal request body: %s", err)
+	}
+
+	target, err := requestCacheUploadURL(ctx, cacheAPIURL, b)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for attempt := 1; err != nil && isRetryableCacheAPIError(err) && attempt <= retry.count; attempt++ {
+		delay := retryAfterDelay(err, retry.delay(attempt, rnd))
+		log.Warnf("Upload url request attempt %d/%d failed: %s, retrying in %s...", attempt, retry.count+1, err, delay)
+		select {
+		case <-ctx.Done():
+			return uploadTarget{}, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		target, err = requestCacheUploadURL(ctx, cacheAPIURL, b)
+	}
+	return target, err
+}
+
+// requestCacheUploadURL sends a single upload url request with the given body.
+func requestCacheUploadURL(ctx context.Context, cacheAPIURL string, b []byte) (uploadTarget, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cacheAPIURL, bytes.NewReader(b))
+	if err != nil {
+		return uploadTarget{}, fmt.Errorf("failed to create request: %s", err)
+	}
+
+	envOf(ctx).cacheAPI.authorize(req)
+
+	resp, err := newHTTPClient(ctx, cacheAPIRequestTimeout).Do(req)
+	if err != nil {
+		return uploadTarget{}, fmt.Errorf("failed to send request: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return uploadTarget{}, fmt.Errorf("failed to read response body: %s", err)
+	}
+	diag.addResponse(resp, body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 202 {
+		return uploadTarget{}, newCacheAPIStatusError("upload url was rejected", resp)
+	}
+
+	var respModel map[string]string
+	if err := json.Unmarshal(body, &respModel); err != nil {
+		return uploadTarget{}, fmt.Errorf("failed to decode response body: %s", err)
+	}
+
+	uploadURL, ok := respModel["upload_url"]
+	if !ok || uploadURL == "" {
+		return uploadTarget{}, fmt.Errorf("request sent, but upload url isn't received")
+	}
+
+	return uploadTarget{url: uploadURL, sessionURL: respModel["resumable_upload_url"], streamURL: respModel["stream_upload_url"]}, nil
+}
+
+// tryToUploadArchive performs the cache upload, starting from the given offset of the archive.
+// If the archive's MD5 digest is known, the Content-MD5 header of the uploaded part is sent,
+// so the storage rejects the upload if the received content is corrupted.
+// If the destination is a local file path (url has a file:// scheme) this function copies the cache archive file to the destination.
+// Otherwise destination should be a remote url.
+func tryToUploadArchive(ctx context.Context, uploadURL string, archiveFilePath string, offset int64, contentMD5 string) error {
+	archFile, err := os.Open(archiveFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file for upload (%s): %s", archiveFilePath, err)
+	}
+
+	defer func() {
+		if err := archFile.Close(); err != nil {
+			log.Warnf("Failed to close archive file (%s): %s", archiveFilePath, err)
+		}
+	}()
+
+	fileInfo, err := archFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file stats of the archive file (%s): %s", archiveFilePath, err)
+	}
+	fileSize := fileInfo.Size()
+	if offset >= fileSize {
+		return nil
+	}
+	if offset > 0 {
+		log.Printf("Continuing the upload from byte %d", offset)
+		if _, err := archFile.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek archive file (%s): %s", archiveFilePath, err)
+		}
+	}
+
+	if contentMD5 != "" && offset > 0 {
+		if contentMD5, err = sectionContentMD5(archFile, offset, fileSize); err != nil {
+			return fmt.Errorf("failed to calculate the digest of the archive file (%s): %s", archiveFilePath, err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, archFile)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %s", err)
+	}
+
+	req.Header.Add("Content-Length", strconv.FormatInt(fileSize-offset, 10))
+	req.ContentLength = fileSize - offset
+	if contentMD5 != "" {
+		req.Header.Set("Content-MD5", contentMD5)
+	}
+	if offset > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, fileSize-1, fileSize))
+	}
+
+	resp, err := newHTTPClient(ctx, 0).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload: %s", err)
+	}
+	defer closeResponseBody(resp)
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiagnosticsResponseBody))
+	if err != nil {
+		log.Debugf("Failed to read upload response body: %s", err)
+	}
+	diag.addResponse(resp, body)
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("upload failed with status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
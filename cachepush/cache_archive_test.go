@@ -1,8 +1,11 @@
-package main
+package cachepush
 
 import (
+	"context"
+	"math/rand"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-utils/pathutil"
 )
@@ -46,7 +49,7 @@ func TestNewArchive(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewArchive(tt.pth, tt.compress)
+			got, err := NewArchive(tt.pth, tt.compress, memoryBudget{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewArchive() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -71,24 +74,24 @@ func TestArchive_Write(t *testing.T) {
 
 	t.Log("no compress")
 	{
-		archive, err := NewArchive(pth, false)
+		archive, err := NewArchive(pth, false, memoryBudget{})
 		if err != nil {
 			t.Fatalf("failed to create archive: %s", err)
 		}
 
-		if err := archive.Write(map[string]string{fileToArchive: "indicator"}); err != nil {
+		if err := archive.Write(context.Background(), map[string]string{fileToArchive: "indicator"}); err != nil {
 			t.Fatalf("failed to write archive: %s", err)
 		}
 	}
 
 	t.Log("compress")
 	{
-		archive, err := NewArchive(pth, true)
+		archive, err := NewArchive(pth, true, memoryBudget{})
 		if err != nil {
 			t.Fatalf("failed to create archive: %s", err)
 		}
 
-		if err := archive.Write(map[string]string{fileToArchive: "indicator"}); err != nil {
+		if err := archive.Write(context.Background(), map[string]string{fileToArchive: "indicator"}); err != nil {
 			t.Fatalf("failed to write archive: %s", err)
 		}
 	}
@@ -104,12 +107,12 @@ func TestArchive_WriteHeader(t *testing.T) {
 	fileToArchive := filepath.Join(tmpDir, "file")
 	createDirStruct(t, map[string]string{fileToArchive: ""})
 
-	archive, err := NewArchive(pth, false)
+	archive, err := NewArchive(pth, false, memoryBudget{})
 	if err != nil {
 		t.Fatalf("failed to create archive: %s", err)
 	}
 
-	if err := archive.WriteHeader(map[string]string{"file/to/cache": "indicator/file"}, cacheInfoFilePath); err != nil {
+	if err := archive.WriteHeader(map[string]string{"file/to/cache": "indicator/file"}, workPath(cacheInfoFileName)); err != nil {
 		t.Fatalf("failed to write archive header: %s", err)
 	}
 }
@@ -126,12 +129,12 @@ func TestArchive_Close(t *testing.T) {
 
 	t.Log("no compress")
 	{
-		archive, err := NewArchive(pth, false)
+		archive, err := NewArchive(pth, false, memoryBudget{})
 		if err != nil {
 			t.Fatalf("failed to create archive: %s", err)
 		}
 
-		if err := archive.Write(map[string]string{fileToArchive: ""}); err != nil {
+		if err := archive.Write(context.Background(), map[string]string{fileToArchive: ""}); err != nil {
 			t.Fatalf("failed to write archive: %s", err)
 		}
 
@@ -142,12 +145,12 @@ func TestArchive_Close(t *testing.T) {
 
 	t.Log("compress")
 	{
-		archive, err := NewArchive(pth, true)
+		archive, err := NewArchive(pth, true, memoryBudget{})
 		if err != nil {
 			t.Fatalf("failed to create archive: %s", err)
 		}
 
-		if err := archive.Write(map[string]string{fileToArchive: ""}); err != nil {
+		if err := archive.Write(context.Background(), map[string]string{fileToArchive: ""}); err != nil {
 			t.Fatalf("failed to write archive: %s", err)
 		}
 
@@ -156,3 +159,19 @@ func TestArchive_Close(t *testing.T) {
 		}
 	}
 }
+
+func Test_uploadRetry_delay(t *testing.T) {
+	retry := uploadRetry{count: 3, backoff: 4 * time.Second}
+	rnd := rand.New(rand.NewSource(1))
+
+	for attempt, max := range []time.Duration{4 * time.Second, 8 * time.Second, 16 * time.Second} {
+		got := retry.delay(attempt+1, rnd)
+		if got > max || got < max/2 {
+			t.Errorf("delay(%d) = %s, want between %s and %s", attempt+1, got, max/2, max)
+		}
+	}
+
+	if got := (uploadRetry{count: 1}).delay(1, rnd); got != 0 {
+		t.Errorf("delay() = %s, want 0", got)
+	}
+}
@@ -0,0 +1,430 @@
+// Cache descriptor file related models and functions.
+package cachepush
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// ChangeIndicator ...
+type ChangeIndicator string
+
+const (
+	// MD5 ...
+	MD5 = ChangeIndicator("file-content-hash")
+	// XXH64 ...
+	XXH64 = ChangeIndicator("file-content-hash-xxh64")
+	// BLAKE3 ...
+	BLAKE3 = ChangeIndicator("file-content-hash-blake3")
+	// MODTIME ...
+	MODTIME = ChangeIndicator("file-mod-time")
+	// SIZEMODTIME ...
+	SIZEMODTIME = ChangeIndicator("file-size-and-mod-time")
+	// GITBLOB ...
+	GITBLOB = ChangeIndicator("file-git-blob-hash")
+)
+
+// contentHashMethods are the change indicators hashing the indicator file's content.
+var contentHashMethods = map[ChangeIndicator]func() hash.Hash{
+	MD5:    md5.New,
+	XXH64:  newXXH64,
+	BLAKE3: newBLAKE3,
+}
+
+// isModTimeMethod reports whether the change indicator is based on the mod time instead of the content.
+func isModTimeMethod(method ChangeIndicator) bool {
+	return method == MODTIME || method == SIZEMODTIME
+}
+
+// fingerprintOptions describes how the change indicators are calculated.
+type fingerprintOptions struct {
+	method ChangeIndicator
+	// includeMode adds the indicator file's permission bits to the change indicator.
+	includeMode bool
+	// includeOwner adds the indicator file's owner (uid:gid) to the change indicator.
+	includeOwner bool
+	// readers is the number of indicator files read in parallel, the number of CPUs if 0.
+	readers int
+	// git resolves the git blob hashes with the GITBLOB method.
+	git *gitIndex
+}
+
+// result stores how the keys are different in two cache descriptor.
+type result struct {
+	removedIgnored []string
+	removed        []string
+	changed        []string
+	matching       []string
+	addedIgnored   []string
+	added          []string
+}
+
+// hasChanges reports whether a new cache needs to be generated or not.
+func (r result) hasChanges() bool {
+	return len(r.removed) > 0 || len(r.changed) > 0 || len(r.added) > 0
+}
+
+// compare compares two cache descriptor file and return the differences.
+func compare(old map[string]string, new map[string]string) result {
+	newCopy := make(map[string]string, len(new))
+	for k, v := range new {
+		newCopy[k] = v
+	}
+
+	var result result
+	for oldPth, oldIndicator := range old {
+		newIndicator, ok := newCopy[oldPth]
+		switch {
+		case !ok && oldIndicator == "-":
+			result.removedIgnored = append(result.removedIgnored, oldPth)
+		case !ok:
+			result.removed = append(result.removed, oldPth)
+		case oldIndicator != newIndicator:
+			result.changed = append(result.changed, oldPth)
+		default:
+			result.matching = append(result.matching, oldPth)
+		}
+
+		delete(newCopy, oldPth)
+	}
+
+	for newPth, newIndicator := range newCopy {
+		if newIndicator == "-" {
+			result.addedIgnored = append(result.addedIgnored, newPth)
+		} else {
+			result.added = append(result.added, newPth)
+		}
+	}
+
+	return result
+}
+
+// descriptorFailure is returned by cacheDescriptor if some of the indicator groups can not be fingerprinted,
+// the descriptor of the rest of the groups is returned with it.
+type descriptorFailure struct {
+	// paths are the cached paths of the failed groups.
+	paths []string
+	errs  []string
+}
+
+func (f *descriptorFailure) Error() string {
+	return fmt.Sprintf("failed to fingerprint %d indicator(s):\n%s", len(f.errs), strings.Join(f.errs, "\n"))
+}
+
+// cacheDescriptor creates a cache descriptor for a given change_indicator_path - cache_path (single-multiple) mapping.
+// The paths sharing the same indicator form a group, the groups are fingerprinted concurrently.
+// A failing group does not stop the others: the descriptor of the rest of the groups is returned
+// with a *descriptorFailure listing every failed group's error and paths.
+func cacheDescriptor(ctx context.Context, pathToIndicatorFile map[string]string, opts fingerprintOptions, hashes *hashCache) (map[string]string, error) {
+	indicatorToPaths := map[string][]string{}
+	for path, indicatorPath := range pathToIndicatorFile {
+		indicatorToPaths[indicatorPath] = append(indicatorToPaths[indicatorPath], path)
+	}
+
+	type groupResult struct {
+		indicatorPath string
+		indicator     string
+		err           error
+	}
+
+	indicatorPaths := make(chan string)
+	results := make(chan groupResult)
+
+	workers := opts.readers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(indicatorToPaths) {
+		workers = len(indicatorToPaths)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indicatorPath := range indicatorPaths {
+				if err := ctx.Err(); err != nil {
+					results <- groupResult{indicatorPath: indicatorPath, err: err}
+					continue
+				}
+
+				indicator, err := fingerprint(indicatorPath, opts, hashes)
+				results <- groupResult{indicatorPath: indicatorPath, indicator: indicator, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for indicatorPath := range indicatorToPaths {
+			indicatorPaths <- indicatorPath
+		}
+		close(indicatorPaths)
+		wg.Wait()
+		close(results)
+	}()
+
+	pathToIndicator := map[string]string{}
+	var failure descriptorFailure
+	for res := range results {
+		if res.err != nil {
+			failure.errs = append(failure.errs, res.err.Error())
+			failure.paths = append(failure.paths, indicatorToPaths[res.indicatorPath]...)
+			continue
+		}
+
+		for _, path := range indicatorToPaths[res.indicatorPath] {
+			pathToIndicator[path] = res.indicator
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(failure.errs) > 0 {
+		sort.Strings(failure.errs)
+		sort.Strings(failure.paths)
+		return pathToIndicator, &failure
+	}
+	return pathToIndicator, nil
+}
+
+// withoutFailedPaths returns the cache paths without the ones which could not be fingerprinted, logging the failure.
+// Any other error is returned as is.
+func withoutFailedPaths(pathToIndicatorPath map[string]string, err error) (map[string]string, error) {
+	var failure *descriptorFailure
+	if !errors.As(err, &failure) {
+		return pathToIndicatorPath, err
+	}
+
+	log.Warnf("%s", failure)
+	log.Warnf("%d paths depending on these indicators are left out of the cache", len(failure.paths))
+	failed := map[string]bool{}
+	for _, pth := range failure.paths {
+		failed[pth] = true
+	}
+	kept := map[string]string{}
+	for pth, indicator := range pathToIndicatorPath {
+		if !failed[pth] {
+			kept[pth] = indicator
+		}
+	}
+	return kept, nil
+}
+
+// fingerprint returns the change indicator of a group of cached paths.
+func fingerprint(indicatorPath string, opts fingerprintOptions, hashes *hashCache) (string, error) {
+	if len(indicatorPath) == 0 {
+		// this file's changes does not invalidate existing cache
+		return "-", nil
+	}
+
+	var indicator string
+	var err error
+	if _, ok := contentHashMethods[opts.method]; ok {
+		indicator, err = hashes.digest(indicatorPath, opts.method)
+	} else if opts.method == SIZEMODTIME {
+		indicator, err = fileSizeAndModtime(indicatorPath)
+	} else if opts.method == GITBLOB {
+		indicator, err = opts.git.blobHash(indicatorPath)
+	} else {
+		indicator, err = fileModtime(indicatorPath)
+	}
+	if err != nil || (!opts.includeMode && !opts.includeOwner) {
+		return indicator, err
+	}
+
+	info, err := os.Stat(indicatorPath)
+	if err != nil {
+		return "", err
+	}
+	if opts.includeMode {
+		indicator += fmt.Sprintf(";mode=%04o", info.Mode().Perm())
+	}
+	if opts.includeOwner {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			indicator += fmt.Sprintf(";owner=%d:%d", stat.Uid, stat.Gid)
+		}
+	}
+	return indicator, nil
+}
+
+// indicatorContentHashes returns the content hash of every indicator file.
+func indicatorContentHashes(pathToIndicatorPath map[string]string, hashes *hashCache) (map[string]string, error) {
+	hashByIndicator := map[string]string{}
+	for _, indicator := range pathToIndicatorPath {
+		if indicator == "" {
+			continue
+		}
+		if _, ok := hashByIndicator[indicator]; ok {
+			continue
+		}
+
+		hash, err := hashes.contentHash(indicator)
+		if err != nil {
+			return nil, err
+		}
+		hashByIndicator[indicator] = hash
+	}
+	return hashByIndicator, nil
+}
+
+// verifyChangesByContent moves the changed paths, whose indicator's content hash matches the previous one, to the matching paths.
+// It is used with the mod-time method to ignore files rewritten with identical content.
+func verifyChangesByContent(changes result, pathToIndicatorPath, prevHashByIndicator map[string]string, hashes *hashCache) (result, error) {
+	var changed []string
+	for _, pth := range changes.changed {
+		indicator := pathToIndicatorPath[pth]
+		prevHash, ok := prevHashByIndicator[indicator]
+		if indicator == "" || !ok {
+			changed = append(changed, pth)
+			continue
+		}
+
+		hash, err := hashes.contentHash(indicator)
+		if err != nil {
+			return result{}, err
+		}
+
+		if hash == prevHash {
+			changes.matching = append(changes.matching, pth)
+		} else {
+			changed = append(changed, pth)
+		}
+	}
+	changes.changed = changed
+
+	return changes, nil
+}
+
+// auditMatching re-hashes a random sample of the matching paths' indicators and compares them to the previous content hashes.
+// It is used with the mod-time method to detect content changes which did not update the mod time.
+// The mismatching paths are moved to the changed paths, the number of audited indicators is returned.
+func auditMatching(changes result, pathToIndicatorPath, prevHashByIndicator map[string]string, hashes *hashCache, percent int, rnd *rand.Rand) (result, int, error) {
+	indicatorToPaths := map[string][]string{}
+	for _, pth := range changes.matching {
+		indicator := pathToIndicatorPath[pth]
+		if _, ok := prevHashByIndicator[indicator]; indicator == "" || !ok {
+			continue
+		}
+		indicatorToPaths[indicator] = append(indicatorToPaths[indicator], pth)
+	}
+
+	indicators := make([]string, 0, len(indicatorToPaths))
+	for indicator := range indicatorToPaths {
+		indicators = append(indicators, indicator)
+	}
+	sort.Strings(indicators)
+	rnd.Shuffle(len(indicators), func(i, j int) { indicators[i], indicators[j] = indicators[j], indicators[i] })
+
+	sampleSize := (len(indicators)*percent + 99) / 100
+	mismatching := map[string]bool{}
+	for _, indicator := range indicators[:sampleSize] {
+		hash, err := hashes.contentHash(indicator)
+		if err != nil {
+			return result{}, 0, err
+		}
+
+		if hash != prevHashByIndicator[indicator] {
+			for _, pth := range indicatorToPaths[indicator] {
+				mismatching[pth] = true
+			}
+		}
+	}
+
+	var matching []string
+	for _, pth := range changes.matching {
+		if mismatching[pth] {
+			changes.changed = append(changes.changed, pth)
+		} else {
+			matching = append(matching, pth)
+		}
+	}
+	changes.matching = matching
+
+	return changes, sampleSize, nil
+}
+
+// fileContentHash returns file's md5 content hash.
+func fileContentHash(pth string) (string, error) {
+	return fileDigest(pth, MD5)
+}
+
+// fileDigest returns file's content hash calculated with the given content hash method.
+func fileDigest(pth string, method ChangeIndicator) (string, error) {
+	newHash, ok := contentHashMethods[method]
+	if !ok {
+		return "", fmt.Errorf("unknown content hash method: %s", method)
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Errorf("Failed to close file (%s), error: %+v", pth, err)
+		}
+	}()
+
+	// #nosec G401 Ignore gosec warning: Use of weak cryptographic primitive
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// fileModtime returns a file's modtime as a Unix timestamp representation.
+func fileModtime(pth string) (string, error) {
+	fi, err := os.Stat(pth)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", fi.ModTime().Unix()), nil
+}
+
+// fileSizeAndModtime returns a file's modtime as a Unix timestamp representation followed by its size,
+// so an in-place rewrite changing the size is detected even if the mod time is preserved.
+func fileSizeAndModtime(pth string) (string, error) {
+	fi, err := os.Stat(pth)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d;size=%d", fi.ModTime().Unix(), fi.Size()), nil
+}
+
+// descriptorFingerprint returns the hex encoded SHA-256 hash of the cache descriptor's entries.
+func descriptorFingerprint(descriptor map[string]string) string {
+	h := sha256.New()
+	for _, key := range sortedKeys(descriptor) {
+		h.Write([]byte(key + "\x00" + descriptor[key] + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,502 @@
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Test_cacheDescriptorModTime(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	pths := map[string]string{
+		filepath.Join(tmpDir, "subdir", "file1"): "some content",
+	}
+
+	// store time frame to test mod time change indicator method
+	start := time.Now()
+	// start and end dates needs to be rounded to second, since file info stores modtime in second precision
+	start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	createDirStruct(t, pths)
+	end := time.Now()
+	end = time.Date(end.Year(), end.Month(), end.Day(), end.Hour(), end.Minute(), end.Second(), 0, end.Location())
+
+	t.Log("mod time method")
+	{
+		descriptor, err := cacheDescriptor(context.Background(), map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join(tmpDir, "subdir", "file1")}, fingerprintOptions{method: MODTIME}, newHashCache())
+		if err != nil {
+			t.Errorf("cacheDescriptor() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		if len(descriptor) != 1 {
+			t.Errorf("want 1 descriptor item, got: %d", len(descriptor))
+			return
+		}
+
+		for _, modTimeStr := range descriptor {
+			modTime, err := strconv.Atoi(modTimeStr)
+			if err != nil {
+				t.Errorf("failed to int parse: %s, error: %s", modTimeStr, err)
+				return
+			}
+			mod := time.Unix(int64(modTime), 0)
+			if start.Before(mod) || end.After(mod) {
+				t.Errorf("invalid modtime (%v) should be > %v && < %v", mod, start, end)
+				return
+			}
+		}
+	}
+}
+
+func Test_cacheDescriptor(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	pths := map[string]string{
+		filepath.Join(tmpDir, "subdir", "file1"): "some content",
+		filepath.Join(tmpDir, "subdir", "file2"): "",
+	}
+
+	createDirStruct(t, pths)
+
+	tests := []struct {
+		name                string
+		indicatorByCachePth map[string]string
+		method              ChangeIndicator
+		descriptor          map[string]string
+		wantErr             bool
+	}{
+		{
+			name:                "no change indicator",
+			indicatorByCachePth: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): ""},
+			method:              MD5,
+			descriptor:          map[string]string{filepath.Join(tmpDir, "subdir", "file1"): "-"},
+			wantErr:             false,
+		},
+		{
+			name:                "content hash method",
+			indicatorByCachePth: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join(tmpDir, "subdir", "file2")},
+			method:              MD5,
+			descriptor:          map[string]string{filepath.Join(tmpDir, "subdir", "file1"): "d41d8cd98f00b204e9800998ecf8427e"}, // empty string MD5 hash
+			wantErr:             false,
+		},
+		{
+			name:                "xxh64 content hash method",
+			indicatorByCachePth: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join(tmpDir, "subdir", "file2")},
+			method:              XXH64,
+			descriptor:          map[string]string{filepath.Join(tmpDir, "subdir", "file1"): "ef46db3751d8e999"}, // empty string XXH64 hash
+			wantErr:             false,
+		},
+		{
+			name:                "blake3 content hash method",
+			indicatorByCachePth: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join(tmpDir, "subdir", "file2")},
+			method:              BLAKE3,
+			descriptor:          map[string]string{filepath.Join(tmpDir, "subdir", "file1"): "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"}, // empty string BLAKE3 hash
+			wantErr:             false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			descriptor, err := cacheDescriptor(context.Background(), tt.indicatorByCachePth, fingerprintOptions{method: tt.method}, newHashCache())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cacheDescriptor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(descriptor, tt.descriptor) {
+				t.Errorf("cacheDescriptor() = %v, want %v", descriptor, tt.descriptor)
+			}
+		})
+	}
+}
+
+func Test_cacheDescriptor_failingGroups(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file1"): "some content"})
+
+	missing1 := filepath.Join(tmpDir, "missing1")
+	missing2 := filepath.Join(tmpDir, "missing2")
+	indicatorByCachePth := map[string]string{
+		filepath.Join(tmpDir, "file1"):   filepath.Join(tmpDir, "file1"),
+		filepath.Join(tmpDir, "cached1"): missing1,
+		filepath.Join(tmpDir, "cached2"): missing2,
+	}
+
+	descriptor, err := cacheDescriptor(context.Background(), indicatorByCachePth, fingerprintOptions{method: MD5}, newHashCache())
+	if err == nil {
+		t.Fatalf("cacheDescriptor() expected error")
+	}
+	for _, missing := range []string{missing1, missing2} {
+		if !strings.Contains(err.Error(), missing) {
+			t.Errorf("cacheDescriptor() error = %v, should report %s", err, missing)
+		}
+	}
+	require.Equal(t, []string{filepath.Join(tmpDir, "file1")}, sortedKeys(descriptor))
+
+	kept, err := withoutFailedPaths(indicatorByCachePth, err)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{filepath.Join(tmpDir, "file1"): filepath.Join(tmpDir, "file1")}, kept)
+
+	_, err = withoutFailedPaths(indicatorByCachePth, context.Canceled)
+	require.Equal(t, context.Canceled, err)
+}
+
+func Test_compare(t *testing.T) {
+	tests := []struct {
+		name string
+		old  map[string]string
+		new  map[string]string
+		want result
+	}{
+		{
+			name: "empty test",
+			old:  map[string]string{},
+			new:  map[string]string{},
+			want: result{},
+		},
+		{
+			name: "removed",
+			old:  map[string]string{"pth": "indicator"},
+			new:  map[string]string{},
+			want: result{removed: []string{"pth"}},
+		},
+		{
+			name: "ignored removed",
+			old:  map[string]string{"pth": "-"},
+			new:  map[string]string{},
+			want: result{removedIgnored: []string{"pth"}},
+		},
+		{
+			name: "changed",
+			old:  map[string]string{"pth": "indicator1"},
+			new:  map[string]string{"pth": "indicator2"},
+			want: result{changed: []string{"pth"}},
+		},
+		{
+			name: "matching",
+			old:  map[string]string{"pth": "indicator"},
+			new:  map[string]string{"pth": "indicator"},
+			want: result{matching: []string{"pth"}},
+		},
+		{
+			name: "added",
+			old:  map[string]string{},
+			new:  map[string]string{"pth": "indicator"},
+			want: result{added: []string{"pth"}},
+		},
+		{
+			name: "ignored added",
+			old:  map[string]string{},
+			new:  map[string]string{"pth": "-"},
+			want: result{addedIgnored: []string{"pth"}},
+		},
+		{
+			name: "complex",
+			old: map[string]string{
+				"removedPth":        "indicator",
+				"ignoredRemovedPth": "-",
+				"changed":           "indicator1",
+				"matching":          "indicator",
+				// "added":             "indicator",
+				// "ignoredAdded":      "-",
+			},
+			new: map[string]string{
+				// "removedPth":        "indicator",
+				// "ignoredRemovedPth": "-",
+				"changed":      "indicator2",
+				"matching":     "indicator",
+				"added":        "indicator",
+				"ignoredAdded": "-",
+			},
+			want: result{
+				removed:        []string{"removedPth"},
+				removedIgnored: []string{"ignoredRemovedPth"},
+				changed:        []string{"changed"},
+				matching:       []string{"matching"},
+				added:          []string{"added"},
+				addedIgnored:   []string{"ignoredAdded"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotR := compare(tt.old, tt.new); !reflect.DeepEqual(gotR, tt.want) {
+				t.Errorf("compare() = %v, want %v", gotR, tt.want)
+			}
+		})
+	}
+}
+
+func Test_result_hasChanges(t *testing.T) {
+	tests := []struct {
+		name            string
+		removedIgnored  []string
+		removed         []string
+		changed         []string
+		matching        []string
+		addedIgnored    []string
+		added           []string
+		triggerNewCache bool
+	}{
+		// do not trigger new cache
+		{
+			name:            "empty",
+			triggerNewCache: false,
+		},
+		{
+			name:            "ignored removed",
+			removedIgnored:  []string{"pth"},
+			triggerNewCache: false,
+		},
+		{
+			name:            "matching",
+			matching:        []string{"pth"},
+			triggerNewCache: false,
+		},
+		{
+			name:            "ignored added",
+			addedIgnored:    []string{"pth"},
+			triggerNewCache: false,
+		},
+		// trigger new cache
+		{
+			name:            "removed",
+			removed:         []string{"pth"},
+			triggerNewCache: true,
+		},
+		{
+			name:            "changed",
+			changed:         []string{"pth"},
+			triggerNewCache: true,
+		},
+		{
+			name:            "added",
+			added:           []string{"pth"},
+			triggerNewCache: true,
+		},
+		{
+			name:            "complex",
+			removedIgnored:  []string{"pth"},
+			removed:         []string{"pth"},
+			changed:         []string{"pth"},
+			matching:        []string{"pth"},
+			addedIgnored:    []string{"pth"},
+			added:           []string{"pth"},
+			triggerNewCache: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := result{
+				removedIgnored: tt.removedIgnored,
+				removed:        tt.removed,
+				changed:        tt.changed,
+				matching:       tt.matching,
+				addedIgnored:   tt.addedIgnored,
+				added:          tt.added,
+			}
+			if got := r.hasChanges(); got != tt.triggerNewCache {
+				t.Errorf("result.triggerNewCache() = %v, want %v", got, tt.triggerNewCache)
+			}
+		})
+	}
+}
+
+func Test_verifyChangesByContent(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+
+	same := filepath.Join(tmpDir, "same")
+	different := filepath.Join(tmpDir, "different")
+	unknown := filepath.Join(tmpDir, "unknown")
+	createDirStruct(t, map[string]string{same: "same", different: "different", unknown: "unknown"})
+
+	hashCache := newHashCache()
+	hashes, err := indicatorContentHashes(map[string]string{same: same, different: different}, hashCache)
+	if err != nil {
+		t.Fatalf("indicatorContentHashes() error = %v", err)
+	}
+	hashes[different] = "outdated"
+
+	changes := result{changed: []string{same, different, unknown}}
+	pathToIndicatorPath := map[string]string{same: same, different: different, unknown: unknown}
+
+	got, err := verifyChangesByContent(changes, pathToIndicatorPath, hashes, hashCache)
+	if err != nil {
+		t.Fatalf("verifyChangesByContent() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.changed, []string{different, unknown}) {
+		t.Errorf("verifyChangesByContent() changed = %v", got.changed)
+	}
+	if !reflect.DeepEqual(got.matching, []string{same}) {
+		t.Errorf("verifyChangesByContent() matching = %v", got.matching)
+	}
+}
+
+func Test_auditMatching(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	same := filepath.Join(tmpDir, "same")
+	rewritten := filepath.Join(tmpDir, "rewritten")
+	createDirStruct(t, map[string]string{same: "", rewritten: "new content"})
+
+	pathToIndicatorPath := map[string]string{same: same, rewritten: rewritten, "ignored": ""}
+	prevHashByIndicator := map[string]string{
+		same:      "d41d8cd98f00b204e9800998ecf8427e", // empty string MD5 hash
+		rewritten: "d41d8cd98f00b204e9800998ecf8427e",
+	}
+	changes := result{matching: []string{same, rewritten, "ignored"}}
+
+	got, audited, err := auditMatching(changes, pathToIndicatorPath, prevHashByIndicator, newHashCache(), 100, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("auditMatching() error = %v", err)
+	}
+	if audited != 2 {
+		t.Errorf("auditMatching() audited = %d, want 2", audited)
+	}
+	want := result{matching: []string{same, "ignored"}, changed: []string{rewritten}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("auditMatching() = %v, want %v", got, want)
+	}
+
+	_, audited, err = auditMatching(changes, pathToIndicatorPath, prevHashByIndicator, newHashCache(), 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("auditMatching() error = %v", err)
+	}
+	if audited != 0 {
+		t.Errorf("auditMatching() audited = %d, want 0", audited)
+	}
+}
+
+func Test_fingerprint_includeMode(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	pth := filepath.Join(tmpDir, "gradlew")
+	createDirStruct(t, map[string]string{pth: ""})
+	if err := os.Chmod(pth, 0644); err != nil {
+		t.Fatalf("failed to chmod: %s", err)
+	}
+
+	opts := fingerprintOptions{method: MD5, includeMode: true}
+	before, err := fingerprint(pth, opts, newHashCache())
+	if err != nil {
+		t.Fatalf("fingerprint() error = %v", err)
+	}
+	if want := "d41d8cd98f00b204e9800998ecf8427e;mode=0644"; before != want {
+		t.Errorf("fingerprint() = %s, want %s", before, want)
+	}
+
+	if err := os.Chmod(pth, 0755); err != nil {
+		t.Fatalf("failed to chmod: %s", err)
+	}
+	after, err := fingerprint(pth, opts, newHashCache())
+	if err != nil {
+		t.Fatalf("fingerprint() error = %v", err)
+	}
+	if before == after {
+		t.Errorf("fingerprint() should change on permission change: %s", after)
+	}
+
+	withoutMode, err := fingerprint(pth, fingerprintOptions{method: MD5}, newHashCache())
+	if err != nil {
+		t.Fatalf("fingerprint() error = %v", err)
+	}
+	if want := "d41d8cd98f00b204e9800998ecf8427e"; withoutMode != want {
+		t.Errorf("fingerprint() = %s, want %s", withoutMode, want)
+	}
+}
+
+func Test_fingerprint_sizeAndModTime(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	pth := filepath.Join(tmpDir, "Podfile.lock")
+	createDirStruct(t, map[string]string{pth: "content"})
+	modTime := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(pth, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mod time: %s", err)
+	}
+
+	opts := fingerprintOptions{method: SIZEMODTIME}
+	before, err := fingerprint(pth, opts, newHashCache())
+	if err != nil {
+		t.Fatalf("fingerprint() error = %v", err)
+	}
+	if want := fmt.Sprintf("%d;size=7", modTime.Unix()); before != want {
+		t.Errorf("fingerprint() = %s, want %s", before, want)
+	}
+
+	// in-place rewrite preserving the mod time
+	if err := ioutil.WriteFile(pth, []byte("new content"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Chtimes(pth, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mod time: %s", err)
+	}
+
+	after, err := fingerprint(pth, opts, newHashCache())
+	if err != nil {
+		t.Fatalf("fingerprint() error = %v", err)
+	}
+	if before == after {
+		t.Errorf("fingerprint() should change on size change: %s", after)
+	}
+
+	modTimeOnly, err := fingerprint(pth, fingerprintOptions{method: MODTIME}, newHashCache())
+	if err != nil {
+		t.Fatalf("fingerprint() error = %v", err)
+	}
+	if want := fmt.Sprintf("%d", modTime.Unix()); modTimeOnly != want {
+		t.Errorf("fingerprint() = %s, want %s", modTimeOnly, want)
+	}
+}
+
+func Test_descriptorFingerprint(t *testing.T) {
+	descriptor := map[string]string{"/cache/a": "1", "/cache/b": "-"}
+	fingerprint := descriptorFingerprint(descriptor)
+	if len(fingerprint) != 64 {
+		t.Fatalf("descriptorFingerprint() = %s, want a sha256 hex digest", fingerprint)
+	}
+	if got := descriptorFingerprint(map[string]string{"/cache/b": "-", "/cache/a": "1"}); got != fingerprint {
+		t.Errorf("descriptorFingerprint() depends on the map order: %s != %s", got, fingerprint)
+	}
+	if got := descriptorFingerprint(map[string]string{"/cache/a": "2", "/cache/b": "-"}); got == fingerprint {
+		t.Errorf("descriptorFingerprint() did not change with the indicator")
+	}
+}
@@ -0,0 +1,50 @@
+// Cache API endpoint failover related functions.
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// parseEndpoints splits the comma separated cache API url list.
+func parseEndpoints(urls string) []string {
+	var endpoints []string
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			endpoints = append(endpoints, url)
+		}
+	}
+	return endpoints
+}
+
+// uploadArchiveWithFailover uploads the archive to the first healthy endpoint:
+// the endpoints are tried in order, an endpoint failing to provide an upload url or to receive the archive is skipped.
+func uploadArchiveWithFailover(ctx context.Context, pth string, endpoints []string, buildSlug string, layerID string, info model.ArchiveInfo, retry uploadRetry) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no cache API url provided")
+	}
+
+	var errs []string
+	for i, endpoint := range endpoints {
+		if i > 0 {
+			log.Warnf("Failing over to cache API endpoint %d/%d: %s", i+1, len(endpoints), redactURL(endpoint))
+		}
+
+		err := uploadArchive(ctx, pth, endpoint, buildSlug, layerID, info, retry)
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		log.Warnf("Upload to cache API endpoint (%s) failed: %s", redactURL(endpoint), err)
+		errs = append(errs, fmt.Sprintf("%s: %s", redactURL(endpoint), err))
+	}
+
+	return fmt.Errorf("upload failed to every cache API endpoint:\n%s", strings.Join(errs, "\n"))
+}
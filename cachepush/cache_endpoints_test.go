@@ -0,0 +1,72 @@
+package cachepush
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseEndpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		urls string
+		want []string
+	}{
+		{
+			name: "single endpoint",
+			urls: "https://cache.bitrise.io",
+			want: []string{"https://cache.bitrise.io"},
+		},
+		{
+			name: "multiple endpoints",
+			urls: "https://primary.cache , https://backup.cache,",
+			want: []string{"https://primary.cache", "https://backup.cache"},
+		},
+		{
+			name: "empty",
+			urls: " ",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseEndpoints(tt.urls))
+		})
+	}
+}
+
+func Test_uploadArchiveWithFailover(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	archive := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{archive: "archive"})
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer unhealthy.Close()
+
+	uploaded := false
+	var healthy *httptest.Server
+	healthy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, err := w.Write([]byte(`{"upload_url": "` + healthy.URL + `/upload"}`))
+			require.NoError(t, err)
+			return
+		}
+		uploaded = true
+	}))
+	defer healthy.Close()
+
+	require.NoError(t, uploadArchiveWithFailover(context.Background(), archive, []string{unhealthy.URL, healthy.URL}, "", "", model.ArchiveInfo{}, uploadRetry{}))
+	require.True(t, uploaded)
+
+	require.Error(t, uploadArchiveWithFailover(context.Background(), archive, nil, "", "", model.ArchiveInfo{}, uploadRetry{}))
+}
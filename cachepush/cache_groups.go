@@ -0,0 +1,195 @@
+// Cache group related functions.
+//
+// Cache groups split the cache into independent archives, each group has its own path list, fingerprint and archive,
+// so a change in one group (for example the Pods) does not re-upload the others (for example the Gradle cache).
+// The metadata files of a group archive (cache info, meta, layers, ...) have the group name in their file name,
+// so the archives of the different groups can be pulled side by side.
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+)
+
+var cacheGroupNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// cacheGroup is a named set of cache paths.
+type cacheGroup struct {
+	name  string
+	paths []string
+}
+
+// parseCacheGroups parses the cache group list.
+// A group starts with a `name:` line, followed by its indented cache paths, for example:
+//
+//	gradle:
+//	  ~/.gradle/caches
+//	pods:
+//	  ./Pods -> ./Podfile.lock
+//	gradle-volume:
+//	  docker-volume:gradle-cache
+func parseCacheGroups(list string) ([]cacheGroup, error) {
+	var groups []cacheGroup
+	seen := map[string]bool{}
+	for i, line := range strings.Split(list, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			name := strings.TrimSpace(line)
+			if !strings.HasSuffix(name, ":") {
+				return nil, fmt.Errorf("line %d: group definition (%s) has to end with a colon", i+1, name)
+			}
+			name = strings.TrimSuffix(name, ":")
+			if !cacheGroupNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("line %d: invalid group name (%s), use lowercase letters, digits, - and _", i+1, name)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("line %d: group (%s) is defined multiple times", i+1, name)
+			}
+			seen[name] = true
+			groups = append(groups, cacheGroup{name: name})
+			continue
+		}
+
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("line %d: cache path (%s) is not in a group", i+1, strings.TrimSpace(line))
+		}
+		groups[len(groups)-1].paths = append(groups[len(groups)-1].paths, strings.TrimSpace(line))
+	}
+
+	for _, group := range groups {
+		if len(group.paths) == 0 {
+			return nil, fmt.Errorf("group (%s) has no cache paths", group.name)
+		}
+	}
+	return groups, nil
+}
+
+// groupEndpoints returns the endpoints of the group's archive: file://, s3:// and ssh:// destinations get the group's name
+// in their file name, the cache API (and the local directory backend) receives the group in the archive info instead.
+func groupEndpoints(endpoints []string, group string) []string {
+	if group == "" {
+		return endpoints
+	}
+
+	var grouped []string
+	for _, endpoint := range endpoints {
+		if (strings.HasPrefix(endpoint, "file://") && !isLocalDirectory(endpoint)) || strings.HasPrefix(endpoint, s3Scheme) || strings.HasPrefix(endpoint, sshScheme) {
+			endpoint = cachecommon.GroupPath(endpoint, group)
+		}
+		grouped = append(grouped, endpoint)
+	}
+	return grouped
+}
+
+// groupConfig returns the config pushing the given group's paths and docker volumes.
+// The read-only paths belong to the default cache.
+func groupConfig(configs Config, group cacheGroup) Config {
+	paths, volumes := splitDockerVolumeItems(group.paths)
+	configs.Paths = strings.Join(paths, "\n")
+	configs.DockerVolumes = strings.Join(volumes, "\n")
+	configs.ReadonlyPaths = ""
+	configs.CacheGroups = ""
+	configs.DetectCommonCaches = false
+	return configs
+}
+
+// RunGroups pushes the default cache (if it has any paths) and every cache group concurrently,
+// so the step finishes in the time of the slowest group. Under a memory budget only as many caches are pushed
+// at the same time as their compressors fit, and the budget is split evenly between them.
+// A failing group does not stop pushing the rest of the groups, the failures are returned together, sorted by group.
+// The caches share the environment set up from the config, like in Run.
+func RunGroups(ctx context.Context, configs Config) (map[string]Report, error) {
+	groups, err := parseCacheGroups(configs.CacheGroups)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache groups: %w", err)
+	}
+
+	ctx, restore, err := startRun(ctx, configs)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	type cacheRun struct {
+		group   string
+		configs Config
+	}
+	var runs []cacheRun
+	if strings.TrimSpace(configs.Paths) != "" || strings.TrimSpace(configs.DockerVolumes) != "" || configs.DetectCommonCaches {
+		runs = append(runs, cacheRun{configs: configs})
+	}
+	for _, group := range groups {
+		runs = append(runs, cacheRun{group: group.name, configs: groupConfig(configs, group)})
+	}
+	// every cache is compressed by its own compressor, as many caches run at the same time as the compressors fit
+	concurrency := memoryBudget{maxMB: configs.MaxMemoryMB}.workers(len(runs), compressorMemory)
+	if configs.MaxMemoryMB > 0 && concurrency > 1 {
+		for i := range runs {
+			runs[i].configs.MaxMemoryMB = configs.MaxMemoryMB / concurrency
+		}
+	}
+	slots := make(chan struct{}, concurrency)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	reports := map[string]Report{}
+	var errs []string
+	for _, run := range runs {
+		wg.Add(1)
+		go func(run cacheRun) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			name := run.group
+			if name == "" {
+				name = "default cache"
+			}
+			log.Infof("Pushing %s", cacheName(run.group))
+
+			report, err := runCache(ctx, run.configs, run.group)
+			if err != nil {
+				log.Errorf("Failed to push %s: %s", cacheName(run.group), err)
+			} else if report.Pushed {
+				log.Donef("Pushed %s", cacheName(run.group))
+			} else {
+				log.Printf("Not pushed %s: %s", cacheName(run.group), report.SkipReason)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			reports[run.group] = report
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+			}
+		}(run)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return reports, err
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return reports, fmt.Errorf("failed to push cache groups:\n%s", strings.Join(errs, "\n"))
+	}
+	return reports, nil
+}
+
+// cacheName returns the name of the cache in the logs.
+func cacheName(group string) string {
+	if group == "" {
+		return "the default cache"
+	}
+	return fmt.Sprintf("cache group (%s)", group)
+}
@@ -0,0 +1,199 @@
+package cachepush
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseCacheGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []cacheGroup
+		wantErr bool
+	}{
+		{name: "empty", list: "", want: nil},
+		{
+			name: "groups",
+			list: "gradle:\n  ~/.gradle/caches\n  ~/.gradle/wrapper\n\npods:\n\t./Pods -> ./Podfile.lock\n",
+			want: []cacheGroup{
+				{name: "gradle", paths: []string{"~/.gradle/caches", "~/.gradle/wrapper"}},
+				{name: "pods", paths: []string{"./Pods -> ./Podfile.lock"}},
+			},
+		},
+		{name: "missing colon", list: "gradle\n  ~/.gradle", wantErr: true},
+		{name: "invalid name", list: "Gradle Cache:\n  ~/.gradle", wantErr: true},
+		{name: "duplicated group", list: "gradle:\n  ~/.gradle\ngradle:\n  ~/.m2", wantErr: true},
+		{name: "path without group", list: "  ~/.gradle", wantErr: true},
+		{name: "group without paths", list: "gradle:\npods:\n  ./Pods", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCacheGroups(tt.list)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_groupEndpoints(t *testing.T) {
+	endpoints := []string{"https://cache.bitrise.io/upload", "file:///cache/cache.tar", "s3://bucket/cache.tar", "file:///local/", "ssh://files.internal/cache.tar"}
+
+	require.Equal(t, endpoints, groupEndpoints(endpoints, ""))
+	require.Equal(t, []string{"https://cache.bitrise.io/upload", "file:///cache/cache-pods.tar", "s3://bucket/cache-pods.tar", "file:///local/", "ssh://files.internal/cache-pods.tar"}, groupEndpoints(endpoints, "pods"))
+}
+
+func TestRunGroups(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	gradleDir := filepath.Join(tmpDir, "gradle")
+	podsDir := filepath.Join(tmpDir, "pods")
+	createDirStruct(t, map[string]string{
+		filepath.Join(gradleDir, "file"): "gradle",
+		filepath.Join(podsDir, "file"):   "pods",
+	})
+	defer func() {
+		for _, group := range []string{"gradle", "pods", "broken"} {
+			require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+		}
+	}()
+
+	var mu sync.Mutex
+	var groups []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body struct {
+				Group string `json:"cache_group"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			mu.Lock()
+			groups = append(groups, body.Group)
+			mu.Unlock()
+
+			if body.Group == "broken" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload"}`))
+			require.NoError(t, err)
+		}
+	}))
+	defer server.Close()
+
+	configs := Config{
+		CacheGroups:         "gradle:\n  " + gradleDir + "\npods:\n  " + podsDir,
+		CacheAPIURL:         server.URL,
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+	}
+
+	reports, err := RunGroups(context.Background(), configs)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(reports), "the default cache without paths is not pushed")
+	require.True(t, reports["gradle"].Pushed)
+	require.True(t, reports["pods"].Pushed)
+	sort.Strings(groups)
+	require.Equal(t, []string{"gradle", "pods"}, groups)
+
+	t.Log("a failing group does not stop the others")
+	{
+		groups = nil
+		configs.CacheGroups = "broken:\n  " + podsDir + "\ngradle:\n  " + gradleDir
+		configs.FailOnUploadError = true
+
+		reports, err := RunGroups(context.Background(), configs)
+		require.Error(t, err)
+		require.True(t, strings.HasPrefix(err.Error(), "failed to push cache groups:\nbroken: "), err.Error())
+		require.False(t, reports["broken"].Pushed)
+		require.Contains(t, reports, "gradle")
+		require.NotContains(t, err.Error(), "gradle:")
+	}
+
+	t.Log("a tight memory budget pushes the groups one by one")
+	{
+		groups = nil
+		configs.CacheGroups = "gradle:\n  " + gradleDir + "\npods:\n  " + podsDir
+		configs.MaxMemoryMB = 8
+
+		reports, err := RunGroups(context.Background(), configs)
+		require.NoError(t, err)
+		require.True(t, reports["gradle"].Pushed)
+		require.True(t, reports["pods"].Pushed)
+	}
+}
+
+func TestRunGroups_diagnostics(t *testing.T) {
+	defer func(d *diagnostics) { diag = d }(diag)
+	diag = &diagnostics{}
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	gradleDir := filepath.Join(tmpDir, "gradle")
+	podsDir := filepath.Join(tmpDir, "pods")
+	createDirStruct(t, map[string]string{
+		filepath.Join(gradleDir, "file"): "gradle",
+		filepath.Join(podsDir, "file"):   "pods",
+	})
+	defer func() {
+		for _, group := range []string{"gradle", "pods"} {
+			require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+		}
+	}()
+
+	configs := Config{
+		CacheGroups:         "gradle:\n  " + gradleDir + "\npods:\n  " + podsDir,
+		CacheAPIURL:         "file://" + filepath.Join(tmpDir, "store") + "/",
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+		CollectDiagnostics:  true,
+		DeployDir:           filepath.Join(tmpDir, "deploy"),
+	}
+	_, err = RunGroups(context.Background(), configs)
+	require.NoError(t, err)
+
+	// the groups are pushed concurrently, each group's entries are kept
+	require.Contains(t, diag.entries["gradle/indicator_by_cache_path"], gradleDir)
+	require.NotContains(t, diag.entries["gradle/indicator_by_cache_path"], podsDir)
+	require.Contains(t, diag.entries["pods/indicator_by_cache_path"], podsDir)
+
+	pth, err := diag.write()
+	require.NoError(t, err)
+	reader, err := zip.OpenReader(pth)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reader.Close()) }()
+	names := map[string]bool{}
+	for _, f := range reader.File {
+		names[f.Name] = true
+	}
+	require.True(t, names["gradle/config.json"])
+	require.True(t, names["pods/config.json"])
+
+	var timings []string
+	for _, timing := range diag.timings {
+		timings = append(timings, timing.Phase)
+	}
+	require.Contains(t, timings, "gradle/total")
+	require.Contains(t, timings, "pods/total")
+}
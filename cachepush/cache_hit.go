@@ -0,0 +1,45 @@
+// Cache hit related functions.
+//
+// The cache-pull step writes the cachePullMarkerFileName marker file (in the working directory) with the result of the pull (hit or miss).
+// Older cache-pull versions do not write the marker, for those a restored cache descriptor means a hit.
+package cachepush
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	cachePullMarkerFileName = "cache-pull-result"
+
+	cachePullHit  = "hit"
+	cachePullMiss = "miss"
+)
+
+// readCacheHit returns whether the cache-pull step restored the cache of the group.
+func readCacheHit(markerPth, descriptorPth string) (bool, error) {
+	data, err := ioutil.ReadFile(markerPth)
+	if err == nil {
+		switch result := strings.TrimSpace(string(data)); result {
+		case cachePullHit:
+			return true, nil
+		case cachePullMiss:
+			return false, nil
+		default:
+			return false, fmt.Errorf("unknown cache pull result (%s) in %s", result, markerPth)
+		}
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if _, err := os.Stat(descriptorPth); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,41 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readCacheHit(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	marker := filepath.Join(tmpDir, "cache-pull-result")
+	descriptor := filepath.Join(tmpDir, "cache-info.json")
+
+	hit, err := readCacheHit(marker, descriptor)
+	require.NoError(t, err)
+	require.False(t, hit)
+
+	// older cache-pull versions only restore the descriptor
+	createDirStruct(t, map[string]string{descriptor: "{}"})
+	hit, err = readCacheHit(marker, descriptor)
+	require.NoError(t, err)
+	require.True(t, hit)
+
+	createDirStruct(t, map[string]string{marker: "miss\n"})
+	hit, err = readCacheHit(marker, descriptor)
+	require.NoError(t, err)
+	require.False(t, hit)
+
+	createDirStruct(t, map[string]string{marker: "hit"})
+	hit, err = readCacheHit(marker, descriptor)
+	require.NoError(t, err)
+	require.True(t, hit)
+
+	createDirStruct(t, map[string]string{marker: "unknown"})
+	_, err = readCacheHit(marker, descriptor)
+	require.Error(t, err)
+}
@@ -0,0 +1,61 @@
+// Pre-archive hook related functions.
+//
+// The pre-archive hook is a user defined script receiving the normalized list of the cached paths on its standard input.
+// Every line it prints is an exclude pattern (same as an ignore item prefixed with `!`),
+// while a non-zero exit code fails the push (for example if a company policy is violated).
+package cachepush
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// runPreArchiveHook runs the hook script with the cached paths on its standard input
+// and returns the exclude patterns printed by the script.
+func runPreArchiveHook(script string, indicatorByCachePth map[string]string) ([]ignorePattern, error) {
+	paths := make([]string, 0, len(indicatorByCachePth))
+	for pth := range indicatorByCachePth {
+		paths = append(paths, pth)
+	}
+	sort.Strings(paths)
+
+	var stdout bytes.Buffer
+	cmd := command.New("bash", "-c", script).
+		SetStdin(strings.NewReader(strings.Join(paths, "\n") + "\n")).
+		SetStdout(&stdout).
+		SetStderr(os.Stderr)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pre-archive hook failed: %s", err)
+	}
+
+	var patterns []ignorePattern
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if pattern := strings.TrimSpace(line); pattern != "" {
+			patterns = append(patterns, ignorePattern{Pattern: pattern, Action: excludeFromCache})
+		}
+	}
+
+	return normalizeIgnorePatterns(patterns)
+}
+
+// applyHookExclusions removes the paths matching any of the hook's exclude patterns
+// and returns the removed paths in order.
+func applyHookExclusions(indicatorByCachePth map[string]string, patterns []ignorePattern) (map[string]string, []string) {
+	kept := map[string]string{}
+	var excluded []string
+	for pth, indicator := range indicatorByCachePth {
+		if action, _ := match(pth, patterns); action == excludeFromCache {
+			excluded = append(excluded, pth)
+			continue
+		}
+		kept[pth] = indicator
+	}
+
+	sort.Strings(excluded)
+	return kept, excluded
+}
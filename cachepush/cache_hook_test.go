@@ -0,0 +1,28 @@
+package cachepush
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runPreArchiveHook(t *testing.T) {
+	indicatorByCachePth := map[string]string{
+		"/cache/a.txt":   "",
+		"/cache/b.key":   "",
+		"/cache/dir/c.x": "",
+	}
+
+	patterns, err := runPreArchiveHook(`grep '\.key$'; echo "/cache/dir/"`, indicatorByCachePth)
+	require.NoError(t, err)
+	require.Len(t, patterns, 2)
+	require.Equal(t, ignorePattern{Pattern: "/cache/b.key", Action: excludeFromCache}, ignorePattern{Pattern: patterns[0].Pattern, Action: patterns[0].Action})
+	require.Equal(t, ignorePattern{Pattern: "/cache/dir/", Action: excludeFromCache}, ignorePattern{Pattern: patterns[1].Pattern, Action: patterns[1].Action})
+
+	kept, excluded := applyHookExclusions(indicatorByCachePth, patterns)
+	require.Equal(t, map[string]string{"/cache/a.txt": ""}, kept)
+	require.Equal(t, []string{"/cache/b.key", "/cache/dir/c.x"}, excluded)
+
+	_, err = runPreArchiveHook("echo 'policy violated' >&2; exit 1", indicatorByCachePth)
+	require.Error(t, err)
+}
@@ -0,0 +1,184 @@
+// Cache key related functions.
+//
+// The cache key is a text/template evaluated at the start of the step, for example:
+//
+//	gradle-{{ .Branch }}-{{ checksum "gradle/wrapper/gradle-wrapper.properties" }}
+//
+// Builds resolving the same key can share the cache, the resolved key is sent with the upload request.
+package cachepush
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bitrise-io/doublestar/v3"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// cacheKeyData is the data available in the cache key template.
+type cacheKeyData struct {
+	Branch       string
+	Workflow     string
+	StackID      string
+	OS           string
+	Architecture string
+	Group        string
+}
+
+// newCacheKeyData returns the cache key template data based on the build's environment.
+func newCacheKeyData(stackID, architecture, group string) cacheKeyData {
+	return cacheKeyData{
+		Branch:       os.Getenv("BITRISE_GIT_BRANCH"),
+		Workflow:     os.Getenv("BITRISE_TRIGGERED_WORKFLOW_ID"),
+		StackID:      stackID,
+		OS:           runtime.GOOS,
+		Architecture: architecture,
+		Group:        group,
+	}
+}
+
+var cacheKeyFuncs = template.FuncMap{
+	"checksum": checksumFiles,
+	"env":      os.Getenv,
+}
+
+// resolveCacheKey evaluates the cache key template, returns an empty key if the template is empty.
+func resolveCacheKey(tmpl string, data cacheKeyData) (string, error) {
+	tmpl = strings.TrimSpace(tmpl)
+	if tmpl == "" {
+		return "", nil
+	}
+
+	t, err := template.New("cache_key").Funcs(cacheKeyFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse cache key template: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to evaluate cache key template: %s", err)
+	}
+
+	key := strings.TrimSpace(b.String())
+	if key == "" {
+		return "", fmt.Errorf("cache key template (%s) resolved to an empty key", tmpl)
+	}
+	if strings.ContainsAny(key, "\n\r") {
+		return "", fmt.Errorf("cache key (%q) contains line breaks", key)
+	}
+	return key, nil
+}
+
+// checksumFiles returns the hex encoded sha256 checksum of the content of the files matching the given glob patterns.
+// The files are hashed in path order, so the checksum does not depend on the order of the patterns.
+func checksumFiles(patterns ...string) (string, error) {
+	if len(patterns) == 0 {
+		return "", fmt.Errorf("checksum requires at least one file")
+	}
+
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := doublestar.Glob(pattern, false)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern (%s): %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("no file matches %s", pattern)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, pth := range files {
+		info, err := os.Stat(pth)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		// the path is hashed too, so moving content between files changes the checksum
+		if _, err := fmt.Fprintf(h, "%s\x00", pth); err != nil {
+			return "", err
+		}
+		if err := hashFileInto(h, pth); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFileInto(w io.Writer, pth string) error {
+	file, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// resolveRestoreKeys evaluates the newline separated restore key templates, in order.
+func resolveRestoreKeys(list string, data cacheKeyData) ([]string, error) {
+	var keys []string
+	for _, tmpl := range strings.Split(list, "\n") {
+		if strings.TrimSpace(tmpl) == "" {
+			continue
+		}
+		key, err := resolveCacheKey(tmpl, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid restore key: %s", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// keyMatch describes how the previous cache's key relates to the current cache key and the restore keys.
+type keyMatch string
+
+const (
+	// exactKeyMatch: the previous cache was pushed with the current cache key.
+	exactKeyMatch keyMatch = "exact"
+	// restoreKeyMatch: the previous cache's key starts with one of the restore keys,
+	// the cache-pull step fell back to it as there was no cache for the current key.
+	restoreKeyMatch keyMatch = "restore_key"
+	// noKeyMatch: the previous cache belongs to an unrelated key.
+	noKeyMatch keyMatch = "none"
+)
+
+// matchCacheKey returns how the previous cache's key matches the current cache key,
+// the restore keys are checked in order (as prefixes, like the restore keys of other CI caches),
+// and the matching restore key if any.
+func matchCacheKey(prevKey, key string, restoreKeys []string) (keyMatch, string) {
+	if prevKey == key {
+		return exactKeyMatch, ""
+	}
+	for _, restoreKey := range restoreKeys {
+		if strings.HasPrefix(prevKey, restoreKey) {
+			return restoreKeyMatch, restoreKey
+		}
+	}
+	return noKeyMatch, ""
+}
@@ -0,0 +1,111 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveCacheKey(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	wrapperPth := filepath.Join(tmpDir, "gradle", "wrapper", "gradle-wrapper.properties")
+	createDirStruct(t, map[string]string{wrapperPth: "distributionUrl=gradle-7.0-bin.zip"})
+	checksum, err := checksumFiles(wrapperPth)
+	require.NoError(t, err)
+
+	setenvForTest(t, "CACHE_KEY_TEST_VAR", "value")
+
+	data := cacheKeyData{Branch: "main", Workflow: "primary", StackID: "osx-xcode-12.4.x", OS: "darwin", Architecture: "arm64"}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", tmpl: "", want: ""},
+		{name: "static", tmpl: "gradle", want: "gradle"},
+		{name: "fields", tmpl: "{{ .Branch }}-{{ .Workflow }}-{{ .StackID }}-{{ .OS }}-{{ .Architecture }}", want: "main-primary-osx-xcode-12.4.x-darwin-arm64"},
+		{name: "checksum", tmpl: `gradle-{{ .Branch }}-{{ checksum "` + wrapperPth + `" }}`, want: "gradle-main-" + checksum},
+		{name: "env", tmpl: `{{ env "CACHE_KEY_TEST_VAR" }}`, want: "value"},
+		{name: "unknown field", tmpl: "{{ .Unknown }}", wantErr: true},
+		{name: "invalid template", tmpl: "{{ .Branch", wantErr: true},
+		{name: "missing file", tmpl: `{{ checksum "` + filepath.Join(tmpDir, "missing") + `" }}`, wantErr: true},
+		{name: "resolves to empty", tmpl: `{{ env "CACHE_KEY_TEST_UNSET_VAR" }}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveCacheKey(tt.tmpl, data)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_checksumFiles(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	a := filepath.Join(tmpDir, "a.lock")
+	b := filepath.Join(tmpDir, "sub", "b.lock")
+	createDirStruct(t, map[string]string{a: "a", b: "b"})
+
+	ab, err := checksumFiles(a, b)
+	require.NoError(t, err)
+
+	ba, err := checksumFiles(b, a)
+	require.NoError(t, err)
+	require.Equal(t, ab, ba, "checksum depends on the order of the patterns")
+
+	glob, err := checksumFiles(filepath.Join(tmpDir, "**", "*.lock"))
+	require.NoError(t, err)
+	require.Equal(t, ab, glob)
+
+	single, err := checksumFiles(a)
+	require.NoError(t, err)
+	require.NotEqual(t, ab, single)
+
+	_, err = checksumFiles()
+	require.Error(t, err)
+}
+
+func Test_resolveRestoreKeys(t *testing.T) {
+	keys, err := resolveRestoreKeys("gradle-{{ .Branch }}-\n\n  gradle-master-  \n", cacheKeyData{Branch: "feature"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"gradle-feature-", "gradle-master-"}, keys)
+
+	keys, err = resolveRestoreKeys("", cacheKeyData{})
+	require.NoError(t, err)
+	require.Nil(t, keys)
+
+	_, err = resolveRestoreKeys("{{ .Unknown }}", cacheKeyData{})
+	require.Error(t, err)
+}
+
+func Test_matchCacheKey(t *testing.T) {
+	restoreKeys := []string{"gradle-feature-", "gradle-master-"}
+	tests := []struct {
+		prevKey        string
+		wantMatch      keyMatch
+		wantRestoreKey string
+	}{
+		{prevKey: "gradle-feature-abc", wantMatch: exactKeyMatch},
+		{prevKey: "gradle-feature-def", wantMatch: restoreKeyMatch, wantRestoreKey: "gradle-feature-"},
+		{prevKey: "gradle-master-abc", wantMatch: restoreKeyMatch, wantRestoreKey: "gradle-master-"},
+		{prevKey: "gradle-other-abc", wantMatch: noKeyMatch},
+		{prevKey: "", wantMatch: noKeyMatch},
+	}
+	for _, tt := range tests {
+		match, restoreKey := matchCacheKey(tt.prevKey, "gradle-feature-abc", restoreKeys)
+		require.Equal(t, tt.wantMatch, match, tt.prevKey)
+		require.Equal(t, tt.wantRestoreKey, restoreKey, tt.prevKey)
+	}
+}
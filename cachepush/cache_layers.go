@@ -0,0 +1,175 @@
+// Layered cache archive related functions.
+//
+// In layered mode the step pushes a full (base) archive first and small delta archives afterwards,
+// containing only the added and changed files plus the list of removed files (tombstones).
+// The layer chain is stored in the archive, so cache-pull can apply the base and the deltas in order.
+package cachepush
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// ArchiveMode ...
+type ArchiveMode string
+
+const (
+	// FullArchive ...
+	FullArchive = ArchiveMode("full")
+	// LayeredArchive ...
+	LayeredArchive = ArchiveMode("layered")
+)
+
+// layerPolicy decides when the layer chain needs to be consolidated into a new base layer,
+// so the chain never grows unbounded. Zero values disable the given limit.
+type layerPolicy struct {
+	maxDeltaCount int
+	maxDeltaSize  int64
+	maxAge        time.Duration
+}
+
+// compactionReason returns why the chain needs to restart with a new base layer
+// if the next delta layer (with the given content size) would be added to it, or an empty string otherwise.
+func (p layerPolicy) compactionReason(prev model.LayerInfo, nextDeltaSize int64, now time.Time) string {
+	deltas := prev.Deltas()
+	if p.maxDeltaCount > 0 && len(deltas)+1 > p.maxDeltaCount {
+		return fmt.Sprintf("delta count would exceed %d", p.maxDeltaCount)
+	}
+
+	if p.maxDeltaSize > 0 {
+		total := nextDeltaSize
+		for _, delta := range deltas {
+			total += delta.ContentSize
+		}
+		if total > p.maxDeltaSize {
+			return fmt.Sprintf("total delta size (%d bytes) would exceed %d bytes", total, p.maxDeltaSize)
+		}
+	}
+
+	if p.maxAge > 0 {
+		if age := now.Sub(prev.Layers[0].CreatedAt); age > p.maxAge {
+			return fmt.Sprintf("base layer is older than %s", p.maxAge)
+		}
+	}
+
+	return ""
+}
+
+// readLayerInfo reads the layer info of the previous cache from pth if exists.
+func readLayerInfo(pth string) (*model.LayerInfo, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	fileBytes, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var info model.LayerInfo
+	if err := json.Unmarshal(fileBytes, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// contentSize returns the sum of the regular files' sizes.
+func contentSize(paths map[string]string) (int64, error) {
+	var size int64
+	for pth := range paths {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return 0, err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// deltaPaths returns the subset of pathToIndicatorPath which needs to be written into a delta layer:
+// the added and changed files.
+func deltaPaths(pathToIndicatorPath map[string]string, changes result) map[string]string {
+	delta := map[string]string{}
+	for _, paths := range [][]string{changes.added, changes.addedIgnored, changes.changed} {
+		for _, pth := range paths {
+			if indicator, ok := pathToIndicatorPath[pth]; ok {
+				delta[pth] = indicator
+			}
+		}
+	}
+	return delta
+}
+
+// tombstones returns the paths removed since the previous cache.
+func tombstones(changes result) []string {
+	removed := append(append([]string{}, changes.removed...), changes.removedIgnored...)
+	sort.Strings(removed)
+	return removed
+}
+
+// nextLayer returns the layer chain including the new layer to push.
+// A delta layer is created if there is a previous chain to build on, and the policy does not require compaction,
+// otherwise the chain restarts with a new base layer.
+// If replacedRoots is not nil (root granularity), the delta layer contains the whole content of these roots,
+// otherwise only the added and changed files.
+func nextLayer(prev *model.LayerInfo, pathToIndicatorPath map[string]string, changes *result, replacedRoots []string, policy layerPolicy, now time.Time) (model.LayerInfo, map[string]string, error) {
+	if prev != nil && len(prev.Layers) > 0 && changes != nil {
+		paths := deltaPaths(pathToIndicatorPath, *changes)
+		if replacedRoots != nil {
+			paths = rootDeltaPaths(pathToIndicatorPath, replacedRoots)
+		}
+		size, err := contentSize(paths)
+		if err != nil {
+			return model.LayerInfo{}, nil, err
+		}
+
+		reason := policy.compactionReason(*prev, size, now)
+		if reason == "" {
+			layers := append(append([]model.Layer{}, prev.Layers...), model.Layer{
+				ID:          layerID(now, len(prev.Layers)),
+				CreatedAt:   now,
+				ContentSize: size,
+				Removed:     tombstones(*changes),
+				Roots:       replacedRoots,
+			})
+
+			return model.LayerInfo{Layers: layers}, paths, nil
+		}
+
+		log.Warnf("Consolidating cache layers into a new base layer: %s", reason)
+	}
+
+	size, err := contentSize(pathToIndicatorPath)
+	if err != nil {
+		return model.LayerInfo{}, nil, err
+	}
+
+	return model.LayerInfo{
+		Layers: []model.Layer{{
+			ID:          layerID(now, 0),
+			Base:        true,
+			CreatedAt:   now,
+			ContentSize: size,
+		}},
+	}, pathToIndicatorPath, nil
+}
+
+func layerID(now time.Time, index int) string {
+	if index == 0 {
+		return fmt.Sprintf("base-%d", now.Unix())
+	}
+	return fmt.Sprintf("delta-%d-%d", index, now.Unix())
+}
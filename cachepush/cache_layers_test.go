@@ -0,0 +1,173 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+func Test_deltaPaths(t *testing.T) {
+	pathToIndicatorPath := map[string]string{
+		"added":   "added",
+		"changed": "indicator",
+		"dir":     "",
+		"same":    "same",
+	}
+	changes := result{
+		added:        []string{"added"},
+		addedIgnored: []string{"dir"},
+		changed:      []string{"changed"},
+		matching:     []string{"same"},
+		removed:      []string{"removed"},
+	}
+
+	want := map[string]string{
+		"added":   "added",
+		"changed": "indicator",
+		"dir":     "",
+	}
+	if got := deltaPaths(pathToIndicatorPath, changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("deltaPaths() = %v, want %v", got, want)
+	}
+}
+
+func Test_nextLayer(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+
+	file1 := filepath.Join(tmpDir, "file1")
+	file2 := filepath.Join(tmpDir, "file2")
+	createDirStruct(t, map[string]string{file1: "content", file2: "other content"})
+
+	pathToIndicatorPath := map[string]string{file1: file1, file2: file2}
+	now := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	t.Log("no previous layers")
+	{
+		info, paths, err := nextLayer(nil, pathToIndicatorPath, nil, nil, layerPolicy{}, now)
+		if err != nil {
+			t.Fatalf("nextLayer() error = %v", err)
+		}
+		if len(info.Layers) != 1 || !info.Layers[0].Base {
+			t.Errorf("want a single base layer, got: %v", info.Layers)
+		}
+		if info.Layers[0].ContentSize != int64(len("content")+len("other content")) {
+			t.Errorf("invalid content size: %d", info.Layers[0].ContentSize)
+		}
+		if !reflect.DeepEqual(paths, pathToIndicatorPath) {
+			t.Errorf("want every path in the base layer, got: %v", paths)
+		}
+	}
+
+	t.Log("delta on top of the base")
+	{
+		prev := &model.LayerInfo{Layers: []model.Layer{{ID: "base", Base: true}}}
+		changes := &result{changed: []string{file2}, removed: []string{"removed"}}
+
+		info, paths, err := nextLayer(prev, pathToIndicatorPath, changes, nil, layerPolicy{}, now)
+		if err != nil {
+			t.Fatalf("nextLayer() error = %v", err)
+		}
+		if len(info.Layers) != 2 || info.Layers[1].Base {
+			t.Fatalf("want a base and a delta layer, got: %v", info.Layers)
+		}
+		if !reflect.DeepEqual(info.Layers[1].Removed, []string{"removed"}) {
+			t.Errorf("invalid tombstones: %v", info.Layers[1].Removed)
+		}
+		if !reflect.DeepEqual(paths, map[string]string{file2: file2}) {
+			t.Errorf("want only the changed file in the delta layer, got: %v", paths)
+		}
+	}
+
+	t.Log("delta of the changed roots")
+	{
+		prev := &model.LayerInfo{Layers: []model.Layer{{ID: "base", Base: true}}}
+		changes := &result{changed: []string{file2}}
+
+		info, paths, err := nextLayer(prev, pathToIndicatorPath, changes, []string{tmpDir}, layerPolicy{}, now)
+		if err != nil {
+			t.Fatalf("nextLayer() error = %v", err)
+		}
+		if len(info.Layers) != 2 || !reflect.DeepEqual(info.Layers[1].Roots, []string{tmpDir}) {
+			t.Fatalf("want a delta layer replacing the root, got: %v", info.Layers)
+		}
+		if !reflect.DeepEqual(paths, pathToIndicatorPath) {
+			t.Errorf("want the whole root in the delta layer, got: %v", paths)
+		}
+	}
+
+	t.Log("max delta count reached")
+	{
+		prev := &model.LayerInfo{Layers: []model.Layer{{ID: "base", Base: true}}}
+		for i := 0; i < 2; i++ {
+			prev.Layers = append(prev.Layers, model.Layer{ID: "delta"})
+		}
+
+		info, _, err := nextLayer(prev, pathToIndicatorPath, &result{changed: []string{file2}}, nil, layerPolicy{maxDeltaCount: 2}, now)
+		if err != nil {
+			t.Fatalf("nextLayer() error = %v", err)
+		}
+		if len(info.Layers) != 1 || !info.Layers[0].Base {
+			t.Errorf("want a new base layer, got: %v", info.Layers)
+		}
+	}
+}
+
+func Test_layerPolicy_compactionReason(t *testing.T) {
+	now := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+	prev := model.LayerInfo{Layers: []model.Layer{
+		{ID: "base", Base: true, CreatedAt: now.Add(-48 * time.Hour), ContentSize: 1000},
+		{ID: "delta-1", CreatedAt: now.Add(-24 * time.Hour), ContentSize: 100},
+	}}
+
+	tests := []struct {
+		name       string
+		policy     layerPolicy
+		size       int64
+		wantReason bool
+	}{
+		{
+			name:       "no limits",
+			policy:     layerPolicy{},
+			size:       100,
+			wantReason: false,
+		},
+		{
+			name:       "delta count within limit",
+			policy:     layerPolicy{maxDeltaCount: 2},
+			size:       100,
+			wantReason: false,
+		},
+		{
+			name:       "delta count exceeded",
+			policy:     layerPolicy{maxDeltaCount: 1},
+			size:       100,
+			wantReason: true,
+		},
+		{
+			name:       "delta size exceeded",
+			policy:     layerPolicy{maxDeltaSize: 150},
+			size:       100,
+			wantReason: true,
+		},
+		{
+			name:       "base too old",
+			policy:     layerPolicy{maxAge: 24 * time.Hour},
+			size:       100,
+			wantReason: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.compactionReason(prev, tt.size, now); (got != "") != tt.wantReason {
+				t.Errorf("layerPolicy.compactionReason() = %v, want reason: %v", got, tt.wantReason)
+			}
+		})
+	}
+}
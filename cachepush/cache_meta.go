@@ -0,0 +1,143 @@
+// Cache meta related functions.
+//
+// The cache meta records when each cached file first entered the cache and when it was last read,
+// so the step can report the oldest entries and the entries never used since they were added,
+// and evict the files not read within the unused_file_max_age.
+package cachepush
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+const (
+	maxCacheMetaReportEntries = 10
+	// unusedFileMaxAgeNever disables the eviction of the unused files.
+	unusedFileMaxAgeNever = "never"
+)
+
+// parseUnusedFileMaxAge returns the max age (given in days) of the unused files, 0 if the eviction is disabled.
+func parseUnusedFileMaxAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == unusedFileMaxAgeNever {
+		return 0, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return 0, fmt.Errorf("invalid unused file max age (%s): should be a positive number of days or %s", value, unusedFileMaxAgeNever)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// updateCacheMeta returns the cache meta of the regular files to cache:
+// the first seen info is kept from the previous meta, the files new to the cache are recorded as first seen in the current build.
+func updateCacheMeta(prev model.CacheMeta, pathToIndicatorPath map[string]string, buildSlug string, now time.Time) (model.CacheMeta, error) {
+	meta := model.CacheMeta{}
+	for pth := range pathToIndicatorPath {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		entry, ok := prev[pth]
+		if !ok || entry.ConsecutiveFailures > 0 {
+			entry = model.CacheMetaEntry{FirstSeenBuild: buildSlug, FirstSeenAt: now.Unix()}
+		}
+
+		if accessTime := fileAccessTime(info); accessTime.After(info.ModTime()) {
+			entry.AccessTime = accessTime.Unix()
+		}
+
+		meta[pth] = entry
+	}
+	return meta, nil
+}
+
+// oldestCacheEntries returns the n paths which are in the cache for the longest time.
+func oldestCacheEntries(meta model.CacheMeta, n int) []string {
+	paths := make([]string, 0, len(meta))
+	for pth, entry := range meta {
+		if entry.ConsecutiveFailures == 0 {
+			paths = append(paths, pth)
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if meta[paths[i]].FirstSeenAt != meta[paths[j]].FirstSeenAt {
+			return meta[paths[i]].FirstSeenAt < meta[paths[j]].FirstSeenAt
+		}
+		return paths[i] < paths[j]
+	})
+
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+// neverAccessedCacheEntries returns the paths added by a previous build, which were never read since then.
+// These files are probably rewritten by every build without being used, so they are only dead weight in the cache.
+func neverAccessedCacheEntries(meta model.CacheMeta, now time.Time) []string {
+	var paths []string
+	for pth, entry := range meta {
+		if entry.ConsecutiveFailures == 0 && entry.FirstSeenAt < now.Unix() && entry.AccessTime == 0 {
+			paths = append(paths, pth)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// evictUnusedFiles removes the files of the previous cache which were not read within maxAge.
+// A file is used when it is added to the cache and whenever it is read, files new to the cache and protected files are kept.
+func evictUnusedFiles(pathToIndicatorPath map[string]string, prev model.CacheMeta, protected []ignorePattern, lastUsedTime accessTimeProvider, maxAge time.Duration, now time.Time) (map[string]string, []string, error) {
+	kept := map[string]string{}
+	var evicted []string
+	for pth, indicator := range pathToIndicatorPath {
+		entry, ok := prev[pth]
+		if !ok || entry.ConsecutiveFailures > 0 || isProtected(pth, protected) {
+			kept[pth] = indicator
+			continue
+		}
+
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.Mode().IsRegular() {
+			kept[pth] = indicator
+			continue
+		}
+
+		if now.Sub(lastUsedAt(entry, info, lastUsedTime)) > maxAge {
+			evicted = append(evicted, pth)
+			continue
+		}
+		kept[pth] = indicator
+	}
+
+	sort.Strings(evicted)
+	return kept, evicted, nil
+}
+
+// lastUsedAt returns when the cached file was last used: added to the cache or read after it was written.
+func lastUsedAt(entry model.CacheMetaEntry, info os.FileInfo, lastUsedTime accessTimeProvider) time.Time {
+	lastUsed := time.Unix(entry.FirstSeenAt, 0)
+	if accessTime := time.Unix(entry.AccessTime, 0); accessTime.After(lastUsed) {
+		lastUsed = accessTime
+	}
+	if accessTime, ok := lastUsedTime(info); ok && accessTime.After(lastUsed) {
+		lastUsed = accessTime
+	}
+	return lastUsed
+}
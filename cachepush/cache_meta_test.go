@@ -0,0 +1,95 @@
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_updateCacheMeta(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	old := filepath.Join(tmpDir, "old")
+	added := filepath.Join(tmpDir, "added")
+	createDirStruct(t, map[string]string{old: "old", added: "added"})
+
+	prev := model.CacheMeta{old: {FirstSeenBuild: "build-1", FirstSeenAt: 100}}
+	now := time.Unix(200, 0)
+
+	meta, err := updateCacheMeta(prev, map[string]string{old: "", added: "", tmpDir: "-"}, "build-2", now)
+	require.NoError(t, err)
+	require.Len(t, meta, 2)
+	require.Equal(t, "build-1", meta[old].FirstSeenBuild)
+	require.Equal(t, int64(100), meta[old].FirstSeenAt)
+	require.Equal(t, "build-2", meta[added].FirstSeenBuild)
+	require.Equal(t, int64(200), meta[added].FirstSeenAt)
+}
+
+func Test_cacheMetaReports(t *testing.T) {
+	meta := model.CacheMeta{
+		"c": {FirstSeenAt: 300},
+		"a": {FirstSeenAt: 100, AccessTime: 150},
+		"b": {FirstSeenAt: 100},
+		"d": {FirstSeenAt: 400},
+	}
+
+	require.Equal(t, []string{"a", "b", "c"}, oldestCacheEntries(meta, 3))
+	require.Equal(t, []string{"b", "c"}, neverAccessedCacheEntries(meta, time.Unix(400, 0)))
+}
+
+func Test_parseUnusedFileMaxAge(t *testing.T) {
+	for value, want := range map[string]time.Duration{"": 0, "never": 0, "7": 7 * 24 * time.Hour, " 30 ": 30 * 24 * time.Hour} {
+		got, err := parseUnusedFileMaxAge(value)
+		require.NoError(t, err, value)
+		require.Equal(t, want, got, value)
+	}
+
+	for _, value := range []string{"0", "-1", "7d", "always"} {
+		_, err := parseUnusedFileMaxAge(value)
+		require.Error(t, err, value)
+	}
+}
+
+func Test_evictUnusedFiles(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	unused := filepath.Join(tmpDir, "unused")
+	read := filepath.Join(tmpDir, "read")
+	readNow := filepath.Join(tmpDir, "read_now")
+	added := filepath.Join(tmpDir, "added")
+	createDirStruct(t, map[string]string{unused: "unused", read: "read", readNow: "read now", added: "added"})
+
+	now := time.Unix(100*24*60*60, 0)
+	written := now.Add(-30 * 24 * time.Hour)
+	for _, pth := range []string{unused, read, readNow, added} {
+		require.NoError(t, os.Chtimes(pth, written, written))
+	}
+	// read after it was written
+	require.NoError(t, os.Chtimes(readNow, now.Add(-time.Hour), written))
+
+	prev := model.CacheMeta{
+		unused:  {FirstSeenAt: written.Unix()},
+		read:    {FirstSeenAt: written.Unix(), AccessTime: now.Add(-2 * 24 * time.Hour).Unix()},
+		readNow: {FirstSeenAt: written.Unix()},
+	}
+	pathToIndicatorPath := map[string]string{unused: "", read: "", readNow: "", added: "", tmpDir: "-"}
+
+	kept, evicted, err := evictUnusedFiles(pathToIndicatorPath, prev, nil, accessTimeOf, 7*24*time.Hour, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{unused}, evicted)
+	require.Equal(t, map[string]string{read: "", readNow: "", added: "", tmpDir: "-"}, kept)
+
+	protected, err := parseNeverEvictList([]string{unused})
+	require.NoError(t, err)
+	kept, evicted, err = evictUnusedFiles(pathToIndicatorPath, prev, protected, accessTimeOf, 7*24*time.Hour, now)
+	require.NoError(t, err)
+	require.Nil(t, evicted)
+	require.Equal(t, pathToIndicatorPath, kept)
+}
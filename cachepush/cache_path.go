@@ -0,0 +1,400 @@
+// Cache path and ignore path related functions.
+//
+// Ignoring symlink target changes for cache invalidation, as we expect
+// the symlinks to be yarn workspace symlink: https://yarnpkg.com/blog/2018/02/15/nohoist/.
+// The symlinks are included in the cache, just not chhecked if the target they point to is changed.
+// If case it is a link to a directory outside of the cached paths (e.g. yarn workspaces),
+// will not add the linked directory to the cache, and will not invalidate the cache if it changes,
+// as we expect them to be part of the repository.
+// If it links to a directory included in the cache already, then also ignoring it.
+// The directory contents will be added to the cache as regular files, no need to check them twice.
+// Symlinks to files are also ignored.
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/doublestar/v3"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/ryanuber/go-glob"
+)
+
+// scopedExcludeSeparator separates the exclude patterns attached to an include item.
+var scopedExcludeSeparator = regexp.MustCompile(`\s+!`)
+
+// splitScopedExcludes separates the include item from the exclude patterns attached to it.
+func splitScopedExcludes(item string) (string, []string) {
+	// file/or/dir/to/cache -> indicator/file !pattern/to/exclude !other/pattern
+	parts := scopedExcludeSeparator.Split(item, -1)
+
+	var patterns []string
+	for _, pattern := range parts[1:] {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return parts[0], patterns
+}
+
+// parseIncludeListItem separates path to cache and change indicator path.
+func parseIncludeListItem(item string) (string, string) {
+	// file/or/dir/to/cache -> indicator/file
+	// file/or/dir/to/cache
+	item, _ = splitScopedExcludes(item)
+	if parts := strings.Split(item, "->"); len(parts) > 1 {
+		return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+	return strings.TrimSpace(item), ""
+}
+
+// parseIgnoreListItem separates ignore pattern and the action to take on the paths matching the pattern.
+func parseIgnoreListItem(item string) (string, ignoreAction) {
+	// path/or/patter/to/ignore
+	// !path/or/patter/to/exclude
+	// +path/or/patter/to/include
+	item = strings.TrimSpace(item)
+	if len(item) > 1 && item[0] == '!' {
+		return strings.TrimSpace(item[1:]), excludeFromCache
+	}
+	if len(item) > 1 && item[0] == '+' {
+		return strings.TrimSpace(item[1:]), reinclude
+	}
+	return strings.TrimLeft(item, "!+"), ignoreChanges
+}
+
+func parseIncludeList(list []string) map[string]string {
+	indicatorByPath := map[string]string{}
+	for _, item := range list {
+		pth, indicator := parseIncludeListItem(item)
+		if len(pth) == 0 {
+			continue
+		}
+		indicatorByPath[pth] = indicator
+	}
+	return indicatorByPath
+}
+
+// parseIgnoreList returns the ignore items in order, their patterns are bound to the working directory.
+func parseIgnoreList(list []string) []ignorePattern {
+	var patterns []ignorePattern
+	for _, item := range list {
+		pth, action := parseIgnoreListItem(item)
+		if len(pth) == 0 {
+			continue
+		}
+
+		patterns = append(patterns, ignorePattern{Pattern: pth, Action: action, inWorkDir: true})
+	}
+	return patterns
+}
+
+// parseScopedExcludes returns the exclude patterns attached to the include items,
+// the patterns are relative to their include item's path, so they only match inside it.
+func parseScopedExcludes(list []string) []ignorePattern {
+	var patterns []ignorePattern
+	for _, item := range list {
+		pth, _ := parseIncludeListItem(item)
+		_, scoped := splitScopedExcludes(item)
+		if len(pth) == 0 {
+			continue
+		}
+
+		for _, pattern := range scoped {
+			if strings.HasPrefix(pattern, regexPatternPrefix) {
+				log.Warnf("Regex exclude patterns can not be attached to a cache path, add it to the ignore list instead: %s", pattern)
+				continue
+			}
+			anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+			pattern = strings.TrimPrefix(pattern, "/")
+			if !anchored {
+				pattern = "**/" + pattern
+			}
+			patterns = append(patterns, ignorePattern{Pattern: strings.TrimSuffix(pth, "/") + "/" + pattern, Action: excludeFromCache})
+		}
+	}
+	return patterns
+}
+
+func isSymlink(pth string) (bool, error) {
+	linkFileInfo, err := os.Lstat(pth)
+	if err != nil {
+		return false, fmt.Errorf("failed to get file info, error: %s", err)
+	}
+
+	return linkFileInfo.Mode()&os.ModeSymlink != 0, nil
+}
+
+// expandPath returns cacheable files inside a directory recursively.
+// If parameter root is a file, it returns that file.
+// An array of regural files, directories and symlinks is returned, other irregural files (named pipe, socket) are ignored.
+// If failures is not nil, the paths failing to be read are recorded and left out instead of failing the walk,
+// and the skip-listed paths are not read.
+// If large is not nil, the regular files exceeding its size limit are recorded and left out.
+// If hygiene is not nil, the useless Gradle cache files and directories are recorded and left out.
+func expandPath(ctx context.Context, root string, failures *pathFailures, large *largeFiles, hygiene *gradleHygiene) (regularFiles []string, symlinkPaths []string, dirPaths []string, err error) {
+	if err := filepath.Walk(root, func(path string, i os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if failures == nil {
+				return err
+			}
+			failures.fail(path, err)
+			return nil
+		}
+		if (failures != nil && failures.skip(path)) || hygiene.skip(path, i) {
+			if i.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		isLink, err := isSymlink(path)
+		if err != nil {
+			return err
+		}
+		if isLink {
+			symlinkPaths = append(symlinkPaths, path)
+			return nil
+		}
+
+		// Adding directories, in case a directory is empty, it will still be included
+		if i.Mode().IsDir() {
+			dirPaths = append(dirPaths, path)
+			return nil
+		}
+
+		// Not adding directories and non symlink irregural files to the cache
+		// ModeNamedPipe | ModeSocket | ModeDevice | ModeCharDevice | ModeIrregular & i.Mode() != 0
+		if !i.Mode().IsRegular() {
+			return nil
+		}
+
+		if large.skip(path, i.Size()) {
+			return nil
+		}
+
+		regularFiles = append(regularFiles, path)
+		return nil
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return regularFiles, symlinkPaths, dirPaths, nil
+}
+
+// isGlobPattern reports whether the path contains wildcards.
+func isGlobPattern(pth string) bool {
+	return strings.ContainsAny(pth, "*?[{")
+}
+
+// outermostPaths returns the paths which are not inside an other path of the list, sorted.
+// A pattern like **/node_modules also matches the nested node_modules directories, those are expanded with their parent.
+func outermostPaths(paths []string) []string {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+
+	var outermost []string
+	for _, pth := range sorted {
+		if rootOf(pth, outermost) == "" {
+			outermost = append(outermost, pth)
+		}
+	}
+	return outermost
+}
+
+// normalizeIndicatorByPath modifies indicatorByPath:
+// expands both path to cache and indicator path
+// removes the item if any of path to cache or indicator path is not exist or if the indicator is a dir
+// resolves the indicator aliases to the matching lockfiles
+// replaces path to cache (if it is a directory) by every file (recursively) in the directory.
+func normalizeIndicatorByPath(ctx context.Context, indicatorByPath map[string]string, failures *pathFailures, large *largeFiles, hygiene *gradleHygiene) (map[string]string, error) {
+	normalized := map[string]string{}
+	resolvedAliases := map[string]string{}
+	for pth, indicator := range indicatorByPath {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if isIndicatorAlias(indicator) {
+			alias := indicator
+			resolved, ok := resolvedAliases[alias]
+			if !ok {
+				wd, err := os.Getwd()
+				if err != nil {
+					return nil, err
+				}
+				if resolved, err = resolveIndicatorAlias(alias, wd, combinedIndicatorDir); err != nil {
+					return nil, err
+				}
+				resolvedAliases[alias] = resolved
+			}
+			if resolved == "" {
+				log.Warnf("indicator alias matches no lockfiles: %s", alias)
+				continue
+			}
+			indicator = resolved
+		}
+
+		if len(indicator) > 0 {
+			var err error
+			indicator, err = pathutil.AbsPath(indicator)
+			if err != nil {
+				return nil, err
+			}
+
+			switch info, exist, err := pathutil.PathCheckAndInfos(indicator); {
+			case err != nil:
+				return nil, err
+			case !exist:
+				log.Warnf("indicator does not exists at: %s", indicator)
+				continue
+			case info.IsDir():
+				log.Warnf("indicator is a directory: %s", indicator)
+				continue
+			}
+		}
+
+		var err error
+		pth, err = pathutil.AbsPath(pth)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := doublestar.Glob(pth, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			log.Warnf("path does not exists at: %s", pth)
+			continue
+		}
+		if isGlobPattern(pth) {
+			matches = outermostPaths(matches)
+			log.Debugf("%s matches %d paths", pth, len(matches))
+		}
+
+		for _, p := range matches {
+			regularFiles, symlinkPaths, dirPaths, err := expandPath(ctx, p, failures, large, hygiene)
+			if err != nil {
+				return nil, err
+			}
+			for _, dir := range dirPaths {
+				normalized[dir] = "-"
+			}
+			for _, file := range regularFiles {
+				normalized[file] = indicator
+			}
+			for _, file := range symlinkPaths {
+				// this file's changes does not fluctuates existing cache invalidation
+				normalized[file] = "-"
+			}
+		}
+	}
+	return normalized, nil
+}
+
+func patternOrPrefixMatch(patternOrPath, subject string) bool {
+	if strings.Contains(patternOrPath, "*") {
+		return glob.Glob(patternOrPath, subject)
+	}
+	return strings.HasPrefix(subject, patternOrPath)
+}
+
+// interleave matches the given include items with the ignore items and returns which path needs to be cached:
+// if an ignore item matches to a path, the path either will not affect the previous cache invalidation
+// or will not be included in the cache.
+// Otherwise a path will affect the previous cache invalidation:
+// if the path has indicator, the indicator will affect the previous cache invalidation
+// otherwise the file itself.
+func interleave(indicatorByPth map[string]string, patterns []ignorePattern) map[string]string {
+	indicatorByCachePth := map[string]string{}
+
+	for pth, indicator := range indicatorByPth {
+		action, ok := match(pth, patterns)
+		if action == excludeFromCache {
+			// this file should not be included in the cache
+			continue
+		}
+
+		if ok || indicator == "-" {
+			// this file's changes does not invalidate existing cache
+			indicator = ""
+		} else if len(indicator) == 0 {
+			// the file's content is used to invalidate existing cache
+			indicator = pth
+		} // else: the file's indicator content is used to invalidate existing cache
+
+		indicatorByCachePth[pth] = indicator
+	}
+
+	return indicatorByCachePth
+}
+
+// parseAutoExcludeList returns the non-empty auto exclude patterns.
+func parseAutoExcludeList(list []string) []string {
+	var patterns []string
+	for _, item := range list {
+		if item = strings.TrimSpace(item); item != "" {
+			patterns = append(patterns, item)
+		}
+	}
+	return patterns
+}
+
+// autoExclude removes the paths matching any of the auto exclude patterns (lock files, journals) from the cache.
+// These files are neither archived nor fingerprinted, as they either change on every build or can not be archived consistently.
+// It is applied after the user defined ignore items, only to the cached paths (indicators are not affected).
+// The removed paths are returned in order.
+func autoExclude(indicatorByCachePth map[string]string, patterns []string) (map[string]string, []string) {
+	if len(patterns) == 0 {
+		return indicatorByCachePth, nil
+	}
+
+	kept := map[string]string{}
+	var excluded []string
+	for pth, indicator := range indicatorByCachePth {
+		matched := false
+		for _, pattern := range patterns {
+			if patternOrPrefixMatch(pattern, pth) {
+				matched = true
+				break
+			}
+		}
+
+		if matched {
+			excluded = append(excluded, pth)
+			continue
+		}
+		kept[pth] = indicator
+	}
+
+	sort.Strings(excluded)
+	return kept, excluded
+}
+
+// selfInvalidatingIndicators returns the indicator files which are cached themselves,
+// mapped to the number of cached paths depending on them.
+// Such indicators are usually generated (and rewritten) by the build inside a cached directory,
+// which causes the cache to be invalidated on every build.
+func selfInvalidatingIndicators(indicatorByCachePth map[string]string) map[string]int {
+	dependentsByIndicator := map[string]int{}
+	for pth, indicator := range indicatorByCachePth {
+		if indicator == "" || indicator == pth {
+			continue
+		}
+
+		if _, cached := indicatorByCachePth[indicator]; cached {
+			dependentsByIndicator[indicator]++
+		}
+	}
+	return dependentsByIndicator
+}
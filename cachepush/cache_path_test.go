@@ -0,0 +1,1019 @@
+package cachepush
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func createDirStruct(t *testing.T, contentByPth map[string]string) {
+	for pth, content := range contentByPth {
+		dir := filepath.Dir(pth)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+			return
+		}
+		if err := fileutil.WriteStringToFile(pth, content); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+			return
+		}
+	}
+}
+
+func Test_parseIgnoreListItem(t *testing.T) {
+	tests := []struct {
+		name        string
+		item        string
+		wantPattern string
+		wantAction  ignoreAction
+	}{
+		{
+			name:        "simple ignore item",
+			item:        "path/to/ignore",
+			wantPattern: "path/to/ignore",
+			wantAction:  ignoreChanges,
+		},
+		{
+			name:        "simple ignore patter",
+			item:        "path/**/ignore",
+			wantPattern: "path/**/ignore",
+			wantAction:  ignoreChanges,
+		},
+		{
+			name:        "ignore item surrounding spaces",
+			item:        " path/to/ignore  ",
+			wantPattern: "path/to/ignore",
+			wantAction:  ignoreChanges,
+		},
+		{
+			name:        "empty ignore item",
+			item:        "",
+			wantPattern: "",
+			wantAction:  ignoreChanges,
+		},
+		{
+			name:        "simple exclude item",
+			item:        "!path/to/ignore",
+			wantPattern: "path/to/ignore",
+			wantAction:  excludeFromCache,
+		},
+		{
+			name:        "exclude item surrounding spaces",
+			item:        "!  path/to/ignore ",
+			wantPattern: "path/to/ignore",
+			wantAction:  excludeFromCache,
+		},
+		{
+			name:        "simple include item",
+			item:        "+path/to/include",
+			wantPattern: "path/to/include",
+			wantAction:  reinclude,
+		},
+		{
+			name:        "empty exclude item",
+			item:        "!",
+			wantPattern: "",
+			wantAction:  ignoreChanges,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, action := parseIgnoreListItem(tt.item)
+			if pattern != tt.wantPattern {
+				t.Errorf("parseIgnoreListItem() pattern = %v, ignoreItem %v", pattern, tt.wantPattern)
+			}
+			if action != tt.wantAction {
+				t.Errorf("parseIgnoreListItem() action = %v, want %v", action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func Test_parseIncludeListItem(t *testing.T) {
+	tests := []struct {
+		name          string
+		item          string
+		wantPth       string
+		wantIndicator string
+	}{
+		{
+			name:          "simple include path",
+			item:          "path/to/include",
+			wantPth:       "path/to/include",
+			wantIndicator: "",
+		},
+		{
+			name:          "simple include path surrounding spaces",
+			item:          "  path/to/include ",
+			wantPth:       "path/to/include",
+			wantIndicator: "",
+		},
+		{
+			name:          "empty include item",
+			item:          "",
+			wantPth:       "",
+			wantIndicator: "",
+		},
+		{
+			name:          "simple indicator",
+			item:          "path/to/include->indicator/path",
+			wantPth:       "path/to/include",
+			wantIndicator: "indicator/path",
+		},
+		{
+			name:          "simple indicator surrounding spaces",
+			item:          "  path/to/include ->  indicator/path ",
+			wantPth:       "path/to/include",
+			wantIndicator: "indicator/path",
+		},
+		{
+			name:          "scoped excludes",
+			item:          "node_modules -> package-lock.json !**/*.map  !.cache/",
+			wantPth:       "node_modules",
+			wantIndicator: "package-lock.json",
+		},
+		{
+			name:          "scoped excludes without indicator",
+			item:          "path/to/include !*.map",
+			wantPth:       "path/to/include",
+			wantIndicator: "",
+		},
+		{
+			name:          "indicator without path",
+			item:          "->indicator/path",
+			wantPth:       "",
+			wantIndicator: "indicator/path",
+		},
+		{
+			name:          "indicator with space path",
+			item:          " ->indicator/path",
+			wantPth:       "",
+			wantIndicator: "indicator/path",
+		},
+		{
+			name:          "indicator without indicator path",
+			item:          "path/to/include->",
+			wantPth:       "path/to/include",
+			wantIndicator: "",
+		},
+		{
+			name:          "indicator with space indicator path",
+			item:          "path/to/include -> ",
+			wantPth:       "path/to/include",
+			wantIndicator: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth, indicator := parseIncludeListItem(tt.item)
+			if pth != tt.wantPth {
+				t.Errorf("parseIncludeListItem() pth = %v, want %v", pth, tt.wantPth)
+			}
+			if indicator != tt.wantIndicator {
+				t.Errorf("parseIncludeListItem() indicator = %v, want %v", indicator, tt.wantIndicator)
+			}
+		})
+	}
+}
+
+func Test_parseIncludeList(t *testing.T) {
+	tests := []struct {
+		name           string
+		list           []string
+		indicatorByPth map[string]string
+	}{
+		{
+			name:           "simple include list",
+			list:           []string{"path1/to/include", "path2/to/include->indicator/path"},
+			indicatorByPth: map[string]string{"path1/to/include": "", "path2/to/include": "indicator/path"},
+		},
+		{
+			name:           "duplicated include items",
+			list:           []string{"path/to/include", "path/to/include->indicator/path"},
+			indicatorByPth: map[string]string{"path/to/include": "indicator/path"},
+		},
+		{
+			name:           "empty item",
+			list:           []string{"", "path/to/include->indicator/path"},
+			indicatorByPth: map[string]string{"path/to/include": "indicator/path"},
+		},
+		{
+			name:           "empty path",
+			list:           []string{"->indicator/path", "path/to/include->indicator/path"},
+			indicatorByPth: map[string]string{"path/to/include": "indicator/path"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseIncludeList(tt.list)
+			if !reflect.DeepEqual(got, tt.indicatorByPth) {
+				t.Errorf("parseIncludeList() = %v, want %v", got, tt.indicatorByPth)
+			}
+		})
+	}
+}
+
+func Test_parseIgnoreList(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     []string
+		patterns []ignorePattern
+	}{
+		{
+			name:     "simple ignore list",
+			list:     []string{"path/to/ignore", "!path/to/exclude", "+path/to/exclude/include"},
+			patterns: []ignorePattern{{Pattern: "path/to/ignore", Action: ignoreChanges, inWorkDir: true}, {Pattern: "path/to/exclude", Action: excludeFromCache, inWorkDir: true}, {Pattern: "path/to/exclude/include", Action: reinclude, inWorkDir: true}},
+		},
+		{
+			name:     "duplicated items are kept in order",
+			list:     []string{"path/to/ignore", "!path/to/ignore"},
+			patterns: []ignorePattern{{Pattern: "path/to/ignore", Action: ignoreChanges, inWorkDir: true}, {Pattern: "path/to/ignore", Action: excludeFromCache, inWorkDir: true}},
+		},
+		{
+			name:     "empty item",
+			list:     []string{"", "!path/to/exclude"},
+			patterns: []ignorePattern{{Pattern: "path/to/exclude", Action: excludeFromCache, inWorkDir: true}},
+		},
+		{
+			name:     "empty path",
+			list:     []string{"!"},
+			patterns: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseIgnoreList(tt.list); !reflect.DeepEqual(got, tt.patterns) {
+				t.Errorf("parseIgnoreList() = %v, want %v", got, tt.patterns)
+			}
+		})
+	}
+}
+
+func Test_expandPath(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Errorf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Warnf("failed to remove directory, error: %s", err)
+		}
+	}()
+
+	pths := map[string]string{
+		filepath.Join(tmpDir, "subdir", "file1"):                   "",
+		filepath.Join(tmpDir, "subdir", "file2"):                   "",
+		filepath.Join(tmpDir, "link", "file"):                      "",
+		filepath.Join(tmpDir, "link_dir", "subdir", "file"):        "",
+		filepath.Join(tmpDir, "not_cached_dir", "not_cached_file"): "",
+	}
+	createDirStruct(t, pths)
+
+	linkFilePath := filepath.Join(tmpDir, "link", "symlink_file")
+	if err := os.Symlink(filepath.Join(tmpDir, "link", "file"), linkFilePath); err != nil {
+		t.Errorf("setup: failed to create symlink, error: %s", err)
+	}
+
+	linkDirPath := filepath.Join(tmpDir, "link_dir", "symlink_dir_outside_cache")
+	if err := os.Symlink(filepath.Join(tmpDir, "link_dir", "not_cached_dir"), linkDirPath); err != nil {
+		t.Errorf("setup: failed to create symlink, error: %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		pth          string
+		regularFiles []string
+		symlinkPaths []string
+		dirPaths     []string
+		wantErr      bool
+	}{
+		{
+			name:         "list files in a directory",
+			pth:          filepath.Join(tmpDir, "subdir"),
+			regularFiles: []string{filepath.Join(tmpDir, "subdir", "file1"), filepath.Join(tmpDir, "subdir", "file2")},
+			symlinkPaths: nil,
+			dirPaths:     []string{filepath.Join(tmpDir, "subdir")},
+			wantErr:      false,
+		},
+		{
+			name:         "puts file path in an array",
+			pth:          filepath.Join(tmpDir, "subdir", "file1"),
+			regularFiles: []string{filepath.Join(tmpDir, "subdir", "file1")},
+			symlinkPaths: nil,
+			dirPaths:     nil,
+			wantErr:      false,
+		},
+		{
+			name:         "single symlink file",
+			pth:          linkFilePath,
+			regularFiles: nil,
+			symlinkPaths: []string{linkFilePath},
+			dirPaths:     nil,
+			wantErr:      false,
+		},
+		{
+			name:         "single symlink directory",
+			pth:          linkDirPath,
+			regularFiles: nil,
+			symlinkPaths: []string{linkDirPath},
+			dirPaths:     nil,
+			wantErr:      false,
+		},
+		{
+			name:         "directory with symlink to file in cache dir",
+			pth:          filepath.Join(tmpDir, "link"),
+			regularFiles: []string{filepath.Join(tmpDir, "link", "file")},
+			symlinkPaths: []string{linkFilePath},
+			dirPaths:     []string{filepath.Join(tmpDir, "link")},
+			wantErr:      false,
+		},
+		{
+			name:         "directory with symlink to dir outside of cache dir",
+			pth:          filepath.Join(tmpDir, "link_dir"),
+			regularFiles: []string{filepath.Join(tmpDir, "link_dir", "subdir", "file")},
+			symlinkPaths: []string{linkDirPath},
+			dirPaths: []string{
+				filepath.Join(tmpDir, "link_dir"),
+				filepath.Join(tmpDir, "link_dir", "subdir"),
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, got2, got3, err := expandPath(context.Background(), tt.pth, nil, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expandPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			require.Equal(t, tt.regularFiles, got1, "expandPath() file paths")
+			require.Equal(t, tt.symlinkPaths, got2, "expandPath() synlink paths")
+			require.Equal(t, tt.dirPaths, got3, "expandPath() directory paths")
+		})
+	}
+}
+
+func Test_normalizeIndicatorByPath(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+		return
+	}
+
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Warnf("failed to remove directory, error: %s", err)
+		}
+	}()
+
+	pths := map[string]string{
+		filepath.Join(tmpDir, "subdir", "file1"):                                       "",
+		filepath.Join(tmpDir, "subdir", "file2"):                                       "",
+		filepath.Join(tmpDir, "dir_with_symlink", "file"):                              "",
+		filepath.Join(tmpDir, "glob", "a", "Build", "file"):                            "",
+		filepath.Join(tmpDir, "glob", "b", "Build", "file"):                            "",
+		filepath.Join(tmpDir, "glob", "b", "Other", "file"):                            "",
+		filepath.Join(tmpDir, "glob", "node_modules", "dep", "node_modules", "subdep"): "",
+	}
+	createDirStruct(t, pths)
+
+	linkFilePath := filepath.Join(tmpDir, "dir_with_symlink", "link_file")
+	if err := os.Symlink(filepath.Join(tmpDir, "dir_with_symlink", "file"), linkFilePath); err != nil {
+		t.Fatalf("failed to create symlink, error: %s", err)
+	}
+
+	linkDirPath := filepath.Join(tmpDir, "dir_with_symlink", "link_dir")
+	if err := os.Symlink(filepath.Join(tmpDir), linkDirPath); err != nil {
+		t.Fatalf("failed to create symlink, error: %s", err)
+	}
+
+	invalidTargetLinkPath := filepath.Join(tmpDir, "dir_with_symlink", "link_invalid")
+	if err := os.Symlink("nonexistent_target", invalidTargetLinkPath); err != nil {
+		t.Fatalf("failed to create symlink, error: %s", err)
+	}
+
+	if err := os.Setenv("NORMALIZE_INDICATOR_BY_PATH_TMP_DIR", tmpDir); err != nil {
+		t.Fatalf("failed to set NORMALIZE_INDICATOR_BY_PATH_TMP_DIR: %s", err)
+		return
+	}
+
+	tests := []struct {
+		name            string
+		indicatorByPath map[string]string
+		normalized      map[string]string
+		wantErr         bool
+	}{
+		{
+			name:            "drops item if indicator does not exists",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): "non/existing/indicator"},
+			normalized:      map[string]string{},
+			wantErr:         false,
+		},
+		{
+			name:            "drops item if indicator is a dir",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join(tmpDir, "subdir")},
+			normalized:      map[string]string{},
+			wantErr:         false,
+		},
+		{
+			name:            "expand envs in indicator",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join("$NORMALIZE_INDICATOR_BY_PATH_TMP_DIR", "subdir", "file2")},
+			normalized:      map[string]string{filepath.Join(tmpDir, "subdir", "file1"): filepath.Join(tmpDir, "subdir", "file2")},
+			wantErr:         false,
+		},
+		{
+			name:            "drops item if path does not exists",
+			indicatorByPath: map[string]string{"non/existing/path": ""},
+			normalized:      map[string]string{},
+			wantErr:         false,
+		},
+		{
+			name:            "expand envs in path",
+			indicatorByPath: map[string]string{filepath.Join("$NORMALIZE_INDICATOR_BY_PATH_TMP_DIR", "subdir", "file1"): ""},
+			normalized:      map[string]string{filepath.Join(tmpDir, "subdir", "file1"): ""},
+			wantErr:         false,
+		},
+		{
+			name:            "expands path if it is a dir",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "subdir"): ""},
+			normalized: map[string]string{
+				filepath.Join(tmpDir, "subdir"):          "-",
+				filepath.Join(tmpDir, "subdir", "file1"): "",
+				filepath.Join(tmpDir, "subdir", "file2"): "",
+			},
+			wantErr: false,
+		},
+		{
+			name:            "expands glob pattern",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "glob", "*", "Build"): ""},
+			normalized: map[string]string{
+				filepath.Join(tmpDir, "glob", "a", "Build"):         "-",
+				filepath.Join(tmpDir, "glob", "a", "Build", "file"): "",
+				filepath.Join(tmpDir, "glob", "b", "Build"):         "-",
+				filepath.Join(tmpDir, "glob", "b", "Build", "file"): "",
+			},
+		},
+		{
+			name:            "expands nested glob matches once",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "glob", "**", "node_modules"): ""},
+			normalized: map[string]string{
+				filepath.Join(tmpDir, "glob", "node_modules"):                                  "-",
+				filepath.Join(tmpDir, "glob", "node_modules", "dep"):                           "-",
+				filepath.Join(tmpDir, "glob", "node_modules", "dep", "node_modules"):           "-",
+				filepath.Join(tmpDir, "glob", "node_modules", "dep", "node_modules", "subdep"): "",
+			},
+		},
+		{
+			name:            "set symlink indicator to ignore file for cache invalidation",
+			indicatorByPath: map[string]string{filepath.Join(tmpDir, "dir_with_symlink"): ""},
+			normalized: map[string]string{
+				filepath.Join(tmpDir, "dir_with_symlink"):         "-",
+				filepath.Join(tmpDir, "dir_with_symlink", "file"): "",
+				linkFilePath:          "-",
+				linkDirPath:           "-",
+				invalidTargetLinkPath: "-",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeIndicatorByPath(context.Background(), tt.indicatorByPath, nil, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeIndicatorByPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			require.Equal(t, tt.normalized, got, "normalizeIndicatorByPath() return value")
+		})
+	}
+}
+
+func Test_normalizeIgnorePatterns(t *testing.T) {
+	if err := os.Setenv("NORMALIZE_EXCLUDE_BY_PATTERN_KEY", "test"); err != nil {
+		t.Fatalf("failed to set NORMALIZE_EXCLUDE_BY_PATTERN_KEY: %s", err)
+		return
+	}
+
+	_, currentFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatalf("failed to get caller file")
+		return
+	}
+	currentDir := filepath.Dir(currentFile)
+
+	tests := []struct {
+		name       string
+		pattern    string
+		inWorkDir  bool
+		normalized string
+	}{
+		{
+			name:       "expands env",
+			pattern:    "/$NORMALIZE_EXCLUDE_BY_PATTERN_KEY/path/to/ignore",
+			normalized: "/test/path/to/ignore",
+		},
+		{
+			name:       "expands current dir",
+			pattern:    "path/to/ignore",
+			normalized: filepath.Join(currentDir, "path/to/ignore"),
+		},
+		{
+			name:       "keeps trailing slash",
+			pattern:    "path/to/ignore/",
+			normalized: filepath.Join(currentDir, "path/to/ignore") + "/",
+		},
+		{
+			name:       "does not expand unanchored pattern",
+			pattern:    "*.log",
+			normalized: "*.log",
+		},
+		{
+			name:       "does not expand unanchored directory pattern",
+			pattern:    "build/",
+			normalized: "build/",
+		},
+		{
+			name:       "binds unanchored pattern to the working directory",
+			pattern:    "*.log",
+			inWorkDir:  true,
+			normalized: filepath.Join(currentDir, "**", "*.log"),
+		},
+		{
+			name:       "binds unanchored directory pattern to the working directory",
+			pattern:    "build/",
+			inWorkDir:  true,
+			normalized: filepath.Join(currentDir, "**", "build") + "/",
+		},
+		{
+			name:       "anchored pattern in the working directory",
+			pattern:    "path/to/ignore",
+			inWorkDir:  true,
+			normalized: filepath.Join(currentDir, "path/to/ignore"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeIgnorePatterns([]ignorePattern{{Pattern: tt.pattern, Action: ignoreChanges, inWorkDir: tt.inWorkDir}})
+			if err != nil {
+				t.Fatalf("normalizeIgnorePatterns() error = %v", err)
+			}
+			if len(got) != 1 || got[0].Pattern != tt.normalized {
+				t.Errorf("normalizeIgnorePatterns() = %v, want %v", got, tt.normalized)
+			}
+		})
+	}
+
+	got, err := normalizeIgnorePatterns([]ignorePattern{{Pattern: `re:^/$NORMALIZE_EXCLUDE_BY_PATTERN_KEY/.*\.map$`, Action: excludeFromCache}})
+	if err != nil {
+		t.Fatalf("normalizeIgnorePatterns() error = %v", err)
+	}
+	if got[0].Pattern != `re:^/$NORMALIZE_EXCLUDE_BY_PATTERN_KEY/.*\.map$` {
+		t.Errorf("normalizeIgnorePatterns() expanded regex pattern: %s", got[0].Pattern)
+	}
+
+	if _, err := normalizeIgnorePatterns([]ignorePattern{{Pattern: "re:path/(", Action: ignoreChanges}}); err == nil {
+		t.Errorf("normalizeIgnorePatterns() expected error for invalid regex")
+	}
+
+	if _, err := normalizeIgnorePatterns([]ignorePattern{{Pattern: "path/[a-", Action: ignoreChanges}}); err == nil {
+		t.Errorf("normalizeIgnorePatterns() expected error for invalid pattern")
+	}
+}
+
+func compileIgnorePatterns(t *testing.T, patterns []ignorePattern) []ignorePattern {
+	var compiled []ignorePattern
+	for _, pattern := range patterns {
+		p, err := compileIgnorePattern(pattern.Pattern, pattern.Action)
+		if err != nil {
+			t.Fatalf("failed to compile ignore pattern: %s", err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled
+}
+
+func Test_match(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ignore_pattern")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "build", "file"): ""})
+
+	tests := []struct {
+		name     string
+		pth      string
+		patterns []ignorePattern
+		ok       bool
+		action   ignoreAction
+	}{
+		{
+			name:     "simple no match",
+			pth:      "/path/to/include",
+			patterns: []ignorePattern{{Pattern: "/path/to/exclude", Action: ignoreChanges}},
+		},
+		{
+			name:     "full match",
+			pth:      "/path/to/cache",
+			patterns: []ignorePattern{{Pattern: "/path/to/cache", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "parent directory match",
+			pth:      "/path/to/cache/file",
+			patterns: []ignorePattern{{Pattern: "/path/to", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "no prefix match",
+			pth:      "/path/to/cache2",
+			patterns: []ignorePattern{{Pattern: "/path/to/cache", Action: ignoreChanges}},
+		},
+		{
+			name:     "glob match",
+			pth:      "/path/to/cache",
+			patterns: []ignorePattern{{Pattern: "/path/*/cache", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "glob does not match multiple elements",
+			pth:      "/path/to/the/cache",
+			patterns: []ignorePattern{{Pattern: "/path/*/cache", Action: ignoreChanges}},
+		},
+		{
+			name:     "double star match",
+			pth:      "/path/to/the/cache",
+			patterns: []ignorePattern{{Pattern: "/path/**/cache", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "leading double star match",
+			pth:      "/path/to/cache",
+			patterns: []ignorePattern{{Pattern: "**/cache", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "unanchored pattern matches at any depth",
+			pth:      "/path/to/app.log",
+			patterns: []ignorePattern{{Pattern: "*.log", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "directory pattern matches the directory content",
+			pth:      "/path/build/file",
+			patterns: []ignorePattern{{Pattern: "build/", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "directory pattern does not match a file",
+			pth:      "/path/to/build",
+			patterns: []ignorePattern{{Pattern: "build/", Action: ignoreChanges}},
+		},
+		{
+			name:     "directory pattern matches a directory",
+			pth:      filepath.Join(tmpDir, "build"),
+			patterns: []ignorePattern{{Pattern: "build/", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "exclude",
+			pth:      "/path/to/cache",
+			patterns: []ignorePattern{{Pattern: "/path/to/cache", Action: excludeFromCache}},
+			ok:       true,
+			action:   excludeFromCache,
+		},
+		{
+			name:     "last match wins",
+			pth:      "/path/to/cache.log",
+			patterns: []ignorePattern{{Pattern: "*.log", Action: excludeFromCache}, {Pattern: "/path/to", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "same pattern, the last one wins",
+			pth:      "/path/to/foo",
+			patterns: []ignorePattern{{Pattern: "foo", Action: excludeFromCache}, {Pattern: "foo", Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "re-include",
+			pth:      "/path/to/keep.log",
+			patterns: []ignorePattern{{Pattern: "*.log", Action: excludeFromCache}, {Pattern: "keep.log", Action: reinclude}},
+		},
+		{
+			name:     "regex match",
+			pth:      "/path/to/build-1.2.3/file",
+			patterns: []ignorePattern{{Pattern: `re:/build-\d+\.\d+\.\d+$`, Action: excludeFromCache}},
+			ok:       true,
+			action:   excludeFromCache,
+		},
+		{
+			name:     "regex no match",
+			pth:      "/path/to/build-latest/file",
+			patterns: []ignorePattern{{Pattern: `re:/build-\d+\.\d+\.\d+$`, Action: excludeFromCache}},
+		},
+		{
+			name:     "regex hashed file name",
+			pth:      "/path/to/main.3f2a9c1b.js",
+			patterns: []ignorePattern{{Pattern: `re:\.[0-9a-f]{8}\.js$`, Action: ignoreChanges}},
+			ok:       true,
+			action:   ignoreChanges,
+		},
+		{
+			name:     "exclude after re-include",
+			pth:      "/path/to/keep.log",
+			patterns: []ignorePattern{{Pattern: "keep.log", Action: reinclude}, {Pattern: "*.log", Action: excludeFromCache}},
+			ok:       true,
+			action:   excludeFromCache,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, ok := match(tt.pth, compileIgnorePatterns(t, tt.patterns))
+			if ok != tt.ok {
+				t.Errorf("match() ok = %v, want %v", ok, tt.ok)
+			}
+			if action != tt.action {
+				t.Errorf("match() action = %v, want %v", action, tt.action)
+			}
+		})
+	}
+}
+
+func Test_interleave(t *testing.T) {
+	tests := []struct {
+		name                string
+		indicatorByPth      map[string]string
+		patterns            []ignorePattern
+		indicatorByCachePth map[string]string
+		wantErr             bool
+	}{
+		{
+			name:                "no indicator, own content is the indicator",
+			indicatorByPth:      map[string]string{"/path/to/cache": ""},
+			indicatorByCachePth: map[string]string{"/path/to/cache": "/path/to/cache"},
+		},
+		{
+			name: "no indicator, own content is the indicator more files",
+			indicatorByPth: map[string]string{
+				"/path/to/cache":  "",
+				"/path/to/cache2": "",
+			},
+			indicatorByCachePth: map[string]string{
+				"/path/to/cache":  "/path/to/cache",
+				"/path/to/cache2": "/path/to/cache2",
+			},
+		},
+		{
+			name:                "no ignore match",
+			indicatorByPth:      map[string]string{"/path/to/cache": "indicator/path"},
+			patterns:            []ignorePattern{{Pattern: "/path/to/include", Action: ignoreChanges}},
+			indicatorByCachePth: map[string]string{"/path/to/cache": "indicator/path"},
+		},
+		{
+			name:                "ignore match, do not track changes",
+			indicatorByPth:      map[string]string{"/path/to/cache": "indicator/path"},
+			patterns:            []ignorePattern{{Pattern: "/path/to", Action: ignoreChanges}},
+			indicatorByCachePth: map[string]string{"/path/to/cache": ""},
+		},
+		{
+			name:                "exclude match, remove",
+			indicatorByPth:      map[string]string{"/path/to/cache": "indicator/path"},
+			patterns:            []ignorePattern{{Pattern: "/path/to", Action: excludeFromCache}},
+			indicatorByCachePth: map[string]string{},
+		},
+		{
+			name:                "both ignore and exclude match, the last one wins",
+			indicatorByPth:      map[string]string{"/path/to/cache.log": "indicator/path"},
+			patterns:            []ignorePattern{{Pattern: "/path/to", Action: ignoreChanges}, {Pattern: "*.log", Action: excludeFromCache}},
+			indicatorByCachePth: map[string]string{},
+		},
+		{
+			name:                "re-included after exclude, track changes",
+			indicatorByPth:      map[string]string{"/path/to/cache": "", "/path/to/other": ""},
+			patterns:            []ignorePattern{{Pattern: "/path/to", Action: excludeFromCache}, {Pattern: "cache", Action: reinclude}},
+			indicatorByCachePth: map[string]string{"/path/to/cache": "/path/to/cache"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := interleave(tt.indicatorByPth, compileIgnorePatterns(t, tt.patterns))
+			if !reflect.DeepEqual(got, tt.indicatorByCachePth) {
+				t.Errorf("interleave() = %v, want %v", got, tt.indicatorByCachePth)
+			}
+		})
+	}
+}
+
+func Test_interleave_ignoreListInWorkDir(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	patterns, err := normalizeIgnorePatterns(parseIgnoreList([]string{"!*.log", "build/"}))
+	require.NoError(t, err)
+
+	inWorkDir := filepath.Join(workDir, "app", "debug.log")
+	buildFile := filepath.Join(workDir, "app", "build", "output.bin")
+	require.Equal(t, map[string]string{
+		buildFile:                    "",
+		"/home/user/.gradle/a.log":   "/home/user/.gradle/a.log",
+		"/home/user/build/output.js": "/home/user/build/output.js",
+	}, interleave(map[string]string{
+		inWorkDir:                    "",
+		buildFile:                    "",
+		"/home/user/.gradle/a.log":   "",
+		"/home/user/build/output.js": "",
+	}, patterns))
+}
+
+func Test_isSymlink(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("setup: failed to create tmp dir, error: %s", err)
+	}
+
+	tmpFile, err := ioutil.TempFile(tmpDir, "")
+	if err != nil {
+		t.Errorf("setup: failed to create tmp file, error: %s", err)
+	}
+
+	linkPth := path.Join(tmpDir, "symlink")
+	if err := os.Symlink(tmpFile.Name(), linkPth); err != nil {
+		t.Errorf("setup: failed to create symlink, error: %s", err)
+	}
+
+	linkDirPth := path.Join(tmpDir, "symlink_dir")
+	if err := os.Symlink(tmpDir, linkDirPth); err != nil {
+		t.Errorf("setup: failed to create symlink, error: %s", err)
+	}
+
+	invalidTargetLinkPth := path.Join(tmpDir, "link_invalid")
+	if err := os.Symlink("nonexistent_target", invalidTargetLinkPth); err != nil {
+		t.Errorf("setup: failed to create symlink, error: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		pth     string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:    "symlink to file",
+			pth:     linkPth,
+			want:    true,
+			wantErr: false,
+		},
+		{
+			name:    "symlink to dir",
+			pth:     linkDirPth,
+			want:    true,
+			wantErr: false,
+		},
+		{
+			name:    "invalid target",
+			pth:     invalidTargetLinkPth,
+			want:    true,
+			wantErr: false,
+		},
+		{
+			name:    "regurlar file",
+			pth:     tmpFile.Name(),
+			want:    false,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isSymlink(tt.pth)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("isSymlink() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("isSymlink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_selfInvalidatingIndicators(t *testing.T) {
+	tests := []struct {
+		name                string
+		indicatorByCachePth map[string]string
+		want                map[string]int
+	}{
+		{
+			name: "indicator outside of the cache",
+			indicatorByCachePth: map[string]string{
+				"/cache/a": "/repo/lock",
+				"/cache/b": "/repo/lock",
+			},
+			want: map[string]int{},
+		},
+		{
+			name: "files are their own indicators",
+			indicatorByCachePth: map[string]string{
+				"/cache/a": "/cache/a",
+				"/cache/b": "",
+			},
+			want: map[string]int{},
+		},
+		{
+			name: "indicator inside the cache",
+			indicatorByCachePth: map[string]string{
+				"/cache/a":    "/cache/lock",
+				"/cache/b":    "/cache/lock",
+				"/cache/lock": "/cache/lock",
+			},
+			want: map[string]int{"/cache/lock": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, selfInvalidatingIndicators(tt.indicatorByCachePth))
+		})
+	}
+}
+
+func Test_autoExclude(t *testing.T) {
+	indicatorByCachePth := map[string]string{
+		"/root/.gradle/caches/journal-1/file-access.bin": "",
+		"/root/.gradle/caches/modules-2/modules-2.lock":  "",
+		"/root/.gradle/caches/modules-2/files-2.1/a.jar": "/root/.gradle/caches/modules-2/files-2.1/a.jar",
+		"/root/project/ios/Pods/Manifest.lock":           "",
+		"/root/project/node_modules/dep/yarn.lock":       "",
+	}
+	patterns := parseAutoExcludeList([]string{"*/.gradle/caches/*.lock", " */.gradle/caches/journal-1/* ", ""})
+
+	kept, excluded := autoExclude(indicatorByCachePth, patterns)
+	require.Equal(t, map[string]string{
+		"/root/.gradle/caches/modules-2/files-2.1/a.jar": "/root/.gradle/caches/modules-2/files-2.1/a.jar",
+		"/root/project/ios/Pods/Manifest.lock":           "",
+		"/root/project/node_modules/dep/yarn.lock":       "",
+	}, kept)
+	require.Equal(t, []string{
+		"/root/.gradle/caches/journal-1/file-access.bin",
+		"/root/.gradle/caches/modules-2/modules-2.lock",
+	}, excluded)
+
+	kept, excluded = autoExclude(indicatorByCachePth, nil)
+	require.Equal(t, indicatorByCachePth, kept)
+	require.Empty(t, excluded)
+}
+
+func Test_outermostPaths(t *testing.T) {
+	require.Equal(t, []string{"/a/node_modules", "/b/node_modules"}, outermostPaths([]string{
+		"/b/node_modules",
+		"/a/node_modules/dep/node_modules",
+		"/a/node_modules",
+	}))
+	require.Equal(t, []string{"/a/build", "/a/build2"}, outermostPaths([]string{"/a/build2", "/a/build"}))
+}
+
+func Test_parseScopedExcludes(t *testing.T) {
+	require.Equal(t, []ignorePattern{
+		{Pattern: "node_modules/**/*.map", Action: excludeFromCache},
+		{Pattern: "node_modules/**/.cache/", Action: excludeFromCache},
+		{Pattern: "node_modules/dep/dist", Action: excludeFromCache},
+		{Pattern: "~/.gradle/caches/build-cache-1", Action: excludeFromCache},
+	}, parseScopedExcludes([]string{
+		"node_modules -> package-lock.json !*.map !.cache/ !/dep/dist",
+		"ios/Pods -> ios/Podfile.lock",
+		"~/.gradle/ !caches/build-cache-1",
+		"-> indicator !*.map",
+	}))
+
+	patterns, err := normalizeIgnorePatterns(parseScopedExcludes([]string{"/cache/node_modules !*.map", "/cache/Pods"}))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"/cache/node_modules/index.js": "/cache/node_modules/index.js",
+		"/cache/Pods/lib.js":           "/cache/Pods/lib.js",
+		"/cache/Pods/lib.js.map":       "/cache/Pods/lib.js.map",
+	}, interleave(map[string]string{
+		"/cache/node_modules/index.js":         "",
+		"/cache/node_modules/dep/index.js.map": "",
+		"/cache/Pods/lib.js":                   "",
+		"/cache/Pods/lib.js.map":               "",
+	}, patterns))
+}
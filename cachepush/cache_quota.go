@@ -0,0 +1,134 @@
+// Cache storage quota related functions.
+//
+// Before uploading, the step asks the cache API for the project's current storage usage and quota.
+// If the new archive would exceed the quota, the configured policy decides whether the step fails or skips the push.
+package cachepush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// QuotaPolicy ...
+type QuotaPolicy string
+
+const (
+	// QuotaPolicyNone ...
+	QuotaPolicyNone = QuotaPolicy("none")
+	// QuotaPolicyFail ...
+	QuotaPolicyFail = QuotaPolicy("fail")
+	// QuotaPolicySkip ...
+	QuotaPolicySkip = QuotaPolicy("skip")
+)
+
+const remainingQuotaEnvKey = "BITRISE_CACHE_REMAINING_QUOTA_BYTES"
+
+// quotaInfo is the cache storage usage reported by the cache API.
+type quotaInfo struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	QuotaBytes int64 `json:"quota_bytes"`
+}
+
+// remaining returns the free storage after storing an archive of the given size.
+func (q quotaInfo) remaining(archiveSize int64) int64 {
+	return q.QuotaBytes - q.UsedBytes - archiveSize
+}
+
+// usageURL returns the storage usage endpoint of the cache API.
+func usageURL(cacheAPIURL string) (string, error) {
+	u, err := url.Parse(cacheAPIURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "usage")
+	return u.String(), nil
+}
+
+// queryQuota requests the storage usage from the cache API.
+// It returns nil if the endpoint does not report usage (the API does not support quotas).
+func queryQuota(ctx context.Context, cacheAPIURL string) (*quotaInfo, error) {
+	usage, err := usageURL(cacheAPIURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cache API url: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, usage, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %s", err)
+	}
+
+	envOf(ctx).cacheAPI.authorize(req)
+
+	resp, err := newHTTPClient(ctx, cacheAPIRequestTimeout).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %s", err)
+	}
+	diag.addResponse(resp, body)
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNotImplemented {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("usage request failed with status code: %d", resp.StatusCode)
+	}
+
+	var info quotaInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %s", err)
+	}
+	if info.QuotaBytes <= 0 {
+		return nil, nil
+	}
+	return &info, nil
+}
+
+// queryQuotaWithFailover returns the storage usage reported by the first endpoint answering the request.
+func queryQuotaWithFailover(ctx context.Context, endpoints []string) (*quotaInfo, error) {
+	var err error
+	for _, endpoint := range endpoints {
+		var info *quotaInfo
+		info, err = queryQuota(ctx, endpoint)
+		if err == nil {
+			return info, nil
+		}
+		log.Warnf("Usage request to cache API endpoint (%s) failed: %s", redactURL(endpoint), err)
+	}
+	return nil, err
+}
+
+// checkQuota applies the policy on an archive of the given size.
+// It returns true if the push needs to be skipped, or an error if the step needs to fail.
+func checkQuota(info quotaInfo, archiveSize int64, policy QuotaPolicy) (bool, error) {
+	if info.remaining(archiveSize) >= 0 {
+		return false, nil
+	}
+
+	msg := fmt.Sprintf("cache archive (%d bytes) would exceed the storage quota (%d of %d bytes used)", archiveSize, info.UsedBytes, info.QuotaBytes)
+	switch policy {
+	case QuotaPolicyFail:
+		return false, fmt.Errorf("%s", msg)
+	case QuotaPolicySkip:
+		log.Warnf("The %s, skipping push...", msg)
+		return true, nil
+	default:
+		log.Warnf("The %s", msg)
+		return false, nil
+	}
+}
@@ -0,0 +1,55 @@
+package cachepush
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cache/usage":
+			_, err := w.Write([]byte(`{"used_bytes": 30, "quota_bytes": 100}`))
+			require.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	info, err := queryQuota(context.Background(), server.URL+"/cache")
+	require.NoError(t, err)
+	require.Equal(t, &quotaInfo{UsedBytes: 30, QuotaBytes: 100}, info)
+
+	info, err = queryQuota(context.Background(), server.URL+"/unsupported")
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
+
+func Test_checkQuota(t *testing.T) {
+	info := quotaInfo{UsedBytes: 30, QuotaBytes: 100}
+
+	tests := []struct {
+		name        string
+		archiveSize int64
+		policy      QuotaPolicy
+		wantSkip    bool
+		wantErr     bool
+	}{
+		{name: "fits", archiveSize: 70, policy: QuotaPolicyFail},
+		{name: "exceeds, fail", archiveSize: 71, policy: QuotaPolicyFail, wantErr: true},
+		{name: "exceeds, skip", archiveSize: 71, policy: QuotaPolicySkip, wantSkip: true},
+		{name: "exceeds, none", archiveSize: 71, policy: QuotaPolicyNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skip, err := checkQuota(info, tt.archiveSize, tt.policy)
+			require.Equal(t, tt.wantErr, err != nil)
+			require.Equal(t, tt.wantSkip, skip)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Cache size limit related functions.
+//
+// Pushing a huge archive slows down every subsequent pull, so the archive size can be limited.
+// The uncompressed content size is checked before archiving (an uncompressed archive is never smaller),
+// the archive size is checked once the archive is generated.
+package cachepush
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// checkSizeLimit applies the policy on an archive of the given size, limit 0 means no limit.
+// It returns true if the push needs to be skipped, or an error if the step needs to fail.
+func checkSizeLimit(size, limit int64, estimated bool, policy QuotaPolicy) (bool, error) {
+	if limit <= 0 || size <= limit {
+		return false, nil
+	}
+
+	kind := "cache archive"
+	if estimated {
+		kind = "estimated cache archive"
+	}
+	msg := fmt.Sprintf("%s size (%s) exceeds the limit (%s)", kind, formatSize(size), formatSize(limit))
+	if policy == QuotaPolicyFail {
+		return false, fmt.Errorf("%s", msg)
+	}
+	log.Warnf("The %s, skipping push...", msg)
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package cachepush
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkSizeLimit(t *testing.T) {
+	skip, err := checkSizeLimit(100, 0, false, QuotaPolicyFail)
+	require.NoError(t, err)
+	require.False(t, skip)
+
+	skip, err = checkSizeLimit(100, 100, false, QuotaPolicyFail)
+	require.NoError(t, err)
+	require.False(t, skip)
+
+	skip, err = checkSizeLimit(2048, 1024, false, QuotaPolicySkip)
+	require.NoError(t, err)
+	require.True(t, skip)
+
+	_, err = checkSizeLimit(2048, 1024, true, QuotaPolicyFail)
+	require.EqualError(t, err, "estimated cache archive size (2.0 KB) exceeds the limit (1.0 KB)")
+}
+
+func TestRun_sizeLimit(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	content := make([]byte, 2*1024*1024)
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file"): string(content)})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+
+	configs := Config{
+		Paths:                tmpDir,
+		CacheAPIURL:          server.URL,
+		FingerprintMethodID:  string(MD5),
+		CompressArchive:      "false",
+		QuotaPolicy:          string(QuotaPolicyNone),
+		MaxCacheSizeMB:       1,
+		CacheSizeLimitPolicy: string(QuotaPolicySkip),
+	}
+	report, err := Run(context.Background(), configs)
+	require.NoError(t, err)
+	require.False(t, report.Pushed)
+	require.Equal(t, "cache size limit exceeded", report.SkipReason)
+
+	configs.CacheSizeLimitPolicy = string(QuotaPolicyFail)
+	_, err = Run(context.Background(), configs)
+	require.Error(t, err)
+}
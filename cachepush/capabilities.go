@@ -0,0 +1,189 @@
+// Runtime capability probing.
+//
+// Some stacks mount the temp volume with noatime (or relatime), have little free disk space
+// or lack the external tools some inputs rely on (git, docker).
+// Instead of failing mid-run, the step probes these capabilities at startup
+// and selects a configuration supported by the environment.
+// The archive is written and compressed in-process (archive/tar, compress/gzip), so no tar or compressor binary is probed.
+// Extended attributes are not archived, so their support is not probed either.
+package cachepush
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// atimeMode is how the file system updates the access time of the files.
+type atimeMode string
+
+const (
+	// atimeStrict updates the access time on every read.
+	atimeStrict atimeMode = "strict"
+	// atimeRelative (relatime) updates the access time only if it is older than the mod time or a day.
+	atimeRelative atimeMode = "relatime"
+	// atimeNone (noatime) never updates the access time.
+	atimeNone atimeMode = "noatime"
+)
+
+// capabilities are the probed features of the environment.
+type capabilities struct {
+	// atime is how reading a file updates its access time.
+	atime atimeMode
+	// freeDiskSpace is the free space in bytes on the archive's volume, -1 if unknown.
+	freeDiskSpace int64
+	// missingTools are the required external tools which are not installed.
+	missingTools []string
+}
+
+// toolFallbacks describes how the step works without the external tools.
+var toolFallbacks = map[string]string{
+	"git":    "git blob hashes are calculated without the repository's filters",
+	"docker": "docker volumes are not cached",
+}
+
+// probeCapabilities probes the environment using the given directory (the archive's directory)
+// and checks whether the tools required by the inputs are installed.
+func probeCapabilities(dir string, tools ...string) capabilities {
+	caps := capabilities{
+		atime:         probeAtime(dir),
+		freeDiskSpace: freeDiskSpace(dir),
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			log.Debugf("Failed to find %s: %s", tool, err)
+			caps.missingTools = append(caps.missingTools, tool)
+		}
+	}
+	return caps
+}
+
+// requiredTools returns the external tools the inputs rely on.
+func requiredTools(configs Config) []string {
+	var tools []string
+	if ChangeIndicator(configs.FingerprintMethodID) == GITBLOB {
+		tools = append(tools, "git")
+	}
+	if strings.TrimSpace(configs.DockerVolumes) != "" {
+		tools = append(tools, "docker")
+	}
+	return tools
+}
+
+// hasTool reports whether the tool is installed, tools which were not probed are reported as installed.
+func (c capabilities) hasTool(tool string) bool {
+	for _, missing := range c.missingTools {
+		if missing == tool {
+			return false
+		}
+	}
+	return true
+}
+
+// reliableAtime reports whether reading a file updates its access time (at least daily).
+func (c capabilities) reliableAtime() bool {
+	return c.atime == atimeStrict || c.atime == atimeRelative
+}
+
+// probeAtime detects how reading a file in the given directory updates its access time.
+func probeAtime(dir string) atimeMode {
+	file, err := ioutil.TempFile(dir, "cache-push-atime-probe")
+	if err != nil {
+		log.Debugf("Failed to create atime probe file: %s", err)
+		return atimeNone
+	}
+	pth := file.Name()
+	defer func() {
+		if err := os.Remove(pth); err != nil {
+			log.Debugf("Failed to remove atime probe file: %s", err)
+		}
+	}()
+
+	if _, err := file.WriteString("probe"); err != nil {
+		log.Debugf("Failed to write atime probe file: %s", err)
+		return atimeNone
+	}
+	if err := file.Close(); err != nil {
+		log.Debugf("Failed to close atime probe file: %s", err)
+		return atimeNone
+	}
+
+	// relatime updates an access time older than a day
+	past := time.Now().Add(-48 * time.Hour)
+	if !atimeUpdated(pth, past, past) {
+		return atimeNone
+	}
+
+	// but not a recent one newer than the mod time
+	recent := time.Now().Add(-time.Hour)
+	if !atimeUpdated(pth, recent, recent.Add(-time.Hour)) {
+		return atimeRelative
+	}
+	return atimeStrict
+}
+
+// atimeUpdated sets the file's times, reads the file and reports whether its access time was updated.
+func atimeUpdated(pth string, atime, mtime time.Time) bool {
+	if err := os.Chtimes(pth, atime, mtime); err != nil {
+		log.Debugf("Failed to set atime probe file times: %s", err)
+		return false
+	}
+
+	if _, err := ioutil.ReadFile(pth); err != nil {
+		log.Debugf("Failed to read atime probe file: %s", err)
+		return false
+	}
+
+	info, err := os.Stat(pth)
+	if err != nil {
+		log.Debugf("Failed to stat atime probe file: %s", err)
+		return false
+	}
+	return fileAccessTime(info).After(atime)
+}
+
+// freeDiskSpace returns the free space in bytes available for the user on the given directory's volume, -1 if unknown.
+func freeDiskSpace(dir string) int64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		log.Debugf("Failed to get free disk space: %s", err)
+		return -1
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize)
+}
+
+// degraded returns the description of the unavailable features.
+func (c capabilities) degraded() []string {
+	var degraded []string
+	switch c.atime {
+	case atimeNone:
+		degraded = append(degraded, "access time is not updated (unused cache entry report disabled, eviction uses the atime fallback)")
+	case atimeRelative:
+		degraded = append(degraded, "access time is updated at most daily (relatime)")
+	}
+	if c.freeDiskSpace < 0 {
+		degraded = append(degraded, "free disk space is unknown")
+	}
+	for _, tool := range c.missingTools {
+		degraded = append(degraded, tool+" is not installed ("+toolFallbacks[tool]+")")
+	}
+	return degraded
+}
+
+// String returns the one-line report of the capabilities.
+func (c capabilities) String() string {
+	if degraded := c.degraded(); len(degraded) > 0 {
+		return "degraded: " + strings.Join(degraded, ", ")
+	}
+	return "all features available"
+}
+
+// needsCompression reports whether an archive of the given uncompressed size only fits on the disk if it is compressed.
+func (c capabilities) needsCompression(contentSize int64) bool {
+	return c.freeDiskSpace >= 0 && contentSize > c.freeDiskSpace
+}
@@ -0,0 +1,48 @@
+package cachepush
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_probeCapabilities(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("capabilities")
+	require.NoError(t, err)
+
+	caps := probeCapabilities(tmpDir)
+	require.True(t, caps.freeDiskSpace > 0)
+	require.Contains(t, []atimeMode{atimeStrict, atimeRelative, atimeNone}, caps.atime)
+	require.Empty(t, caps.missingTools)
+
+	caps = probeCapabilities(tmpDir, "cache-push-missing-tool")
+	require.Equal(t, []string{"cache-push-missing-tool"}, caps.missingTools)
+	require.False(t, caps.hasTool("cache-push-missing-tool"))
+	require.True(t, caps.hasTool("git"))
+}
+
+func Test_requiredTools(t *testing.T) {
+	require.Empty(t, requiredTools(Config{FingerprintMethodID: string(MD5)}))
+	require.Equal(t, []string{"git", "docker"}, requiredTools(Config{FingerprintMethodID: string(GITBLOB), DockerVolumes: "gradle-cache"}))
+}
+
+func Test_capabilities(t *testing.T) {
+	caps := capabilities{atime: atimeStrict, freeDiskSpace: 100}
+	require.Equal(t, "all features available", caps.String())
+	require.True(t, caps.reliableAtime())
+	require.False(t, caps.needsCompression(100))
+	require.True(t, caps.needsCompression(101))
+
+	caps = capabilities{atime: atimeRelative, freeDiskSpace: 100}
+	require.Equal(t, "degraded: access time is updated at most daily (relatime)", caps.String())
+	require.True(t, caps.reliableAtime())
+
+	caps = capabilities{atime: atimeNone, freeDiskSpace: -1}
+	require.Equal(t, "degraded: access time is not updated (unused cache entry report disabled, eviction uses the atime fallback), free disk space is unknown", caps.String())
+	require.False(t, caps.needsCompression(101))
+	require.False(t, caps.reliableAtime())
+
+	caps = capabilities{atime: atimeStrict, freeDiskSpace: 100, missingTools: []string{"docker"}}
+	require.Equal(t, "degraded: docker is not installed (docker volumes are not cached)", caps.String())
+}
@@ -0,0 +1,70 @@
+// Change log related functions.
+//
+// The changed, added and removed paths are logged grouped by their directory, so large diffs stay readable.
+// The number of logged paths is limited, the rest is summarized.
+package cachepush
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// changeLogGroup is the list of changed files in a directory.
+type changeLogGroup struct {
+	dir   string
+	names []string
+}
+
+// groupByDir groups the paths by their directory, both the directories and the file names are sorted.
+func groupByDir(paths []string) []changeLogGroup {
+	namesByDir := map[string][]string{}
+	for _, pth := range paths {
+		dir := filepath.Dir(pth)
+		namesByDir[dir] = append(namesByDir[dir], filepath.Base(pth))
+	}
+
+	groups := make([]changeLogGroup, 0, len(namesByDir))
+	for dir, names := range namesByDir {
+		sort.Strings(names)
+		groups = append(groups, changeLogGroup{dir: dir, names: names})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].dir < groups[j].dir })
+	return groups
+}
+
+// changeLogLines returns the lines listing the paths grouped by directory.
+// At most maxEntries paths are listed (all of them if maxEntries is 0), the rest is summarized.
+func changeLogLines(paths []string, maxEntries int) []string {
+	var lines []string
+	listed, remaining := 0, len(paths)
+	groups := groupByDir(paths)
+	for i, group := range groups {
+		if maxEntries > 0 && listed >= maxEntries {
+			lines = append(lines, fmt.Sprintf("- ... and %d more files in %d directories", remaining, len(groups)-i))
+			break
+		}
+
+		lines = append(lines, fmt.Sprintf("- %s (%d files):", group.dir, len(group.names)))
+		for j, name := range group.names {
+			if maxEntries > 0 && listed >= maxEntries {
+				lines = append(lines, fmt.Sprintf("  - ... and %d more files", len(group.names)-j))
+				break
+			}
+			lines = append(lines, "  - "+name)
+			listed++
+		}
+		remaining -= len(group.names)
+	}
+	return lines
+}
+
+// logChanges logs the number of changed paths as a warning, followed by the paths grouped by directory.
+func logChanges(format string, paths []string, maxEntries int) {
+	log.Warnf(format, len(paths))
+	for _, line := range changeLogLines(paths, maxEntries) {
+		log.Printf("%s", line)
+	}
+}
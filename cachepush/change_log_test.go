@@ -0,0 +1,48 @@
+package cachepush
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_groupByDir(t *testing.T) {
+	require.Equal(t, []changeLogGroup{
+		{dir: "/cache/a", names: []string{"1", "2"}},
+		{dir: "/cache/b", names: []string{"3"}},
+	}, groupByDir([]string{"/cache/b/3", "/cache/a/2", "/cache/a/1"}))
+	require.Equal(t, []changeLogGroup{}, groupByDir(nil))
+}
+
+func Test_changeLogLines(t *testing.T) {
+	paths := []string{"/cache/a/1", "/cache/a/2", "/cache/a/3", "/cache/b/4", "/cache/c/5"}
+
+	require.Equal(t, []string{
+		"- /cache/a (3 files):",
+		"  - 1",
+		"  - 2",
+		"  - 3",
+		"- /cache/b (1 files):",
+		"  - 4",
+		"- /cache/c (1 files):",
+		"  - 5",
+	}, changeLogLines(paths, 0))
+
+	require.Equal(t, []string{
+		"- /cache/a (3 files):",
+		"  - 1",
+		"  - 2",
+		"  - ... and 1 more files",
+		"- ... and 2 more files in 2 directories",
+	}, changeLogLines(paths, 2))
+
+	require.Equal(t, []string{
+		"- /cache/a (3 files):",
+		"  - 1",
+		"  - 2",
+		"  - 3",
+		"- ... and 2 more files in 2 directories",
+	}, changeLogLines(paths, 3))
+
+	require.Nil(t, changeLogLines(nil, 10))
+}
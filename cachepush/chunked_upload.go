@@ -0,0 +1,221 @@
+// Chunked (content-addressed) upload related functions.
+//
+// In chunked mode the archive is split into content-defined chunks, and only the chunks missing on the server are uploaded.
+// The archive itself is uploaded as a manifest listing its chunks in order, cache-pull reassembles the archive from the chunks.
+//
+// The chunk API is served next to the cache API url:
+//
+//	POST <cache API url>/chunks/missing {"chunks": ["<sha256>", ...]} responds {"missing": ["<sha256>", ...]}
+//	POST <cache API url>/chunks {"checksum_sha256": "<sha256>", "size": <bytes>} responds {"upload_url": "<url>"}
+package cachepush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// chunkManifest describes the archive as the ordered list of its chunks.
+type chunkManifest struct {
+	Size   int64          `json:"size"`
+	Chunks []contentChunk `json:"chunks"`
+}
+
+// chunkAPIURL returns the url of the chunk API endpoint.
+func chunkAPIURL(cacheAPIURL, endpoint string) string {
+	return strings.TrimSuffix(cacheAPIURL, "/") + "/" + endpoint
+}
+
+// postJSON sends the request body to the url and decodes the response body into resp.
+func postJSON(ctx context.Context, url string, reqBody, respBody interface{}) error {
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %s", err)
+	}
+
+	envOf(ctx).cacheAPI.authorize(req)
+
+	resp, err := newHTTPClient(ctx, cacheAPIRequestTimeout).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %s", err)
+	}
+	diag.addResponse(resp, body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 202 {
+		return fmt.Errorf("request was rejected with status code: %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, respBody); err != nil {
+		return fmt.Errorf("failed to decode response body: %s", err)
+	}
+	return nil
+}
+
+// queryMissingChunks returns the chunks not stored on the server yet.
+func queryMissingChunks(ctx context.Context, cacheAPIURL string, chunks []contentChunk) (map[string]bool, error) {
+	var hashes []string
+	for _, chunk := range chunks {
+		hashes = append(hashes, chunk.Hash)
+	}
+
+	var resp struct {
+		Missing []string `json:"missing"`
+	}
+	if err := postJSON(ctx, chunkAPIURL(cacheAPIURL, "chunks/missing"), map[string]interface{}{"chunks": hashes}, &resp); err != nil {
+		return nil, fmt.Errorf("failed to query missing chunks: %s", err)
+	}
+
+	missing := map[string]bool{}
+	for _, hash := range resp.Missing {
+		missing[hash] = true
+	}
+	return missing, nil
+}
+
+// putSection uploads size bytes of the reader to the upload url.
+func putSection(ctx context.Context, uploadURL string, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, r)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %s", err)
+	}
+	req.ContentLength = size
+
+	resp, err := newHTTPClient(ctx, 0).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiagnosticsResponseBody))
+	if err != nil {
+		log.Debugf("Failed to read upload response body: %s", err)
+	}
+	diag.addResponse(resp, body)
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("upload failed with status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// uploadChunk uploads the chunk of the archive file, failed attempts are retried based on the retry policy.
+func uploadChunk(ctx context.Context, cacheAPIURL string, archive *os.File, chunk contentChunk, retry uploadRetry, rnd *rand.Rand) error {
+	upload := func() error {
+		var resp struct {
+			UploadURL string `json:"upload_url"`
+		}
+		reqBody := map[string]interface{}{"checksum_sha256": chunk.Hash, "size": chunk.Size}
+		if err := postJSON(ctx, chunkAPIURL(cacheAPIURL, "chunks"), reqBody, &resp); err != nil {
+			return fmt.Errorf("failed to generate chunk upload url: %s", err)
+		}
+		if resp.UploadURL == "" {
+			return fmt.Errorf("request sent, but chunk upload url isn't received")
+		}
+		return putSection(ctx, resp.UploadURL, io.NewSectionReader(archive, chunk.Offset, chunk.Size), chunk.Size)
+	}
+
+	err := upload()
+	for attempt := 1; err != nil && attempt <= retry.count; attempt++ {
+		delay := retry.delay(attempt, rnd)
+		log.Warnf("Chunk (%s) upload attempt %d/%d failed: %s, retrying in %s...", chunk.Hash, attempt, retry.count+1, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		err = upload()
+	}
+	return err
+}
+
+// uploadArchiveChunked uploads the chunks of the archive missing on the server, then the chunk manifest as the archive.
+// The archive info sent with the manifest is expected to have Chunked set.
+func uploadArchiveChunked(ctx context.Context, pth, url string, layerID string, info model.ArchiveInfo, retry uploadRetry) error {
+	archive, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file (%s): %s", pth, err)
+	}
+	defer func() {
+		if err := archive.Close(); err != nil {
+			log.Warnf("Failed to close archive file (%s): %s", pth, err)
+		}
+	}()
+
+	chunks, err := splitChunks(archive)
+	if err != nil {
+		return fmt.Errorf("failed to split archive into chunks: %s", err)
+	}
+
+	missing, err := queryMissingChunks(ctx, url, chunks)
+	if err != nil {
+		return err
+	}
+
+	manifest := chunkManifest{Chunks: chunks}
+	var missingCount, missingSize int64
+	uploaded := map[string]bool{}
+	for _, chunk := range chunks {
+		manifest.Size += chunk.Size
+		if missing[chunk.Hash] && !uploaded[chunk.Hash] {
+			uploaded[chunk.Hash] = true
+			missingCount++
+			missingSize += chunk.Size
+		}
+	}
+	log.Printf("Uploading %d of %d chunks (%d of %d bytes), the rest is already stored", missingCount, len(chunks), missingSize, manifest.Size)
+	diag.forGroup(info.Group).add("chunks", map[string]int64{"total": int64(len(chunks)), "uploaded": missingCount, "uploaded_bytes": missingSize})
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, chunk := range chunks {
+		if !uploaded[chunk.Hash] {
+			continue
+		}
+		// a chunk may occur several times in the archive, it is uploaded once
+		delete(uploaded, chunk.Hash)
+
+		if err := uploadChunk(ctx, url, archive, chunk, retry, rnd); err != nil {
+			return err
+		}
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chunk manifest: %s", err)
+	}
+
+	uploadURL, err := getCacheUploadURL(ctx, url, int64(len(manifestData)), layerID, &info, retry)
+	if err != nil {
+		return fmt.Errorf("failed to generate upload url: %s", err)
+	}
+	return putSection(ctx, uploadURL, bytes.NewReader(manifestData), int64(len(manifestData)))
+}
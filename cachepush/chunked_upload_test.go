@@ -0,0 +1,98 @@
+package cachepush
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_uploadArchiveChunked(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	data := make([]byte, 3*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	pth := filepath.Join(tmpDir, "cache.tar")
+	require.NoError(t, ioutil.WriteFile(pth, data, 0600))
+
+	var mu sync.Mutex
+	stored := map[string][]byte{}
+	chunkUploads := 0
+	var manifest chunkManifest
+	var manifestInfo model.ArchiveInfo
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/chunks/missing":
+			var req struct {
+				Chunks []string `json:"chunks"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			missing := []string{}
+			for _, hash := range req.Chunks {
+				if _, ok := stored[hash]; !ok {
+					missing = append(missing, hash)
+				}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string][]string{"missing": missing}))
+		case r.Method == http.MethodPost && r.URL.Path == "/chunks":
+			var req struct {
+				Hash string `json:"checksum_sha256"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]string{"upload_url": server.URL + "/chunk/" + req.Hash}))
+		case r.Method == http.MethodPost:
+			var req struct {
+				Info model.ArchiveInfo `json:"archive_info"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			manifestInfo = req.Info
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]string{"upload_url": server.URL + "/manifest"}))
+		case r.URL.Path == "/manifest":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&manifest))
+		default:
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			stored[filepath.Base(r.URL.Path)] = body
+			chunkUploads++
+		}
+	}))
+	defer server.Close()
+
+	info := model.ArchiveInfo{Chunked: true}
+	require.NoError(t, uploadArchiveChunked(context.Background(), pth, server.URL, "", info, uploadRetry{}))
+	require.True(t, manifestInfo.Chunked)
+	require.Equal(t, int64(len(data)), manifest.Size)
+
+	var reassembled []byte
+	for _, chunk := range manifest.Chunks {
+		reassembled = append(reassembled, stored[chunk.Hash]...)
+	}
+	require.Equal(t, data, reassembled)
+
+	t.Log("only the missing chunks are uploaded")
+	{
+		require.True(t, len(manifest.Chunks) > 1)
+		delete(stored, manifest.Chunks[0].Hash)
+		chunkUploads = 0
+
+		require.NoError(t, uploadArchiveChunked(context.Background(), pth, server.URL, "", info, uploadRetry{}))
+		require.Equal(t, 1, chunkUploads)
+		require.Contains(t, stored, manifest.Chunks[0].Hash)
+	}
+}
@@ -0,0 +1,122 @@
+// Common cache detection related functions.
+//
+// With detect_common_caches enabled the project files (package.json, build.gradle, Podfile and pubspec.yaml)
+// are searched in the working directory and its direct subdirectories (like the ios and android directories
+// of a React Native or Flutter project), and the well-known cache directories of the detected project types
+// are added to the cache paths, with the project's lockfile as the indicator.
+// Local cache directories are only added if they exist, and the paths already in the cache paths are not added again.
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// projectFilePatterns match the project files in the working directory and its direct subdirectories.
+var projectFilePatterns = []string{
+	"package.json", "*/package.json",
+	"build.gradle", "*/build.gradle", "build.gradle.kts", "*/build.gradle.kts",
+	"Podfile", "*/Podfile",
+	"pubspec.yaml", "*/pubspec.yaml",
+}
+
+// nodeLockfiles are the lockfiles of the node package managers, in order of precedence.
+var nodeLockfiles = []string{"yarn.lock", "pnpm-lock.yaml", "package-lock.json", "npm-shrinkwrap.json"}
+
+// existingFile returns the first of the files existing in the directory, an empty string if none exists.
+func existingFile(dir string, names ...string) string {
+	for _, name := range names {
+		pth := filepath.Join(dir, name)
+		if info, err := os.Stat(pth); err == nil && info.Mode().IsRegular() {
+			return pth
+		}
+	}
+	return ""
+}
+
+// existingDir reports whether the directory exists.
+func existingDir(pth string) bool {
+	info, err := os.Stat(pth)
+	return err == nil && info.IsDir()
+}
+
+// projectCaches returns the cache items (`path -> indicator`) of the project file's directory.
+func projectCaches(projectFile string, hasGradleLockfiles bool) [][2]string {
+	dir := filepath.Dir(projectFile)
+	var items [][2]string
+	switch filepath.Base(projectFile) {
+	case "package.json":
+		lockfile := existingFile(dir, nodeLockfiles...)
+		if nodeModules := filepath.Join(dir, "node_modules"); lockfile != "" && existingDir(nodeModules) {
+			items = append(items, [2]string{nodeModules, lockfile})
+		}
+	case "build.gradle", "build.gradle.kts":
+		wrapperProperties := existingFile(dir, filepath.Join("gradle", "wrapper", "gradle-wrapper.properties"))
+		indicator := projectFile
+		if hasGradleLockfiles {
+			indicator = "@gradle-lockfiles"
+		} else if wrapperProperties != "" {
+			indicator = wrapperProperties
+		}
+		items = append(items, [2]string{"~/.gradle/caches", indicator})
+		if wrapperProperties != "" {
+			items = append(items, [2]string{"~/.gradle/wrapper", wrapperProperties})
+		}
+	case "Podfile":
+		lockfile := existingFile(dir, "Podfile.lock")
+		if pods := filepath.Join(dir, "Pods"); lockfile != "" && existingDir(pods) {
+			items = append(items, [2]string{pods, lockfile})
+		}
+	case "pubspec.yaml":
+		pubCache := os.Getenv("PUB_CACHE")
+		if pubCache == "" {
+			pubCache = "~/.pub-cache"
+		}
+		if lockfile := existingFile(dir, "pubspec.lock"); lockfile != "" {
+			items = append(items, [2]string{pubCache, lockfile})
+		}
+	}
+	return items
+}
+
+// detectCommonCaches returns the include items of the common cache directories of the projects found in the root directory.
+// The paths already in the include list are left out.
+func detectCommonCaches(root string, indicatorByPath map[string]string) ([]string, error) {
+	projectFiles, err := findLockfiles(root, projectFilePatterns)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(projectFiles)
+	gradleLockfiles, err := findLockfiles(root, indicatorAliases["@gradle-lockfiles"])
+	if err != nil {
+		return nil, err
+	}
+
+	added := map[string]bool{}
+	for pth := range indicatorByPath {
+		if abs, err := pathutil.AbsPath(pth); err == nil {
+			added[abs] = true
+		}
+	}
+
+	var items []string
+	for _, projectFile := range projectFiles {
+		for _, item := range projectCaches(projectFile, len(gradleLockfiles) > 0) {
+			abs, err := pathutil.AbsPath(item[0])
+			if err != nil {
+				return nil, err
+			}
+			if added[abs] {
+				log.Debugf("Detected cache path is already cached: %s", item[0])
+				continue
+			}
+			added[abs] = true
+			items = append(items, item[0]+" -> "+item[1])
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,56 @@
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_detectCommonCaches(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+	setenvForTest(t, "PUB_CACHE", "")
+
+	root := filepath.Join(tmpDir, "repo")
+	createDirStruct(t, map[string]string{
+		filepath.Join(root, "package.json"):                                              "{}",
+		filepath.Join(root, "yarn.lock"):                                                 "yarn",
+		filepath.Join(root, "node_modules", "dep", "package.json"):                       "{}",
+		filepath.Join(root, "android", "build.gradle"):                                   "gradle",
+		filepath.Join(root, "android", "gradle", "wrapper", "gradle-wrapper.properties"): "wrapper",
+		filepath.Join(root, "ios", "Podfile"):                                            "pods",
+		filepath.Join(root, "ios", "Podfile.lock"):                                       "pods lock",
+		filepath.Join(root, "app", "pubspec.yaml"):                                       "pubspec",
+		filepath.Join(root, "app", "pubspec.lock"):                                       "pubspec lock",
+		filepath.Join(root, "web", "package.json"):                                       "{}",
+	})
+	// ios/Pods does not exist, web has no lockfile
+	detected, err := detectCommonCaches(root, map[string]string{"~/.pub-cache": ""})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"~/.gradle/caches -> " + filepath.Join(root, "android", "gradle", "wrapper", "gradle-wrapper.properties"),
+		"~/.gradle/wrapper -> " + filepath.Join(root, "android", "gradle", "wrapper", "gradle-wrapper.properties"),
+		filepath.Join(root, "node_modules") + " -> " + filepath.Join(root, "yarn.lock"),
+	}, detected)
+
+	t.Log("gradle lockfiles")
+	{
+		createDirStruct(t, map[string]string{
+			filepath.Join(root, "android", "app", "gradle.lockfile"): "lock",
+			filepath.Join(root, "ios", "Pods", "Manifest.lock"):      "manifest",
+		})
+		detected, err := detectCommonCaches(root, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"~/.gradle/caches -> @gradle-lockfiles",
+			"~/.gradle/wrapper -> " + filepath.Join(root, "android", "gradle", "wrapper", "gradle-wrapper.properties"),
+			"~/.pub-cache -> " + filepath.Join(root, "app", "pubspec.lock"),
+			filepath.Join(root, "ios", "Pods") + " -> " + filepath.Join(root, "ios", "Podfile.lock"),
+			filepath.Join(root, "node_modules") + " -> " + filepath.Join(root, "yarn.lock"),
+		}, detected)
+	}
+}
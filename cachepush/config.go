@@ -0,0 +1,119 @@
+package cachepush
+
+import (
+	"os"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+)
+
+// Config stores the step inputs
+type Config struct {
+	Paths                   string          `env:"cache_paths"`
+	CacheGroups             string          `env:"cache_groups"`
+	IgnoredPaths            string          `env:"ignore_check_on_paths"`
+	AutoExcludePatterns     string          `env:"auto_exclude_patterns"`
+	GradleCacheHygiene      bool            `env:"gradle_cache_hygiene"`
+	IOSExclusionProfile     bool            `env:"ios_exclusion_profile"`
+	SecretsScan             string          `env:"secrets_scan,opt[off,warn,exclude]"`
+	ExcludeGitignoredFiles  bool            `env:"exclude_gitignored_files"`
+	ModifiedFilePolicy      string          `env:"modified_file_policy,opt[retry,skip,fail]"`
+	ModifiedFileRetries     int             `env:"modified_file_retry_count,range[0..10]"`
+	ReproducibleArchive     bool            `env:"reproducible_archive"`
+	Archiver                string          `env:"archiver,opt[tar,fast]"`
+	ArchiverWorkers         int             `env:"archiver_workers,range[0..64]"`
+	ArchiverQueueSize       int             `env:"archiver_queue_size,range[0..1024]"`
+	ArchiverBlockSizeKB     int             `env:"archiver_block_size_kb,range[0..65536]"`
+	PathNormalization       string          `env:"path_normalization,opt[auto,case_and_unicode,case,none]"`
+	PreArchiveHook          string          `env:"pre_archive_hook"`
+	ReadonlyPaths           string          `env:"readonly_paths"`
+	DockerVolumes           string          `env:"docker_volumes"`
+	EncryptionKey           stepconf.Secret `env:"encryption_key"`
+	CacheKey                string          `env:"cache_key"`
+	RestoreKeys             string          `env:"restore_keys"`
+	CacheAPIURL             string          `env:"cache_api_url,required"`
+	CacheAPIToken           stepconf.Secret `env:"cache_api_token"`
+	CacheAPITokenHeader     string          `env:"cache_api_token_header"`
+	CacheAPIHeaders         stepconf.Secret `env:"cache_api_headers"`
+	TLSCABundle             string          `env:"tls_ca_bundle"`
+	ProxyURL                stepconf.Secret `env:"proxy_url"`
+	ConnectTimeout          int             `env:"connect_timeout,range[0..600]"`
+	FingerprintMethodID     string          `env:"fingerprint_method,opt[file-content-hash,file-content-hash-xxh64,file-content-hash-blake3,file-mod-time,file-size-and-mod-time,file-git-blob-hash]"`
+	FingerprintIncludeMode  bool            `env:"fingerprint_include_mode"`
+	FingerprintIncludeOwner bool            `env:"fingerprint_include_owner"`
+	FingerprintEnvVars      string          `env:"fingerprint_env_vars"`
+	FingerprintSources      string          `env:"fingerprint_sources"`
+	VerifyModTimeChanges    bool            `env:"verify_mod_time_changes"`
+	AuditSamplePercent      int             `env:"audit_sample_percent,range[0..100]"`
+	CompressArchive         string          `env:"compress_archive,opt[true,false]"`
+	ArchiveMode             string          `env:"archive_mode,opt[full,layered]"`
+	LayerGranularity        string          `env:"layer_granularity,opt[file,root]"`
+	LayerMaxDeltaCount      int             `env:"layer_max_delta_count"`
+	LayerMaxDeltaSizeMB     int             `env:"layer_max_delta_size_mb"`
+	LayerMaxAgeDays         int             `env:"layer_max_age_days"`
+	DebugMode               bool            `env:"is_debug_mode"`
+	MaxChangeLogEntries     int             `env:"max_change_log_entries,range[0..100000]"`
+	DryRun                  bool            `env:"is_dry_run"`
+	ForceCachePush          bool            `env:"force_cache_push"`
+	PushOnBranches          string          `env:"push_on_branches"`
+	SkipOnPullRequests      bool            `env:"skip_on_pull_requests"`
+	PushOnlyOnCacheMiss     bool            `env:"push_only_on_cache_miss"`
+	ReaderConcurrency       int             `env:"reader_concurrency,range[0..64]"`
+	MaxMemoryMB             int             `env:"max_memory_mb"`
+	CollectDiagnostics      bool            `env:"collect_diagnostics"`
+	SummaryReportPath       string          `env:"summary_report_path"`
+	WorkingDirectory        string          `env:"working_directory"`
+	QuotaPolicy             string          `env:"quota_policy,opt[none,fail,skip]"`
+	MaxCacheSizeMB          int             `env:"max_cache_size"`
+	TargetCacheSizeMB       int             `env:"target_cache_size"`
+	MaxFileSizeMB           int             `env:"max_file_size"`
+	CacheSizeLimitPolicy    string          `env:"cache_size_limit_policy,opt[skip,fail]"`
+	StreamUpload            bool            `env:"stream_upload"`
+	ChunkedUpload           bool            `env:"chunked_upload"`
+	UploadMetadataSidecar   bool            `env:"upload_metadata_sidecar"`
+	VerifyArchive           bool            `env:"verify_archive"`
+	DetectCommonCaches      bool            `env:"detect_common_caches"`
+	SimulatePullSampleSize  int             `env:"simulate_pull_sample_size,range[0..100000]"`
+	LocalCacheRetention     int             `env:"local_cache_retention,range[0..1000]"`
+	SSHPrivateKey           stepconf.Secret `env:"ssh_private_key"`
+	SSHKnownHosts           string          `env:"ssh_known_hosts"`
+	UploadRetryCount        int             `env:"upload_retry_count,range[0..10]"`
+	UploadRetryBackoff      float64         `env:"upload_retry_backoff,range[0..300]"`
+	FailOnUploadError       bool            `env:"fail_on_upload_error"`
+	SkipFailingPathsAfter   int             `env:"skip_failing_paths_after,range[0..100]"`
+	UnusedFileMaxAge        string          `env:"unused_file_max_age"`
+	NeverEvictPaths         string          `env:"never_evict_paths"`
+	AtimeFallback           string          `env:"atime_fallback,opt[mtime,ctime,disable]"`
+	ExecutionMode           string          `env:"execution_mode,opt[full,prepare,push]"`
+	StepTimeout             int             `env:"step_timeout,range[0..86400]"`
+	ArchiveTimeout          int             `env:"archive_timeout,range[0..86400]"`
+	UploadTimeout           int             `env:"upload_timeout,range[0..86400]"`
+	StackID                 string          `env:"BITRISEIO_STACK_ID"`
+	BuildSlug               string          `env:"BITRISE_BUILD_SLUG"`
+	DeployDir               string          `env:"BITRISE_DEPLOY_DIR"`
+}
+
+// ParseConfig expands the step inputs from the current environment
+func ParseConfig() (c Config, err error) {
+	err = stepconf.Parse(&c)
+	if err == nil {
+		c.Paths += "\n" + os.Getenv("bitrise_cache_include_paths")
+		c.IgnoredPaths += "\n" + os.Getenv("bitrise_cache_exclude_paths")
+	}
+	return
+}
+
+// Print prints the config, with the cache API url redacted.
+func (c Config) Print() {
+	// TODO: update stepconf.Print to receive the output writer
+	// and write test for this method
+	stepconf.Print(c.redacted())
+}
+
+// redacted returns a copy of the config which is safe to share.
+func (c Config) redacted() Config {
+	c.CacheAPIURL = redactURL(c.CacheAPIURL)
+	if c.EncryptionKey != "" {
+		c.EncryptionKey = "[REDACTED]"
+	}
+	return c
+}
@@ -0,0 +1,87 @@
+// Content-defined chunking related functions.
+//
+// The archive is split into chunks at positions selected by a rolling (gear) hash of the content,
+// so inserting or removing data only changes the chunks around the modification,
+// the rest of the chunks (and their hashes) remain the same and can be deduplicated.
+package cachepush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+const (
+	chunkMinSize = 256 * 1024
+	chunkMaxSize = 4 * 1024 * 1024
+	// chunkBoundaryMask selects a boundary every 1 MB on average (after the minimum size).
+	chunkBoundaryMask = 1<<20 - 1
+)
+
+// gearTable maps every byte to a pseudo random value, it has to be stable across builds
+// to produce the same chunk boundaries for the same content.
+var gearTable = newGearTable(0x6361636865707368)
+
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+	// splitmix64
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// contentChunk is a content-defined chunk of the archive.
+type contentChunk struct {
+	Hash   string `json:"sha256"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// splitChunks splits the content of the reader into content-defined chunks.
+func splitChunks(r io.Reader) ([]contentChunk, error) {
+	var chunks []contentChunk
+	var offset, size int64
+	var gear uint64
+	h := sha256.New()
+
+	cut := func() {
+		chunks = append(chunks, contentChunk{Hash: hex.EncodeToString(h.Sum(nil)), Offset: offset, Size: size})
+		offset += size
+		size = 0
+		gear = 0
+		h.Reset()
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		start := 0
+		for i := 0; i < n; i++ {
+			gear = (gear << 1) + gearTable[buf[i]]
+			size++
+			if (size >= chunkMinSize && gear&chunkBoundaryMask == 0) || size >= chunkMaxSize {
+				h.Write(buf[start : i+1])
+				start = i + 1
+				cut()
+			}
+		}
+		h.Write(buf[start:n])
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if size > 0 {
+		cut()
+	}
+	return chunks, nil
+}
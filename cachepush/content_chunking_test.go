@@ -0,0 +1,56 @@
+package cachepush
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitChunks(t *testing.T) {
+	data := make([]byte, 12*1024*1024)
+	_, err := rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+
+	chunks, err := splitChunks(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, len(chunks) > 1)
+
+	var offset int64
+	for i, chunk := range chunks {
+		require.Equal(t, offset, chunk.Offset)
+		require.True(t, chunk.Size <= chunkMaxSize)
+		if i < len(chunks)-1 {
+			require.True(t, chunk.Size >= chunkMinSize)
+		}
+		offset += chunk.Size
+	}
+	require.Equal(t, int64(len(data)), offset)
+
+	t.Log("inserting data only changes the chunks around the insertion")
+	{
+		modified := append(append(append([]byte{}, data[:5*1024*1024]...), []byte("inserted")...), data[5*1024*1024:]...)
+		modifiedChunks, err := splitChunks(bytes.NewReader(modified))
+		require.NoError(t, err)
+
+		hashes := map[string]bool{}
+		for _, chunk := range chunks {
+			hashes[chunk.Hash] = true
+		}
+		changed := 0
+		for _, chunk := range modifiedChunks {
+			if !hashes[chunk.Hash] {
+				changed++
+			}
+		}
+		require.True(t, changed <= 2, "%d of %d chunks changed", changed, len(modifiedChunks))
+	}
+
+	t.Log("empty content")
+	{
+		chunks, err := splitChunks(bytes.NewReader(nil))
+		require.NoError(t, err)
+		require.Empty(t, chunks)
+	}
+}
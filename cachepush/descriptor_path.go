@@ -0,0 +1,81 @@
+// Cache descriptor path normalization related functions.
+//
+// The previous descriptor's keys are aligned to the current paths which differ only in letter case
+// or in unicode normalization form, see the path_normalization input.
+package cachepush
+
+import (
+	"strings"
+)
+
+// pathNormalization decides which differences between the previous and the current descriptor keys are ignored.
+type pathNormalization string
+
+const (
+	normalizeAuto           pathNormalization = "auto"
+	normalizeCaseAndUnicode pathNormalization = "case_and_unicode"
+	normalizeCase           pathNormalization = "case"
+	normalizeNone           pathNormalization = "none"
+)
+
+// resolve returns the normalization used on the given OS: auto (or empty) ignores the letter case
+// and the unicode normalization form on macOS and Windows, whose file systems are case insensitive by default,
+// and compares the paths as they are on Linux (and on the other case sensitive systems).
+func (n pathNormalization) resolve(goos string) pathNormalization {
+	if n != normalizeAuto && n != "" {
+		return n
+	}
+	switch goos {
+	case "darwin", "ios", "windows":
+		return normalizeCaseAndUnicode
+	default:
+		return normalizeNone
+	}
+}
+
+// key returns the form of the path the descriptor keys are compared in.
+func (n pathNormalization) key(pth string) string {
+	switch n {
+	case normalizeCaseAndUnicode:
+		return strings.ToLower(composeUnicode(pth))
+	case normalizeCase:
+		return strings.ToLower(pth)
+	default:
+		return pth
+	}
+}
+
+// alignDescriptorKeys re-keys the previous descriptor's paths which differ from a current path only in letter case
+// or in unicode normalization form, depending on the normalization
+// (the previous cache was generated on a case insensitive file system or by a tool using an other normalization form),
+// so they are not reported as removed and added.
+// Ambiguous paths are left unchanged.
+func alignDescriptorKeys(prev, cur map[string]string, normalization pathNormalization) map[string]string {
+	if normalization != normalizeCaseAndUnicode && normalization != normalizeCase {
+		return prev
+	}
+
+	curByKey := map[string]string{}
+	ambiguous := map[string]bool{}
+	for pth := range cur {
+		key := normalization.key(pth)
+		if _, ok := curByKey[key]; ok {
+			ambiguous[key] = true
+		}
+		curByKey[key] = pth
+	}
+
+	aligned := make(map[string]string, len(prev))
+	for pth, indicator := range prev {
+		if _, ok := cur[pth]; !ok {
+			key := normalization.key(pth)
+			if curPth, ok := curByKey[key]; ok && !ambiguous[key] {
+				if _, taken := prev[curPth]; !taken {
+					pth = curPth
+				}
+			}
+		}
+		aligned[pth] = indicator
+	}
+	return aligned
+}
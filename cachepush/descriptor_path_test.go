@@ -0,0 +1,87 @@
+package cachepush
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_alignDescriptorKeys(t *testing.T) {
+	tests := []struct {
+		name          string
+		prev          map[string]string
+		cur           map[string]string
+		normalization pathNormalization
+		want          map[string]string
+	}{
+		{
+			name:          "case difference",
+			prev:          map[string]string{"/src/Pods/Alamofire": "1", "/src/removed": "2"},
+			cur:           map[string]string{"/src/pods/alamofire": "1"},
+			normalization: normalizeCaseAndUnicode,
+			want:          map[string]string{"/src/pods/alamofire": "1", "/src/removed": "2"},
+		},
+		{
+			name:          "ambiguous",
+			prev:          map[string]string{"/src/File": "1"},
+			cur:           map[string]string{"/src/file": "1", "/src/FILE": "2"},
+			normalization: normalizeCaseAndUnicode,
+			want:          map[string]string{"/src/File": "1"},
+		},
+		{
+			name:          "exact match wins",
+			prev:          map[string]string{"/src/File": "1", "/src/file": "2"},
+			cur:           map[string]string{"/src/file": "2"},
+			normalization: normalizeCaseAndUnicode,
+			want:          map[string]string{"/src/File": "1", "/src/file": "2"},
+		},
+		{
+			name:          "unicode normalization form difference",
+			prev:          map[string]string{"/src/Cafe\u0301/Re\u0301sume\u0301.txt": "1"},
+			cur:           map[string]string{"/src/caf\u00e9/r\u00e9sum\u00e9.txt": "1"},
+			normalization: normalizeCaseAndUnicode,
+			want:          map[string]string{"/src/caf\u00e9/r\u00e9sum\u00e9.txt": "1"},
+		},
+		{
+			name:          "unicode normalization form difference, case only",
+			prev:          map[string]string{"/src/cafe\u0301": "1"},
+			cur:           map[string]string{"/src/caf\u00e9": "1"},
+			normalization: normalizeCase,
+			want:          map[string]string{"/src/cafe\u0301": "1"},
+		},
+		{
+			name:          "no normalization",
+			prev:          map[string]string{"/src/Pods": "1"},
+			cur:           map[string]string{"/src/pods": "1"},
+			normalization: normalizeNone,
+			want:          map[string]string{"/src/Pods": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := alignDescriptorKeys(tt.prev, tt.cur, tt.normalization); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("alignDescriptorKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pathNormalization_resolve(t *testing.T) {
+	tests := []struct {
+		normalization pathNormalization
+		goos          string
+		want          pathNormalization
+	}{
+		{normalization: "", goos: "linux", want: normalizeNone},
+		{normalization: normalizeAuto, goos: "linux", want: normalizeNone},
+		{normalization: normalizeAuto, goos: "darwin", want: normalizeCaseAndUnicode},
+		{normalization: normalizeAuto, goos: "windows", want: normalizeCaseAndUnicode},
+		{normalization: normalizeCaseAndUnicode, goos: "linux", want: normalizeCaseAndUnicode},
+		{normalization: normalizeCase, goos: "darwin", want: normalizeCase},
+		{normalization: normalizeNone, goos: "darwin", want: normalizeNone},
+	}
+	for _, tt := range tests {
+		if got := tt.normalization.resolve(tt.goos); got != tt.want {
+			t.Errorf("%q.resolve(%q) = %q, want %q", tt.normalization, tt.goos, got, tt.want)
+		}
+	}
+}
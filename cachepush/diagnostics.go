@@ -0,0 +1,255 @@
+// Diagnostic bundle related functions.
+//
+// If enabled, the step collects the parsed config, the normalized include and ignore lists,
+// descriptor stats, phase timings and the last HTTP responses (with redacted urls, headers and bodies)
+// and writes them into a single zip file in the deploy directory, to be attached to support tickets.
+package cachepush
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	diagnosticsFileName        = "cache-push-diagnostics.zip"
+	maxDiagnosticsResponseBody = 4 * 1024
+	maxDiagnosticsResponses    = 10
+)
+
+// phaseTiming is the duration of a step phase.
+type phaseTiming struct {
+	Phase    string `json:"phase"`
+	Duration string `json:"duration"`
+}
+
+// httpResponseInfo is the redacted summary of an HTTP response.
+type httpResponseInfo struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	StatusCode int                 `json:"status_code"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       string              `json:"body,omitempty"`
+}
+
+// diagnostics collects the data of the diagnostic bundle.
+// Every method is a no-op if collecting is not enabled.
+type diagnostics struct {
+	mu        sync.Mutex
+	enabled   bool
+	dir       string
+	secrets   []string
+	entries   map[string]interface{}
+	timings   []phaseTiming
+	responses []httpResponseInfo
+}
+
+// diag is the step run's diagnostics collector.
+var diag = &diagnostics{}
+
+// enable turns on collecting, the bundle will be written into the given directory.
+// The secret values are redacted from the collected HTTP responses.
+// Enabling it again (by a concurrently pushed cache group) keeps the collected data.
+func (d *diagnostics) enable(dir string, secrets []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enabled = true
+	d.dir = dir
+	d.secrets = newRedactingWriter(nil, secrets).values
+	if d.entries == nil {
+		d.entries = map[string]interface{}{}
+	}
+}
+
+// add stores a value to be written into the bundle as a json file with the given name.
+func (d *diagnostics) add(name string, value interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.enabled {
+		return
+	}
+	d.entries[name] = value
+}
+
+// addTiming stores a phase's duration.
+func (d *diagnostics) addTiming(phase string, duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.enabled {
+		return
+	}
+	d.timings = append(d.timings, phaseTiming{Phase: phase, Duration: duration.String()})
+}
+
+// groupDiagnostics collects the diagnostics of a cache group into the step run's collector, see forGroup.
+type groupDiagnostics struct {
+	d     *diagnostics
+	group string
+}
+
+// forGroup returns the collector of the cache group: its entries and phase timings are prefixed with the group's name
+// (the entries are written into the group's directory of the bundle), so the concurrently pushed groups
+// do not overwrite each other's data. The default cache (empty group) keeps the names as they are.
+func (d *diagnostics) forGroup(group string) groupDiagnostics {
+	return groupDiagnostics{d: d, group: group}
+}
+
+func (g groupDiagnostics) name(name string) string {
+	if g.group == "" {
+		return name
+	}
+	return g.group + "/" + name
+}
+
+// add stores a value of the group, see diagnostics.add.
+func (g groupDiagnostics) add(name string, value interface{}) {
+	g.d.add(g.name(name), value)
+}
+
+// addTiming stores a phase's duration of the group.
+func (g groupDiagnostics) addTiming(phase string, duration time.Duration) {
+	g.d.addTiming(g.name(phase), duration)
+}
+
+// addResponse stores the redacted summary of an HTTP response, keeping only the last few responses.
+// The header values and the body are redacted like the logs, as they can contain presigned urls (like the upload_url).
+func (d *diagnostics) addResponse(resp *http.Response, body []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.enabled || resp == nil || resp.Request == nil {
+		return
+	}
+
+	if len(body) > maxDiagnosticsResponseBody {
+		body = body[:maxDiagnosticsResponseBody]
+	}
+
+	header := map[string][]string{}
+	for key, values := range resp.Header {
+		if key == "Set-Cookie" || key == "Authorization" {
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			redacted[i] = redactText(value, d.secrets)
+		}
+		header[key] = redacted
+	}
+
+	d.responses = append(d.responses, httpResponseInfo{
+		Method:     resp.Request.Method,
+		URL:        redactURL(resp.Request.URL.String()),
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       redactText(string(body), d.secrets),
+	})
+	if len(d.responses) > maxDiagnosticsResponses {
+		d.responses = d.responses[len(d.responses)-maxDiagnosticsResponses:]
+	}
+}
+
+// write writes the diagnostic bundle and returns its path.
+func (d *diagnostics) write() (_ string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.enabled {
+		return "", nil
+	}
+
+	entries := map[string]interface{}{}
+	for name, value := range d.entries {
+		entries[name] = value
+	}
+	entries["timings"] = d.timings
+	entries["http_responses"] = d.responses
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return "", err
+	}
+
+	pth := filepath.Join(d.dir, diagnosticsFileName)
+	file, err := os.Create(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cerr := file.Close(); cerr != nil {
+			log.Debugf("Failed to close diagnostics file (%s): %s", pth, cerr)
+		}
+	}()
+
+	zipWriter := zip.NewWriter(file)
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := json.MarshalIndent(entries[name], "", " ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal %s: %s", name, err)
+		}
+
+		w, err := zipWriter.Create(name + ".json")
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write(data); err != nil {
+			return "", err
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return "", err
+	}
+	return pth, file.Close()
+}
+
+// redactURL removes the credentials and the query (usually a presigned signature) from the url.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[REDACTED]"
+	}
+
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	return u.String()
+}
+
+// descriptorStats summarizes a cache descriptor.
+func descriptorStats(descriptor map[string]string) map[string]int {
+	stats := map[string]int{
+		"entries":         len(descriptor),
+		"ignored_entries": 0,
+	}
+	for _, indicator := range descriptor {
+		if indicator == "-" {
+			stats["ignored_entries"]++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,67 @@
+// Disk space preflight check related functions.
+//
+// The archive is written to the working directory's volume. Instead of failing mid-archive
+// with "no space left on device", the space needed by the archive is estimated from the size and the number
+// of the cached files, and the push fails before archiving if the archive can not fit on the disk.
+package cachepush
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// tarEntryOverhead is the upper bound of the tar header and the padding of an archived file.
+	tarEntryOverhead = 2 * 512
+	// minCompressionRatio is the best expected gzip compression ratio of cache content,
+	// a compressed archive is assumed to need at least this fraction of the uncompressed archive's size.
+	minCompressionRatio = 0.1
+)
+
+// archiveSizeEstimate is the estimated size range of the cache archive in bytes.
+type archiveSizeEstimate struct {
+	// min is the size of the archive if it is compressed as well as expected, max otherwise.
+	min, max int64
+}
+
+// estimateArchiveSize returns the estimated size of the archive of the given content:
+// the uncompressed archive is the content with the tar headers of its entries,
+// a compressed one is smaller by an unknown ratio, but never larger.
+func estimateArchiveSize(content archiveContent, contentSize int64, compress bool) archiveSizeEstimate {
+	size := contentSize + int64(len(content.pathToIndicatorPath))*tarEntryOverhead
+	for _, metadata := range content.metadata {
+		size += int64(len(metadata.data)) + tarEntryOverhead
+	}
+
+	if !compress {
+		return archiveSizeEstimate{min: size, max: size}
+	}
+	return archiveSizeEstimate{min: int64(float64(size) * minCompressionRatio), max: size}
+}
+
+// checkDiskSpace fails if the estimated archive can not fit on the disk. The space of the archive left at pth
+// by a previous push is also available, as it is overwritten.
+// If only the uncompressed size does not fit, the compressed archive might still fit, so it only warns.
+func checkDiskSpace(pth string, freeSpace int64, estimate archiveSizeEstimate) error {
+	if freeSpace < 0 {
+		log.Debugf("Free disk space is unknown, skipping the disk space check")
+		return nil
+	}
+
+	available := freeSpace
+	if info, err := os.Stat(pth); err == nil {
+		available += info.Size()
+	}
+
+	log.Debugf("Estimated archive size: %s - %s, available disk space: %s", formatSize(estimate.min), formatSize(estimate.max), formatSize(available))
+
+	if estimate.min > available {
+		return fmt.Errorf("not enough disk space for the cache archive: at least %s is needed, but only %s is available on the volume of %s; free up disk space, cache fewer files or enable stream_upload", formatSize(estimate.min), formatSize(available), pth)
+	}
+	if estimate.max > available {
+		log.Warnf("The cache archive might not fit on the disk: up to %s is needed, %s is available", formatSize(estimate.max), formatSize(available))
+	}
+	return nil
+}
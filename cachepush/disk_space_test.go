@@ -0,0 +1,37 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_estimateArchiveSize(t *testing.T) {
+	content := archiveContent{
+		pathToIndicatorPath: map[string]string{"a": "", "b": ""},
+		metadata:            []archiveMetadata{{path: "meta", data: []byte("data")}},
+	}
+
+	require.Equal(t, archiveSizeEstimate{min: 3*1024 + 1004, max: 3*1024 + 1004}, estimateArchiveSize(content, 1000, false))
+	require.Equal(t, archiveSizeEstimate{min: 407, max: 3*1024 + 1004}, estimateArchiveSize(content, 1000, true))
+}
+
+func Test_checkDiskSpace(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	pth := filepath.Join(tmpDir, "cache.tar")
+
+	require.NoError(t, checkDiskSpace(pth, -1, archiveSizeEstimate{min: 100, max: 100}))
+	require.NoError(t, checkDiskSpace(pth, 100, archiveSizeEstimate{min: 100, max: 100}))
+	// the compressed archive might fit
+	require.NoError(t, checkDiskSpace(pth, 100, archiveSizeEstimate{min: 10, max: 1000}))
+
+	err = checkDiskSpace(pth, 100, archiveSizeEstimate{min: 101, max: 101})
+	require.EqualError(t, err, "not enough disk space for the cache archive: at least 101 B is needed, but only 100 B is available on the volume of "+pth+"; free up disk space, cache fewer files or enable stream_upload")
+
+	// the previous archive is overwritten
+	createDirStruct(t, map[string]string{pth: "previous archive"})
+	require.NoError(t, checkDiskSpace(pth, 100, archiveSizeEstimate{min: 101, max: 101}))
+}
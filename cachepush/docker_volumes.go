@@ -0,0 +1,127 @@
+// Docker volume caching related functions.
+//
+// Builds running in containers often keep their caches in named Docker volumes, which never exist on the host filesystem.
+// The step exports each configured volume's content into a tar file using a helper container,
+// and caches these files like any other path. A manifest maps the volumes to the exported files,
+// so cache-pull can import them into the volumes.
+// The exported file is rewritten on every build, so its change indicator is the hash of the exported content.
+package cachepush
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	dockerVolumesDirName          = "cache-docker-volumes"
+	dockerVolumesManifestFileName = "cache-docker-volumes.json"
+	dockerVolumeHelperImage       = "busybox"
+	// dockerVolumeItemPrefix marks the docker volumes in the cache group paths.
+	dockerVolumeItemPrefix = "docker-volume:"
+)
+
+// parseDockerVolumes returns the non-empty volume names.
+func parseDockerVolumes(list []string) ([]string, error) {
+	var volumes []string
+	for _, item := range list {
+		name := strings.TrimSpace(item)
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, `/\:`) {
+			return nil, fmt.Errorf("invalid docker volume name: %s", name)
+		}
+		volumes = append(volumes, name)
+	}
+	return volumes, nil
+}
+
+// dockerVolumeArchivePath returns the path of the file the volume's content is exported into.
+func dockerVolumeArchivePath(dir, volume string) string {
+	return filepath.Join(dir, volume+".tar")
+}
+
+// exportDockerVolume writes the volume's content as a tar archive into the given file using a helper container,
+// and returns the sha256 hash of the archive.
+func exportDockerVolume(ctx context.Context, volume, dst string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+
+	cmd := command.NewWithCmd(exec.CommandContext(ctx, "docker", "run", "--rm",
+		"-v", volume+":/volume:ro",
+		dockerVolumeHelperImage,
+		"tar", "-cf", "-", "-C", "/volume", ".",
+	)).SetStdout(io.MultiWriter(file, h)).SetStderr(os.Stderr)
+
+	log.Debugf("$ %s", cmd.PrintableCommandArgs())
+
+	if err := cmd.Run(); err != nil {
+		if cerr := file.Close(); cerr != nil {
+			log.Debugf("Failed to close file (%s): %s", dst, cerr)
+		}
+		return "", fmt.Errorf("failed to export docker volume (%s): %s", volume, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), file.Close()
+}
+
+// exportDockerVolumes exports the volumes into the given directory and returns the cache manifest
+// mapping the volume names to the exported files, and the change indicators of the exported files.
+func exportDockerVolumes(ctx context.Context, volumes []string, dir string) (map[string]string, map[string]string, error) {
+	manifest := map[string]string{}
+	indicators := map[string]string{}
+	for _, volume := range volumes {
+		dst := dockerVolumeArchivePath(dir, volume)
+		hash, err := exportDockerVolume(ctx, volume, dst)
+		if err != nil {
+			return nil, nil, err
+		}
+		manifest[volume] = dst
+		indicators[dst] = hash
+	}
+	return manifest, indicators, nil
+}
+
+// withDockerVolumeIndicators returns the descriptor with the exported volumes' content hashes as their indicators.
+func withDockerVolumeIndicators(descriptor, indicators map[string]string) map[string]string {
+	for pth, indicator := range indicators {
+		if _, ok := descriptor[pth]; ok {
+			descriptor[pth] = indicator
+		}
+	}
+	return descriptor
+}
+
+// splitDockerVolumeItems separates the docker volumes (`docker-volume:<name>` items) from the cache paths of a group.
+func splitDockerVolumeItems(items []string) (paths []string, volumes []string) {
+	for _, item := range items {
+		if strings.HasPrefix(item, dockerVolumeItemPrefix) {
+			volumes = append(volumes, strings.TrimSpace(strings.TrimPrefix(item, dockerVolumeItemPrefix)))
+		} else {
+			paths = append(paths, item)
+		}
+	}
+	return paths, volumes
+}
+
+// dockerVolumesManifest returns the json manifest of the exported volumes.
+func dockerVolumesManifest(manifest map[string]string) ([]byte, error) {
+	return json.MarshalIndent(manifest, "", " ")
+}
@@ -0,0 +1,47 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseDockerVolumes(t *testing.T) {
+	volumes, err := parseDockerVolumes([]string{"gradle-cache", " node_modules ", ""})
+	require.NoError(t, err)
+	require.Equal(t, []string{"gradle-cache", "node_modules"}, volumes)
+
+	_, err = parseDockerVolumes([]string{"/host/path:/container/path"})
+	require.Error(t, err)
+}
+
+func Test_dockerVolumesManifest(t *testing.T) {
+	pth := dockerVolumeArchivePath(workPath(dockerVolumesDirName), "gradle-cache")
+	require.Equal(t, filepath.Join(defaultRunEnv.workDir, "cache-docker-volumes", "gradle-cache.tar"), pth)
+
+	data, err := dockerVolumesManifest(map[string]string{"gradle-cache": "/tmp/cache-docker-volumes/gradle-cache.tar"})
+	require.NoError(t, err)
+	require.Equal(t, "{\n \"gradle-cache\": \"/tmp/cache-docker-volumes/gradle-cache.tar\"\n}", string(data))
+}
+
+func Test_withDockerVolumeIndicators(t *testing.T) {
+	descriptor := map[string]string{
+		"/tmp/cache-docker-volumes/gradle-cache.tar": "-",
+		"/tmp/Pods/Manifest.lock":                    "1600000000",
+	}
+	got := withDockerVolumeIndicators(descriptor, map[string]string{
+		"/tmp/cache-docker-volumes/gradle-cache.tar": "sha256:abc",
+		"/tmp/cache-docker-volumes/ignored.tar":      "sha256:def",
+	})
+	require.Equal(t, map[string]string{
+		"/tmp/cache-docker-volumes/gradle-cache.tar": "sha256:abc",
+		"/tmp/Pods/Manifest.lock":                    "1600000000",
+	}, got)
+}
+
+func Test_splitDockerVolumeItems(t *testing.T) {
+	paths, volumes := splitDockerVolumeItems([]string{"~/.gradle/caches", "docker-volume: gradle-cache", "./Pods -> ./Podfile.lock"})
+	require.Equal(t, []string{"~/.gradle/caches", "./Pods -> ./Podfile.lock"}, paths)
+	require.Equal(t, []string{"gradle-cache"}, volumes)
+}
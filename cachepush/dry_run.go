@@ -0,0 +1,63 @@
+// Dry run related functions.
+package cachepush
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// dryRunEntry is a file or directory which would be written into the archive.
+type dryRunEntry struct {
+	path      string
+	indicator string
+	size      int64
+	dir       bool
+}
+
+// dryRunEntries returns the entries which would be written into the archive, sorted by path.
+func dryRunEntries(pathToIndicatorPath map[string]string) ([]dryRunEntry, error) {
+	entries := make([]dryRunEntry, 0, len(pathToIndicatorPath))
+	for pth, indicator := range pathToIndicatorPath {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := dryRunEntry{path: pth, indicator: indicator, dir: info.IsDir()}
+		if info.Mode().IsRegular() {
+			entry.size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+// logDryRun prints the content of the archive which would be generated, and returns its estimated (uncompressed) size.
+func logDryRun(pathToIndicatorPath map[string]string) (int64, error) {
+	entries, err := dryRunEntries(pathToIndicatorPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the archive content: %s", err)
+	}
+
+	var size int64
+	log.Printf("The archive would contain %d entries:", len(entries))
+	for _, entry := range entries {
+		size += entry.size
+
+		desc := fmt.Sprintf("%d bytes", entry.size)
+		if entry.dir {
+			desc = "directory"
+		}
+		if entry.indicator != "" && entry.indicator != entry.path {
+			desc += ", indicator: " + entry.indicator
+		}
+		log.Printf("- %s (%s)", entry.path, desc)
+	}
+	log.Printf("Estimated archive size (uncompressed): %d bytes", size)
+	return size, nil
+}
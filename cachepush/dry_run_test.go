@@ -0,0 +1,60 @@
+package cachepush
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dryRunEntries(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	file := filepath.Join(tmpDir, "dir", "file")
+	indicator := filepath.Join(tmpDir, "indicator")
+	createDirStruct(t, map[string]string{file: "content", indicator: "indicator"})
+
+	entries, err := dryRunEntries(map[string]string{
+		file:                         indicator,
+		filepath.Join(tmpDir, "dir"): "",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []dryRunEntry{
+		{path: filepath.Join(tmpDir, "dir"), dir: true},
+		{path: file, indicator: indicator, size: 7},
+	}, entries)
+
+	_, err = dryRunEntries(map[string]string{filepath.Join(tmpDir, "missing"): ""})
+	require.Error(t, err)
+}
+
+func TestRun_dryRun(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file"): "content"})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request in dry run: %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{
+		Paths:               tmpDir,
+		CacheAPIURL:         server.URL,
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+		DryRun:              true,
+	})
+	require.NoError(t, err)
+	require.False(t, report.Pushed)
+	require.Equal(t, "dry run", report.SkipReason)
+}
@@ -0,0 +1,59 @@
+// Environment variable fingerprint related functions.
+//
+// The values of the listed environment variables (like JAVA_HOME or a toolchain version) are fingerprinted
+// next to the cached files, the cache is regenerated if any of them changes.
+// The environment variables are fingerprint sources stored in the cache descriptor with the envDescriptorPrefix.
+package cachepush
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+const (
+	envDescriptorPrefix = "env:"
+	// envUnsetIndicator is the fingerprint of an unset environment variable, it differs from an empty value's.
+	envUnsetIndicator = "unset"
+)
+
+// parseEnvVarNames returns the environment variable names of the newline separated list, without duplicates.
+func parseEnvVarNames(list string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// envSources returns the fingerprint sources of the environment variables.
+func envSources(names []string) []fingerprintSource {
+	var sources []fingerprintSource
+	for _, name := range names {
+		sources = append(sources, envSource{name: name})
+	}
+	return sources
+}
+
+// envSource fingerprints the value of an environment variable.
+type envSource struct {
+	name string
+}
+
+func (s envSource) key() string {
+	return envDescriptorPrefix + s.name
+}
+
+func (s envSource) fingerprint(context.Context) (string, error) {
+	value, ok := os.LookupEnv(s.name)
+	if !ok {
+		return envUnsetIndicator, nil
+	}
+	return valueFingerprint([]byte(value)), nil
+}
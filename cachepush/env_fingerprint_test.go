@@ -0,0 +1,34 @@
+package cachepush
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseEnvVarNames(t *testing.T) {
+	require.Equal(t, []string{"JAVA_HOME", "XCODE_VERSION"}, parseEnvVarNames("JAVA_HOME\n\n  XCODE_VERSION \nJAVA_HOME\n"))
+	require.Nil(t, parseEnvVarNames(""))
+}
+
+func Test_envSources(t *testing.T) {
+	setenvForTest(t, "ENV_FINGERPRINT_SET", "value")
+	setenvForTest(t, "ENV_FINGERPRINT_EMPTY", "")
+	require.NoError(t, os.Unsetenv("ENV_FINGERPRINT_UNSET"))
+
+	sources := envSources([]string{"ENV_FINGERPRINT_SET", "ENV_FINGERPRINT_EMPTY", "ENV_FINGERPRINT_UNSET"})
+	fingerprints, err := sourceFingerprints(context.Background(), sources)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"env:ENV_FINGERPRINT_SET":   "cd42404d52ad55ccfa9aca4adc828aa5800ad9d385a0671fbcbf724118320619",
+		"env:ENV_FINGERPRINT_EMPTY": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"env:ENV_FINGERPRINT_UNSET": "unset",
+	}, fingerprints)
+
+	setenvForTest(t, "ENV_FINGERPRINT_SET", "changed")
+	changed, err := sourceFingerprints(context.Background(), sources)
+	require.NoError(t, err)
+	require.Equal(t, []string{"env:ENV_FINGERPRINT_SET"}, changedSources(fingerprints, changed))
+}
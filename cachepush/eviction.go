@@ -0,0 +1,106 @@
+// Eviction report related functions.
+//
+// The files evicted from the cache (by the unused_file_max_age and target_cache_size inputs) are summarized
+// with the reclaimed size and the directories losing the most, so an unexpectedly shrinking cache can be explained.
+// The paths matching the never_evict_paths patterns (ignore item syntax) are always retained.
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// retain marks the never evicted paths.
+	retain ignoreAction = "retain"
+
+	maxEvictionReportDirs = 5
+)
+
+// evictedDir is the number and the size of the files evicted from a directory.
+type evictedDir struct {
+	Path      string `json:"path"`
+	Files     int    `json:"files"`
+	Reclaimed int64  `json:"reclaimed"`
+}
+
+// evictionSummary describes the files evicted from the cache.
+type evictionSummary struct {
+	Files     int          `json:"files"`
+	Reclaimed int64        `json:"reclaimed"`
+	TopDirs   []evictedDir `json:"top_dirs"`
+}
+
+// parseNeverEvictList returns the compiled patterns of the paths which are never evicted.
+func parseNeverEvictList(list []string) ([]ignorePattern, error) {
+	var items []ignorePattern
+	for _, item := range list {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, ignorePattern{Pattern: item, Action: retain})
+		}
+	}
+	return normalizeIgnorePatterns(items)
+}
+
+// isProtected reports whether the path (or any of its parent directories) matches a never evict pattern.
+func isProtected(pth string, protected []ignorePattern) bool {
+	_, ok := match(pth, protected)
+	return ok
+}
+
+// summarizeEviction returns the number and the total size of the evicted files,
+// and the directories with the largest reclaimed size.
+func summarizeEviction(evicted []string) (evictionSummary, error) {
+	summary := evictionSummary{Files: len(evicted)}
+	dirByPath := map[string]*evictedDir{}
+	for _, pth := range evicted {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return evictionSummary{}, err
+		}
+		summary.Reclaimed += info.Size()
+
+		dir, ok := dirByPath[filepath.Dir(pth)]
+		if !ok {
+			dir = &evictedDir{Path: filepath.Dir(pth)}
+			dirByPath[dir.Path] = dir
+		}
+		dir.Files++
+		dir.Reclaimed += info.Size()
+	}
+
+	for _, dir := range dirByPath {
+		summary.TopDirs = append(summary.TopDirs, *dir)
+	}
+	sort.Slice(summary.TopDirs, func(i, j int) bool {
+		if summary.TopDirs[i].Reclaimed != summary.TopDirs[j].Reclaimed {
+			return summary.TopDirs[i].Reclaimed > summary.TopDirs[j].Reclaimed
+		}
+		return summary.TopDirs[i].Path < summary.TopDirs[j].Path
+	})
+	if len(summary.TopDirs) > maxEvictionReportDirs {
+		summary.TopDirs = summary.TopDirs[:maxEvictionReportDirs]
+	}
+	return summary, nil
+}
+
+// logEvictionSummary prints the eviction summary, the evicted files are listed in debug mode.
+func logEvictionSummary(reason string, summary evictionSummary, evicted []string) {
+	if summary.Files == 0 {
+		log.Printf("No files evicted (%s)", reason)
+		return
+	}
+
+	log.Printf("%d files evicted (%s), %s reclaimed", summary.Files, reason, formatSize(summary.Reclaimed))
+	log.Printf("Top directories:")
+	for _, dir := range summary.TopDirs {
+		log.Printf("- %s: %d files, %s", dir.Path, dir.Files, formatSize(dir.Reclaimed))
+	}
+	for _, pth := range evicted {
+		log.Debugf("- %s", pth)
+	}
+}
@@ -0,0 +1,45 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseNeverEvictList(t *testing.T) {
+	protected, err := parseNeverEvictList([]string{"", " /cache/wrapper/ ", "*.jar"})
+	require.NoError(t, err)
+	require.Len(t, protected, 2)
+
+	require.True(t, isProtected("/cache/wrapper/dists/gradle.zip", protected))
+	require.True(t, isProtected("/cache/modules/lib.jar", protected))
+	require.False(t, isProtected("/cache/modules/lib.pom", protected))
+	require.False(t, isProtected("/cache/modules/lib.pom", nil))
+}
+
+func Test_summarizeEviction(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	a1 := filepath.Join(tmpDir, "a", "1")
+	a2 := filepath.Join(tmpDir, "a", "2")
+	b1 := filepath.Join(tmpDir, "b", "1")
+	createDirStruct(t, map[string]string{a1: "12", a2: "34", b1: "12345"})
+
+	summary, err := summarizeEviction([]string{a1, a2, b1})
+	require.NoError(t, err)
+	require.Equal(t, evictionSummary{
+		Files:     3,
+		Reclaimed: 9,
+		TopDirs: []evictedDir{
+			{Path: filepath.Join(tmpDir, "b"), Files: 1, Reclaimed: 5},
+			{Path: filepath.Join(tmpDir, "a"), Files: 2, Reclaimed: 4},
+		},
+	}, summary)
+
+	summary, err = summarizeEviction(nil)
+	require.NoError(t, err)
+	require.Equal(t, evictionSummary{}, summary)
+}
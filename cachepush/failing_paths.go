@@ -0,0 +1,91 @@
+// Failing path skip-list related functions.
+//
+// Paths which can not be read while walking the cache paths (permission denied, file system quirks) are left out of the cache
+// and their consecutive failure count is persisted in the cache meta. After the configured number of consecutive failures
+// the path is skip-listed: it is not read anymore and no further warnings are printed about it.
+package cachepush
+
+import (
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// pathFailures tracks the paths which could not be read.
+type pathFailures struct {
+	threshold int
+	prev      map[string]int
+	cur       map[string]int
+}
+
+// newPathFailures returns the failure tracker based on the failure counts recorded in the previous cache meta.
+func newPathFailures(prevMeta model.CacheMeta, threshold int) *pathFailures {
+	f := &pathFailures{threshold: threshold, prev: map[string]int{}, cur: map[string]int{}}
+	for pth, entry := range prevMeta {
+		if entry.ConsecutiveFailures > 0 {
+			f.prev[pth] = entry.ConsecutiveFailures
+		}
+	}
+	return f
+}
+
+// scoped returns a tracker sharing the previous failure counts, which records the failures of a single walk.
+func (f *pathFailures) scoped() *pathFailures {
+	if f == nil {
+		return nil
+	}
+	return &pathFailures{threshold: f.threshold, prev: f.prev, cur: map[string]int{}}
+}
+
+// merge adds the failure counts recorded by a scoped tracker.
+func (f *pathFailures) merge(cur map[string]int) {
+	if f == nil {
+		return
+	}
+	for pth, count := range cur {
+		f.cur[pth] = count
+	}
+}
+
+// skip reports whether the path is skip-listed, skip-listed paths remain on the list.
+func (f *pathFailures) skip(pth string) bool {
+	if f.prev[pth] < f.threshold {
+		return false
+	}
+	f.cur[pth] = f.prev[pth]
+	return true
+}
+
+// fail records that the path could not be read.
+func (f *pathFailures) fail(pth string, err error) {
+	count := f.prev[pth] + 1
+	f.cur[pth] = count
+
+	if count == f.threshold {
+		log.Warnf("Failed to read %s in %d consecutive builds, skipping it from now on: %s", pth, count, err)
+	} else {
+		log.Warnf("Failed to read %s, leaving it out of the cache: %s", pth, err)
+	}
+}
+
+// skipped returns the skip-listed paths.
+func (f *pathFailures) skipped() []string {
+	var paths []string
+	for pth, count := range f.cur {
+		if count >= f.threshold {
+			paths = append(paths, pth)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// record adds the failure counts of the current build to the cache meta.
+func (f *pathFailures) record(meta model.CacheMeta) {
+	for pth, count := range f.cur {
+		entry := meta[pth]
+		entry.ConsecutiveFailures = count
+		meta[pth] = entry
+	}
+}
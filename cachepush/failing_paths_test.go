@@ -0,0 +1,59 @@
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pathFailures(t *testing.T) {
+	prev := model.CacheMeta{
+		"/cached":      {FirstSeenAt: 1},
+		"/failed-once": {ConsecutiveFailures: 1},
+		"/skip-listed": {ConsecutiveFailures: 2},
+		"/recovered":   {ConsecutiveFailures: 1},
+	}
+	failures := newPathFailures(prev, 2)
+
+	require.False(t, failures.skip("/cached"))
+	require.False(t, failures.skip("/failed-once"))
+	require.True(t, failures.skip("/skip-listed"))
+
+	failures.fail("/failed-once", errors.New("permission denied"))
+	failures.fail("/new", errors.New("permission denied"))
+	require.Equal(t, []string{"/failed-once", "/skip-listed"}, failures.skipped())
+
+	meta := model.CacheMeta{"/cached": {FirstSeenAt: 1}, "/recovered": {FirstSeenAt: 2}}
+	failures.record(meta)
+	require.Equal(t, model.CacheMeta{
+		"/cached":      {FirstSeenAt: 1},
+		"/recovered":   {FirstSeenAt: 2},
+		"/failed-once": {ConsecutiveFailures: 2},
+		"/skip-listed": {ConsecutiveFailures: 2},
+		"/new":         {ConsecutiveFailures: 1},
+	}, meta)
+}
+
+func Test_expandPath_skipListed(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	cached := filepath.Join(tmpDir, "cached", "file")
+	skipListed := filepath.Join(tmpDir, "skip-listed")
+	createDirStruct(t, map[string]string{
+		cached:                            "",
+		filepath.Join(skipListed, "file"): "",
+	})
+
+	failures := newPathFailures(model.CacheMeta{skipListed: {ConsecutiveFailures: 3}}, 3)
+	regularFiles, _, dirPaths, err := expandPath(context.Background(), tmpDir, failures, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{cached}, regularFiles)
+	require.Equal(t, []string{tmpDir, filepath.Dir(cached)}, dirPaths)
+	require.Equal(t, []string{skipListed}, failures.skipped())
+}
@@ -0,0 +1,42 @@
+// Fast archiver related functions.
+//
+// Both archivers read the cached files with the parallel readers (see parallel_read.go),
+// the default (tar) archiver compresses with the best, the fast archiver with the fastest gzip level.
+package cachepush
+
+import (
+	"archive/tar"
+	"compress/gzip"
+)
+
+// archiverKind selects how the cache archive is written.
+type archiverKind string
+
+const (
+	tarArchiver  archiverKind = "tar"
+	fastArchiver archiverKind = "fast"
+)
+
+// archiverCompressionLevel returns the gzip compression level of the archiver.
+func archiverCompressionLevel(kind archiverKind) int {
+	if kind == fastArchiver {
+		return gzip.BestSpeed
+	}
+	return gzip.BestCompression
+}
+
+// setCompressionLevel recreates the compressing writer with the given level, it has no effect on uncompressed archives.
+// It needs to be called before anything is written into the archive.
+func (a *Archive) setCompressionLevel(level int) error {
+	if a.gzip == nil {
+		return nil
+	}
+
+	gzipWriter, err := gzip.NewWriterLevel(a.buffer, level)
+	if err != nil {
+		return err
+	}
+	a.gzip = gzipWriter
+	a.tar = tar.NewWriter(gzipWriter)
+	return nil
+}
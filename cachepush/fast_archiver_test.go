@@ -0,0 +1,163 @@
+package cachepush
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/stretchr/testify/require"
+)
+
+type archivedEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func readArchiveEntries(t *testing.T, pth string) []archivedEntry {
+	reader, closeArchive, err := openArchiveReader(pth, nil)
+	require.NoError(t, err)
+	defer closeArchive()
+
+	var entries []archivedEntry
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		entries = append(entries, archivedEntry{name: header.Name, typeflag: header.Typeflag, linkname: header.Linkname, content: string(content)})
+	}
+	return entries
+}
+
+func Test_writeArchive_fast(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fast_archiver")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	pathToIndicatorPath := map[string]string{}
+	for i, name := range []string{"a", "b/c", "b/d", "e", "f/g/h"} {
+		pth := filepath.Join(tmpDir, "cache", name)
+		createDirStruct(t, map[string]string{pth: strings.Repeat(name, i+1)})
+		pathToIndicatorPath[pth] = ""
+	}
+	large := filepath.Join(tmpDir, "cache", "large")
+	createDirStruct(t, map[string]string{large: strings.Repeat("x", 2*defaultCopyBufferSize+1)})
+	pathToIndicatorPath[large] = ""
+	link := filepath.Join(tmpDir, "cache", "link")
+	require.NoError(t, os.Link(filepath.Join(tmpDir, "cache", "a"), link))
+	pathToIndicatorPath[link] = ""
+	pathToIndicatorPath[filepath.Join(tmpDir, "cache", "b")] = ""
+
+	var entries [][]archivedEntry
+	for _, archiver := range []archiverKind{tarArchiver, fastArchiver} {
+		pth := filepath.Join(tmpDir, string(archiver)+".tar.gz")
+		archive, err := NewArchive(pth, true, memoryBudget{})
+		require.NoError(t, err)
+		require.NoError(t, writeArchive(context.Background(), archive, archiveContent{
+			stackData:           []byte("{}"),
+			pathToIndicatorPath: pathToIndicatorPath,
+			descriptor:          map[string]string{},
+			reproducible:        true,
+			archiver:            archiver,
+			archiverSettings:    newArchiverSettings(3, 0, 2, 0, memoryBudget{}),
+		}))
+		require.Empty(t, archive.modified)
+
+		entries = append(entries, readArchiveEntries(t, pth))
+	}
+	require.Equal(t, entries[0], entries[1])
+
+	var linked bool
+	for _, entry := range entries[1] {
+		if entry.name == link || entry.name == filepath.Join(tmpDir, "cache", "a") {
+			linked = linked || entry.typeflag == tar.TypeLink
+		}
+	}
+	require.True(t, linked)
+}
+
+func TestRun_fastArchiverIgnoredPaths(t *testing.T) {
+	const group = "fast-archiver-ignore-test"
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	keep := filepath.Join(tmpDir, "src", "keep.txt")
+	reincluded := filepath.Join(tmpDir, "build", "keep.txt")
+	createDirStruct(t, map[string]string{
+		keep:       "keep",
+		reincluded: "keep",
+		filepath.Join(tmpDir, "src", "a", "debug.log"):  "ignored",
+		filepath.Join(tmpDir, "build", "output.bin"):    "ignored",
+		filepath.Join(tmpDir, "tmp", "x", "y", "z.txt"): "ignored",
+	})
+	defer func() {
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheInfoFileName), group)))
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+	}()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload"}`))
+			require.NoError(t, err)
+		}
+	}))
+	defer server.Close()
+
+	report, err := runCache(context.Background(), Config{
+		Paths:               tmpDir,
+		IgnoredPaths:        "!" + filepath.Join(tmpDir, "**", "*.log") + "\n!" + filepath.Join(tmpDir, "build") + "/\n+" + reincluded + "\n!" + filepath.Join(tmpDir, "tmp", "**", "*.txt"),
+		CacheAPIURL:         server.URL,
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+		QuotaPolicy:         string(QuotaPolicyNone),
+		Archiver:            string(fastArchiver),
+		ArchiverWorkers:     2,
+	}, group)
+	require.NoError(t, err)
+	require.True(t, report.Pushed)
+
+	var files []string
+	for _, entry := range readArchiveEntries(t, cachecommon.GroupPath(workPath(cacheArchiveFileName), group)) {
+		if entry.typeflag == tar.TypeReg && strings.HasPrefix(entry.name, tmpDir) {
+			files = append(files, entry.name)
+		}
+	}
+	require.Equal(t, []string{reincluded, keep}, files)
+}
+
+func TestArchive_setCompressionLevel(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fast_archiver")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	pth := filepath.Join(tmpDir, "cache")
+	createDirStruct(t, map[string]string{pth: "content"})
+
+	archivePth := filepath.Join(tmpDir, "cache.tar.gz")
+	archive, err := NewArchive(archivePth, true, memoryBudget{})
+	require.NoError(t, err)
+	require.NoError(t, archive.setCompressionLevel(archiverCompressionLevel(fastArchiver)))
+	require.NoError(t, archive.Write(context.Background(), map[string]string{pth: ""}))
+	require.NoError(t, archive.Close())
+
+	entries := readArchiveEntries(t, archivePth)
+	require.Equal(t, []archivedEntry{{name: pth, typeflag: tar.TypeReg, content: "content"}}, entries)
+}
@@ -0,0 +1,225 @@
+// Fingerprint source related functions.
+//
+// Besides the cached files, the cache can depend on values not reflected by them: environment variables,
+// files outside of the cache, the output of a command (like `node --version`) or a literal version string.
+// These fingerprint sources are stored in the cache descriptor next to the cached files, keyed by their type and value
+// (like `cmd:node --version`), the cache is regenerated if any of their fingerprints changes.
+// Only the hash of the values is stored, as they might be sensitive.
+package cachepush
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	fileSourcePrefix    = "file:"
+	commandSourcePrefix = "cmd:"
+	literalSourcePrefix = "literal:"
+	// missingSourceIndicator is the fingerprint of a missing file, failedSourceIndicator the one of a failing command.
+	missingSourceIndicator = "missing"
+	failedSourceIndicator  = "failed"
+	commandSourceTimeout   = 30 * time.Second
+)
+
+// fingerprintSource is a value the cached content depends on, fingerprinted in the cache descriptor.
+type fingerprintSource interface {
+	// key returns the source's entry in the cache descriptor.
+	key() string
+	// fingerprint returns the current fingerprint of the source.
+	fingerprint(ctx context.Context) (string, error)
+}
+
+// valueFingerprint returns the fingerprint of the value.
+func valueFingerprint(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileSource fingerprints the content of a file.
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) key() string {
+	return fileSourcePrefix + s.path
+}
+
+func (s fileSource) fingerprint(context.Context) (string, error) {
+	pth, err := pathutil.AbsPath(s.path)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(pth)
+	if os.IsNotExist(err) {
+		return missingSourceIndicator, nil
+	} else if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// commandSource fingerprints the standard output of a shell command.
+type commandSource struct {
+	command string
+}
+
+func (s commandSource) key() string {
+	return commandSourcePrefix + s.command
+}
+
+// fingerprint runs the command, a failing command has the failedSourceIndicator fingerprint
+// (for example if the tool is not installed), so a transient failure does not fail the push.
+func (s commandSource) fingerprint(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandSourceTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := command.NewWithCmd(exec.CommandContext(ctx, "bash", "-c", s.command)).SetStdout(&stdout).SetStderr(&stderr)
+	if err := cmd.Run(); err != nil {
+		log.Warnf("Fingerprint command (%s) failed: %s: %s", s.command, err, strings.TrimSpace(stderr.String()))
+		return failedSourceIndicator, nil
+	}
+	return valueFingerprint(bytes.TrimSpace(stdout.Bytes())), nil
+}
+
+// literalSource fingerprints a literal string, bumping it invalidates the cache.
+type literalSource struct {
+	value string
+}
+
+func (s literalSource) key() string {
+	return literalSourcePrefix + s.value
+}
+
+func (s literalSource) fingerprint(context.Context) (string, error) {
+	return valueFingerprint([]byte(s.value)), nil
+}
+
+// sourcePrefixes are the cache descriptor key prefixes of the fingerprint sources.
+var sourcePrefixes = []string{envDescriptorPrefix, fileSourcePrefix, commandSourcePrefix, literalSourcePrefix}
+
+// isSourceKey reports whether the cache descriptor key is a fingerprint source's entry (and not a cached path's).
+func isSourceKey(key string) bool {
+	for _, prefix := range sourcePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFingerprintSources parses the newline separated `<type>:<value>` list of fingerprint sources,
+// the type is one of env, file, cmd and literal. Duplicated sources are listed once.
+func parseFingerprintSources(list string) ([]fingerprintSource, error) {
+	var sources []fingerprintSource
+	seen := map[string]bool{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var source fingerprintSource
+		switch {
+		case strings.HasPrefix(line, envDescriptorPrefix):
+			source = envSource{name: strings.TrimSpace(strings.TrimPrefix(line, envDescriptorPrefix))}
+		case strings.HasPrefix(line, fileSourcePrefix):
+			source = fileSource{path: strings.TrimSpace(strings.TrimPrefix(line, fileSourcePrefix))}
+		case strings.HasPrefix(line, commandSourcePrefix):
+			source = commandSource{command: strings.TrimSpace(strings.TrimPrefix(line, commandSourcePrefix))}
+		case strings.HasPrefix(line, literalSourcePrefix):
+			source = literalSource{value: strings.TrimSpace(strings.TrimPrefix(line, literalSourcePrefix))}
+		default:
+			return nil, fmt.Errorf("invalid fingerprint source (%s), expected format: env:NAME, file:path, cmd:command or literal:value", line)
+		}
+		if source.key() == strings.SplitN(line, ":", 2)[0]+":" {
+			return nil, fmt.Errorf("invalid fingerprint source (%s): empty value", line)
+		}
+
+		if !seen[source.key()] {
+			seen[source.key()] = true
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+// sourceFingerprints returns the cache descriptor entries of the fingerprint sources.
+func sourceFingerprints(ctx context.Context, sources []fingerprintSource) (map[string]string, error) {
+	fingerprints := map[string]string{}
+	for _, source := range sources {
+		fingerprint, err := source.fingerprint(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fingerprint %s: %w", source.key(), err)
+		}
+		fingerprints[source.key()] = fingerprint
+	}
+	return fingerprints, nil
+}
+
+// splitSourceFingerprints separates the fingerprint source entries of the cache descriptor from the path entries.
+func splitSourceFingerprints(descriptor map[string]string) (paths map[string]string, sources map[string]string) {
+	paths = map[string]string{}
+	sources = map[string]string{}
+	for key, indicator := range descriptor {
+		if isSourceKey(key) {
+			sources[key] = indicator
+		} else {
+			paths[key] = indicator
+		}
+	}
+	return paths, sources
+}
+
+// changedSources returns the keys of the fingerprint sources whose fingerprint differs, sorted.
+// Sources added to or removed from the list are reported as changed.
+func changedSources(prev, cur map[string]string) []string {
+	r := compare(prev, cur)
+
+	var keys []string
+	keys = append(keys, r.changed...)
+	keys = append(keys, r.added...)
+	keys = append(keys, r.removed...)
+	sort.Strings(keys)
+	return keys
+}
+
+// withSourceFingerprints returns the cache descriptor extended with the fingerprint source entries.
+func withSourceFingerprints(descriptor, sources map[string]string) map[string]string {
+	if len(sources) == 0 {
+		return descriptor
+	}
+
+	merged := make(map[string]string, len(descriptor)+len(sources))
+	for key, indicator := range descriptor {
+		merged[key] = indicator
+	}
+	for key, indicator := range sources {
+		merged[key] = indicator
+	}
+	return merged
+}
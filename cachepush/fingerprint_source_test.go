@@ -0,0 +1,72 @@
+package cachepush
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseFingerprintSources(t *testing.T) {
+	sources, err := parseFingerprintSources("env:JAVA_HOME\n\n  file: .nvmrc \ncmd:node --version\nliteral:v2\nenv:JAVA_HOME\n")
+	require.NoError(t, err)
+	require.Equal(t, []fingerprintSource{
+		envSource{name: "JAVA_HOME"},
+		fileSource{path: ".nvmrc"},
+		commandSource{command: "node --version"},
+		literalSource{value: "v2"},
+	}, sources)
+
+	sources, err = parseFingerprintSources("")
+	require.NoError(t, err)
+	require.Nil(t, sources)
+
+	for _, list := range []string{"JAVA_HOME", "url:https://example.com", "cmd: "} {
+		_, err := parseFingerprintSources(list)
+		require.Error(t, err, list)
+	}
+}
+
+func Test_sourceFingerprints(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	file := filepath.Join(tmpDir, ".nvmrc")
+	createDirStruct(t, map[string]string{file: "value"})
+
+	fingerprints, err := sourceFingerprints(context.Background(), []fingerprintSource{
+		fileSource{path: file},
+		fileSource{path: filepath.Join(tmpDir, "missing")},
+		commandSource{command: "echo value"},
+		commandSource{command: "exit 1"},
+		literalSource{value: "value"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"file:" + file: "cd42404d52ad55ccfa9aca4adc828aa5800ad9d385a0671fbcbf724118320619",
+		"file:" + filepath.Join(tmpDir, "missing"): "missing",
+		// the trailing newline of the output is trimmed
+		"cmd:echo value": "cd42404d52ad55ccfa9aca4adc828aa5800ad9d385a0671fbcbf724118320619",
+		"cmd:exit 1":     "failed",
+		"literal:value":  "cd42404d52ad55ccfa9aca4adc828aa5800ad9d385a0671fbcbf724118320619",
+	}, fingerprints)
+}
+
+func Test_changedSources(t *testing.T) {
+	prev := map[string]string{"env:A": "1", "cmd:B": "2", "literal:C": "3"}
+	cur := map[string]string{"env:A": "1", "cmd:B": "changed", "file:D": "4"}
+	require.Equal(t, []string{"cmd:B", "file:D", "literal:C"}, changedSources(prev, cur))
+	require.Nil(t, changedSources(prev, prev))
+	require.Nil(t, changedSources(nil, nil))
+}
+
+func Test_splitSourceFingerprints(t *testing.T) {
+	descriptor := withSourceFingerprints(map[string]string{"/cache/a": "1"}, map[string]string{"env:JAVA_HOME": "2", "cmd:node --version": "3"})
+	require.Equal(t, map[string]string{"/cache/a": "1", "env:JAVA_HOME": "2", "cmd:node --version": "3"}, descriptor)
+
+	paths, sources := splitSourceFingerprints(descriptor)
+	require.Equal(t, map[string]string{"/cache/a": "1"}, paths)
+	require.Equal(t, map[string]string{"env:JAVA_HOME": "2", "cmd:node --version": "3"}, sources)
+}
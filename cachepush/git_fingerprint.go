@@ -0,0 +1,195 @@
+// Git blob hash fingerprint related functions.
+//
+// The git blob hash of an indicator file tracked in a git repository (like Gemfile.lock or Podfile.lock) is read
+// from the repository's index, it does not depend on the file's mod time, which differs on every fresh checkout.
+// Modified tracked files are hashed with `git hash-object` (applying the repository's filters),
+// files outside of a git repository are hashed the same way as git would hash them.
+package cachepush
+
+import (
+	"bytes"
+	"crypto/sha1" // #nosec G505 -- git object ids are sha1 hashes
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// gitRepoIndex holds the index entries of a git repository.
+type gitRepoIndex struct {
+	// blobs maps the absolute path of the tracked files to their blob hash.
+	blobs map[string]string
+	// modified holds the absolute path of the tracked files which differ from their index entry.
+	modified map[string]bool
+}
+
+// gitIndex resolves the git blob hash of files, the repositories and their indexes are read once.
+type gitIndex struct {
+	mu sync.Mutex
+	// rootByDir maps directories to the root of the repository containing them, empty if there is none.
+	rootByDir map[string]string
+	repos     map[string]*gitRepoIndex
+}
+
+func newGitIndex() *gitIndex {
+	return &gitIndex{rootByDir: map[string]string{}, repos: map[string]*gitRepoIndex{}}
+}
+
+// repoRoot returns the work tree root of the git repository containing the directory, empty if there is none.
+func (g *gitIndex) repoRoot(dir string) string {
+	var visited []string
+	root := ""
+	for {
+		if cached, ok := g.rootByDir[dir]; ok {
+			root = cached
+			break
+		}
+		visited = append(visited, dir)
+
+		// .git is a directory in a regular repository, and a file in worktrees and submodules
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			root = dir
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	for _, d := range visited {
+		g.rootByDir[d] = root
+	}
+	return root
+}
+
+// repo returns the index of the git repository, an empty index if it can not be read.
+func (g *gitIndex) repo(root string) *gitRepoIndex {
+	if repo, ok := g.repos[root]; ok {
+		return repo
+	}
+
+	repo, err := readGitRepoIndex(root)
+	if err != nil {
+		log.Warnf("Failed to read the git index of %s, hashing its files instead: %s", root, err)
+		repo = &gitRepoIndex{blobs: map[string]string{}, modified: map[string]bool{}}
+	}
+	g.repos[root] = repo
+	return repo
+}
+
+// blobHash returns the git blob hash of the file.
+func (g *gitIndex) blobHash(pth string) (string, error) {
+	if g == nil {
+		return fileGitBlobHash(pth)
+	}
+
+	g.mu.Lock()
+	root := g.repoRoot(filepath.Dir(pth))
+	var repo *gitRepoIndex
+	if root != "" {
+		repo = g.repo(root)
+	}
+	g.mu.Unlock()
+
+	if repo == nil {
+		return fileGitBlobHash(pth)
+	}
+	blob, tracked := repo.blobs[pth]
+	if !tracked {
+		return fileGitBlobHash(pth)
+	}
+	if repo.modified[pth] {
+		return gitHashObject(root, pth)
+	}
+	return blob, nil
+}
+
+// gitOutput runs the git command in the repository and returns its stdout.
+func gitOutput(root string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := command.New("git", append([]string{"-C", root}, args...)...).SetStdout(&stdout).SetStderr(&stderr)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s: %s", cmd.PrintableCommandArgs(), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// readGitRepoIndex reads the stage 0 entries of the repository's index and the tracked files differing from them.
+func readGitRepoIndex(root string) (*gitRepoIndex, error) {
+	out, err := gitOutput(root, "ls-files", "--stage", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &gitRepoIndex{blobs: map[string]string{}, modified: map[string]bool{}}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// <mode> <object> <stage>\t<path>
+		split := strings.SplitN(entry, "\t", 2)
+		fields := strings.Fields(split[0])
+		if len(split) != 2 || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected index entry: %s", entry)
+		}
+		// only regular files and symlinks are blobs, submodules (160000) are commits
+		if fields[0] == "160000" || fields[2] != "0" {
+			continue
+		}
+		repo.blobs[filepath.Join(root, filepath.FromSlash(split[1]))] = fields[1]
+	}
+
+	out, err = gitOutput(root, "diff-files", "--name-only", "-z")
+	if err != nil {
+		return nil, err
+	}
+	for _, pth := range strings.Split(string(out), "\x00") {
+		if pth != "" {
+			repo.modified[filepath.Join(root, filepath.FromSlash(pth))] = true
+		}
+	}
+	return repo, nil
+}
+
+// gitHashObject returns the blob hash of the file as git would store it in the repository.
+func gitHashObject(root, pth string) (string, error) {
+	out, err := gitOutput(root, "hash-object", "--", pth)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// fileGitBlobHash returns the git blob hash of the file's content, without applying any git filters.
+func fileGitBlobHash(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New() // #nosec G401 -- git object ids are sha1 hashes
+	h.Write([]byte(fmt.Sprintf("blob %d\x00", info.Size())))
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,105 @@
+package cachepush
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func Test_fileGitBlobHash(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "git_fingerprint")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	for content, want := range map[string]string{
+		"":              "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		"hello world\n": "3b18e512dba79e4c8300dd08aeb37f8e728b8dad",
+	} {
+		pth := filepath.Join(tmpDir, "file")
+		if err := ioutil.WriteFile(pth, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+
+		got, err := fileGitBlobHash(pth)
+		if err != nil {
+			t.Fatalf("fileGitBlobHash() error = %s", err)
+		}
+		if got != want {
+			t.Errorf("fileGitBlobHash(%q) = %s, want %s", content, got, want)
+		}
+	}
+}
+
+func Test_gitIndex_blobHash(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "git_fingerprint")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	repoDir := filepath.Join(tmpDir, "repo")
+	createDirStruct(t, map[string]string{
+		filepath.Join(repoDir, "Gemfile.lock"):         "gems",
+		filepath.Join(repoDir, "ios", "Podfile.lock"):  "pods",
+		filepath.Join(repoDir, "ios", "untracked.txt"): "untracked",
+		filepath.Join(tmpDir, "outside.txt"):           "outside",
+	})
+	runGit(t, repoDir, "init", "-q")
+	runGit(t, repoDir, "add", "Gemfile.lock", "ios/Podfile.lock")
+	runGit(t, repoDir, "commit", "-q", "-m", "initial")
+
+	gemfileLock := filepath.Join(repoDir, "Gemfile.lock")
+	podfileLock := filepath.Join(repoDir, "ios", "Podfile.lock")
+
+	// a fresh checkout only changes the mod time
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(gemfileLock, future, future); err != nil {
+		t.Fatalf("failed to change mod time: %s", err)
+	}
+	if err := ioutil.WriteFile(podfileLock, []byte("pods changed"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	index := newGitIndex()
+	for _, pth := range []string{
+		gemfileLock,
+		podfileLock,
+		filepath.Join(repoDir, "ios", "untracked.txt"),
+		filepath.Join(tmpDir, "outside.txt"),
+	} {
+		got, err := index.blobHash(pth)
+		if err != nil {
+			t.Fatalf("blobHash(%s) error = %s", pth, err)
+		}
+		if want := runGit(t, repoDir, "hash-object", "--no-filters", pth); got != want {
+			t.Errorf("blobHash(%s) = %s, want %s", pth, got, want)
+		}
+	}
+
+	if root := index.rootByDir[filepath.Join(repoDir, "ios")]; root != repoDir {
+		t.Errorf("repository root of ios = %s, want %s", root, repoDir)
+	}
+	if root := index.rootByDir[tmpDir]; root != "" {
+		t.Errorf("repository root of %s = %s, want none", tmpDir, root)
+	}
+}
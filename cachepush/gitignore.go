@@ -0,0 +1,162 @@
+// Gitignore related functions.
+//
+// The files ignored by the git repository containing a cache path can be left out of the cache automatically.
+// Only the gitignore rules matching inside the cache path count: the cache path itself is usually git ignored
+// (like node_modules), but that does not exclude its content.
+// The .gitignore files between the repository root and the cache path, the .gitignore files inside the cache path
+// and the repository's info/exclude file are read, the global excludes file is not.
+package cachepush
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// parseGitignore returns the exclude patterns of the gitignore file content, dir is the gitignore file's directory.
+func parseGitignore(dir, content string) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// trailing spaces are ignored unless they are escaped
+		if trimmed := strings.TrimRight(line, " "); strings.HasSuffix(trimmed, "\\") && trimmed != line {
+			line = trimmed + " "
+		} else {
+			line = trimmed
+		}
+
+		action := excludeFromCache
+		if strings.HasPrefix(line, "!") {
+			action = reinclude
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if line == "" || line == "/" {
+			continue
+		}
+
+		pattern := strings.TrimPrefix(line, "/")
+		if !strings.Contains(strings.TrimSuffix(line, "/"), "/") {
+			// a name matches at any depth below the gitignore file
+			pattern = "**/" + pattern
+		}
+
+		p, err := compileIgnorePattern(filepath.Join(dir, pattern)+trailingSlash(pattern), action)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func trailingSlash(pattern string) string {
+	if strings.HasSuffix(pattern, "/") {
+		return "/"
+	}
+	return ""
+}
+
+// readGitignore returns the exclude patterns of the gitignore file, nil if it does not exist.
+func readGitignore(dir, pth string) ([]ignorePattern, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseGitignore(dir, string(content))
+}
+
+// gitignoreDirs returns the directories whose .gitignore file applies to the cache root:
+// the directories from the repository root to the root and the directories of the cached .gitignore files,
+// ordered by depth (the deeper gitignore files take precedence).
+func gitignoreDirs(repoRoot, root string, cachedPaths []string) []string {
+	dirSet := map[string]bool{}
+	for dir := filepath.Dir(root); isUnderRoot(dir, repoRoot); dir = filepath.Dir(dir) {
+		dirSet[dir] = true
+		if dir == repoRoot {
+			break
+		}
+	}
+	for _, pth := range cachedPaths {
+		if filepath.Base(pth) == ".gitignore" && isUnderRoot(pth, root) {
+			dirSet[filepath.Dir(pth)] = true
+		}
+	}
+
+	dirs := make([]string, 0, len(dirSet))
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		di, dj := strings.Count(dirs[i], string(filepath.Separator)), strings.Count(dirs[j], string(filepath.Separator))
+		if di != dj {
+			return di < dj
+		}
+		return dirs[i] < dirs[j]
+	})
+	return dirs
+}
+
+// gitignorePatterns returns the gitignore patterns applying to the cache root, in order of precedence.
+func gitignorePatterns(repoRoot, root string, cachedPaths []string) ([]ignorePattern, error) {
+	patterns, err := readGitignore(repoRoot, filepath.Join(repoRoot, ".git", "info", "exclude"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range gitignoreDirs(repoRoot, root, cachedPaths) {
+		filePatterns, err := readGitignore(dir, filepath.Join(dir, ".gitignore"))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	return patterns, nil
+}
+
+// excludeGitignored removes the paths ignored by the git repository containing their cache root
+// and returns the removed paths in order.
+func excludeGitignored(indicatorByCachePth map[string]string, roots []string) (map[string]string, []string, error) {
+	cachedPaths := make([]string, 0, len(indicatorByCachePth))
+	for pth := range indicatorByCachePth {
+		cachedPaths = append(cachedPaths, pth)
+	}
+
+	index := newGitIndex()
+	patternsByRoot := map[string][]ignorePattern{}
+	for _, root := range roots {
+		repoRoot := index.repoRoot(root)
+		if repoRoot == "" {
+			continue
+		}
+
+		patterns, err := gitignorePatterns(repoRoot, root, cachedPaths)
+		if err != nil {
+			return nil, nil, err
+		}
+		patternsByRoot[root] = patterns
+	}
+
+	kept := map[string]string{}
+	var excluded []string
+	for pth, indicator := range indicatorByCachePth {
+		root := rootOf(pth, roots)
+		if action, _ := matchBelow(pth, root, patternsByRoot[root]); action == excludeFromCache {
+			excluded = append(excluded, pth)
+			continue
+		}
+		kept[pth] = indicator
+	}
+
+	sort.Strings(excluded)
+	return kept, excluded, nil
+}
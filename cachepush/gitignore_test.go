@@ -0,0 +1,82 @@
+package cachepush
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseGitignore(t *testing.T) {
+	patterns, err := parseGitignore("/repo", "# comment\n\n*.log\n/local.properties\nbuild/\n!keep.log\ndocs/*.md  \n\\#hash\r\n")
+	require.NoError(t, err)
+
+	var got []ignorePattern
+	for _, p := range patterns {
+		got = append(got, ignorePattern{Pattern: p.Pattern, Action: p.Action})
+	}
+	require.Equal(t, []ignorePattern{
+		{Pattern: "/repo/**/*.log", Action: excludeFromCache},
+		{Pattern: "/repo/local.properties", Action: excludeFromCache},
+		{Pattern: "/repo/**/build/", Action: excludeFromCache},
+		{Pattern: "/repo/**/keep.log", Action: reinclude},
+		{Pattern: "/repo/docs/*.md", Action: excludeFromCache},
+		{Pattern: "/repo/**/#hash", Action: excludeFromCache},
+	}, got)
+}
+
+func Test_excludeGitignored(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gitignore")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	repo := filepath.Join(tmpDir, "repo")
+	project := filepath.Join(repo, "project")
+	other := filepath.Join(tmpDir, "other")
+	createDirStruct(t, map[string]string{
+		filepath.Join(repo, ".gitignore"):                 "project/\n*.log\n!keep.log\n",
+		filepath.Join(repo, ".git", "info", "exclude"):    "*.tmp\n",
+		filepath.Join(project, ".gitignore"):              "/local.properties\nbuild/\n",
+		filepath.Join(project, "local.properties"):        "",
+		filepath.Join(project, "app", "local.properties"): "",
+		filepath.Join(project, "app", "build", "out.bin"): "",
+		filepath.Join(project, "app", "debug.log"):        "",
+		filepath.Join(project, "app", "keep.log"):         "",
+		filepath.Join(project, "app", "scratch.tmp"):      "",
+		filepath.Join(project, "app", "src", "main.kt"):   "",
+		filepath.Join(other, "debug.log"):                 "",
+	})
+
+	indicatorByCachePth := map[string]string{}
+	for _, pth := range []string{
+		filepath.Join(project, ".gitignore"),
+		filepath.Join(project, "local.properties"),
+		filepath.Join(project, "app", "local.properties"),
+		filepath.Join(project, "app", "build", "out.bin"),
+		filepath.Join(project, "app", "debug.log"),
+		filepath.Join(project, "app", "keep.log"),
+		filepath.Join(project, "app", "scratch.tmp"),
+		filepath.Join(project, "app", "src", "main.kt"),
+		filepath.Join(other, "debug.log"),
+	} {
+		indicatorByCachePth[pth] = ""
+	}
+
+	kept, excluded, err := excludeGitignored(indicatorByCachePth, []string{other, project})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(project, "app", "build", "out.bin"),
+		filepath.Join(project, "app", "debug.log"),
+		filepath.Join(project, "app", "scratch.tmp"),
+		filepath.Join(project, "local.properties"),
+	}, excluded)
+	require.Equal(t, map[string]string{
+		filepath.Join(project, ".gitignore"):              "",
+		filepath.Join(project, "app", "local.properties"): "",
+		filepath.Join(project, "app", "keep.log"):         "",
+		filepath.Join(project, "app", "src", "main.kt"):   "",
+		filepath.Join(other, "debug.log"):                 "",
+	}, kept)
+}
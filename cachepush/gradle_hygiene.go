@@ -0,0 +1,103 @@
+// Gradle cache hygiene related functions.
+//
+// The Gradle user home (and the project's .gradle directory) contains files which are useless in the cache
+// and change on every build: the lock files, the file access journal, the daemon's logs and registry
+// and the temporary build scan data. Caching them bloats the archive and makes every build invalidate the cache.
+// With gradle_cache_hygiene enabled these files are left out while expanding the cache paths,
+// the excluded directories are not walked at all.
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const gradleHomeDirName = ".gradle"
+
+// gradleHygieneDirs are the excluded directories, relative to the Gradle home.
+// The build scan data directories are excluded at any depth.
+var gradleHygieneDirs = map[string]bool{
+	"daemon":           true,
+	"caches/journal-1": true,
+	".tmp":             true,
+	"caches/.tmp":      true,
+}
+
+// gradleHygiene leaves out the useless Gradle cache files and records the excluded paths.
+type gradleHygiene struct {
+	// userHome is the GRADLE_USER_HOME, if set.
+	userHome string
+	excluded []string
+}
+
+// newGradleHygiene returns the Gradle cache filter, nil (no filtering) if it is disabled.
+func newGradleHygiene(enabled bool) *gradleHygiene {
+	if !enabled {
+		return nil
+	}
+	return &gradleHygiene{userHome: filepath.Clean(os.Getenv("GRADLE_USER_HOME"))}
+}
+
+// gradleRelPath returns the path relative to the Gradle home containing it, false if the path is not inside a Gradle home.
+func (h *gradleHygiene) gradleRelPath(pth string) (string, bool) {
+	if h.userHome != "." && strings.HasPrefix(pth, h.userHome+string(filepath.Separator)) {
+		return filepath.ToSlash(strings.TrimPrefix(pth, h.userHome+string(filepath.Separator))), true
+	}
+
+	sep := string(filepath.Separator)
+	idx := strings.LastIndex(pth, sep+gradleHomeDirName+sep)
+	if idx == -1 {
+		return "", false
+	}
+	return filepath.ToSlash(pth[idx+len(gradleHomeDirName)+2:]), true
+}
+
+// skip reports whether the path is useless Gradle cache content, and records it if it is.
+func (h *gradleHygiene) skip(pth string, info os.FileInfo) bool {
+	if h == nil {
+		return false
+	}
+	rel, ok := h.gradleRelPath(pth)
+	if !ok {
+		return false
+	}
+
+	var useless bool
+	if info.IsDir() {
+		useless = gradleHygieneDirs[rel] || filepath.Base(pth) == "build-scan-data"
+	} else {
+		useless = strings.HasSuffix(rel, ".lock") || filepath.Base(pth) == "gc.properties"
+	}
+	if useless {
+		h.excluded = append(h.excluded, pth)
+	}
+	return useless
+}
+
+// skipped returns the excluded paths, sorted.
+func (h *gradleHygiene) skipped() []string {
+	if h == nil {
+		return nil
+	}
+
+	paths := append([]string{}, h.excluded...)
+	sort.Strings(paths)
+	return paths
+}
+
+// logSkipped logs the number of excluded paths, the paths are debug logged.
+func (h *gradleHygiene) logSkipped() {
+	skipped := h.skipped()
+	if len(skipped) == 0 {
+		return
+	}
+
+	log.Printf("%d Gradle lock, journal, daemon and temporary paths are left out of the cache", len(skipped))
+	for _, pth := range skipped {
+		log.Debugf("- %s", pth)
+	}
+}
@@ -0,0 +1,63 @@
+package cachepush
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_gradleHygiene(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	home := filepath.Join(tmpDir, "home", ".gradle")
+	userHome := filepath.Join(tmpDir, "gradle-home")
+	createDirStruct(t, map[string]string{
+		filepath.Join(home, "caches", "modules-2", "modules-2.lock"):            "lock",
+		filepath.Join(home, "caches", "modules-2", "files-2.1", "dep.jar"):      "jar",
+		filepath.Join(home, "caches", "modules-2", "gc.properties"):             "gc",
+		filepath.Join(home, "caches", "journal-1", "file-access.bin"):           "journal",
+		filepath.Join(home, "caches", ".tmp", "tmp"):                            "tmp",
+		filepath.Join(home, "daemon", "7.4", "daemon-1.out.log"):                "log",
+		filepath.Join(home, "wrapper", "dists", "gradle-7.4-bin", "gradle.zip"): "zip",
+		filepath.Join(home, "build-scan-data", "3.9", "data"):                   "scan",
+		filepath.Join(userHome, "caches", "transforms-3", "transforms-3.lock"):  "lock",
+		filepath.Join(userHome, "caches", "transforms-3", "file"):               "file",
+		filepath.Join(tmpDir, "project", "app.lock"):                            "not gradle",
+	})
+	setenvForTest(t, "GRADLE_USER_HOME", userHome)
+
+	t.Log("disabled")
+	{
+		require.Nil(t, newGradleHygiene(false))
+		regularFiles, _, _, err := expandPath(context.Background(), home, nil, nil, newGradleHygiene(false))
+		require.NoError(t, err)
+		require.Len(t, regularFiles, 8)
+	}
+
+	hygiene := newGradleHygiene(true)
+	regularFiles, _, dirPaths, err := expandPath(context.Background(), tmpDir, nil, nil, hygiene)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(tmpDir, "gradle-home", "caches", "transforms-3", "file"),
+		filepath.Join(home, "caches", "modules-2", "files-2.1", "dep.jar"),
+		filepath.Join(home, "wrapper", "dists", "gradle-7.4-bin", "gradle.zip"),
+		filepath.Join(tmpDir, "project", "app.lock"),
+	}, regularFiles)
+	require.NotContains(t, dirPaths, filepath.Join(home, "daemon"))
+
+	require.Equal(t, []string{
+		filepath.Join(userHome, "caches", "transforms-3", "transforms-3.lock"),
+		filepath.Join(home, "build-scan-data"),
+		filepath.Join(home, "caches", ".tmp"),
+		filepath.Join(home, "caches", "journal-1"),
+		filepath.Join(home, "caches", "modules-2", "gc.properties"),
+		filepath.Join(home, "caches", "modules-2", "modules-2.lock"),
+		filepath.Join(home, "daemon"),
+	}, hygiene.skipped())
+}
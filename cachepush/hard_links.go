@@ -0,0 +1,25 @@
+// Hard link related functions.
+//
+// Hard linked files (like the ones in .git/objects or in some Gradle caches) are archived once,
+// the other paths linking to the same file are written as tar hard link entries pointing to the first archived path.
+package cachepush
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file on the file system.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLinkID returns the identifier of the regular file, ok is false if the file has a single link.
+func hardLinkID(info os.FileInfo) (id fileID, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.Mode().IsRegular() || uint64(stat.Nlink) < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,63 @@
+package cachepush
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_Write_hardLinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hard_links")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	original := filepath.Join(tmpDir, "a", "object")
+	link := filepath.Join(tmpDir, "b", "object")
+	single := filepath.Join(tmpDir, "single")
+	createDirStruct(t, map[string]string{original: "content", single: "single"})
+	require.NoError(t, os.MkdirAll(filepath.Dir(link), 0755))
+	require.NoError(t, os.Link(original, link))
+
+	pth := filepath.Join(tmpDir, "cache.tar")
+	archive, err := NewArchive(pth, false, memoryBudget{})
+	require.NoError(t, err)
+	require.NoError(t, writeArchive(context.Background(), archive, archiveContent{
+		pathToIndicatorPath: map[string]string{original: "", link: "", single: ""},
+	}))
+
+	reader, closeArchive, err := openArchiveReader(pth, nil)
+	require.NoError(t, err)
+	defer closeArchive()
+
+	headers := map[string]*tar.Header{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		headers[header.Name] = header
+	}
+
+	require.Equal(t, byte(tar.TypeReg), headers[single].Typeflag)
+
+	// either of the links can be archived first
+	names := []string{original, link}
+	if headers[link].Typeflag == tar.TypeReg {
+		names = []string{link, original}
+	}
+	require.Equal(t, byte(tar.TypeReg), headers[names[0]].Typeflag)
+	require.Equal(t, int64(len("content")), headers[names[0]].Size)
+	require.Equal(t, byte(tar.TypeLink), headers[names[1]].Typeflag)
+	require.Equal(t, names[0], headers[names[1]].Linkname)
+
+	mismatching, err := simulatePull(pth, []string{names[1]}, newHashCache(), nil)
+	require.NoError(t, err)
+	require.Empty(t, mismatching)
+}
@@ -0,0 +1,222 @@
+// BLAKE3 content hash related functions.
+//
+// BLAKE3 is a cryptographic hash, considerably faster than MD5. This is the sequential, hash mode only
+// (32 bytes output, no key and no key derivation) implementation of the reference design.
+package cachepush
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19}
+
+var blake3MsgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] = s[a] + s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Round(s *[16]uint32, m *[16]uint32) {
+	blake3G(s, 0, 4, 8, 12, m[0], m[1])
+	blake3G(s, 1, 5, 9, 13, m[2], m[3])
+	blake3G(s, 2, 6, 10, 14, m[4], m[5])
+	blake3G(s, 3, 7, 11, 15, m[6], m[7])
+	blake3G(s, 0, 5, 10, 15, m[8], m[9])
+	blake3G(s, 1, 6, 11, 12, m[10], m[11])
+	blake3G(s, 2, 7, 8, 13, m[12], m[13])
+	blake3G(s, 3, 4, 9, 14, m[14], m[15])
+}
+
+func blake3Compress(cv [8]uint32, block [16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := block
+	for r := 0; r < 7; r++ {
+		blake3Round(&s, &m)
+		if r < 6 {
+			var permuted [16]uint32
+			for i, j := range blake3MsgPermutation {
+				permuted[i] = m[j]
+			}
+			m = permuted
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func blake3Words(b []byte) [16]uint32 {
+	var block [blake3BlockLen]byte
+	copy(block[:], b)
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+	return words
+}
+
+// blake3Output is a node of the hash tree, which is either compressed into a chaining value or into the root hash.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o blake3Output) chainingValue() [8]uint32 {
+	var cv [8]uint32
+	s := blake3Compress(o.cv, o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o blake3Output) rootHash() []byte {
+	s := blake3Compress(o.cv, o.block, 0, o.blockLen, o.flags|blake3Root)
+	out := make([]byte, 32)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(out[i*4:], s[i])
+	}
+	return out
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return blake3Output{cv: blake3IV, block: block, blockLen: blake3BlockLen, flags: blake3Parent}
+}
+
+// blake3ChunkState hashes a 1 KB chunk of the input.
+type blake3ChunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [blake3BlockLen]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func newBlake3ChunkState(counter uint64) blake3ChunkState {
+	return blake3ChunkState{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3ChunkState) len() int {
+	return blake3BlockLen*c.blocksCompressed + c.blockLen
+}
+
+func (c *blake3ChunkState) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3ChunkState) update(p []byte) {
+	for len(p) > 0 {
+		// the last block is only compressed in the output, with the chunk end flag
+		if c.blockLen == blake3BlockLen {
+			s := blake3Compress(c.cv, blake3Words(c.block[:]), c.counter, blake3BlockLen, c.startFlag())
+			copy(c.cv[:], s[:8])
+			c.blocksCompressed++
+			c.blockLen = 0
+		}
+
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3ChunkState) output() blake3Output {
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(c.block[:c.blockLen]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3 is a streaming BLAKE3 hash.
+type blake3 struct {
+	chunk   blake3ChunkState
+	cvStack [][8]uint32
+}
+
+func newBLAKE3() hash.Hash {
+	return &blake3{chunk: newBlake3ChunkState(0)}
+}
+
+func (h *blake3) Reset() {
+	h.chunk = newBlake3ChunkState(0)
+	h.cvStack = h.cvStack[:0]
+}
+
+func (h *blake3) Size() int { return 32 }
+
+func (h *blake3) BlockSize() int { return blake3BlockLen }
+
+// addChunkChainingValue merges the completed subtrees: the number of trailing zero bits of the total chunk count
+// is the number of subtrees to merge.
+func (h *blake3) addChunkChainingValue(cv [8]uint32, totalChunks uint64) {
+	for totalChunks&1 == 0 {
+		last := h.cvStack[len(h.cvStack)-1]
+		h.cvStack = h.cvStack[:len(h.cvStack)-1]
+		cv = blake3ParentOutput(last, cv).chainingValue()
+		totalChunks >>= 1
+	}
+	h.cvStack = append(h.cvStack, cv)
+}
+
+func (h *blake3) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if h.chunk.len() == blake3ChunkLen {
+			cv := h.chunk.output().chainingValue()
+			totalChunks := h.chunk.counter + 1
+			h.addChunkChainingValue(cv, totalChunks)
+			h.chunk = newBlake3ChunkState(totalChunks)
+		}
+
+		n := blake3ChunkLen - h.chunk.len()
+		if n > len(p) {
+			n = len(p)
+		}
+		h.chunk.update(p[:n])
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (h *blake3) Sum(b []byte) []byte {
+	output := h.chunk.output()
+	for i := len(h.cvStack) - 1; i >= 0; i-- {
+		output = blake3ParentOutput(h.cvStack[i], output.chainingValue())
+	}
+	return append(b, output.rootHash()...)
+}
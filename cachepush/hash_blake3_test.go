@@ -0,0 +1,62 @@
+package cachepush
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// testInput returns the input of the reference test vectors: the byte sequence 0, 1, ..., 250, 0, 1, ...
+func testInput(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func Test_newBLAKE3(t *testing.T) {
+	tests := []struct {
+		len  int
+		want string
+	}{
+		{len: 0, want: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{len: 1, want: "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{len: 1024, want: "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{len: 1025, want: "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{len: 2048, want: "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+	}
+	for _, tt := range tests {
+		if got := hashHex(newBLAKE3(), testInput(tt.len)); got != tt.want {
+			t.Errorf("blake3(%d bytes) = %s, want %s", tt.len, got, tt.want)
+		}
+	}
+
+	if got := hashHex(newBLAKE3(), []byte("abc")); got != "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85" {
+		t.Errorf("blake3(abc) = %s", got)
+	}
+}
+
+func Test_contentHashMethods_streaming(t *testing.T) {
+	input := testInput(10 * 1024)
+	for method, newHash := range contentHashMethods {
+		want := hashHex(newHash(), input)
+
+		h := newHash()
+		for rest := input; len(rest) > 0; {
+			n := 7
+			if n > len(rest) {
+				n = len(rest)
+			}
+			h.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("%s: streamed hash = %s, want %s", method, got, want)
+		}
+
+		h.Reset()
+		if got := hashHex(h, input); got != want {
+			t.Errorf("%s: hash after reset = %s, want %s", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Per-run file content hash cache.
+//
+// In two-phase execution the cache is saved by the prepare invocation and loaded by the push invocation,
+// so the files not modified in between are not hashed again.
+package cachepush
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// hashCacheKey identifies a file's state: the digest is reused only if the file did not change since hashing it.
+type hashCacheKey struct {
+	path    string
+	size    int64
+	modTime int64
+	method  ChangeIndicator
+}
+
+// hashCache stores the file content hashes calculated during the step run,
+// so descriptor generation, change verification and archive metadata don't read the same files multiple times.
+type hashCache struct {
+	mu      sync.Mutex
+	digests map[hashCacheKey]string
+	hits    int
+	loaded  int
+}
+
+// hashCacheEntry is the persisted form of a cached digest.
+type hashCacheEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	// Method is the content hash method of the digest, empty for MD5.
+	Method string `json:"method,omitempty"`
+	Digest string `json:"digest"`
+}
+
+func newHashCache() *hashCache {
+	return &hashCache{digests: map[hashCacheKey]string{}}
+}
+
+// contentHash returns the file's MD5 content hash, calculating it only if not yet cached.
+func (c *hashCache) contentHash(pth string) (string, error) {
+	return c.digest(pth, MD5)
+}
+
+// digest returns the file's content hash calculated with the given method, calculating it only if not yet cached.
+func (c *hashCache) digest(pth string, method ChangeIndicator) (string, error) {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return "", err
+	}
+	key := hashCacheKey{path: pth, size: info.Size(), modTime: info.ModTime().UnixNano(), method: method}
+
+	c.mu.Lock()
+	digest, ok := c.digests[key]
+	if ok {
+		c.hits++
+	}
+	c.mu.Unlock()
+	if ok {
+		return digest, nil
+	}
+
+	digest, err = fileDigest(pth, method)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.digests[key] = digest
+	c.mu.Unlock()
+
+	return digest, nil
+}
+
+// stats returns the number of hashed files and the number of reused hashes.
+func (c *hashCache) stats() (hashed int, hits int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.digests) - c.loaded, c.hits
+}
+
+// save writes the cached digests into the given file.
+func (c *hashCache) save(pth string) error {
+	c.mu.Lock()
+	entries := make([]hashCacheEntry, 0, len(c.digests))
+	for key, digest := range c.digests {
+		entry := hashCacheEntry{Path: key.path, Size: key.size, ModTime: key.modTime, Digest: digest}
+		if key.method != MD5 {
+			entry.Method = string(key.method)
+		}
+		entries = append(entries, entry)
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
+
+// loadHashCache returns a hash cache containing the digests saved into the given file, or an empty cache if the file does not exist.
+// A digest is only reused if its file's size and mod time did not change since saving it.
+func loadHashCache(pth string) (*hashCache, error) {
+	c := newHashCache()
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return c, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []hashCacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		method := MD5
+		if entry.Method != "" {
+			method = ChangeIndicator(entry.Method)
+		}
+		c.digests[hashCacheKey{path: entry.Path, size: entry.Size, modTime: entry.ModTime, method: method}] = entry.Digest
+	}
+	c.loaded = len(c.digests)
+	return c, nil
+}
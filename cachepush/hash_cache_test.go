@@ -0,0 +1,94 @@
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_hashCache_contentHash(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+
+	pth := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{pth: "content"})
+
+	cache := newHashCache()
+
+	first, err := cache.contentHash(pth)
+	if err != nil {
+		t.Fatalf("contentHash() error = %v", err)
+	}
+	second, err := cache.contentHash(pth)
+	if err != nil {
+		t.Fatalf("contentHash() error = %v", err)
+	}
+	if first != second {
+		t.Errorf("hash changed for the same file: %s != %s", first, second)
+	}
+	if hashed, hits := cache.stats(); hashed != 1 || hits != 1 {
+		t.Errorf("stats() = %d, %d, want 1, 1", hashed, hits)
+	}
+
+	createDirStruct(t, map[string]string{pth: "modified content"})
+	if err := os.Chtimes(pth, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to change mod time: %s", err)
+	}
+
+	third, err := cache.contentHash(pth)
+	if err != nil {
+		t.Fatalf("contentHash() error = %v", err)
+	}
+	if third == first {
+		t.Errorf("hash did not change for a modified file")
+	}
+}
+
+func Test_hashCache_saveAndLoad(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+
+	pth := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{pth: "content"})
+
+	cache := newHashCache()
+	digest, err := cache.contentHash(pth)
+	if err != nil {
+		t.Fatalf("contentHash() error = %v", err)
+	}
+
+	statePth := filepath.Join(tmpDir, "hashes.json")
+	if err := cache.save(statePth); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	loaded, err := loadHashCache(statePth)
+	if err != nil {
+		t.Fatalf("loadHashCache() error = %v", err)
+	}
+	reused, err := loaded.contentHash(pth)
+	if err != nil {
+		t.Fatalf("contentHash() error = %v", err)
+	}
+	if reused != digest {
+		t.Errorf("contentHash() = %s, want %s", reused, digest)
+	}
+	if hashed, hits := loaded.stats(); hashed != 0 || hits != 1 {
+		t.Errorf("stats() = %d, %d, want 0, 1", hashed, hits)
+	}
+
+	empty, err := loadHashCache(filepath.Join(tmpDir, "missing.json"))
+	if err != nil {
+		t.Fatalf("loadHashCache() error = %v", err)
+	}
+	if hashed, hits := empty.stats(); hashed != 0 || hits != 0 {
+		t.Errorf("stats() = %d, %d, want 0, 0", hashed, hits)
+	}
+}
@@ -0,0 +1,129 @@
+// XXH64 content hash related functions.
+//
+// XXH64 is a non-cryptographic hash, several times faster than MD5 and sufficient for change detection.
+package cachepush
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+// xxh64 is a streaming XXH64 hash with zero seed.
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	buf            [32]byte
+	n              int
+}
+
+func newXXH64() hash.Hash {
+	h := &xxh64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	// the initial accumulators of seed 0, wrapping around as uint64 arithmetic
+	p1, p2 := xxhPrime1, xxhPrime2
+	h.v1 = p1 + p2
+	h.v2 = p2
+	h.v3 = 0
+	h.v4 = -p1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh64) Size() int { return 8 }
+
+func (h *xxh64) BlockSize() int { return 32 }
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxhPrime1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*xxhPrime1 + xxhPrime4
+}
+
+func (h *xxh64) stripe(b []byte) {
+	h.v1 = xxhRound(h.v1, binary.LittleEndian.Uint64(b[0:]))
+	h.v2 = xxhRound(h.v2, binary.LittleEndian.Uint64(b[8:]))
+	h.v3 = xxhRound(h.v3, binary.LittleEndian.Uint64(b[16:]))
+	h.v4 = xxhRound(h.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+func (h *xxh64) Write(p []byte) (int, error) {
+	written := len(p)
+	h.total += uint64(len(p))
+
+	if h.n > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n < len(h.buf) {
+			return written, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+
+	for ; len(p) >= 32; p = p[32:] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+	return written, nil
+}
+
+func (h *xxh64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) + bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		acc = xxhMergeRound(acc, h.v1)
+		acc = xxhMergeRound(acc, h.v2)
+		acc = xxhMergeRound(acc, h.v3)
+		acc = xxhMergeRound(acc, h.v4)
+	} else {
+		acc = xxhPrime5
+	}
+	acc += h.total
+
+	p := h.buf[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		acc ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		acc = bits.RotateLeft64(acc, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		acc = bits.RotateLeft64(acc, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc ^= uint64(b) * xxhPrime5
+		acc = bits.RotateLeft64(acc, 11) * xxhPrime1
+	}
+
+	acc ^= acc >> 33
+	acc *= xxhPrime2
+	acc ^= acc >> 29
+	acc *= xxhPrime3
+	acc ^= acc >> 32
+	return acc
+}
+
+func (h *xxh64) Sum(b []byte) []byte {
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], h.Sum64())
+	return append(b, sum[:]...)
+}
@@ -0,0 +1,58 @@
+package cachepush
+
+import (
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func Test_newXXH64(t *testing.T) {
+	// the inputs are prefixes of the repeated alphabet, so the lengths around the 32 byte stripe size are covered
+	alphabet := strings.Repeat("abcdefghijklmnopqrstuvwxyz0123456789", 28)
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "", want: "ef46db3751d8e999"},
+		{input: "a", want: "d24ec4f1a98c6e5b"},
+		{input: "abc", want: "44bc2cf5ad770999"},
+		{input: alphabet[:4], want: "de0327b0d25d92cc"},
+		{input: alphabet[:8], want: "3ad351775b4634b7"},
+		{input: alphabet[:31], want: "16058c7b947da137"},
+		{input: alphabet[:32], want: "bf2cd639b4143b80"},
+		{input: alphabet[:33], want: "4f89e4082bcbf673"},
+		{input: alphabet[:63], want: "e1d5bec70d85cd20"},
+		{input: alphabet[:64], want: "040d7eb5d0212db5"},
+		{input: alphabet[:65], want: "61779b1514785232"},
+		{input: alphabet[:100], want: "5f009d36eeb305be"},
+		{input: alphabet[:1000], want: "c1c170c6c2158bc4"},
+		{input: "The quick brown fox jumps over the lazy dog", want: "0b242d361fda71bc"},
+	}
+	for _, tt := range tests {
+		if got := hashHex(newXXH64(), []byte(tt.input)); got != tt.want {
+			t.Errorf("xxh64(%d bytes) = %s, want %s", len(tt.input), got, tt.want)
+		}
+
+		// the same digest if the input is written in parts not aligned to the stripes
+		for _, chunk := range []int{1, 7, 31, 33} {
+			h := newXXH64()
+			for rest := []byte(tt.input); len(rest) > 0; {
+				n := chunk
+				if n > len(rest) {
+					n = len(rest)
+				}
+				h.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+				t.Errorf("xxh64(%d bytes in %d byte writes) = %s, want %s", len(tt.input), chunk, got, tt.want)
+			}
+		}
+	}
+}
+
+func hashHex(h hash.Hash, b []byte) string {
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,113 @@
+// HTTP client related functions.
+//
+// Every request of the step (cache API, uploads, S3) goes through the transport built here:
+// it uses the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables (or the proxy_url input),
+// trusts the custom CA bundle in addition to the system certificates, and limits the connection setup time,
+// so an unreachable endpoint fails fast instead of hanging until the upload timeout.
+package cachepush
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	// maxDrainedResponseBody is the most read from an unread response body before closing it,
+	// a longer body closes the connection instead of keeping it for the next request.
+	maxDrainedResponseBody = 256 * 1024
+)
+
+// httpSettings configures the transport of the step's requests.
+type httpSettings struct {
+	// proxyURL overrides the proxy environment variables if set.
+	proxyURL string
+	// caBundle is the path of a PEM file with additionally trusted CA certificates.
+	caBundle string
+	// connectTimeout limits the TCP connection and the TLS handshake, 0 means the default.
+	connectTimeout time.Duration
+}
+
+func mustHTTPTransport(settings httpSettings) *http.Transport {
+	transport, err := newHTTPTransport(settings)
+	if err != nil {
+		panic(err)
+	}
+	return transport
+}
+
+// newHTTPTransport returns the transport configured by the settings.
+func newHTTPTransport(settings httpSettings) (*http.Transport, error) {
+	connectTimeout := settings.connectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if settings.proxyURL != "" {
+		proxyURL, err := url.Parse(settings.proxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url (%s)", redactURL(settings.proxyURL))
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if settings.caBundle != "" {
+		pem, err := ioutil.ReadFile(settings.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %s", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the CA bundle (%s)", settings.caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = bypassLinkLocal(proxy)
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// newHTTPClient returns a client using the transport of the push, 0 timeout means no timeout.
+func newHTTPClient(ctx context.Context, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: envOf(ctx).transport, Timeout: timeout}
+}
+
+// bypassLinkLocal returns the proxy func connecting directly to link-local hosts,
+// like the instance metadata and the container credentials endpoints, which are only reachable from the host.
+func bypassLinkLocal(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if ip := net.ParseIP(req.URL.Hostname()); ip != nil && ip.IsLinkLocalUnicast() {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// closeResponseBody drains and closes the response body, so the connection can be reused by the next request.
+func closeResponseBody(resp *http.Response) {
+	if _, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainedResponseBody)); err != nil {
+		log.Debugf("Failed to drain response body: %s", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Warnf("Failed to close response body: %s", err)
+	}
+}
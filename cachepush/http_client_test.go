@@ -0,0 +1,94 @@
+package cachepush
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newHTTPTransport_caBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "http_client")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	caBundle := filepath.Join(tmpDir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	transport, err := newHTTPTransport(httpSettings{})
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	require.Error(t, err)
+
+	transport, err = newHTTPTransport(httpSettings{caBundle: caBundle})
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	invalid := filepath.Join(tmpDir, "invalid.pem")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("not a certificate"), 0600))
+	_, err = newHTTPTransport(httpSettings{caBundle: invalid})
+	require.Error(t, err)
+
+	_, err = newHTTPTransport(httpSettings{caBundle: filepath.Join(tmpDir, "missing.pem")})
+	require.Error(t, err)
+}
+
+func Test_newHTTPTransport_proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	transport, err := newHTTPTransport(httpSettings{proxyURL: proxy.URL})
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get("http://cache.example.invalid/upload")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "http://cache.example.invalid/upload", proxied)
+
+	for _, proxyURL := range []string{"://invalid", "no-host"} {
+		_, err := newHTTPTransport(httpSettings{proxyURL: proxyURL})
+		require.Error(t, err, proxyURL)
+	}
+}
+
+func Test_newHTTPTransport_connectTimeout(t *testing.T) {
+	transport, err := newHTTPTransport(httpSettings{})
+	require.NoError(t, err)
+	require.Equal(t, defaultConnectTimeout, transport.TLSHandshakeTimeout)
+
+	transport, err = newHTTPTransport(httpSettings{connectTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, transport.TLSHandshakeTimeout)
+}
+
+func Test_newHTTPTransport_linkLocal(t *testing.T) {
+	transport, err := newHTTPTransport(httpSettings{proxyURL: "http://proxy.example.invalid:3128"})
+	require.NoError(t, err)
+
+	for _, rawURL := range []string{"http://169.254.169.254/latest/api/token", "http://169.254.170.2/v2/credentials", "http://[fe80::1]/"} {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		require.NoError(t, err)
+		proxyURL, err := transport.Proxy(req)
+		require.NoError(t, err)
+		require.Nil(t, proxyURL, rawURL)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://cache.example.invalid/upload", nil)
+	require.NoError(t, err)
+	proxyURL, err := transport.Proxy(req)
+	require.NoError(t, err)
+	require.Equal(t, "http://proxy.example.invalid:3128", proxyURL.String())
+}
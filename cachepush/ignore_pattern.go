@@ -0,0 +1,228 @@
+// Ignore pattern related functions.
+//
+// The ignore items are matched the way git matches .gitignore patterns, the working directory taking
+// the place of the .gitignore file's directory: a pattern without a slash (apart from a trailing one) matches
+// a file or directory name at any depth inside the working directory (like *.log, which the ignore items
+// always matched this way), any other pattern is anchored: relative patterns to the working directory,
+// `~` patterns to the home directory. The built-in patterns (like the iOS exclusion profile's) and the never evicted
+// patterns are not bound to the working directory, their patterns without a slash match a name anywhere.
+// A pattern ending with a slash only matches directories.
+// `*` and `?` match within a path element, `**` matches any number of path elements.
+// A pattern matching a directory matches everything inside it.
+// The items are evaluated in order, the last matching item decides: an item prefixed with `+` re-includes
+// the paths matched by the previous items, like a negated .gitignore pattern
+// (the `!` prefix keeps its meaning: excluding the paths from the cache).
+// An item prefixed with `re:` is an RE2 regular expression, matched against the absolute paths.
+package cachepush
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// ignoreAction is what happens with the paths matching an ignore item.
+type ignoreAction string
+
+// regexPatternPrefix marks the ignore items which are regular expressions.
+const regexPatternPrefix = "re:"
+
+const (
+	// ignoreChanges keeps the path in the cache, but its changes do not invalidate the cache.
+	ignoreChanges ignoreAction = "ignore"
+	// excludeFromCache leaves the path out of the cache.
+	excludeFromCache ignoreAction = "exclude"
+	// reinclude cancels the previous matching items.
+	reinclude ignoreAction = "include"
+)
+
+// ignorePattern is a compiled ignore item.
+type ignorePattern struct {
+	Pattern string       `json:"pattern"`
+	Action  ignoreAction `json:"action"`
+
+	// inWorkDir binds a pattern without a slash to the working directory.
+	inWorkDir bool
+	dirOnly   bool
+	// re is the regular expression of the regex patterns.
+	re *regexp.Regexp
+	// elements are the path elements of the pattern, unanchored patterns start with **.
+	elements []string
+}
+
+// compileIgnorePattern returns the ignore pattern of the (already expanded) pattern.
+func compileIgnorePattern(pattern string, action ignoreAction) (ignorePattern, error) {
+	p := ignorePattern{Pattern: pattern, Action: action}
+
+	if strings.HasPrefix(pattern, regexPatternPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexPatternPrefix))
+		if err != nil {
+			return ignorePattern{}, fmt.Errorf("invalid ignore pattern (%s): %s", pattern, err)
+		}
+		p.re = re
+		return p, nil
+	}
+
+	trimmed := pattern
+	if len(trimmed) > 1 && strings.HasSuffix(trimmed, "/") {
+		p.dirOnly = true
+		trimmed = strings.TrimRight(trimmed, "/")
+	}
+
+	p.elements = strings.Split(trimmed, "/")
+	if !strings.Contains(trimmed, "/") {
+		p.elements = []string{"**", trimmed}
+	}
+
+	for _, element := range p.elements {
+		if _, err := filepath.Match(element, ""); err != nil {
+			return ignorePattern{}, fmt.Errorf("invalid ignore pattern (%s): %s", pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// normalizeIgnorePatterns expands the environment variables in the ignore items,
+// makes the anchored ones absolute and compiles them, the regex items are compiled as they are.
+func normalizeIgnorePatterns(items []ignorePattern) ([]ignorePattern, error) {
+	var normalized []ignorePattern
+	for _, item := range items {
+		if strings.HasPrefix(item.Pattern, regexPatternPrefix) {
+			p, err := compileIgnorePattern(item.Pattern, item.Action)
+			if err != nil {
+				return nil, err
+			}
+			normalized = append(normalized, p)
+			continue
+		}
+
+		pattern := os.ExpandEnv(item.Pattern)
+		dirOnly := strings.HasSuffix(pattern, "/")
+
+		unanchored := !strings.Contains(strings.TrimRight(pattern, "/"), "/") && !strings.HasPrefix(pattern, "~")
+		if unanchored && item.inWorkDir {
+			pattern = "**/" + pattern
+		}
+
+		if !unanchored || item.inWorkDir {
+			abs, err := pathutil.AbsPath(pattern)
+			if err != nil {
+				return nil, err
+			}
+			pattern = abs
+			if dirOnly && abs != "/" {
+				pattern += "/"
+			}
+		}
+
+		p, err := compileIgnorePattern(pattern, item.Action)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
+
+// matchElements reports whether the path elements match the pattern elements.
+func matchElements(pattern, pth []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(pth); i++ {
+				if matchElements(pattern[1:], pth[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(pth) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(pattern[0], pth[0]); err != nil || !ok {
+			return false
+		}
+		pattern, pth = pattern[1:], pth[1:]
+	}
+	return len(pth) == 0
+}
+
+// matches reports whether the pattern matches the path or any of its parent directories.
+func (p ignorePattern) matches(pth string) bool {
+	return p.matchesBelow(pth, "")
+}
+
+// matchesBelow reports whether the pattern matches the path or any of its parent directories inside the root
+// (the root itself and its parent directories are not matched), every parent directory is checked if the root is empty.
+// A directory only pattern matches the path itself only if it is a directory.
+func (p ignorePattern) matchesBelow(pth, root string) bool {
+	elements := strings.Split(filepath.Clean(pth), string(filepath.Separator))
+	min := 1
+	if root != "" {
+		min = len(strings.Split(filepath.Clean(root), string(filepath.Separator))) + 1
+	}
+
+	for i := len(elements); i >= min; i-- {
+		if p.re != nil {
+			if p.re.MatchString(strings.Join(elements[:i], string(filepath.Separator))) {
+				return true
+			}
+			continue
+		}
+		if !matchElements(p.elements, elements[:i]) {
+			continue
+		}
+		if i < len(elements) || !p.dirOnly {
+			return true
+		}
+		if info, err := os.Lstat(pth); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the action of the last ignore item matching the path,
+// ok is false if no item matches or the last matching one re-includes the path.
+func match(pth string, patterns []ignorePattern) (action ignoreAction, ok bool) {
+	return matchBelow(pth, "", patterns)
+}
+
+// matchBelow is match, only considering the path and its parent directories inside the root.
+func matchBelow(pth, root string, patterns []ignorePattern) (action ignoreAction, ok bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].matchesBelow(pth, root) {
+			if patterns[i].Action == reinclude {
+				return "", false
+			}
+			return patterns[i].Action, true
+		}
+	}
+	return "", false
+}
+
+// logIgnorePatterns prints the ignore items in the order they are evaluated.
+func logIgnorePatterns(patterns []ignorePattern) {
+	if len(patterns) == 0 {
+		return
+	}
+
+	log.Printf("Ignore items (evaluated in order, the last matching item decides):")
+	for i, p := range patterns {
+		var description string
+		switch p.Action {
+		case ignoreChanges:
+			description = "cached, changes ignored"
+		case excludeFromCache:
+			description = "not cached"
+		case reinclude:
+			description = "cached, changes checked"
+		}
+		log.Printf("%3d. %s (%s)", i+1, p.Pattern, description)
+	}
+}
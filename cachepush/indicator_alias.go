@@ -0,0 +1,184 @@
+// Indicator alias related functions.
+//
+// Instead of an indicator file, a cache path can use a built-in alias of the lockfiles of a dependency manager,
+// like `$HOME/.gradle/caches -> @gradle-lockfiles`. The alias is resolved to the matching lockfiles in the working directory,
+// skipping the dependency and build directories. A single lockfile is used as the indicator directly,
+// multiple lockfiles (like the per-module lockfiles of a Gradle project) are combined into one indicator file,
+// listing every lockfile with its content hash, so a change in any of them invalidates the cache.
+package cachepush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/doublestar/v3"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const indicatorAliasPrefix = "@"
+
+// indicatorAliases are the lockfile patterns of the aliases, relative to the working directory.
+var indicatorAliases = map[string][]string{
+	"@gradle-lockfiles":   {"**/gradle.lockfile", "**/settings-gradle.lockfile", "**/gradle/dependency-locks/*.lockfile"},
+	"@npm-lockfile":       {"**/package-lock.json", "**/npm-shrinkwrap.json"},
+	"@yarn-lockfile":      {"**/yarn.lock"},
+	"@pnpm-lockfile":      {"**/pnpm-lock.yaml"},
+	"@cocoapods-lockfile": {"**/Podfile.lock"},
+	"@carthage-lockfile":  {"**/Cartfile.resolved"},
+	"@spm-lockfile":       {"**/Package.resolved"},
+	"@bundler-lockfile":   {"**/Gemfile.lock"},
+	"@flutter-lockfile":   {"**/pubspec.lock"},
+	"@go-lockfile":        {"**/go.sum"},
+}
+
+// aliasSkippedDirs are the directories not searched for lockfiles: the installed dependencies
+// (which contain the lockfiles of the dependencies themselves) and the build outputs.
+var aliasSkippedDirs = map[string]bool{
+	".git": true, "node_modules": true, "Pods": true, "Carthage": true, ".build": true,
+	"build": true, ".gradle": true, ".dart_tool": true, "vendor": true, "DerivedData": true,
+}
+
+// combinedIndicatorDir holds the combined indicator files of the aliases matching multiple lockfiles.
+// It does not depend on the build, so the indicator path is the same in the next build.
+var combinedIndicatorDir = filepath.Join(os.TempDir(), "cache-push-indicators")
+
+// isIndicatorAlias reports whether the indicator is an alias.
+func isIndicatorAlias(indicator string) bool {
+	return strings.HasPrefix(indicator, indicatorAliasPrefix)
+}
+
+// indicatorAliasNames returns the names of the aliases, sorted.
+func indicatorAliasNames() []string {
+	names := make([]string, 0, len(indicatorAliases))
+	for name := range indicatorAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveIndicatorAlias returns the indicator file of the alias, searching the lockfiles in the root directory.
+// Multiple lockfiles are combined into an indicator file in the dir. An empty path is returned if no lockfile matches.
+func resolveIndicatorAlias(alias, root, dir string) (string, error) {
+	patterns, ok := indicatorAliases[alias]
+	if !ok {
+		return "", fmt.Errorf("unknown indicator alias (%s), available aliases: %s", alias, strings.Join(indicatorAliasNames(), ", "))
+	}
+
+	lockfiles, err := findLockfiles(root, patterns)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("%s matches %d lockfiles", alias, len(lockfiles))
+
+	switch len(lockfiles) {
+	case 0:
+		return "", nil
+	case 1:
+		return lockfiles[0], nil
+	}
+
+	pth := filepath.Join(dir, strings.TrimPrefix(alias, indicatorAliasPrefix))
+	if err := writeCombinedIndicator(pth, root, lockfiles); err != nil {
+		return "", fmt.Errorf("failed to combine the lockfiles of %s: %s", alias, err)
+	}
+	return pth, nil
+}
+
+// findLockfiles returns the files in the root directory matching any of the patterns, sorted.
+func findLockfiles(root string, patterns []string) ([]string, error) {
+	var lockfiles []string
+	if err := filepath.Walk(root, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Debugf("Failed to search lockfiles in %s: %s", pth, err)
+			return nil
+		}
+		if info.IsDir() {
+			if pth != root && aliasSkippedDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, pth)
+		if err != nil {
+			return err
+		}
+		for _, pattern := range patterns {
+			if match, err := doublestar.Match(pattern, filepath.ToSlash(rel)); err != nil {
+				return err
+			} else if match {
+				lockfiles = append(lockfiles, pth)
+				break
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return lockfiles, nil
+}
+
+// writeCombinedIndicator writes the lockfiles' paths (relative to the root) with their content hash to the indicator file.
+// The file's modification time is the one of the most recently modified lockfile, so the mod time based fingerprint methods work too.
+func writeCombinedIndicator(pth, root string, lockfiles []string) error {
+	var b strings.Builder
+	var modTime time.Time
+	for _, lockfile := range lockfiles {
+		hash, err := lockfileHash(lockfile)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(lockfile)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+
+		rel, err := filepath.Rel(root, lockfile)
+		if err != nil {
+			return err
+		}
+		b.WriteString(hash + "  " + filepath.ToSlash(rel) + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(pth, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(pth, modTime, modTime)
+}
+
+// lockfileHash returns the sha256 hash of the lockfile's content.
+func lockfileHash(pth string) (string, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close lockfile (%s): %s", pth, err)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
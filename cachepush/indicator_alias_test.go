@@ -0,0 +1,74 @@
+package cachepush
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveIndicatorAlias(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	root := filepath.Join(tmpDir, "repo")
+	dir := filepath.Join(tmpDir, "indicators")
+	createDirStruct(t, map[string]string{
+		filepath.Join(root, "package-lock.json"):                                     "npm",
+		filepath.Join(root, "node_modules", "dep", "package-lock.json"):              "dependency",
+		filepath.Join(root, "ios", "Podfile.lock"):                                   "pods",
+		filepath.Join(root, "app", "gradle.lockfile"):                                "app",
+		filepath.Join(root, "lib", "gradle", "dependency-locks", "compile.lockfile"): "lib",
+		filepath.Join(root, "build", "gradle.lockfile"):                              "build output",
+	})
+
+	t.Log("a single lockfile is the indicator")
+	{
+		pth, err := resolveIndicatorAlias("@npm-lockfile", root, dir)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(root, "package-lock.json"), pth)
+
+		pth, err = resolveIndicatorAlias("@cocoapods-lockfile", root, dir)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(root, "ios", "Podfile.lock"), pth)
+	}
+
+	t.Log("multiple lockfiles are combined")
+	{
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, os.Chtimes(filepath.Join(root, "app", "gradle.lockfile"), modTime, modTime))
+
+		pth, err := resolveIndicatorAlias("@gradle-lockfiles", root, dir)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, "gradle-lockfiles"), pth)
+
+		content, err := ioutil.ReadFile(pth)
+		require.NoError(t, err)
+		require.Equal(t, valueFingerprint([]byte("app"))+"  app/gradle.lockfile\n"+
+			valueFingerprint([]byte("lib"))+"  lib/gradle/dependency-locks/compile.lockfile\n", string(content))
+
+		info, err := os.Stat(pth)
+		require.NoError(t, err)
+		lib, err := os.Stat(filepath.Join(root, "lib", "gradle", "dependency-locks", "compile.lockfile"))
+		require.NoError(t, err)
+		require.True(t, info.ModTime().Equal(lib.ModTime()))
+	}
+
+	t.Log("no lockfile")
+	{
+		pth, err := resolveIndicatorAlias("@yarn-lockfile", root, dir)
+		require.NoError(t, err)
+		require.Equal(t, "", pth)
+	}
+
+	t.Log("unknown alias")
+	{
+		_, err := resolveIndicatorAlias("@npm-lockfiles", root, dir)
+		require.Error(t, err)
+	}
+}
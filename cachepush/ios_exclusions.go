@@ -0,0 +1,28 @@
+// iOS exclusion profile related functions.
+//
+// The DerivedData and Pods directories of an iOS project contain content which is useless in the cache:
+// the clang module cache and the index are rebuilt by Xcode anyway and change on every build,
+// the build logs and the per-user Xcode state only grow the archive. With ios_exclusion_profile enabled
+// these paths are excluded from the cache by built-in ignore items, evaluated before the user defined ones,
+// so they can be re-included with a `+` item.
+package cachepush
+
+// iosExclusionProfile are the ignore items of the iOS exclusion profile.
+var iosExclusionProfile = []ignorePattern{
+	// the clang module cache, rebuilt whenever the SDK or a module map changes
+	{Pattern: "ModuleCache.noindex/", Action: excludeFromCache},
+	// the index store and the SDK stat caches, used by the editor and recreated on demand
+	{Pattern: "Index.noindex/", Action: excludeFromCache},
+	{Pattern: "SDKStatCaches.noindex/", Action: excludeFromCache},
+	// the build, test and package resolution logs
+	{Pattern: "*.xcactivitylog", Action: excludeFromCache},
+	{Pattern: `re:/DerivedData/[^/]+/Logs$`, Action: excludeFromCache},
+	// the per-user Xcode state of the projects (like the Pods project)
+	{Pattern: "xcuserdata/", Action: excludeFromCache},
+	{Pattern: ".DS_Store", Action: excludeFromCache},
+}
+
+// withIOSExclusionProfile returns the ignore items preceded by the iOS exclusion profile's items.
+func withIOSExclusionProfile(items []ignorePattern) []ignorePattern {
+	return append(append([]ignorePattern{}, iosExclusionProfile...), items...)
+}
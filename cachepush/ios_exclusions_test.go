@@ -0,0 +1,36 @@
+package cachepush
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withIOSExclusionProfile(t *testing.T) {
+	patterns, err := normalizeIgnorePatterns(withIOSExclusionProfile(parseIgnoreList([]string{
+		"+/Users/vagrant/Library/Developer/Xcode/DerivedData/App-abc/Logs/Package",
+	})))
+	require.NoError(t, err)
+
+	derivedData := "/Users/vagrant/Library/Developer/Xcode/DerivedData"
+	got := interleave(map[string]string{
+		derivedData + "/ModuleCache.noindex/ABC/Foundation.pcm":                       "",
+		derivedData + "/App-abc/Index.noindex/DataStore/v5/units/a":                   "",
+		derivedData + "/App-abc/Logs/Build/abc.xcactivitylog":                         "",
+		derivedData + "/App-abc/Logs/Build/LogStoreManifest.plist":                    "",
+		derivedData + "/App-abc/Logs/Package/abc.xcactivitylog":                       "",
+		derivedData + "/App-abc/Logs/Package/LogStoreManifest.plist":                  "",
+		derivedData + "/App-abc/Build/Products/Debug-iphonesimulator/App.app/App":     "",
+		"/bitrise/src/ios/Pods/Pods.xcodeproj/xcuserdata/vagrant.xcuserdatad/a.plist": "",
+		"/bitrise/src/ios/Pods/Alamofire/Source/Session.swift":                        "",
+		"/bitrise/src/ios/Pods/.DS_Store":                                             "",
+	}, patterns)
+
+	// the re-included directory is cached entirely
+	require.Equal(t, map[string]string{
+		derivedData + "/App-abc/Logs/Package/abc.xcactivitylog":                   derivedData + "/App-abc/Logs/Package/abc.xcactivitylog",
+		derivedData + "/App-abc/Logs/Package/LogStoreManifest.plist":              derivedData + "/App-abc/Logs/Package/LogStoreManifest.plist",
+		derivedData + "/App-abc/Build/Products/Debug-iphonesimulator/App.app/App": derivedData + "/App-abc/Build/Products/Debug-iphonesimulator/App.app/App",
+		"/bitrise/src/ios/Pods/Alamofire/Source/Session.swift":                    "/bitrise/src/ios/Pods/Alamofire/Source/Session.swift",
+	}, got)
+}
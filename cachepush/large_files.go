@@ -0,0 +1,61 @@
+// Large file filter related functions.
+//
+// Files larger than the configured size (for example emulator images or .ipa artifacts which end up in a cached directory)
+// are left out of the cache while walking the cache paths.
+package cachepush
+
+import (
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// largeFiles collects the files skipped for exceeding the size limit.
+type largeFiles struct {
+	limit      int64
+	sizeByPath map[string]int64
+}
+
+// newLargeFiles returns the large file filter, nil (no filtering) if the limit is 0.
+func newLargeFiles(limit int64) *largeFiles {
+	if limit <= 0 {
+		return nil
+	}
+	return &largeFiles{limit: limit, sizeByPath: map[string]int64{}}
+}
+
+// skip reports whether the file exceeds the size limit, and records it if it does.
+func (l *largeFiles) skip(pth string, size int64) bool {
+	if l == nil || size <= l.limit {
+		return false
+	}
+	l.sizeByPath[pth] = size
+	return true
+}
+
+// skipped returns the skipped files, sorted.
+func (l *largeFiles) skipped() []string {
+	if l == nil {
+		return nil
+	}
+
+	paths := make([]string, 0, len(l.sizeByPath))
+	for pth := range l.sizeByPath {
+		paths = append(paths, pth)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// logSkipped warns about the skipped files.
+func (l *largeFiles) logSkipped() {
+	skipped := l.skipped()
+	if len(skipped) == 0 {
+		return
+	}
+
+	log.Warnf("%d files larger than %s are left out of the cache:", len(skipped), formatSize(l.limit))
+	for _, pth := range skipped {
+		log.Warnf("- %s (%s)", pth, formatSize(l.sizeByPath[pth]))
+	}
+}
@@ -0,0 +1,45 @@
+package cachepush
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_largeFiles_skip(t *testing.T) {
+	var disabled *largeFiles
+	require.Nil(t, newLargeFiles(0))
+	require.False(t, disabled.skip("/cache/huge", 1<<40))
+	require.Nil(t, disabled.skipped())
+
+	large := newLargeFiles(10)
+	require.False(t, large.skip("/cache/small", 10))
+	require.True(t, large.skip("/cache/b", 11))
+	require.True(t, large.skip("/cache/a", 100))
+	require.Equal(t, []string{"/cache/a", "/cache/b"}, large.skipped())
+}
+
+func Test_expandPath_largeFiles(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	small := filepath.Join(tmpDir, "small")
+	huge := filepath.Join(tmpDir, "dir", "emulator.img")
+	createDirStruct(t, map[string]string{
+		small: "small",
+		huge:  strings.Repeat("x", 1024),
+	})
+
+	large := newLargeFiles(100)
+	regularFiles, _, dirPaths, err := expandPath(context.Background(), tmpDir, nil, large, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{small}, regularFiles)
+	require.Equal(t, []string{tmpDir, filepath.Dir(huge)}, dirPaths)
+	require.Equal(t, []string{huge}, large.skipped())
+}
@@ -0,0 +1,225 @@
+// Local directory cache backend related functions.
+//
+// A file:// destination ending with a slash is a local directory backend, for self-hosted runners keeping their caches
+// on a local disk without any cache API. Every push writes a new archive into the cache key's directory,
+// named by the build (or the time of the push), and points the `latest` symlink to it once it is complete:
+//
+//	<dir>/[<group>/]<key>/<build>.tar
+//	<dir>/[<group>/]<key>/<build>.tar.sha256
+//	<dir>/[<group>/]<key>/latest.tar -> <build>.tar
+//
+// The archive is written to a temporary file and renamed once complete, so readers never see a partial archive.
+// Only the most recent archives are kept according to the retention. The layers of a layered cache depend on each other,
+// they are stored under their ID in the key's layers directory and are never pruned.
+package cachepush
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+const (
+	localArchiveExt     = ".tar"
+	localLatestName     = "latest"
+	localLayersDirName  = "layers"
+	localDefaultKeyName = "cache"
+)
+
+// localArchiveStamp names the archives of the push if the build slug is unknown.
+var localArchiveStamp = time.Now().UTC().Format("20060102T150405Z")
+
+// unsafeKeyCharacters matches the characters not allowed in the directory name of a cache key.
+var unsafeKeyCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// isLocalDirectory reports whether the destination is a local directory backend.
+func isLocalDirectory(url string) bool {
+	return strings.HasPrefix(url, "file://") && strings.HasSuffix(url, "/")
+}
+
+// localKeyDir returns the directory of the cache key's archives in the local directory destination.
+func localKeyDir(url string, info model.ArchiveInfo) string {
+	name := unsafeKeyCharacters.ReplaceAllString(info.CacheKey, "_")
+	if name == "" || strings.Trim(name, ".") == "" {
+		name = localDefaultKeyName
+	}
+
+	dir := strings.TrimPrefix(url, "file://")
+	if info.Group != "" {
+		dir = filepath.Join(dir, unsafeKeyCharacters.ReplaceAllString(info.Group, "_"))
+	}
+	return filepath.Join(dir, name)
+}
+
+// localArchivePath returns the path of the archive (or layer) in the key's directory.
+func localArchivePath(keyDir, buildSlug, layerID string) string {
+	if layerID != "" && layerID != metadataSidecarID("") {
+		return filepath.Join(keyDir, localLayersDirName, layerID+localArchiveExt)
+	}
+
+	id := buildSlug
+	if id == "" {
+		id = localArchiveStamp
+	}
+	return filepath.Join(keyDir, withLayerID(id+localArchiveExt, layerID))
+}
+
+// writeLocalArchive writes the archive into the local directory destination using the given function,
+// which has to close the writer once the archive is written. The archive is renamed to its final path once complete,
+// then the latest symlink is updated and the archives over the retention are removed.
+// retention is the number of archives kept per cache key, 0 keeps every archive.
+func writeLocalArchive(url, buildSlug, layerID string, info model.ArchiveInfo, retention int, write func(io.WriteCloser) error) error {
+	keyDir := localKeyDir(url, info)
+	dst := localArchivePath(keyDir, buildSlug, layerID)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		if cerr := tmp.Close(); cerr != nil {
+			log.Debugf("Failed to close archive file (%s): %s", tmp.Name(), cerr)
+		}
+		if rerr := os.Remove(tmp.Name()); rerr != nil {
+			log.Debugf("Failed to remove partial archive file (%s): %s", tmp.Name(), rerr)
+		}
+		return err
+	}
+
+	// the checksum is in place before the archive appears
+	if info.Checksum != "" {
+		if err := writeChecksumFile(dst, info.Checksum); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	log.Printf("Archive written to: %s", dst)
+
+	if filepath.Dir(dst) != keyDir {
+		return nil
+	}
+	if err := updateLocalLatest(dst, info.Checksum != ""); err != nil {
+		log.Warnf("Failed to update the latest archive link: %s", err)
+	}
+	if layerID == "" && retention > 0 {
+		if err := pruneLocalArchives(keyDir, retention); err != nil {
+			log.Warnf("Failed to remove old archives: %s", err)
+		}
+	}
+	return nil
+}
+
+// copyLocalArchive copies the archive file at pth into the local directory destination.
+func copyLocalArchive(pth, url, buildSlug, layerID string, info model.ArchiveInfo, retention int) error {
+	return writeLocalArchive(url, buildSlug, layerID, info, retention, func(w io.WriteCloser) error {
+		src, err := os.Open(pth)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := src.Close(); err != nil {
+				log.Debugf("Failed to close archive file (%s): %s", pth, err)
+			}
+		}()
+
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// updateLocalLatest atomically points the latest symlink (and the one of the checksum file) next to the archive to the archive.
+func updateLocalLatest(dst string, withChecksum bool) error {
+	dir := filepath.Dir(dst)
+	name := filepath.Base(dst)
+	// the latest link of a sidecar keeps its suffix: <build>.metadata.tar -> latest.metadata.tar
+	link := localLatestName + name[strings.Index(name, "."):]
+
+	links := map[string]string{link: name}
+	if withChecksum {
+		links[link+checksumFileExt] = name + checksumFileExt
+	}
+	for link, target := range links {
+		tmp := filepath.Join(dir, "."+link+".tmp")
+		if err := os.RemoveAll(tmp); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, link)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneLocalArchives removes the files of every archive in the key's directory except the most recent ones.
+// The files of an archive (the archive, its checksum and sidecar) share the name before the first dot.
+func pruneLocalArchives(keyDir string, retention int) error {
+	infos, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]string{}
+	modTimes := map[string]time.Time{}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id := strings.SplitN(name, ".", 2)[0]
+		if id == localLatestName {
+			continue
+		}
+		files[id] = append(files[id], name)
+		if info.ModTime().After(modTimes[id]) {
+			modTimes[id] = info.ModTime()
+		}
+	}
+
+	ids := make([]string, 0, len(files))
+	for id := range files {
+		ids = append(ids, id)
+	}
+	// the most recent first
+	sort.Slice(ids, func(i, j int) bool {
+		if !modTimes[ids[i]].Equal(modTimes[ids[j]]) {
+			return modTimes[ids[i]].After(modTimes[ids[j]])
+		}
+		return ids[i] > ids[j]
+	})
+	if len(ids) <= retention {
+		return nil
+	}
+
+	var errs []string
+	for _, id := range ids[retention:] {
+		for _, name := range files[id] {
+			if err := os.Remove(filepath.Join(keyDir, name)); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		log.Debugf("Removed old archive: %s", id)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
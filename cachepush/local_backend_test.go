@@ -0,0 +1,102 @@
+package cachepush
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_localKeyDir(t *testing.T) {
+	require.True(t, isLocalDirectory("file:///cache/"))
+	require.False(t, isLocalDirectory("file:///cache/cache.tar"))
+	require.False(t, isLocalDirectory("https://cache.bitrise.io/"))
+
+	require.Equal(t, "/cache/cache", localKeyDir("file:///cache/", model.ArchiveInfo{}))
+	require.Equal(t, "/cache/pods/ios-Podfile.lock_1", localKeyDir("file:///cache/", model.ArchiveInfo{CacheKey: "ios-Podfile.lock/1", Group: "pods"}))
+	require.Equal(t, "/cache/cache", localKeyDir("file:///cache/", model.ArchiveInfo{CacheKey: ".."}))
+
+	require.Equal(t, "/cache/key/build.tar", localArchivePath("/cache/key", "build", ""))
+	require.Equal(t, "/cache/key/build.metadata.tar", localArchivePath("/cache/key", "build", metadataSidecarID("")))
+	require.Equal(t, "/cache/key/"+localArchiveStamp+".tar", localArchivePath("/cache/key", "", ""))
+	require.Equal(t, "/cache/key/layers/layer.tar", localArchivePath("/cache/key", "build", "layer"))
+	require.Equal(t, "/cache/key/layers/layer.metadata.tar", localArchivePath("/cache/key", "build", metadataSidecarID("layer")))
+}
+
+func Test_copyLocalArchive(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	archive := filepath.Join(tmpDir, "cache-archive.tar")
+	createDirStruct(t, map[string]string{archive: "archive"})
+	url := "file://" + filepath.Join(tmpDir, "local") + "/"
+	keyDir := filepath.Join(tmpDir, "local", "key")
+	info := model.ArchiveInfo{CacheKey: "key", Checksum: "abc"}
+
+	for i, build := range []string{"build1", "build2", "build3"} {
+		require.NoError(t, copyLocalArchive(archive, url, build, "", info, 2))
+		require.NoError(t, copyLocalArchive(archive, url, build, metadataSidecarID(""), info, 2))
+		// the retention orders the archives by their modification time
+		modTime := time.Now().Add(time.Duration(i-3) * time.Minute)
+		for _, name := range []string{build + ".tar", build + ".tar.sha256", build + ".metadata.tar", build + ".metadata.tar.sha256"} {
+			require.NoError(t, os.Chtimes(filepath.Join(keyDir, name), modTime, modTime))
+		}
+	}
+
+	// the oldest archive is removed by the last push's retention, the sidecars follow their archives
+	infos, err := ioutil.ReadDir(keyDir)
+	require.NoError(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	require.Equal(t, []string{
+		"build2.metadata.tar", "build2.metadata.tar.sha256", "build2.tar", "build2.tar.sha256",
+		"build3.metadata.tar", "build3.metadata.tar.sha256", "build3.tar", "build3.tar.sha256",
+		"latest.metadata.tar", "latest.metadata.tar.sha256", "latest.tar", "latest.tar.sha256",
+	}, names)
+
+	target, err := os.Readlink(filepath.Join(keyDir, "latest.tar"))
+	require.NoError(t, err)
+	require.Equal(t, "build3.tar", target)
+	content, err := ioutil.ReadFile(filepath.Join(keyDir, "latest.tar"))
+	require.NoError(t, err)
+	require.Equal(t, "archive", string(content))
+	checksum, err := ioutil.ReadFile(filepath.Join(keyDir, "latest.tar.sha256"))
+	require.NoError(t, err)
+	require.Equal(t, "abc  build3.tar\n", string(checksum))
+
+	// layers are kept under their ID, the latest link is not updated
+	require.NoError(t, copyLocalArchive(archive, url, "build4", "layer", info, 2))
+	require.FileExists(t, filepath.Join(keyDir, "layers", "layer.tar"))
+	target, err = os.Readlink(filepath.Join(keyDir, "latest.tar"))
+	require.NoError(t, err)
+	require.Equal(t, "build3.tar", target)
+}
+
+func Test_writeLocalArchive_failed(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	url := "file://" + tmpDir + "/"
+	err = writeLocalArchive(url, "build", "", model.ArchiveInfo{}, 0, func(w io.WriteCloser) error {
+		_, err := w.Write([]byte("partial"))
+		require.NoError(t, err)
+		return errors.New("interrupted")
+	})
+	require.EqualError(t, err, "interrupted")
+
+	// no partial archive is left behind
+	infos, err := ioutil.ReadDir(filepath.Join(tmpDir, "cache"))
+	require.NoError(t, err)
+	require.Empty(t, infos)
+}
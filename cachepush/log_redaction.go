@@ -0,0 +1,127 @@
+// Log redaction related functions.
+//
+// The step logs are visible to everyone with access to the build, but the messages can contain sensitive values:
+// presigned upload urls (in the errors of the http client too), the credentials of the cache API and of the proxy,
+// and the values of secret environment variables expanded into the inputs. Every message of the logger passes through
+// a redacting writer, which removes the credentials and the query of the urls, and replaces the known secret values.
+package cachepush
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// redactedValue replaces the secret values in the logs.
+const redactedValue = "[REDACTED]"
+
+// minSecretLength is the length of the shortest redacted value, shorter values would redact unrelated parts of the logs.
+const minSecretLength = 6
+
+// urlInText matches the urls in a log message.
+var urlInText = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// secretEnvName matches the names of the environment variables holding secrets.
+var secretEnvName = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSWD|PASSPHRASE|API_KEY|APIKEY|PRIVATE_KEY|ACCESS_KEY|CREDENTIAL)`)
+
+// redactingWriter writes the log messages with the sensitive values redacted.
+type redactingWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	values []string
+}
+
+// stepLog is the writer of the step's logs, the secrets of every push are added to it, see SetLogOutput.
+var stepLog = newRedactingWriter(os.Stdout, nil)
+
+// SetLogOutput sets the output of the step's logs, the messages are written with the secrets of the pushes redacted.
+func SetLogOutput(w io.Writer) {
+	stepLog.mu.Lock()
+	stepLog.w = w
+	stepLog.mu.Unlock()
+	log.SetOutWriter(stepLog)
+}
+
+// newRedactingWriter returns the redacting writer replacing the secret values.
+func newRedactingWriter(w io.Writer, secrets []string) *redactingWriter {
+	r := &redactingWriter{w: w}
+	r.addSecrets(secrets)
+	return r
+}
+
+// addSecrets adds the secret values to the redacted ones, the longest values are replaced first.
+func (r *redactingWriter) addSecrets(secrets []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, value := range r.values {
+		seen[value] = true
+	}
+	for _, secret := range secrets {
+		// multiline secrets (like a private key) are redacted line by line
+		for _, value := range strings.Split(secret, "\n") {
+			value = strings.TrimSpace(value)
+			if len(value) >= minSecretLength && !seen[value] {
+				seen[value] = true
+				r.values = append(r.values, value)
+			}
+		}
+	}
+	sort.SliceStable(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+// Write writes the message redacted, it reports the length of the original message.
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := io.WriteString(r.w, redactText(string(p), r.values)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactText returns the text with the urls redacted and the secret values replaced.
+func redactText(text string, values []string) string {
+	for _, value := range values {
+		text = strings.ReplaceAll(text, value, redactedValue)
+	}
+	return urlInText.ReplaceAllStringFunc(text, func(rawURL string) string {
+		// only the urls with credentials or a query are changed, the rest is kept as written
+		if u, err := url.Parse(rawURL); err != nil || (u.User == nil && u.RawQuery == "") {
+			return rawURL
+		}
+		return redactURL(rawURL)
+	})
+}
+
+// secretEnvValues returns the values of the environment variables with secret-like names.
+func secretEnvValues(environ []string) []string {
+	var values []string
+	for _, env := range environ {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) == 2 && secretEnvName.MatchString(parts[0]) {
+			values = append(values, parts[1])
+		}
+	}
+	return values
+}
+
+// logSecrets returns the values redacted from the logs: the secret inputs (the values of the cache API headers)
+// and the secret environment variables.
+func logSecrets(configs Config) []string {
+	secrets := []string{string(configs.CacheAPIToken), string(configs.EncryptionKey), string(configs.SSHPrivateKey)}
+	for _, line := range strings.Split(string(configs.CacheAPIHeaders), "\n") {
+		if split := strings.SplitN(line, ":", 2); len(split) == 2 {
+			secrets = append(secrets, split[1])
+		}
+	}
+	return append(secrets, secretEnvValues(os.Environ())...)
+}
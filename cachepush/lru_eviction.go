@@ -0,0 +1,82 @@
+// LRU eviction related functions.
+//
+// Without a bound the cache grows forever, as files are added by new dependencies but never removed.
+// With a target size the least recently used files are evicted until the cache content fits into it,
+// so the cache converges to a bounded size. The content size is used as the projected archive size
+// (an uncompressed archive is about the same size, a compressed one is smaller).
+package cachepush
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// lruFile is a regular file to cache with the time it was last used.
+type lruFile struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// evictLeastRecentlyUsed removes the least recently used regular files until the content size is under targetSize.
+// The files new to the cache count as used now, the files used at the same time are evicted by path order.
+// The protected files are never evicted, but their size counts.
+func evictLeastRecentlyUsed(pathToIndicatorPath map[string]string, prev model.CacheMeta, protected []ignorePattern, lastUsedTime accessTimeProvider, targetSize int64, now time.Time) (map[string]string, []string, error) {
+	var files []lruFile
+	var size int64
+	for pth := range pathToIndicatorPath {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		size += info.Size()
+		if isProtected(pth, protected) {
+			continue
+		}
+
+		lastUsed := now
+		if entry, ok := prev[pth]; ok && entry.ConsecutiveFailures == 0 {
+			lastUsed = lastUsedAt(entry, info, lastUsedTime)
+		}
+		files = append(files, lruFile{path: pth, size: info.Size(), lastUsed: lastUsed})
+	}
+
+	if size <= targetSize {
+		return pathToIndicatorPath, nil, nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].lastUsed.Equal(files[j].lastUsed) {
+			return files[i].lastUsed.Before(files[j].lastUsed)
+		}
+		return files[i].path < files[j].path
+	})
+
+	evictedSet := map[string]bool{}
+	var evicted []string
+	for _, file := range files {
+		if size <= targetSize {
+			break
+		}
+		evictedSet[file.path] = true
+		evicted = append(evicted, file.path)
+		size -= file.size
+	}
+
+	kept := map[string]string{}
+	for pth, indicator := range pathToIndicatorPath {
+		if !evictedSet[pth] {
+			kept[pth] = indicator
+		}
+	}
+
+	sort.Strings(evicted)
+	return kept, evicted, nil
+}
@@ -0,0 +1,67 @@
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_evictLeastRecentlyUsed(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	oldest := filepath.Join(tmpDir, "oldest")
+	old := filepath.Join(tmpDir, "old")
+	recent := filepath.Join(tmpDir, "recent")
+	added := filepath.Join(tmpDir, "added")
+	createDirStruct(t, map[string]string{oldest: "1234", old: "1234", recent: "1234", added: "1234"})
+
+	now := time.Unix(100*24*60*60, 0)
+	written := now.Add(-30 * 24 * time.Hour)
+	for _, pth := range []string{oldest, old, recent, added} {
+		require.NoError(t, os.Chtimes(pth, written, written))
+	}
+
+	prev := model.CacheMeta{
+		oldest: {FirstSeenAt: written.Unix()},
+		old:    {FirstSeenAt: written.Unix(), AccessTime: now.Add(-20 * 24 * time.Hour).Unix()},
+		recent: {FirstSeenAt: written.Unix(), AccessTime: now.Add(-time.Hour).Unix()},
+	}
+	pathToIndicatorPath := map[string]string{oldest: "", old: "", recent: "", added: "", tmpDir: "-"}
+
+	t.Run("fits", func(t *testing.T) {
+		kept, evicted, err := evictLeastRecentlyUsed(pathToIndicatorPath, prev, nil, accessTimeOf, 16, now)
+		require.NoError(t, err)
+		require.Nil(t, evicted)
+		require.Equal(t, pathToIndicatorPath, kept)
+	})
+
+	t.Run("least recently used first", func(t *testing.T) {
+		kept, evicted, err := evictLeastRecentlyUsed(pathToIndicatorPath, prev, nil, accessTimeOf, 9, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{old, oldest}, evicted)
+		require.Equal(t, map[string]string{recent: "", added: "", tmpDir: "-"}, kept)
+	})
+
+	t.Run("new files last", func(t *testing.T) {
+		kept, evicted, err := evictLeastRecentlyUsed(pathToIndicatorPath, prev, nil, accessTimeOf, 4, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{old, oldest, recent}, evicted)
+		require.Equal(t, map[string]string{added: "", tmpDir: "-"}, kept)
+	})
+
+	t.Run("protected", func(t *testing.T) {
+		protected, err := parseNeverEvictList([]string{oldest})
+		require.NoError(t, err)
+
+		kept, evicted, err := evictLeastRecentlyUsed(pathToIndicatorPath, prev, protected, accessTimeOf, 9, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{old, recent}, evicted)
+		require.Equal(t, map[string]string{oldest: "", added: "", tmpDir: "-"}, kept)
+	})
+}
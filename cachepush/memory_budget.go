@@ -0,0 +1,131 @@
+// Memory budget related functions.
+//
+// The max_memory_mb budget sizes the buffers which scale with the archiving throughput: the file copy buffer,
+// the buffered writer in front of the archive, and the archiver's prefetch queue (see newArchiverSettings);
+// it bounds the number of hash workers and of the caches compressed at the same time (see workers),
+// it also tunes the garbage collector while the push runs and returns the freed memory to the OS between the phases.
+// The path maps and the cache descriptor are not tuned, they grow with the number of cached files.
+package cachepush
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	minCopyBufferSize     = 32 * 1024
+	maxCopyBufferSize     = 4 * 1024 * 1024
+	defaultCopyBufferSize = 1024 * 1024
+
+	// hashWorkerMemory is the memory used by a hash worker: its read buffer and the state of the hash.
+	hashWorkerMemory = 64 * 1024
+	// compressorMemory is the memory used by a gzip compressor: its window, hash chains and output buffers.
+	compressorMemory = 1200 * 1024
+)
+
+// memoryBudget derives the step's internal buffer sizes from the max_memory_mb input.
+// A zero (or negative) limit means the step is not limited and uses its defaults.
+type memoryBudget struct {
+	maxMB int
+}
+
+// limited reports whether a memory limit is set.
+func (b memoryBudget) limited() bool {
+	return b.maxMB > 0
+}
+
+// bytes returns the limit in bytes.
+func (b memoryBudget) bytes() int {
+	return b.maxMB * 1024 * 1024
+}
+
+// copyBufferSize returns the size of the buffer used to copy a single file into the archive
+// and the size of the buffered writer in front of the archive file.
+// The buffers get at most 1/64 of the budget, the rest is left for the path maps and the descriptors.
+func (b memoryBudget) copyBufferSize() int {
+	if !b.limited() {
+		return defaultCopyBufferSize
+	}
+
+	size := b.bytes() / 64
+	if size < minCopyBufferSize {
+		return minCopyBufferSize
+	}
+	if size > maxCopyBufferSize {
+		return maxCopyBufferSize
+	}
+	return size
+}
+
+// workers returns the number of workers (at most n, at least 1) whose memory fits into 1/8 of the budget,
+// if each of them uses perWorker bytes. An unlimited budget does not bound the workers.
+func (b memoryBudget) workers(n, perWorker int) int {
+	if b.limited() {
+		if fit := b.bytes() / 8 / perWorker; n > fit {
+			n = fit
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// gcPercent returns the garbage collection target percentage fitting to the budget.
+// The tighter the budget the more often the garbage collector runs.
+func (b memoryBudget) gcPercent() int {
+	switch {
+	case !b.limited():
+		return 100
+	case b.maxMB < 512:
+		return 25
+	case b.maxMB < 2048:
+		return 50
+	default:
+		return 100
+	}
+}
+
+// gcTuning tracks the pushes tuning the garbage collector, the original setting is restored after the last one finished.
+var gcTuning struct {
+	mu       sync.Mutex
+	running  int
+	original int
+}
+
+// apply configures the runtime according to the budget, an unlimited budget keeps the runtime's settings.
+// The returned function restores the settings of the process once every push applying a budget finished.
+func (b memoryBudget) apply() (restore func()) {
+	if !b.limited() {
+		return func() {}
+	}
+
+	gcTuning.mu.Lock()
+	defer gcTuning.mu.Unlock()
+	prev := debug.SetGCPercent(b.gcPercent())
+	if gcTuning.running == 0 {
+		gcTuning.original = prev
+	}
+	gcTuning.running++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			gcTuning.mu.Lock()
+			defer gcTuning.mu.Unlock()
+			gcTuning.running--
+			if gcTuning.running == 0 {
+				debug.SetGCPercent(gcTuning.original)
+			}
+		})
+	}
+}
+
+// release runs a garbage collection and returns the freed memory to the OS, if the budget is limited.
+// It is called when the large intermediate maps (like the previous cache descriptor) are dropped,
+// so the archiving phase starts from a low memory usage.
+func (b memoryBudget) release() {
+	if b.limited() {
+		debug.FreeOSMemory()
+	}
+}
@@ -0,0 +1,82 @@
+package cachepush
+
+import "testing"
+
+func Test_memoryBudget_copyBufferSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget memoryBudget
+		want   int
+	}{
+		{
+			name:   "no limit",
+			budget: memoryBudget{},
+			want:   defaultCopyBufferSize,
+		},
+		{
+			name:   "small limit",
+			budget: memoryBudget{maxMB: 1},
+			want:   minCopyBufferSize,
+		},
+		{
+			name:   "medium limit",
+			budget: memoryBudget{maxMB: 128},
+			want:   2 * 1024 * 1024,
+		},
+		{
+			name:   "large limit",
+			budget: memoryBudget{maxMB: 16 * 1024},
+			want:   maxCopyBufferSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.budget.copyBufferSize(); got != tt.want {
+				t.Errorf("memoryBudget.copyBufferSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_memoryBudget_gcPercent(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget memoryBudget
+		want   int
+	}{
+		{name: "no limit", budget: memoryBudget{}, want: 100},
+		{name: "tight limit", budget: memoryBudget{maxMB: 256}, want: 25},
+		{name: "medium limit", budget: memoryBudget{maxMB: 1024}, want: 50},
+		{name: "large limit", budget: memoryBudget{maxMB: 4096}, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.budget.gcPercent(); got != tt.want {
+				t.Errorf("memoryBudget.gcPercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_memoryBudget_workers(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    memoryBudget
+		n         int
+		perWorker int
+		want      int
+	}{
+		{name: "no limit", budget: memoryBudget{}, n: 64, perWorker: compressorMemory, want: 64},
+		{name: "fits", budget: memoryBudget{maxMB: 1024}, n: 8, perWorker: hashWorkerMemory, want: 8},
+		{name: "bounded hash workers", budget: memoryBudget{maxMB: 1}, n: 8, perWorker: hashWorkerMemory, want: 2},
+		{name: "bounded compressors", budget: memoryBudget{maxMB: 32}, n: 8, perWorker: compressorMemory, want: 3},
+		{name: "at least one", budget: memoryBudget{maxMB: 1}, n: 4, perWorker: compressorMemory, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.budget.workers(tt.n, tt.perWorker); got != tt.want {
+				t.Errorf("memoryBudget.workers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Archive metadata integrity related functions.
+//
+// The sha256 checksum of every metadata file written into the archive (stack data, cache descriptor, ...)
+// is stored as the last archive entry, so the metadata can be verified after a round trip
+// before the stack and version checks rely on it.
+// The cached files are verified with their CRC-32C checksums, stored in the file checksums metadata file.
+package cachepush
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+)
+
+// verifyArchiveMetadata reads the archive at the given path and checks that its metadata files
+// match the given checksums, and that the checksums file stored at checksumsPth contains the same checksums.
+// The archived regular files are checked against the fileChecksums.
+// The key decrypts the archive if it is encrypted.
+func verifyArchiveMetadata(pth, checksumsPth string, checksums, fileChecksums map[string]string, key []byte) error {
+	tarReader, closeArchive, err := openArchiveReader(pth, key)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+
+	found := map[string]string{}
+	var corruptedFiles []string
+	var stored []byte
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		if checksum, ok := fileChecksums[header.Name]; ok && header.Typeflag == tar.TypeReg {
+			h := cachecommon.NewFileHash()
+			if _, err := io.Copy(h, tarReader); err != nil {
+				return fmt.Errorf("failed to read %s from archive: %w", header.Name, err)
+			}
+			if cachecommon.FileChecksum(h) != checksum {
+				corruptedFiles = append(corruptedFiles, header.Name)
+			}
+			continue
+		}
+
+		if _, ok := checksums[header.Name]; !ok && header.Name != checksumsPth {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return fmt.Errorf("failed to read %s from archive: %w", header.Name, err)
+		}
+		if header.Name == checksumsPth {
+			stored = data
+		} else {
+			found[header.Name] = cachecommon.MetadataChecksum(data)
+		}
+	}
+
+	if len(corruptedFiles) > 0 {
+		return fmt.Errorf("file checksum mismatch: %s", strings.Join(corruptedFiles, ", "))
+	}
+
+	var mismatching []string
+	for name, checksum := range checksums {
+		if found[name] != checksum {
+			mismatching = append(mismatching, name)
+		}
+	}
+	if len(mismatching) > 0 {
+		sort.Strings(mismatching)
+		return fmt.Errorf("metadata checksum mismatch: %s", strings.Join(mismatching, ", "))
+	}
+
+	expected, err := cachecommon.EncodeMetadataChecksums(checksums)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored, expected) {
+		return fmt.Errorf("metadata checksums file (%s) is missing or corrupted", checksumsPth)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cachepush
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_verifyArchiveMetadata(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	fileToArchive := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{fileToArchive: "content"})
+
+	for _, compress := range []bool{false, true} {
+		pth := filepath.Join(tmpDir, "cache.tar")
+		archive, err := NewArchive(pth, compress, memoryBudget{})
+		require.NoError(t, err)
+
+		require.NoError(t, writeArchive(context.Background(), archive, archiveContent{
+			stackData:           []byte("stack"),
+			pathToIndicatorPath: map[string]string{fileToArchive: ""},
+			descriptor:          map[string]string{fileToArchive: "-"},
+		}))
+
+		require.Len(t, archive.checksums, 3)
+		require.Contains(t, archive.checksums, workPath(fileChecksumsFileName))
+		require.Equal(t, map[string]string{fileToArchive: "61af7533"}, archive.fileChecksums)
+		require.NoError(t, verifyArchiveMetadata(pth, workPath(metadataChecksumsFileName), archive.checksums, archive.fileChecksums, nil))
+
+		corrupted := map[string]string{}
+		for name, checksum := range archive.checksums {
+			corrupted[name] = checksum
+		}
+		corrupted[workPath(stackVersionsFileName)] = cachecommon.MetadataChecksum([]byte("other stack"))
+		require.EqualError(t, verifyArchiveMetadata(pth, workPath(metadataChecksumsFileName), corrupted, archive.fileChecksums, nil), "metadata checksum mismatch: "+workPath(stackVersionsFileName))
+
+		corruptedFiles := map[string]string{fileToArchive: "00000000"}
+		require.EqualError(t, verifyArchiveMetadata(pth, workPath(metadataChecksumsFileName), archive.checksums, corruptedFiles, nil), "file checksum mismatch: "+fileToArchive)
+
+		require.NoError(t, os.Remove(pth))
+	}
+}
@@ -0,0 +1,116 @@
+// Metadata sidecar related functions.
+//
+// The metadata sidecar is a small archive uploaded next to the cache archive, holding the archive info,
+// the cache descriptor and the cache meta (with the same paths as in the cache archive).
+// cache-pull and dashboards can inspect the fingerprints and the cached paths without downloading the whole cache.
+// The sidecar is uploaded as the `metadata` layer of the cache (next to the layer in layered mode).
+// As it is uploaded after the archive, it also holds the phase stats including the archive upload's duration.
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+const (
+	metadataSidecarFileName = "cache-metadata.tar.gz"
+	metadataSidecarLayerID  = "metadata"
+)
+
+// metadataSidecarID returns the layer ID of the metadata sidecar of the given layer.
+func metadataSidecarID(layerID string) string {
+	if layerID == "" {
+		return metadataSidecarLayerID
+	}
+	return layerID + "." + metadataSidecarLayerID
+}
+
+// sidecarMetadataPaths returns the metadata files of the cache archive which are copied into the sidecar.
+func sidecarMetadataPaths(content archiveContent) map[string]bool {
+	return map[string]bool{content.metadataPath(cacheMetaFileName): true}
+}
+
+// createMetadataSidecar writes the metadata sidecar of the archive content to pth, as a gzip compressed tar.
+// The extra metadata files are only written into the sidecar, they are generated after the archive upload.
+// The sidecar is encrypted with the same key as the archive.
+func createMetadataSidecar(pth string, budget memoryBudget, content archiveContent, extra []archiveMetadata) (err error) {
+	file, err := os.Create(pth)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata sidecar: %w", err)
+	}
+
+	output, err := encryptOutput(file, content.encryptionKey)
+	if err != nil {
+		if cerr := file.Close(); cerr != nil {
+			log.Debugf("Failed to close metadata sidecar (%s): %s", pth, cerr)
+		}
+		return fmt.Errorf("failed to create metadata sidecar encryption: %w", err)
+	}
+
+	sidecar, err := newArchive(output, true, budget)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata sidecar: %w", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cerr := sidecar.output.Close(); cerr != nil {
+			log.Debugf("Failed to close metadata sidecar (%s): %s", pth, cerr)
+		}
+	}()
+
+	if err := sidecar.writeData(content.stackData, content.metadataPath(stackVersionsFileName)); err != nil {
+		return fmt.Errorf("failed to write cache info to metadata sidecar: %w", err)
+	}
+
+	included := sidecarMetadataPaths(content)
+	for _, metadata := range content.metadata {
+		if !included[metadata.path] {
+			continue
+		}
+		if err := sidecar.writeData(metadata.data, metadata.path); err != nil {
+			return fmt.Errorf("failed to write %s to metadata sidecar: %w", metadata.path, err)
+		}
+	}
+	for _, metadata := range extra {
+		if err := sidecar.writeData(metadata.data, metadata.path); err != nil {
+			return fmt.Errorf("failed to write %s to metadata sidecar: %w", metadata.path, err)
+		}
+	}
+
+	if err := sidecar.WriteHeader(content.descriptor, content.metadataPath(cacheInfoFileName)); err != nil {
+		return fmt.Errorf("failed to write cache descriptor to metadata sidecar: %w", err)
+	}
+	if err := sidecar.WriteChecksums(content.metadataPath(metadataChecksumsFileName)); err != nil {
+		return fmt.Errorf("failed to write metadata checksums to metadata sidecar: %w", err)
+	}
+	return sidecar.Close()
+}
+
+// uploadMetadataSidecar creates and uploads the metadata sidecar of the archive content next to the archive (or layer).
+// The sidecar is uploaded with the archive info of the archive, with the sidecar's own checksum and digest.
+func uploadMetadataSidecar(ctx context.Context, endpoints []string, buildSlug, layerID string, info model.ArchiveInfo, budget memoryBudget, content archiveContent, extra []archiveMetadata, retry uploadRetry) error {
+	pth := content.metadataPath(metadataSidecarFileName)
+	if err := createMetadataSidecar(pth, budget, content, extra); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(pth); err != nil {
+			log.Debugf("Failed to remove metadata sidecar (%s): %s", pth, err)
+		}
+	}()
+
+	checksum, contentMD5, err := fileDigests(pth)
+	if err != nil {
+		return fmt.Errorf("failed to calculate metadata sidecar checksum: %w", err)
+	}
+	info.Checksum, info.ContentMD5 = checksum, contentMD5
+	info.Chunked = false
+
+	return uploadArchiveWithFailover(ctx, pth, endpoints, buildSlug, metadataSidecarID(layerID), info, retry)
+}
@@ -0,0 +1,111 @@
+package cachepush
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_metadataSidecarID(t *testing.T) {
+	require.Equal(t, "metadata", metadataSidecarID(""))
+	require.Equal(t, "layer-1.metadata", metadataSidecarID("layer-1"))
+	require.Equal(t, "/cache/cache.metadata.tar", fileDestination("file:///cache/cache.tar", metadataSidecarID("")))
+}
+
+func Test_createMetadataSidecar(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "metadata_sidecar")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	cached := filepath.Join(tmpDir, "cached")
+	content := archiveContent{
+		stackData: []byte("{}"),
+		metadata: []archiveMetadata{
+			{path: workPath(cacheMetaFileName), data: []byte("meta")},
+			{path: workPath(contentHashesFileName), data: []byte("hashes")},
+		},
+		pathToIndicatorPath: map[string]string{cached: ""},
+		descriptor:          map[string]string{cached: "indicator"},
+	}
+
+	pth := filepath.Join(tmpDir, metadataSidecarFileName)
+	stats := archiveMetadata{path: workPath(phaseStatsFileName), data: []byte("stats")}
+	require.NoError(t, createMetadataSidecar(pth, memoryBudget{}, content, []archiveMetadata{stats}))
+
+	var names []string
+	for _, entry := range readArchiveEntries(t, pth) {
+		names = append(names, entry.name)
+	}
+	require.Equal(t, []string{workPath(stackVersionsFileName), workPath(cacheMetaFileName), workPath(phaseStatsFileName), workPath(cacheInfoFileName), workPath(metadataChecksumsFileName)}, names)
+
+}
+
+func TestRun_uploadMetadataSidecar(t *testing.T) {
+	const group = "metadata-sidecar-test"
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file"): "content"})
+	defer func() {
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+	}()
+
+	var layers []string
+	uploaded := map[string][]byte{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body struct {
+				Layer string `json:"cache_layer"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			layers = append(layers, body.Layer)
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload/` + body.Layer + `"}`))
+			require.NoError(t, err)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		uploaded[r.URL.Path] = data
+	}))
+	defer server.Close()
+
+	report, err := runCache(context.Background(), Config{
+		Paths:                 tmpDir,
+		CacheAPIURL:           server.URL,
+		FingerprintMethodID:   string(MD5),
+		CompressArchive:       "false",
+		QuotaPolicy:           string(QuotaPolicyNone),
+		UploadMetadataSidecar: true,
+	}, group)
+	require.NoError(t, err)
+	require.True(t, report.Pushed)
+	require.Equal(t, []string{"", metadataSidecarLayerID}, layers)
+
+	sidecar := filepath.Join(tmpDir, "sidecar.tar.gz")
+	require.NoError(t, ioutil.WriteFile(sidecar, uploaded["/upload/"+metadataSidecarLayerID], 0600))
+	var names []string
+	var stats phaseStats
+	for _, entry := range readArchiveEntries(t, sidecar) {
+		names = append(names, entry.name)
+		if entry.name == cachecommon.GroupPath(workPath(phaseStatsFileName), group) {
+			require.NoError(t, json.Unmarshal([]byte(entry.content), &stats))
+		}
+	}
+	require.Contains(t, names, cachecommon.GroupPath(workPath(cacheInfoFileName), group))
+	// the sidecar is uploaded after the archive, it records the upload duration
+	require.Len(t, stats.History[phaseUpload], 1)
+	require.Len(t, stats.History[phaseArchive], 1)
+	require.NoFileExists(t, cachecommon.GroupPath(workPath(metadataSidecarFileName), group))
+}
@@ -0,0 +1,138 @@
+// Modified file related functions.
+//
+// Files written by running processes (like Gradle daemons) can change while they are archived:
+// a growing file is truncated to its size at the time its header was written, a shrinking one is padded with zeros.
+// The modified files are detected while copying them and by a final consistency pass comparing their size and mod time
+// to the archived ones. As the archive can not be fixed in place, it is recreated according to the modified file policy.
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// modifiedFilePolicy decides what happens if files are modified while they are archived.
+type modifiedFilePolicy string
+
+const (
+	// modifiedFileRetry recreates the archive with the modified files, then without them if they keep changing.
+	modifiedFileRetry modifiedFilePolicy = "retry"
+	// modifiedFileSkip recreates the archive without the modified files.
+	modifiedFileSkip modifiedFilePolicy = "skip"
+	// modifiedFileFail fails the archive generation.
+	modifiedFileFail modifiedFilePolicy = "fail"
+
+	// maxSkipRounds limits how many times the archive is recreated without the modified files.
+	maxSkipRounds = 3
+)
+
+// archivedState is the size and mod time of an archived regular file.
+type archivedState struct {
+	size    int64
+	modTime time.Time
+}
+
+func (s archivedState) differs(info os.FileInfo) bool {
+	return info.Size() != s.size || !info.ModTime().Equal(s.modTime)
+}
+
+// modifiedFilesError is returned if files were modified while they were archived.
+type modifiedFilesError struct {
+	paths []string
+}
+
+func (e *modifiedFilesError) Error() string {
+	return fmt.Sprintf("%d files were modified while archiving them: %s", len(e.paths), strings.Join(e.paths, ", "))
+}
+
+// modifiedFiles returns the archived regular files which were modified while they were copied
+// or which differ from their archived state since, sorted.
+func (a *Archive) modifiedFiles() []string {
+	modified := map[string]bool{}
+	for _, pth := range a.modified {
+		modified[pth] = true
+	}
+	for pth, state := range a.archived {
+		if info, err := os.Lstat(pth); err != nil || state.differs(info) {
+			modified[pth] = true
+		}
+	}
+
+	paths := make([]string, 0, len(modified))
+	for pth := range modified {
+		paths = append(paths, pth)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// without returns the archive content leaving out the given paths.
+func (c archiveContent) without(paths []string) archiveContent {
+	left := map[string]bool{}
+	for _, pth := range paths {
+		left[pth] = true
+	}
+
+	pathToIndicatorPath := map[string]string{}
+	for pth, indicator := range c.pathToIndicatorPath {
+		if !left[pth] {
+			pathToIndicatorPath[pth] = indicator
+		}
+	}
+	descriptor := map[string]string{}
+	for pth, indicator := range c.descriptor {
+		if !left[pth] {
+			descriptor[pth] = indicator
+		}
+	}
+
+	c.pathToIndicatorPath = pathToIndicatorPath
+	c.descriptor = descriptor
+	return c
+}
+
+// createConsistentArchive creates the cache archive and recreates it according to the policy
+// if files were modified while they were archived.
+// It returns the paths retried due to transient filesystem errors and the modified paths left out of the archive.
+func createConsistentArchive(ctx context.Context, pth string, compress bool, budget memoryBudget, content archiveContent, policy modifiedFilePolicy, maxRetries int) ([]string, []string, error) {
+	var retried, skipped []string
+	retries, skipRounds := 0, 0
+	for {
+		attemptRetried, err := createArchiveWithRetry(ctx, pth, compress, budget, content)
+		for _, p := range attemptRetried {
+			retried = appendIfMissing(retried, p)
+		}
+
+		var modifiedErr *modifiedFilesError
+		if !errors.As(err, &modifiedErr) {
+			return retried, skipped, err
+		}
+
+		log.Warnf("%d files were modified while archiving them:", len(modifiedErr.paths))
+		for _, p := range modifiedErr.paths {
+			log.Warnf("- %s", p)
+		}
+
+		switch {
+		case policy == modifiedFileFail:
+			return retried, skipped, err
+		case policy == modifiedFileRetry && retries < maxRetries:
+			retries++
+			log.Warnf("Recreating the archive (attempt %d/%d)...", retries, maxRetries)
+		case skipRounds < maxSkipRounds:
+			skipRounds++
+			log.Warnf("Recreating the archive without the modified files...")
+			content = content.without(modifiedErr.paths)
+			skipped = append(skipped, modifiedErr.paths...)
+		default:
+			return retried, skipped, err
+		}
+	}
+}
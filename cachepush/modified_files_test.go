@@ -0,0 +1,83 @@
+package cachepush
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_modifiedFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "modified_files")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	stable := filepath.Join(tmpDir, "stable")
+	growing := filepath.Join(tmpDir, "growing")
+	createDirStruct(t, map[string]string{stable: "content", growing: "content"})
+
+	archive, err := NewArchive(filepath.Join(tmpDir, "cache.tar"), false, memoryBudget{})
+	require.NoError(t, err)
+	require.NoError(t, archive.Write(context.Background(), map[string]string{stable: "", growing: ""}))
+	require.Empty(t, archive.modifiedFiles())
+
+	createDirStruct(t, map[string]string{growing: "content and more"})
+	require.Equal(t, []string{growing}, archive.modifiedFiles())
+}
+
+func Test_createConsistentArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "modified_files")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	stable := filepath.Join(tmpDir, "stable")
+	changing := filepath.Join(tmpDir, "changing")
+	createDirStruct(t, map[string]string{stable: "content", changing: "content"})
+	pth := filepath.Join(tmpDir, "cache.tar")
+
+	// the trailer runs after the files are archived, it modifies the changing file in the first attempts
+	newContent := func(modifications int) archiveContent {
+		attempt := 0
+		return archiveContent{
+			stackData:           []byte("{}"),
+			pathToIndicatorPath: map[string]string{stable: "", changing: ""},
+			descriptor:          map[string]string{stable: "1", changing: "2"},
+			trailer: func() (archiveMetadata, error) {
+				attempt++
+				if attempt <= modifications {
+					createDirStruct(t, map[string]string{changing: string(make([]byte, attempt))})
+				}
+				return archiveMetadata{path: filepath.Join(tmpDir, "trailer"), data: []byte("{}")}, nil
+			},
+		}
+	}
+
+	_, skipped, err := createConsistentArchive(context.Background(), pth, false, memoryBudget{}, newContent(1), modifiedFileRetry, 1)
+	require.NoError(t, err)
+	require.Empty(t, skipped)
+
+	_, skipped, err = createConsistentArchive(context.Background(), pth, false, memoryBudget{}, newContent(2), modifiedFileRetry, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{changing}, skipped)
+
+	_, skipped, err = createConsistentArchive(context.Background(), pth, false, memoryBudget{}, newContent(1), modifiedFileSkip, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{changing}, skipped)
+
+	_, _, err = createConsistentArchive(context.Background(), pth, false, memoryBudget{}, newContent(1), modifiedFileFail, 1)
+	require.Error(t, err)
+}
+
+func Test_archiveContent_without(t *testing.T) {
+	content := archiveContent{
+		pathToIndicatorPath: map[string]string{"/a": "", "/b": ""},
+		descriptor:          map[string]string{"/a": "1", "/b": "2", "env:A": "3"},
+	}
+	without := content.without([]string{"/b"})
+	require.Equal(t, map[string]string{"/a": ""}, without.pathToIndicatorPath)
+	require.Equal(t, map[string]string{"/a": "1", "env:A": "3"}, without.descriptor)
+	require.Len(t, content.pathToIndicatorPath, 2)
+}
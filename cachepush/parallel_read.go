@@ -0,0 +1,205 @@
+// Parallel file reading related functions.
+//
+// Writing the cache archive is sequential (the entries are written in path order), but stating, opening
+// and reading the cached files is not: a pool of readers opens the entries and reads the small files ahead,
+// feeding the ordered writer. This hides the file system latency, especially with many small files.
+package cachepush
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// maxAutoArchiverWorkers limits the automatically chosen number of readers, more readers rarely help
+	// as the archive is written sequentially.
+	maxAutoArchiverWorkers = 16
+	// minArchiverBlockSize is the smallest prefetch block size, smaller files are always prefetched.
+	minArchiverBlockSize = 4 * 1024
+)
+
+// archiverSettings describes the parallelism and the memory use of the archiver.
+type archiverSettings struct {
+	// workers is the number of parallel readers, 1 means the files are read sequentially.
+	workers int
+	// queueSize is the number of entries opened ahead of the one being written.
+	queueSize int
+	// blockSize is the size of the largest file whose content is read ahead, larger files are copied when written.
+	blockSize int
+}
+
+// newArchiverSettings returns the settings of the archiver, the zero values are tuned automatically
+// from the readers tuned to the storage of the cache paths and the memory budget:
+// defaultWorkers readers (one per CPU if 0, at most maxAutoArchiverWorkers), the copy buffer size as the block size
+// and twice as many queued entries as readers, limited so that the prefetched content fits into 1/8 of the budget.
+func newArchiverSettings(workers, defaultWorkers, queueSize, blockSize int, budget memoryBudget) archiverSettings {
+	if workers <= 0 {
+		workers = defaultWorkers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		if workers > maxAutoArchiverWorkers {
+			workers = maxAutoArchiverWorkers
+		}
+	}
+
+	if blockSize <= 0 {
+		blockSize = budget.copyBufferSize()
+	}
+	if blockSize < minArchiverBlockSize {
+		blockSize = minArchiverBlockSize
+	}
+
+	if queueSize <= 0 {
+		queueSize = 2 * workers
+		if budget.limited() {
+			if fit := budget.bytes() / 8 / blockSize; queueSize > fit {
+				queueSize = fit
+			}
+		}
+		if queueSize < workers {
+			queueSize = workers
+		}
+	}
+
+	return archiverSettings{workers: workers, queueSize: queueSize, blockSize: blockSize}
+}
+
+// apply configures the archive to be written with the settings.
+func (s archiverSettings) apply(a *Archive) {
+	a.workers = s.workers
+	a.queueSize = s.queueSize
+	a.blockSize = s.blockSize
+}
+
+// prefetchJob is a path to open (and read if small) by a prefetch worker, the result is sent on the result channel.
+type prefetchJob struct {
+	pth    string
+	result chan prefetchResult
+}
+
+type prefetchResult struct {
+	entry   archiveEntry
+	retried bool
+	err     error
+}
+
+// prefetch opens the archive entry and reads the content of the regular files fitting into the archive's block size
+// (or the copy buffer if not set).
+// If the read fails, the content is copied from the file when it is written.
+func (a *Archive) prefetch(pth string) prefetchResult {
+	blockSize := a.blockSize
+	if blockSize <= 0 {
+		blockSize = len(a.copyBuffer)
+	}
+
+	entry, retried, err := openArchiveEntryWithRetry(pth)
+	if err != nil || entry.file == nil || entry.header.Size > int64(blockSize) {
+		return prefetchResult{entry: entry, retried: retried, err: err}
+	}
+
+	data := make([]byte, entry.header.Size)
+	n, err := io.ReadFull(entry.file, data)
+	switch {
+	case err == nil:
+		entry.data = data
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		// the file shrank since it was opened, the entry is padded when it is written
+		entry.data = data[:n]
+	default:
+		log.Debugf("Failed to prefetch %s, copying it from the file: %s", pth, err)
+		if _, err := entry.file.Seek(0, io.SeekStart); err != nil {
+			closeArchiveEntry(pth, entry)
+			return prefetchResult{retried: retried, err: fmt.Errorf("failed to read file(%s), error: %w", pth, err)}
+		}
+	}
+	return prefetchResult{entry: entry, retried: retried}
+}
+
+// closeArchiveEntry closes the file of an archive entry which is not going to be written.
+func closeArchiveEntry(pth string, entry archiveEntry) {
+	if entry.file == nil {
+		return
+	}
+	if err := entry.file.Close(); err != nil {
+		log.Warnf("Failed to close file (%s): %s", pth, err)
+	}
+}
+
+// writeParallel writes the files in the given order, opening and reading them ahead with the archive's workers.
+// At most queueSize entries (twice as many as workers if not set) are prefetched, to bound the memory use and the open files.
+func (a *Archive) writeParallel(ctx context.Context, paths []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan prefetchJob)
+	queueSize := a.queueSize
+	if queueSize <= 0 {
+		queueSize = 2 * a.workers
+	}
+	queue := make(chan prefetchJob, queueSize)
+
+	var wg sync.WaitGroup
+	for i := 0; i < a.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.result <- a.prefetch(job.pth)
+			}
+		}()
+	}
+
+	// every queued job is handed to the workers, so every queued job gets a result
+	go func() {
+		defer close(jobs)
+		defer close(queue)
+		for _, pth := range paths {
+			job := prefetchJob{pth: pth, result: make(chan prefetchResult, 1)}
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return
+			}
+			jobs <- job
+		}
+	}()
+
+	var err error
+	written := 0
+	for job := range queue {
+		result := <-job.result
+		written++
+		if err != nil {
+			closeArchiveEntry(job.pth, result.entry)
+			continue
+		}
+
+		if result.retried {
+			a.retried = appendIfMissing(a.retried, job.pth)
+		}
+		if result.err != nil {
+			err = result.err
+		} else if ctxErr := ctx.Err(); ctxErr != nil {
+			closeArchiveEntry(job.pth, result.entry)
+			err = ctxErr
+		} else {
+			err = a.writeEntry(job.pth, result.entry)
+		}
+		if err != nil {
+			cancel()
+		}
+	}
+
+	wg.Wait()
+	if err == nil && written < len(paths) {
+		// the dispatcher stopped queueing the paths as the context was canceled
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package cachepush
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_writeParallel(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "parallel_read")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	pathToIndicatorPath := map[string]string{}
+	for _, name := range []string{"a", "b/c", "b/d", "e/f", "g"} {
+		pth := filepath.Join(tmpDir, "cache", name)
+		createDirStruct(t, map[string]string{pth: name})
+		pathToIndicatorPath[pth] = ""
+	}
+	pathToIndicatorPath[filepath.Join(tmpDir, "cache", "b")] = ""
+
+	var entries [][]archivedEntry
+	for _, workers := range []int{1, 4} {
+		pth := filepath.Join(tmpDir, "cache.tar")
+		archive, err := NewArchive(pth, false, memoryBudget{})
+		require.NoError(t, err)
+		newArchiverSettings(workers, 0, 0, 0, memoryBudget{}).apply(archive)
+		require.NoError(t, archive.Write(context.Background(), pathToIndicatorPath))
+		require.NoError(t, archive.Close())
+		require.Len(t, archive.fileChecksums, 5)
+
+		entries = append(entries, readArchiveEntries(t, pth))
+	}
+	require.Equal(t, entries[0], entries[1])
+}
+
+func TestArchive_writeParallel_canceled(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fast_archiver")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	var paths []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		pth := filepath.Join(tmpDir, name)
+		createDirStruct(t, map[string]string{pth: name})
+		paths = append(paths, pth)
+	}
+
+	archive, err := NewArchive(filepath.Join(tmpDir, "cache.tar"), false, memoryBudget{})
+	require.NoError(t, err)
+	archive.workers = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, archive.writeParallel(ctx, paths))
+}
+
+func TestArchive_prefetch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fast_archiver")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	small, large := filepath.Join(tmpDir, "small"), filepath.Join(tmpDir, "large")
+	createDirStruct(t, map[string]string{small: "small", large: "large content"})
+
+	archive, err := NewArchive(filepath.Join(tmpDir, "cache.tar"), false, memoryBudget{})
+	require.NoError(t, err)
+	archive.copyBuffer = make([]byte, 8)
+
+	result := archive.prefetch(small)
+	require.NoError(t, result.err)
+	require.Equal(t, []byte("small"), result.entry.data)
+	closeArchiveEntry(small, result.entry)
+
+	result = archive.prefetch(large)
+	require.NoError(t, result.err)
+	require.Nil(t, result.entry.data)
+	closeArchiveEntry(large, result.entry)
+
+	result = archive.prefetch(filepath.Join(tmpDir, "missing"))
+	require.Error(t, result.err)
+}
+
+func Test_newArchiverSettings(t *testing.T) {
+	autoWorkers := runtime.NumCPU()
+	if autoWorkers > maxAutoArchiverWorkers {
+		autoWorkers = maxAutoArchiverWorkers
+	}
+
+	tests := []struct {
+		name           string
+		workers        int
+		defaultWorkers int
+		queueSize      int
+		blockSize      int
+		budget         memoryBudget
+		want           archiverSettings
+	}{
+		{name: "sequential", workers: 1, want: archiverSettings{workers: 1, queueSize: 2, blockSize: defaultCopyBufferSize}},
+		{name: "auto", want: archiverSettings{workers: autoWorkers, queueSize: 2 * autoWorkers, blockSize: defaultCopyBufferSize}},
+		{name: "storage tuned", defaultWorkers: 3, want: archiverSettings{workers: 3, queueSize: 6, blockSize: defaultCopyBufferSize}},
+		{name: "storage tuned, capped", defaultWorkers: 64, want: archiverSettings{workers: maxAutoArchiverWorkers, queueSize: 2 * maxAutoArchiverWorkers, blockSize: defaultCopyBufferSize}},
+		{name: "set", workers: 2, defaultWorkers: 3, queueSize: 3, blockSize: 8192, want: archiverSettings{workers: 2, queueSize: 3, blockSize: 8192}},
+		{name: "small block", workers: 2, blockSize: 1, want: archiverSettings{workers: 2, queueSize: 4, blockSize: minArchiverBlockSize}},
+		{name: "queue limited by budget", workers: 4, blockSize: 1024 * 1024, budget: memoryBudget{maxMB: 40}, want: archiverSettings{workers: 4, queueSize: 5, blockSize: 1024 * 1024}},
+		{name: "queue at least workers", workers: 4, blockSize: 1024 * 1024, budget: memoryBudget{maxMB: 2}, want: archiverSettings{workers: 4, queueSize: 4, blockSize: 1024 * 1024}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, newArchiverSettings(tt.workers, tt.defaultWorkers, tt.queueSize, tt.blockSize, tt.budget))
+		})
+	}
+}
@@ -0,0 +1,16 @@
+// Unicode path normalization related functions.
+//
+// macOS file systems (HFS+ and some tools on APFS) store file names in decomposed form (NFD, like `e` followed by
+// a combining acute accent), while other tools and file systems use the composed form (NFC, like `é`).
+// The same path recorded in different forms is reported as removed and added, so the descriptor keys are compared
+// in the canonical composed form (NFC).
+package cachepush
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// composeUnicode returns the path in the canonical composed form (NFC).
+func composeUnicode(pth string) string {
+	return norm.NFC.String(pth)
+}
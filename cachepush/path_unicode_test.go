@@ -0,0 +1,35 @@
+package cachepush
+
+import (
+	"testing"
+)
+
+func Test_composeUnicode(t *testing.T) {
+	tests := []struct {
+		name string
+		pth  string
+		want string
+	}{
+		{name: "ascii", pth: "/plain/ascii", want: "/plain/ascii"},
+		{name: "composed", pth: "/caf\u00e9", want: "/caf\u00e9"},
+		{name: "decomposed latin", pth: "/cafe\u0301", want: "/caf\u00e9"},
+		{name: "several letters", pth: "/A\u030angstro\u0308m", want: "/\u00c5ngstr\u00f6m"},
+		{name: "two marks", pth: "/u\u0308\u0304", want: "/\u01d6"},
+		{name: "marks in non canonical order", pth: "/a\u0301\u0323", want: "/\u1ea1\u0301"},
+		{name: "vietnamese", pth: "/Vie\u0323\u0302t", want: "/Vi\u1ec7t"},
+		{name: "latin extended additional", pth: "/w\u0308", want: "/\u1e85"},
+		{name: "greek", pth: "/\u03b1\u0301\u03b8\u03b7\u03bd\u03b1", want: "/\u03ac\u03b8\u03b7\u03bd\u03b1"},
+		{name: "cyrillic", pth: "/\u0435\u0308\u043b\u043a\u0430", want: "/\u0451\u043b\u043a\u0430"},
+		{name: "hangul jamo", pth: "/\u1112\u1161\u11ab", want: "/\ud55c"},
+		{name: "japanese voiced mark", pth: "/\u30ab\u3099", want: "/\u30ac"},
+		{name: "leading mark", pth: "/\u0301leading/x\u0301", want: "/\u0301leading/x\u0301"},
+		{name: "composition exclusion", pth: "/\u0915\u093c", want: "/\u0915\u093c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := composeUnicode(tt.pth); got != tt.want {
+				t.Errorf("composeUnicode(%q) = %q, want %q", tt.pth, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Phase duration statistics.
+//
+// The durations of the step phases of the recent pushes are stored in the cache archive.
+// If the current build's phase takes longer than the 90th percentile of the recent history,
+// the step warns about it, so infrastructure regressions (slow disk, throttled network) are noticed.
+// The upload duration can not be stored in the archive being uploaded: it is checked against the history
+// and recorded into the phase stats of the metadata sidecar, which is uploaded after the archive
+// at the same path as the archive's phase stats. The upload history is only available to the next build
+// if the metadata sidecar is uploaded and restored with the cache.
+package cachepush
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	phaseStatsFileName   = "cache-push-stats.json"
+	maxPhaseStatsHistory = 20
+	minPhaseStatsHistory = 5
+	slowPhasePercentile  = 90
+	phaseWalk            = "walk"
+	phaseHash            = "hash"
+	phaseArchive         = "archive"
+	phaseUpload          = "upload"
+)
+
+// phaseStats stores the recent durations (in seconds) of the step phases, the oldest first.
+type phaseStats struct {
+	History map[string][]float64 `json:"history"`
+}
+
+// readPhaseStats reads the phase stats of the previous cache from pth if exists.
+func readPhaseStats(pth string) (phaseStats, error) {
+	stats := phaseStats{History: map[string][]float64{}}
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return stats, err
+	} else if !exists {
+		return stats, nil
+	}
+
+	fileBytes, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return stats, err
+	}
+
+	if err := json.Unmarshal(fileBytes, &stats); err != nil {
+		return stats, err
+	}
+	if stats.History == nil {
+		stats.History = map[string][]float64{}
+	}
+	return stats, nil
+}
+
+// copy returns a deep copy of the stats.
+func (s phaseStats) copy() phaseStats {
+	c := phaseStats{History: map[string][]float64{}}
+	for phase, history := range s.History {
+		c.History[phase] = append([]float64{}, history...)
+	}
+	return c
+}
+
+// record adds the phase's duration to the history, keeping only the most recent durations.
+func (s *phaseStats) record(phase string, d time.Duration) {
+	history := append(s.History[phase], d.Seconds())
+	if len(history) > maxPhaseStatsHistory {
+		history = history[len(history)-maxPhaseStatsHistory:]
+	}
+	s.History[phase] = history
+}
+
+// percentile returns the given (nearest-rank) percentile of the phase's recent durations,
+// or false if the history is too short to be meaningful.
+func (s phaseStats) percentile(phase string, p float64) (time.Duration, bool) {
+	history := s.History[phase]
+	if len(history) < minPhaseStatsHistory {
+		return 0, false
+	}
+
+	sorted := append([]float64{}, history...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return time.Duration(sorted[rank-1] * float64(time.Second)), true
+}
+
+// checkPhase warns if the phase took longer than usual, then records its duration.
+func (s *phaseStats) checkPhase(phase string, d time.Duration) {
+	if limit, ok := s.percentile(phase, slowPhasePercentile); ok && d > limit {
+		log.Warnf("The %s phase took %s, longer than P%d (%s) of the recent builds", phase, d.Round(time.Millisecond), slowPhasePercentile, limit.Round(time.Millisecond))
+	}
+	s.record(phase, d)
+}
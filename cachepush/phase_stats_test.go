@@ -0,0 +1,32 @@
+package cachepush
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_phaseStats(t *testing.T) {
+	stats := phaseStats{History: map[string][]float64{}}
+
+	for i := 1; i < minPhaseStatsHistory; i++ {
+		stats.checkPhase(phaseHash, time.Duration(i)*time.Second)
+	}
+	_, ok := stats.percentile(phaseHash, slowPhasePercentile)
+	require.False(t, ok)
+
+	for i := minPhaseStatsHistory; i <= 10; i++ {
+		stats.checkPhase(phaseHash, time.Duration(i)*time.Second)
+	}
+	p90, ok := stats.percentile(phaseHash, slowPhasePercentile)
+	require.True(t, ok)
+	require.Equal(t, 9*time.Second, p90)
+
+	copied := stats.copy()
+	for i := 0; i < 2*maxPhaseStatsHistory; i++ {
+		stats.record(phaseHash, time.Second)
+	}
+	require.Len(t, stats.History[phaseHash], maxPhaseStatsHistory)
+	require.Len(t, copied.History[phaseHash], 10)
+}
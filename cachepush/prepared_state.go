@@ -0,0 +1,315 @@
+// Prepared state of the two-phase execution.
+//
+// The prepare invocation saves the expanded cache paths and the change indicators of the cache descriptor,
+// the push invocation reuses them while they are valid, so it only walks and fingerprints what changed in between.
+package cachepush
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// fileStamp identifies the state of a file or directory, a missing path has the zero stamp.
+// Adding or removing a file changes the stamp of its directory.
+type fileStamp struct {
+	Size    int64       `json:"size,omitempty"`
+	ModTime int64       `json:"mod_time,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+}
+
+func statStamp(pth string, stat func(string) (os.FileInfo, error)) (fileStamp, error) {
+	info, err := stat(pth)
+	if os.IsNotExist(err) {
+		return fileStamp{}, nil
+	} else if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Mode: info.Mode()}, nil
+}
+
+// preparedItem is the expansion of an include item with the stamps it is valid for.
+type preparedItem struct {
+	Paths map[string]string `json:"paths"`
+	// Root is the stamp of the item's path, Dirs are the stamps of the walked directories.
+	Root fileStamp            `json:"root"`
+	Dirs map[string]fileStamp `json:"dirs,omitempty"`
+	// Indicator reports whether the item's indicator was an existing file, the item is left out otherwise.
+	Indicator bool `json:"indicator,omitempty"`
+	// Failures are the failure counts of the paths which could not be read or are skip-listed.
+	Failures map[string]int `json:"failures,omitempty"`
+}
+
+// preparedFingerprint is a change indicator of the descriptor with the stamp of its indicator file,
+// taken before fingerprinting it.
+type preparedFingerprint struct {
+	Stamp     fileStamp `json:"stamp"`
+	Indicator string    `json:"indicator"`
+}
+
+// preparedState is saved by the prepare invocation for the push invocation.
+type preparedState struct {
+	// Key identifies the inputs and the working directory of the prepare invocation.
+	Key           string                         `json:"key"`
+	Items         map[string]preparedItem        `json:"items"`
+	ReadonlyItems map[string]preparedItem        `json:"readonly_items,omitempty"`
+	Fingerprints  map[string]preparedFingerprint `json:"fingerprints"`
+}
+
+// preparedStateKey returns the key of the state prepared with the given inputs in the current working directory.
+// Every input but the execution mode is part of the key, a state prepared with different inputs is not reused.
+func preparedStateKey(configs Config) (string, error) {
+	configs.ExecutionMode = ""
+	data, err := json.Marshal(configs)
+	if err != nil {
+		return "", err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := h.Write(append(data, wd...)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// save writes the state into the given file.
+func (s preparedState) save(pth string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
+
+// readPreparedState returns the state saved into the given file if it was prepared with the given key, nil otherwise.
+func readPreparedState(pth, key string) (*preparedState, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		log.Printf("No prepared state found, the cache paths are walked")
+		return nil, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	var state preparedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Key != key {
+		log.Printf("The inputs changed since the prepare invocation, the cache paths are walked")
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func preparedItemKey(pth, indicator string) string {
+	if indicator == "" {
+		return pth
+	}
+	return pth + " -> " + indicator
+}
+
+// newPreparedItem returns the expansion of the include item with the current stamps of its path, indicator and walked directories.
+func newPreparedItem(pth, indicator string, paths map[string]string) (preparedItem, error) {
+	item := preparedItem{Paths: paths, Dirs: map[string]fileStamp{}}
+
+	abs, err := pathutil.AbsPath(pth)
+	if err != nil {
+		return preparedItem{}, err
+	}
+	if item.Root, err = statStamp(abs, os.Stat); err != nil {
+		return preparedItem{}, err
+	}
+	if item.Indicator, err = isExistingFile(indicator); err != nil {
+		return preparedItem{}, err
+	}
+
+	for p, ind := range paths {
+		if ind != "-" {
+			continue
+		}
+		stamp, err := statStamp(p, os.Lstat)
+		if err != nil {
+			return preparedItem{}, err
+		}
+		if stamp.Mode.IsDir() {
+			item.Dirs[p] = stamp
+		}
+	}
+	return item, nil
+}
+
+// valid reports whether the item's expansion is still valid: no file was added or removed in the walked directories
+// and the indicator still exists.
+func (i preparedItem) valid(pth, indicator string) (bool, error) {
+	abs, err := pathutil.AbsPath(pth)
+	if err != nil {
+		return false, err
+	}
+	if root, err := statStamp(abs, os.Stat); err != nil || root != i.Root {
+		return false, err
+	}
+	if exists, err := isExistingFile(indicator); err != nil || exists != i.Indicator {
+		return false, err
+	}
+
+	for dir, prev := range i.Dirs {
+		if stamp, err := statStamp(dir, os.Lstat); err != nil || stamp != prev {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// isExistingFile reports whether the indicator is an existing file, false for an empty indicator.
+func isExistingFile(indicator string) (bool, error) {
+	if indicator == "" {
+		return false, nil
+	}
+	abs, err := pathutil.AbsPath(indicator)
+	if err != nil {
+		return false, err
+	}
+	info, exist, err := pathutil.PathCheckAndInfos(abs)
+	if err != nil || !exist {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// expandCachePaths expands the include items like normalizeIndicatorByPath,
+// reusing the prepared expansion of the items which are still valid.
+// It returns the expanded paths and the expansion of every item, which the prepare invocation saves.
+// The glob patterns and the items with an indicator alias are always expanded, as their matches can appear anywhere.
+// The failures recorded while walking an item are recorded again when its expansion is reused.
+func expandCachePaths(ctx context.Context, indicatorByPath map[string]string, prepared map[string]preparedItem, failures *pathFailures, large *largeFiles, hygiene *gradleHygiene) (map[string]string, map[string]preparedItem, error) {
+	items := map[string]preparedItem{}
+	volatile := map[string]string{}
+	var reused int
+	for pth, indicator := range indicatorByPath {
+		if isGlobPattern(pth) || isIndicatorAlias(indicator) {
+			volatile[pth] = indicator
+			continue
+		}
+
+		key := preparedItemKey(pth, indicator)
+		if item, ok := prepared[key]; ok {
+			valid, err := item.valid(pth, indicator)
+			if err != nil {
+				return nil, nil, err
+			}
+			if valid {
+				failures.merge(item.Failures)
+				items[key] = item
+				reused++
+				continue
+			}
+		}
+
+		itemFailures := failures.scoped()
+		paths, err := normalizeIndicatorByPath(ctx, map[string]string{pth: indicator}, itemFailures, large, hygiene)
+		if err != nil {
+			return nil, nil, err
+		}
+		item, err := newPreparedItem(pth, indicator, paths)
+		if err != nil {
+			return nil, nil, err
+		}
+		if itemFailures != nil {
+			item.Failures = itemFailures.cur
+			failures.merge(item.Failures)
+		}
+		items[key] = item
+	}
+
+	expanded := map[string]string{}
+	if len(volatile) > 0 {
+		var err error
+		if expanded, err = normalizeIndicatorByPath(ctx, volatile, failures, large, hygiene); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, item := range items {
+		for pth, indicator := range item.Paths {
+			expanded[pth] = indicator
+		}
+	}
+
+	if reused > 0 {
+		log.Printf("%d of %d cache paths are reused as expanded by the prepare invocation", reused, len(indicatorByPath))
+	}
+	return expanded, items, nil
+}
+
+// indicatorStamps returns the stamps of the indicator files, taken before fingerprinting them.
+func indicatorStamps(pathToIndicatorPath map[string]string) (map[string]fileStamp, error) {
+	stamps := map[string]fileStamp{}
+	for _, indicator := range pathToIndicatorPath {
+		if _, ok := stamps[indicator]; ok || indicator == "" {
+			continue
+		}
+		stamp, err := statStamp(indicator, os.Stat)
+		if err != nil {
+			return nil, err
+		}
+		stamps[indicator] = stamp
+	}
+	return stamps, nil
+}
+
+// preparedFingerprints returns the change indicators of the descriptor by indicator file, with the file's stamp.
+func preparedFingerprints(pathToIndicatorPath, descriptor map[string]string, stamps map[string]fileStamp) map[string]preparedFingerprint {
+	fingerprints := map[string]preparedFingerprint{}
+	for pth, indicatorPath := range pathToIndicatorPath {
+		stamp, ok := stamps[indicatorPath]
+		indicator, fingerprinted := descriptor[pth]
+		if !ok || !fingerprinted || stamp == (fileStamp{}) {
+			continue
+		}
+		fingerprints[indicatorPath] = preparedFingerprint{Stamp: stamp, Indicator: indicator}
+	}
+	return fingerprints
+}
+
+// reuseFingerprints splits the cache paths into the descriptor of the ones whose indicator file did not change
+// since the prepare invocation and the ones to be fingerprinted.
+func reuseFingerprints(pathToIndicatorPath map[string]string, prepared map[string]preparedFingerprint) (map[string]string, map[string]string, error) {
+	descriptor := map[string]string{}
+	pending := map[string]string{}
+	stamps := map[string]fileStamp{}
+	for pth, indicatorPath := range pathToIndicatorPath {
+		fingerprint, ok := prepared[indicatorPath]
+		if !ok {
+			pending[pth] = indicatorPath
+			continue
+		}
+
+		stamp, stamped := stamps[indicatorPath]
+		if !stamped {
+			var err error
+			if stamp, err = statStamp(indicatorPath, os.Stat); err != nil {
+				return nil, nil, err
+			}
+			stamps[indicatorPath] = stamp
+		}
+		if stamp == fingerprint.Stamp {
+			descriptor[pth] = fingerprint.Indicator
+		} else {
+			pending[pth] = indicatorPath
+		}
+	}
+	return descriptor, pending, nil
+}
@@ -0,0 +1,128 @@
+package cachepush
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_expandCachePaths(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	dir := filepath.Join(tmpDir, "dir")
+	sub := filepath.Join(dir, "sub")
+	createDirStruct(t, map[string]string{
+		filepath.Join(dir, "a"): "a",
+		filepath.Join(sub, "b"): "b",
+	})
+	// the directories are stamped by their mod time, the files added later in the test must change it
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(sub, past, past))
+	require.NoError(t, os.Chtimes(dir, past, past))
+
+	include := map[string]string{dir: ""}
+	expanded, items, err := expandCachePaths(context.Background(), include, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		dir:                     "-",
+		sub:                     "-",
+		filepath.Join(dir, "a"): "",
+		filepath.Join(sub, "b"): "",
+	}, expanded)
+	require.Len(t, items[dir].Dirs, 2)
+
+	t.Log("reuses the valid expansion")
+	{
+		// the marker proves the expansion is not walked again
+		prepared := items[dir]
+		prepared.Paths = map[string]string{"marker": ""}
+		reused, _, err := expandCachePaths(context.Background(), include, map[string]preparedItem{dir: prepared}, nil, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"marker": ""}, reused)
+	}
+
+	t.Log("walks the item again if a file was added")
+	{
+		prepared := items[dir]
+		prepared.Paths = map[string]string{"marker": ""}
+		createDirStruct(t, map[string]string{filepath.Join(sub, "c"): "c"})
+
+		walked, _, err := expandCachePaths(context.Background(), include, map[string]preparedItem{dir: prepared}, nil, nil, nil)
+		require.NoError(t, err)
+		require.NotContains(t, walked, "marker")
+		require.Contains(t, walked, filepath.Join(sub, "c"))
+	}
+
+	t.Log("records the failures of the reused expansion")
+	{
+		prevMeta := model.CacheMeta{sub: {ConsecutiveFailures: 3}}
+		_, items, err := expandCachePaths(context.Background(), include, nil, newPathFailures(prevMeta, 3), nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{sub: 3}, items[dir].Failures)
+
+		failures := newPathFailures(prevMeta, 3)
+		expanded, _, err := expandCachePaths(context.Background(), include, items, failures, nil, nil)
+		require.NoError(t, err)
+		require.NotContains(t, expanded, sub)
+		require.Equal(t, []string{sub}, failures.skipped())
+	}
+
+	t.Log("walks the item again if its indicator was created")
+	{
+		indicator := filepath.Join(tmpDir, "indicator")
+		include := map[string]string{dir: indicator}
+		expanded, items, err := expandCachePaths(context.Background(), include, nil, nil, nil, nil)
+		require.NoError(t, err)
+		require.Empty(t, expanded)
+
+		createDirStruct(t, map[string]string{indicator: "lock"})
+		expanded, _, err = expandCachePaths(context.Background(), include, items, nil, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, indicator, expanded[filepath.Join(dir, "a")])
+	}
+}
+
+func Test_reuseFingerprints(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	file := filepath.Join(tmpDir, "file")
+	indicator := filepath.Join(tmpDir, "indicator")
+	createDirStruct(t, map[string]string{file: "content", indicator: "lock"})
+
+	pathToIndicatorPath := map[string]string{file: indicator, tmpDir: ""}
+	stamps, err := indicatorStamps(pathToIndicatorPath)
+	require.NoError(t, err)
+	fingerprints := preparedFingerprints(pathToIndicatorPath, map[string]string{file: "digest", tmpDir: "-"}, stamps)
+	require.Equal(t, []string{indicator}, keys(fingerprints))
+
+	descriptor, pending, err := reuseFingerprints(pathToIndicatorPath, fingerprints)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{file: "digest"}, descriptor)
+	require.Equal(t, map[string]string{tmpDir: ""}, pending)
+
+	// a modified indicator is fingerprinted again
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(indicator, past, past))
+	descriptor, pending, err = reuseFingerprints(pathToIndicatorPath, fingerprints)
+	require.NoError(t, err)
+	require.Empty(t, descriptor)
+	require.Equal(t, pathToIndicatorPath, pending)
+}
+
+func keys(m map[string]preparedFingerprint) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
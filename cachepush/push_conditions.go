@@ -0,0 +1,56 @@
+// Push condition related functions.
+//
+// The push can be restricted to the builds of given branches, and skipped for pull request builds,
+// so feature branch and fork pull request builds do not overwrite the project's cache.
+package cachepush
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// buildInfo describes the build triggering the push.
+type buildInfo struct {
+	branch      string
+	pullRequest bool
+}
+
+// newBuildInfo returns the build info based on the build's environment.
+func newBuildInfo() buildInfo {
+	return buildInfo{
+		branch:      os.Getenv("BITRISE_GIT_BRANCH"),
+		pullRequest: os.Getenv("BITRISE_PULL_REQUEST") != "" || os.Getenv("PR") == "true",
+	}
+}
+
+// parseBranchPatterns returns the branch patterns of the newline separated list.
+func parseBranchPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, "\n") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// pushSkipReason returns why the build must not push the cache, empty if it can push.
+// Every branch can push if no branch pattern is given, a pattern can contain * wildcards (for example release/*).
+func pushSkipReason(info buildInfo, branchPatterns []string, skipOnPullRequests bool) string {
+	if skipOnPullRequests && info.pullRequest {
+		return "pull request build"
+	}
+	if len(branchPatterns) == 0 {
+		return ""
+	}
+
+	for _, pattern := range branchPatterns {
+		if glob.Glob(pattern, info.branch) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("branch (%s) is not allowed to push", info.branch)
+}
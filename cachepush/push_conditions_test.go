@@ -0,0 +1,48 @@
+package cachepush
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newBuildInfo(t *testing.T) {
+	setenvForTest(t, "BITRISE_GIT_BRANCH", "feature/login")
+	setenvForTest(t, "BITRISE_PULL_REQUEST", "")
+	setenvForTest(t, "PR", "")
+	require.Equal(t, buildInfo{branch: "feature/login"}, newBuildInfo())
+
+	setenvForTest(t, "BITRISE_PULL_REQUEST", "42")
+	require.Equal(t, buildInfo{branch: "feature/login", pullRequest: true}, newBuildInfo())
+
+	setenvForTest(t, "BITRISE_PULL_REQUEST", "")
+	setenvForTest(t, "PR", "true")
+	require.True(t, newBuildInfo().pullRequest)
+}
+
+func Test_parseBranchPatterns(t *testing.T) {
+	require.Equal(t, []string{"main", "release/*"}, parseBranchPatterns("main\n\n  release/* \n"))
+	require.Nil(t, parseBranchPatterns(""))
+}
+
+func Test_pushSkipReason(t *testing.T) {
+	tests := []struct {
+		name               string
+		info               buildInfo
+		branchPatterns     []string
+		skipOnPullRequests bool
+		want               string
+	}{
+		{name: "no conditions", info: buildInfo{branch: "feature/login", pullRequest: true}},
+		{name: "allowed branch", info: buildInfo{branch: "main"}, branchPatterns: []string{"main"}},
+		{name: "allowed branch pattern", info: buildInfo{branch: "release/1.0"}, branchPatterns: []string{"main", "release/*"}},
+		{name: "not allowed branch", info: buildInfo{branch: "feature/login"}, branchPatterns: []string{"main", "release/*"}, want: "branch (feature/login) is not allowed to push"},
+		{name: "pull request", info: buildInfo{branch: "main", pullRequest: true}, branchPatterns: []string{"main"}, skipOnPullRequests: true, want: "pull request build"},
+		{name: "pull request allowed", info: buildInfo{branch: "main", pullRequest: true}, branchPatterns: []string{"main"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, pushSkipReason(tt.info, tt.branchPatterns, tt.skipOnPullRequests))
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Read-only cache path related functions.
+//
+// Read-only paths (for example a toolchain seeded by a nightly job) are fingerprinted to report their changes,
+// but they are never written into the archive and never invalidate the cache,
+// so regular builds can not overwrite the curated content with their local modifications.
+package cachepush
+
+import (
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// splitByPaths splits the map into the entries not in paths and the entries in paths.
+func splitByPaths(m map[string]string, paths map[string]string) (rest map[string]string, in map[string]string) {
+	rest = map[string]string{}
+	in = map[string]string{}
+	for pth, value := range m {
+		if _, ok := paths[pth]; ok {
+			in[pth] = value
+		} else {
+			rest[pth] = value
+		}
+	}
+	return rest, in
+}
+
+// readonlyChanges returns the read-only paths changed since the previous cache, sorted.
+func readonlyChanges(prevDescriptor, readonlyDescriptor map[string]string) []string {
+	r := compare(prevDescriptor, readonlyDescriptor)
+
+	var changes []string
+	changes = append(changes, r.changed...)
+	changes = append(changes, r.added...)
+	changes = append(changes, r.removed...)
+	sort.Strings(changes)
+	return changes
+}
+
+// logReadonlyChanges reports the changes of the read-only paths, which are not uploaded.
+func logReadonlyChanges(changes []string) {
+	if len(changes) == 0 {
+		log.Printf("Read-only paths are unchanged")
+		return
+	}
+
+	log.Warnf("%d read-only paths changed since the cache was pushed, these changes are not uploaded", len(changes))
+	for _, pth := range changes {
+		log.Debugf("- %s", pth)
+	}
+}
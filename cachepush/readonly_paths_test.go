@@ -0,0 +1,23 @@
+package cachepush
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitByPaths(t *testing.T) {
+	rest, in := splitByPaths(
+		map[string]string{"/cache/a": "1", "/toolchain/b": "2"},
+		map[string]string{"/toolchain/b": "", "/toolchain/c": ""},
+	)
+	require.Equal(t, map[string]string{"/cache/a": "1"}, rest)
+	require.Equal(t, map[string]string{"/toolchain/b": "2"}, in)
+}
+
+func Test_readonlyChanges(t *testing.T) {
+	prev := map[string]string{"/toolchain/changed": "1", "/toolchain/same": "2", "/toolchain/removed": "3"}
+	cur := map[string]string{"/toolchain/changed": "4", "/toolchain/same": "2", "/toolchain/added": "5"}
+	require.Equal(t, []string{"/toolchain/added", "/toolchain/changed", "/toolchain/removed"}, readonlyChanges(prev, cur))
+	require.Empty(t, readonlyChanges(cur, cur))
+}
@@ -0,0 +1,96 @@
+package cachepush
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeArchive_reproducible(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "reproducible_archive")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	var files []string
+	pathToIndicatorPath := map[string]string{}
+	for _, name := range []string{"c", "a", "b/d", "b/e"} {
+		pth := filepath.Join(tmpDir, "cache", name)
+		createDirStruct(t, map[string]string{pth: name})
+		files = append(files, pth)
+		pathToIndicatorPath[pth] = ""
+	}
+
+	var checksums []string
+	for i, pth := range []string{filepath.Join(tmpDir, "first.tar.gz"), filepath.Join(tmpDir, "second.tar.gz")} {
+		archive, err := NewArchive(pth, true, memoryBudget{})
+		require.NoError(t, err)
+		require.NoError(t, writeArchive(context.Background(), archive, archiveContent{
+			stackData:           []byte("{}"),
+			pathToIndicatorPath: pathToIndicatorPath,
+			descriptor:          map[string]string{files[0]: "1"},
+			reproducible:        true,
+		}))
+
+		checksum, err := fileSHA256(pth)
+		require.NoError(t, err)
+		checksums = append(checksums, checksum)
+
+		if i == 0 {
+			reader, closeArchive, err := openArchiveReader(pth, nil)
+			require.NoError(t, err)
+
+			var names []string
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				if _, ok := pathToIndicatorPath[header.Name]; ok {
+					names = append(names, header.Name)
+				}
+				require.Zero(t, header.Uid)
+				require.Empty(t, header.Uname)
+				require.True(t, header.AccessTime.IsZero())
+			}
+			closeArchive()
+			require.Len(t, names, len(files))
+		}
+	}
+	require.Equal(t, checksums[0], checksums[1])
+}
+
+func TestArchive_Write_sorted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "reproducible_archive")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	a, b, c := filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"), filepath.Join(tmpDir, "c")
+	createDirStruct(t, map[string]string{a: "", b: "", c: ""})
+
+	pth := filepath.Join(tmpDir, "cache.tar")
+	archive, err := NewArchive(pth, false, memoryBudget{})
+	require.NoError(t, err)
+	require.NoError(t, archive.Write(context.Background(), map[string]string{c: "", a: "", b: ""}))
+	require.NoError(t, archive.Close())
+
+	reader, closeArchive, err := openArchiveReader(pth, nil)
+	require.NoError(t, err)
+	defer closeArchive()
+
+	var names []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	require.Equal(t, []string{a, b, c}, names)
+}
@@ -0,0 +1,136 @@
+// Resumable cache archive upload related functions.
+//
+// If the cache API returns a resumable upload session url (`resumable_upload_url`) next to the upload url,
+// the archive is uploaded to the session, and a failed upload attempt is continued from the last byte confirmed
+// by the storage, instead of uploading the whole archive again. The offset is queried by an empty PUT request with a
+// `Content-Range: bytes */<size>` header, the session answers with 308 and the received `Range`.
+// Any other answer leaves the offset unknown and the whole archive is uploaded again: a plain presigned url
+// would store the empty request body as the object and answer 200, so a 2xx answer never means the upload is complete.
+// The upload URL and the confirmed offset are persisted, so a restarted step can continue uploading the same archive.
+package cachepush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const uploadProgressFileName = "cache-upload-progress.json"
+
+const statusResumeIncomplete = 308
+
+// uploadTarget is where the cache API asks the archive to be uploaded.
+type uploadTarget struct {
+	// url is the presigned upload url, the archive is uploaded with a single PUT request.
+	url string
+	// sessionURL is the url of a resumable upload session, empty if the storage does not support resuming.
+	sessionURL string
+	// streamURL accepts the archive with a chunked PUT request of unknown length, empty if the storage does not support streaming.
+	streamURL string
+}
+
+// uploadProgress describes an upload in progress.
+type uploadProgress struct {
+	CacheAPIURL string `json:"cache_api_url"`
+	LayerID     string `json:"layer_id,omitempty"`
+	UploadURL   string `json:"upload_url"`
+	// Resumable reports whether the upload url is a resumable upload session.
+	Resumable      bool  `json:"resumable,omitempty"`
+	ArchiveSize    int64 `json:"archive_size"`
+	ArchiveModTime int64 `json:"archive_mod_time"`
+	Offset         int64 `json:"offset"`
+}
+
+// readUploadProgress reads the persisted upload progress, returns nil if there is no upload in progress.
+func readUploadProgress(pth string) (*uploadProgress, error) {
+	if exists, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var progress uploadProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// save persists the upload progress.
+func (p uploadProgress) save(pth string) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
+
+// resumes reports whether the progress belongs to the upload of the given archive to the given endpoint.
+func (p uploadProgress) resumes(cacheAPIURL, layerID string, archive os.FileInfo) bool {
+	return p.UploadURL != "" &&
+		p.Resumable &&
+		p.CacheAPIURL == cacheAPIURL &&
+		p.LayerID == layerID &&
+		p.ArchiveSize == archive.Size() &&
+		p.ArchiveModTime == archive.ModTime().UnixNano()
+}
+
+// queryUploadOffset returns the number of bytes already received by the resumable upload session.
+// The returned bool is false if the offset is unknown, then the whole archive has to be uploaded again.
+func queryUploadOffset(ctx context.Context, uploadURL string, size int64) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create upload status request: %s", err)
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	req.ContentLength = 0
+
+	resp, err := newHTTPClient(ctx, 0).Do(req)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to query upload status: %s", err)
+	}
+	defer closeResponseBody(resp)
+
+	// only an incomplete session reports the received bytes, a 2xx answer is not trusted as a completed upload
+	if resp.StatusCode != statusResumeIncomplete {
+		return 0, false, nil
+	}
+	offset, err := parseRangeEnd(resp.Header.Get("Range"))
+	if err != nil {
+		return 0, false, err
+	}
+	if offset >= size {
+		return 0, false, nil
+	}
+	return offset, true, nil
+}
+
+// parseRangeEnd returns the number of received bytes based on a `bytes=0-<last byte>` range header.
+func parseRangeEnd(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+
+	split := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(split) != 2 || split[0] != "0" {
+		return 0, fmt.Errorf("invalid range header: %s", header)
+	}
+
+	last, err := strconv.ParseInt(split[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range header: %s", header)
+	}
+	return last + 1, nil
+}
@@ -0,0 +1,220 @@
+package cachepush
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRangeEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    int64
+		wantErr bool
+	}{
+		{name: "nothing received", header: "", want: 0},
+		{name: "partially received", header: "bytes=0-1023", want: 1024},
+		{name: "not from the start", header: "bytes=10-1023", wantErr: true},
+		{name: "invalid", header: "bytes=0-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRangeEnd(tt.header)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_uploadArchive_resume(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	archive := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{archive: "archive"})
+
+	attempts := 0
+	var resumed []byte
+	var contentRange string
+	var contentMD5s []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload", "resumable_upload_url": "` + server.URL + `/session"}`))
+			require.NoError(t, err)
+		case r.URL.Path != "/session":
+			t.Errorf("unexpected request to the presigned url: %s", r.URL.Path)
+		case r.Header.Get("Content-Range") == "bytes */7":
+			// the first 3 bytes were received before the connection broke
+			w.Header().Set("Range", "bytes=0-2")
+			w.WriteHeader(statusResumeIncomplete)
+		default:
+			attempts++
+			contentMD5s = append(contentMD5s, r.Header.Get("Content-MD5"))
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			contentRange = r.Header.Get("Content-Range")
+			resumed, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+		}
+	}))
+	defer server.Close()
+
+	_, contentMD5, err := fileDigests(archive)
+	require.NoError(t, err)
+
+	require.NoError(t, uploadArchive(context.Background(), archive, server.URL, "", "", model.ArchiveInfo{ContentMD5: contentMD5}, uploadRetry{count: 1, backoff: time.Millisecond}))
+	require.Equal(t, 2, attempts)
+	require.Equal(t, "bytes 3-6/7", contentRange)
+	require.Equal(t, "hive", string(resumed))
+	// the resumed attempt sends the digest of the uploaded part only
+	require.Equal(t, []string{"iI0O42GvNgNzbzITHnsgog==", "ikrCFvsjDaODTeZBs+XQ9w=="}, contentMD5s)
+
+	progress, err := readUploadProgress(workPath(uploadProgressFileName))
+	require.NoError(t, err)
+	require.Nil(t, progress)
+}
+
+func Test_uploadArchive_notResumable(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	archive := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{archive: "archive"})
+
+	for _, tt := range []struct {
+		name     string
+		response string
+	}{
+		{name: "presigned url", response: `{"upload_url": "%s/upload"}`},
+		// the session answers the status query with 200, like a storage ignoring the Content-Range header
+		{name: "session answering 200", response: `{"upload_url": "%s/upload", "resumable_upload_url": "%s/upload"}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var probes int
+			var uploads []string
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					_, err := w.Write([]byte(strings.ReplaceAll(tt.response, "%s", server.URL)))
+					require.NoError(t, err)
+					return
+				}
+
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				if r.Header.Get("Content-Range") == "bytes */7" {
+					probes++
+					return
+				}
+				uploads = append(uploads, string(body))
+				if len(uploads) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			require.NoError(t, uploadArchive(context.Background(), archive, server.URL, "", "", model.ArchiveInfo{}, uploadRetry{count: 1, backoff: time.Millisecond}))
+			// the retry uploads the whole archive, the 200 status answer is not taken as a completed upload
+			require.Equal(t, []string{"archive", "archive"}, uploads)
+			if strings.Contains(tt.response, "resumable_upload_url") {
+				require.Equal(t, 1, probes)
+			} else {
+				require.Equal(t, 0, probes)
+			}
+		})
+	}
+}
+
+func Test_queryUploadOffset(t *testing.T) {
+	status := http.StatusOK
+	rangeHeader := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rangeHeader != "" {
+			w.Header().Set("Range", rangeHeader)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		status     int
+		rangeValue string
+		wantOffset int64
+		wantOK     bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusCreated},
+		{status: http.StatusNotFound},
+		{status: statusResumeIncomplete, rangeValue: "bytes=0-2", wantOffset: 3, wantOK: true},
+		{status: statusResumeIncomplete, wantOffset: 0, wantOK: true},
+		// every byte received, but the session is not finalized
+		{status: statusResumeIncomplete, rangeValue: "bytes=0-6"},
+	} {
+		status, rangeHeader = tt.status, tt.rangeValue
+		offset, ok, err := queryUploadOffset(context.Background(), server.URL, 7)
+		require.NoError(t, err)
+		require.Equal(t, tt.wantOffset, offset, tt)
+		require.Equal(t, tt.wantOK, ok, tt)
+	}
+}
+
+func Test_uploadResponses_reuseConnection(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	archive := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{archive: "archive"})
+
+	var mu sync.Mutex
+	connections := 0
+	status := http.StatusOK
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.WriteHeader(status)
+		// longer than the part read for the diagnostics
+		_, err = w.Write([]byte(strings.Repeat("x", 2*maxDiagnosticsResponseBody)))
+		require.NoError(t, err)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	require.NoError(t, tryToUploadArchive(context.Background(), server.URL, archive, 0, ""))
+	status = http.StatusInternalServerError
+	require.Error(t, tryToUploadArchive(context.Background(), server.URL, archive, 0, ""))
+	status = statusResumeIncomplete
+	_, _, err = queryUploadOffset(context.Background(), server.URL, 7)
+	require.NoError(t, err)
+	status = http.StatusOK
+	require.NoError(t, tryToUploadArchive(context.Background(), server.URL, archive, 3, ""))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 1, connections)
+}
@@ -0,0 +1,111 @@
+// Per-root layer related functions.
+//
+// A cache root is a path of the cache path list (for example ~/.gradle or ./Pods).
+// With root granularity the layered archive tracks a fingerprint per root, and a delta layer
+// contains the whole content of the changed roots only, cache-pull replaces these roots and keeps the rest.
+package cachepush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// LayerGranularity ...
+type LayerGranularity string
+
+const (
+	// FileGranularity ...
+	FileGranularity = LayerGranularity("file")
+	// RootGranularity ...
+	RootGranularity = LayerGranularity("root")
+)
+
+// cacheRoots returns the absolute, glob free roots of the cache paths, sorted.
+// Roots nested into an other root are dropped, so every cached file belongs to exactly one root.
+func cacheRoots(indicatorByPath map[string]string) ([]string, error) {
+	var candidates []string
+	for pth := range indicatorByPath {
+		abs, err := pathutil.AbsPath(pth)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, filepath.Clean(globFreePrefix(abs)))
+	}
+	sort.Strings(candidates)
+
+	var roots []string
+	for _, candidate := range candidates {
+		if rootOf(candidate, roots) != "" {
+			continue
+		}
+		roots = append(roots, candidate)
+	}
+	return roots, nil
+}
+
+func isUnderRoot(pth, root string) bool {
+	return pth == root || strings.HasPrefix(pth, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// rootOf returns the root containing the path, empty if no root contains it.
+func rootOf(pth string, roots []string) string {
+	for _, root := range roots {
+		if isUnderRoot(pth, root) {
+			return root
+		}
+	}
+	return ""
+}
+
+// rootFingerprints returns the fingerprint of every root having cached files, computed from the fingerprints
+// of its files in the descriptor.
+func rootFingerprints(descriptor map[string]string, roots []string) map[string]string {
+	pathsByRoot := map[string][]string{}
+	for pth := range descriptor {
+		if root := rootOf(pth, roots); root != "" {
+			pathsByRoot[root] = append(pathsByRoot[root], pth)
+		}
+	}
+
+	fingerprints := map[string]string{}
+	for root, paths := range pathsByRoot {
+		sort.Strings(paths)
+
+		h := sha256.New()
+		for _, pth := range paths {
+			h.Write([]byte(pth + "\x00" + descriptor[pth] + "\n"))
+		}
+		fingerprints[root] = hex.EncodeToString(h.Sum(nil))
+	}
+	return fingerprints
+}
+
+// changedRoots returns the roots whose fingerprint differs in the previous and current descriptor, sorted.
+func changedRoots(prevDescriptor, curDescriptor map[string]string, roots []string) []string {
+	prev := rootFingerprints(prevDescriptor, roots)
+	cur := rootFingerprints(curDescriptor, roots)
+
+	var changed []string
+	for _, root := range roots {
+		if prev[root] != cur[root] {
+			changed = append(changed, root)
+		}
+	}
+	return changed
+}
+
+// rootDeltaPaths returns the subset of pathToIndicatorPath which belongs to the given roots.
+func rootDeltaPaths(pathToIndicatorPath map[string]string, roots []string) map[string]string {
+	delta := map[string]string{}
+	for pth, indicator := range pathToIndicatorPath {
+		if rootOf(pth, roots) != "" {
+			delta[pth] = indicator
+		}
+	}
+	return delta
+}
@@ -0,0 +1,85 @@
+package cachepush
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_cacheRoots(t *testing.T) {
+	indicatorByPath := map[string]string{
+		"/cache/gradle":          "",
+		"/cache/gradle/caches":   "",
+		"/cache/gradle-wrapper":  "/project/gradle-wrapper.properties",
+		"/cache/pods/**/*.xcf":   "",
+		"/cache/pods/Target/abc": "",
+	}
+
+	got, err := cacheRoots(indicatorByPath)
+	if err != nil {
+		t.Fatalf("cacheRoots() error = %v", err)
+	}
+	want := []string{"/cache/gradle", "/cache/gradle-wrapper", "/cache/pods"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cacheRoots() = %v, want %v", got, want)
+	}
+}
+
+func Test_rootOf(t *testing.T) {
+	roots := []string{"/cache/gradle", "/cache/gradle-wrapper"}
+
+	tests := []struct {
+		pth  string
+		want string
+	}{
+		{pth: "/cache/gradle", want: "/cache/gradle"},
+		{pth: "/cache/gradle/caches/file", want: "/cache/gradle"},
+		{pth: "/cache/gradle-wrapper/file", want: "/cache/gradle-wrapper"},
+		{pth: "/cache/other/file", want: ""},
+	}
+	for _, tt := range tests {
+		if got := rootOf(tt.pth, roots); got != tt.want {
+			t.Errorf("rootOf(%s) = %v, want %v", tt.pth, got, tt.want)
+		}
+	}
+}
+
+func Test_changedRoots(t *testing.T) {
+	roots := []string{"/gradle", "/npm", "/pods"}
+	prev := map[string]string{
+		"/gradle/a": "1",
+		"/gradle/b": "2",
+		"/pods/a":   "1",
+		"/pods/b":   "2",
+	}
+	cur := map[string]string{
+		"/gradle/a": "1",
+		"/gradle/b": "2",
+		"/pods/a":   "1",
+		"/pods/b":   "3",
+		"/npm/a":    "1",
+	}
+
+	want := []string{"/npm", "/pods"}
+	if got := changedRoots(prev, cur, roots); !reflect.DeepEqual(got, want) {
+		t.Errorf("changedRoots() = %v, want %v", got, want)
+	}
+	if got := changedRoots(cur, cur, roots); got != nil {
+		t.Errorf("changedRoots() = %v, want no changes", got)
+	}
+}
+
+func Test_rootDeltaPaths(t *testing.T) {
+	pathToIndicatorPath := map[string]string{
+		"/gradle/a": "/gradle/a",
+		"/pods/a":   "/Podfile.lock",
+		"/pods/b":   "/Podfile.lock",
+	}
+
+	want := map[string]string{
+		"/pods/a": "/Podfile.lock",
+		"/pods/b": "/Podfile.lock",
+	}
+	if got := rootDeltaPaths(pathToIndicatorPath, []string{"/pods"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("rootDeltaPaths() = %v, want %v", got, want)
+	}
+}
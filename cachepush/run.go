@@ -0,0 +1,1099 @@
+// Cache push entry point.
+package cachepush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// Execution modes: prepare computes the cache descriptor early in the workflow,
+// the following push invocation reuses its expanded cache paths, change indicators and content hashes for the unchanged files.
+const (
+	executionModeFull    = "full"
+	executionModePrepare = "prepare"
+	executionModePush    = "push"
+)
+
+// Report summarizes a cache push.
+type Report struct {
+	// Pushed reports whether a new cache archive was uploaded.
+	Pushed bool
+	// SkipReason describes why no cache archive was uploaded.
+	SkipReason string
+	// ArchiveSize is the size of the generated cache archive in bytes.
+	ArchiveSize int64
+	// Duration is the total time of the push.
+	Duration time.Duration
+	// Fingerprint identifies the current cache content, it is the hash of the cache descriptor.
+	Fingerprint string
+	// Paths summarizes the cached files by cache path.
+	Paths []pathSummary
+	// Changes is the number of changed files since the previous cache, nil if there is no previous cache.
+	Changes *changeSummary
+	// ContentSize is the size of the files written into the archive.
+	ContentSize int64
+	// CacheHit reports whether the cache-pull step restored the cache.
+	CacheHit bool
+	// Phases are the durations of the step phases.
+	Phases map[string]time.Duration
+	// UploadError describes why the archive upload failed, if the failure did not fail the step.
+	UploadError string
+	// SecretFindings are the cached files looking like credentials, reported by the secrets scan.
+	SecretFindings []secretFinding
+}
+
+// addTiming records the phase's duration in the report and in the cache group's diagnostics.
+func (r *Report) addTiming(diag groupDiagnostics, phase string, d time.Duration) {
+	if r.Phases == nil {
+		r.Phases = map[string]time.Duration{}
+	}
+	r.Phases[phase] = d
+	diag.addTiming(phase, d)
+}
+
+// Run pushes the cache described by the given config.
+// Cancelling the context aborts the running phase (walking, hashing, archiving or uploading).
+// The working directory, the HTTP and authentication settings and the log redaction are set up from the config,
+// the runtime settings tuned by the memory budget are restored when the push finishes.
+func Run(ctx context.Context, configs Config) (Report, error) {
+	ctx, restore, err := startRun(ctx, configs)
+	if err != nil {
+		return Report{}, err
+	}
+	defer restore()
+
+	return runCache(ctx, configs, "")
+}
+
+// runCache pushes the cache of the given group, the group is empty for the default cache.
+func runCache(ctx context.Context, configs Config, group string) (Report, error) {
+	env := envOf(ctx)
+	architecture, translated := detectArchitecture()
+	stepStartedAt := time.Now()
+
+	var report Report
+
+	groupDiag := diag.forGroup(group)
+	if configs.CollectDiagnostics {
+		diag.enable(configs.DeployDir, logSecrets(configs))
+		groupDiag.add("config", configs.redacted())
+		groupDiag.add("architecture", architecture)
+		groupDiag.add("rosetta", translated)
+	}
+	if translated {
+		log.Warnf("The step runs translated by Rosetta, using the machine's architecture: %s", architecture)
+	}
+
+	hit, err := readCacheHit(cachecommon.GroupPath(env.workPath(cachePullMarkerFileName), group), cachecommon.GroupPath(env.workPath(cacheInfoFileName), group))
+	if err != nil {
+		log.Warnf("Failed to read the cache pull result, assuming a cache miss: %s", err)
+	}
+	report.CacheHit = hit
+	if hit {
+		log.Printf("The cache was restored by the cache-pull step (cache hit)")
+	}
+	if configs.PushOnlyOnCacheMiss && hit {
+		log.Donef("Skipping push: the cache was restored, it is only pushed on a cache miss")
+		report.SkipReason = "cache hit"
+		return report, nil
+	}
+
+	if reason := pushSkipReason(newBuildInfo(), parseBranchPatterns(configs.PushOnBranches), configs.SkipOnPullRequests); reason != "" {
+		log.Warnf("Skipping push: %s", reason)
+		report.SkipReason = reason
+		return report, nil
+	}
+
+	encryptionKey, err := parseEncryptionKey(string(configs.EncryptionKey))
+	if err != nil {
+		return report, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	unusedFileMaxAge, err := parseUnusedFileMaxAge(configs.UnusedFileMaxAge)
+	if err != nil {
+		return report, err
+	}
+	neverEvictPatterns, err := parseNeverEvictList(strings.Split(configs.NeverEvictPaths, "\n"))
+	if err != nil {
+		return report, fmt.Errorf("failed to parse never evict list: %w", err)
+	}
+
+	cacheKey, err := resolveCacheKey(configs.CacheKey, newCacheKeyData(configs.StackID, architecture, group))
+	if err != nil {
+		return report, fmt.Errorf("invalid cache key: %w", err)
+	}
+	if cacheKey != "" {
+		log.Printf("Cache key: %s", cacheKey)
+	}
+
+	budget := memoryBudget{maxMB: configs.MaxMemoryMB}
+	if budget.limited() {
+		log.Debugf("Memory budget: %d MB, copy buffer size: %d bytes", budget.maxMB, budget.copyBufferSize())
+	}
+
+	caps := probeCapabilities(env.workDir, requiredTools(configs)...)
+	log.Printf("Capabilities: %s", caps)
+	groupDiag.add("capabilities", caps.degraded())
+
+	stats, err := readPhaseStats(cachecommon.GroupPath(env.workPath(phaseStatsFileName), group))
+	if err != nil {
+		log.Warnf("Failed to read previous phase stats: %s", err)
+		stats = phaseStats{History: map[string][]float64{}}
+	}
+
+	// Cleaning paths
+	startTime := time.Now()
+
+	log.Infof("Cleaning paths")
+
+	stateKey, err := preparedStateKey(configs)
+	if err != nil {
+		return report, fmt.Errorf("failed to identify the inputs: %w", err)
+	}
+
+	pathToIndicatorPath := parseIncludeList(strings.Split(configs.Paths, "\n"))
+
+	if configs.DetectCommonCaches {
+		wd, err := os.Getwd()
+		if err != nil {
+			return report, err
+		}
+		detected, err := detectCommonCaches(wd, pathToIndicatorPath)
+		if err != nil {
+			return report, fmt.Errorf("failed to detect common caches: %w", err)
+		}
+		if len(detected) > 0 {
+			log.Printf("Detected %d common cache paths:", len(detected))
+			for _, item := range detected {
+				log.Printf("- %s", item)
+			}
+			configs.Paths += "\n" + strings.Join(detected, "\n")
+			pathToIndicatorPath = parseIncludeList(strings.Split(configs.Paths, "\n"))
+		}
+	}
+
+	volumes, err := parseDockerVolumes(strings.Split(configs.DockerVolumes, "\n"))
+	if err != nil {
+		return report, fmt.Errorf("failed to parse docker volume list: %w", err)
+	}
+	if len(volumes) > 0 && !caps.hasTool("docker") {
+		log.Warnf("Docker is not installed, the %d docker volumes are not cached", len(volumes))
+		volumes = nil
+	}
+
+	var volumeManifest, volumeIndicators map[string]string
+	if len(volumes) > 0 {
+		log.Printf("Exporting %d docker volumes", len(volumes))
+
+		volumeManifest, volumeIndicators, err = exportDockerVolumes(ctx, volumes, env.workPath(dockerVolumesDirName))
+		if err != nil {
+			return report, fmt.Errorf("failed to export docker volumes: %w", err)
+		}
+		// the exported file's indicator is replaced by its content hash in the descriptor
+		for _, pth := range volumeManifest {
+			pathToIndicatorPath[pth] = ""
+		}
+	}
+
+	roots, err := cacheRoots(pathToIndicatorPath)
+	if err != nil {
+		return report, fmt.Errorf("failed to determine cache roots: %w", err)
+	}
+
+	if len(pathToIndicatorPath) == 0 {
+		log.Warnf("No path to cache, skip caching...")
+		report.SkipReason = "no path to cache"
+		return report, nil
+	}
+
+	prevStack, err := cachecommon.ReadArchiveInfo(cachecommon.GroupPath(env.workPath(stackVersionsFileName), group))
+	if err != nil {
+		return report, fmt.Errorf("failed to read previous archive info: %w", err)
+	}
+
+	// the metadata of a cache written by a newer, incompatible step version can not be interpreted
+	prevCompatible := true
+	if err := cachecommon.CheckCompatibility(prevStack, stepID, model.Version, cachecommon.SupportedFeatures()); err != nil {
+		log.Warnf("The previous cache's metadata is ignored, the cache is regenerated: %s", err)
+		prevCompatible = false
+		prevStack = nil
+	}
+
+	restoreKeys, err := resolveRestoreKeys(configs.RestoreKeys, newCacheKeyData(configs.StackID, architecture, group))
+	if err != nil {
+		return report, err
+	}
+
+	// restoredFrom is the restore key the previous cache was found by, the cache is pushed with the exact key then.
+	// The descriptor of a previous cache with an unrelated key is not compared.
+	var restoredFrom string
+	prevRelated := true
+	if len(restoreKeys) > 0 && prevStack != nil {
+		match, restoreKey := matchCacheKey(prevStack.CacheKey, cacheKey, restoreKeys)
+		groupDiag.add("cache_key_match", match)
+		switch match {
+		case restoreKeyMatch:
+			log.Printf("The previous cache (key: %s) was restored by the %s restore key, the cache is pushed with the exact key", prevStack.CacheKey, restoreKey)
+			restoredFrom = restoreKey
+		case noKeyMatch:
+			log.Warnf("The previous cache (key: %s) matches neither the cache key nor the restore keys, it is not compared", prevStack.CacheKey)
+			prevRelated = false
+		}
+	}
+
+	var prevMeta model.CacheMeta
+	if prevCompatible {
+		prevMeta, err = cachecommon.ReadCacheMeta(cachecommon.GroupPath(env.workPath(cacheMetaFileName), group))
+		if err != nil {
+			return report, fmt.Errorf("failed to read previous cache meta: %w", err)
+		}
+	}
+
+	var failures *pathFailures
+	if configs.SkipFailingPathsAfter > 0 {
+		failures = newPathFailures(prevMeta, configs.SkipFailingPathsAfter)
+	}
+
+	readers := configs.ReaderConcurrency
+	if readers == 0 {
+		storageTypes := detectStorageTypes(pathToIndicatorPath)
+		logStorageTypes(storageTypes)
+
+		types := make([]storageType, 0, len(storageTypes))
+		for _, t := range storageTypes {
+			types = append(types, t)
+		}
+		readers = readerConcurrency(types, runtime.NumCPU())
+		log.Printf("Parallel file readers: %d (tuned to the storage of the cache paths)", readers)
+	} else {
+		log.Printf("Parallel file readers: %d", readers)
+	}
+	groupDiag.add("reader_concurrency", readers)
+
+	var prepared preparedState
+	if configs.ExecutionMode == executionModePush {
+		if state, err := readPreparedState(cachecommon.GroupPath(env.workPath(preparedStateFileName), group), stateKey); err != nil {
+			log.Warnf("Failed to read the prepared state, the cache paths are walked: %s", err)
+		} else if state != nil {
+			prepared = *state
+		}
+	}
+	preparedItems, preparedReadonlyItems := prepared.Items, prepared.ReadonlyItems
+	large := newLargeFiles(int64(configs.MaxFileSizeMB) * 1024 * 1024)
+	if large != nil {
+		// the large files are only detected while walking the cache paths
+		preparedItems, preparedReadonlyItems = nil, nil
+	}
+
+	hygiene := newGradleHygiene(configs.GradleCacheHygiene)
+	state := preparedState{Key: stateKey}
+	pathToIndicatorPath, state.Items, err = expandCachePaths(ctx, pathToIndicatorPath, preparedItems, failures, large, hygiene)
+	if err != nil {
+		return report, fmt.Errorf("failed to parse include list: %w", err)
+	}
+	large.logSkipped()
+	groupDiag.add("large_files", large.skipped())
+	hygiene.logSkipped()
+	groupDiag.add("gradle_hygiene_excluded", hygiene.skipped())
+
+	readonlyPathToIndicatorPath := map[string]string{}
+	if strings.TrimSpace(configs.ReadonlyPaths) != "" {
+		readonlyPathToIndicatorPath, state.ReadonlyItems, err = expandCachePaths(ctx, parseIncludeList(strings.Split(configs.ReadonlyPaths, "\n")), preparedReadonlyItems, failures, nil, nil)
+		if err != nil {
+			return report, fmt.Errorf("failed to parse read-only list: %w", err)
+		}
+		pathToIndicatorPath, _ = splitByPaths(pathToIndicatorPath, readonlyPathToIndicatorPath)
+		log.Printf("%d read-only paths are not uploaded", len(readonlyPathToIndicatorPath))
+	}
+
+	// the exclude patterns attached to the cache paths take precedence over the global ignore list
+	ignoreList := append(parseIgnoreList(strings.Split(configs.IgnoredPaths, "\n")), parseScopedExcludes(strings.Split(configs.Paths, "\n"))...)
+	if configs.IOSExclusionProfile {
+		ignoreList = withIOSExclusionProfile(ignoreList)
+	}
+	ignorePatterns, err := normalizeIgnorePatterns(ignoreList)
+	if err != nil {
+		return report, fmt.Errorf("failed to parse ignore list: %w", err)
+	}
+
+	logIgnorePatterns(ignorePatterns)
+
+	pathToIndicatorPath = interleave(pathToIndicatorPath, ignorePatterns)
+
+	pathToIndicatorPath, autoExcluded := autoExclude(pathToIndicatorPath, parseAutoExcludeList(strings.Split(configs.AutoExcludePatterns, "\n")))
+	if len(autoExcluded) > 0 {
+		log.Printf("%d files excluded automatically", len(autoExcluded))
+		for _, pth := range autoExcluded {
+			log.Debugf("- %s", pth)
+		}
+	}
+	groupDiag.add("auto_excluded", autoExcluded)
+
+	if configs.ExcludeGitignoredFiles {
+		var gitignored []string
+		pathToIndicatorPath, gitignored, err = excludeGitignored(pathToIndicatorPath, roots)
+		if err != nil {
+			return report, fmt.Errorf("failed to read gitignore files: %w", err)
+		}
+		log.Printf("%d git ignored files excluded", len(gitignored))
+		for _, pth := range gitignored {
+			log.Debugf("- %s", pth)
+		}
+		groupDiag.add("gitignored", gitignored)
+	}
+
+	if policy := SecretsScanPolicy(configs.SecretsScan); policy == SecretsScanWarn || policy == SecretsScanExclude {
+		findings, err := scanForSecrets(pathToIndicatorPath)
+		if err != nil {
+			return report, fmt.Errorf("failed to scan the cached files for secrets: %w", err)
+		}
+		logSecretFindings(findings, policy)
+		if policy == SecretsScanExclude {
+			pathToIndicatorPath = excludeSecrets(pathToIndicatorPath, findings)
+		}
+		report.SecretFindings = findings
+		groupDiag.add("secret_findings", findings)
+	}
+
+	lastUsedTime := newAccessTimeProvider(caps.atime, atimeFallback(configs.AtimeFallback))
+	if (unusedFileMaxAge > 0 || configs.TargetCacheSizeMB > 0) && caps.atime == atimeNone {
+		if lastUsedTime == nil {
+			log.Warnf("File access times are not updated on this stack (noatime), the files are not evicted")
+		} else {
+			log.Warnf("File access times are not updated on this stack (noatime), the files are evicted by their %s", configs.AtimeFallback)
+		}
+	}
+
+	if unusedFileMaxAge > 0 && prevMeta != nil && lastUsedTime != nil {
+		var evicted []string
+		pathToIndicatorPath, evicted, err = evictUnusedFiles(pathToIndicatorPath, prevMeta, neverEvictPatterns, lastUsedTime, unusedFileMaxAge, time.Now())
+		if err != nil {
+			return report, fmt.Errorf("failed to evict unused files: %w", err)
+		}
+		summary, err := summarizeEviction(evicted)
+		if err != nil {
+			return report, fmt.Errorf("failed to summarize evicted files: %w", err)
+		}
+		logEvictionSummary(fmt.Sprintf("not read in the last %.0f days", unusedFileMaxAge.Hours()/24), summary, evicted)
+		groupDiag.add("evicted_unused_files", summary)
+	}
+
+	if configs.TargetCacheSizeMB > 0 && lastUsedTime != nil {
+		var evicted []string
+		pathToIndicatorPath, evicted, err = evictLeastRecentlyUsed(pathToIndicatorPath, prevMeta, neverEvictPatterns, lastUsedTime, int64(configs.TargetCacheSizeMB)*1024*1024, time.Now())
+		if err != nil {
+			return report, fmt.Errorf("failed to evict least recently used files: %w", err)
+		}
+		summary, err := summarizeEviction(evicted)
+		if err != nil {
+			return report, fmt.Errorf("failed to summarize evicted files: %w", err)
+		}
+		logEvictionSummary(fmt.Sprintf("least recently used, target cache size: %d MB", configs.TargetCacheSizeMB), summary, evicted)
+		groupDiag.add("evicted_lru_files", summary)
+	}
+
+	if strings.TrimSpace(configs.PreArchiveHook) != "" {
+		log.Printf("Running pre-archive hook")
+
+		hookPatterns, err := runPreArchiveHook(configs.PreArchiveHook, pathToIndicatorPath)
+		if err != nil {
+			return report, fmt.Errorf("cache content rejected: %w", err)
+		}
+
+		var hookExcluded []string
+		pathToIndicatorPath, hookExcluded = applyHookExclusions(pathToIndicatorPath, hookPatterns)
+		log.Printf("%d files excluded by the pre-archive hook", len(hookExcluded))
+		for _, pth := range hookExcluded {
+			log.Debugf("- %s", pth)
+		}
+		groupDiag.add("hook_excluded", hookExcluded)
+	}
+
+	groupDiag.add("ignore_patterns", ignorePatterns)
+	groupDiag.add("indicator_by_cache_path", pathToIndicatorPath)
+
+	for indicator, dependents := range selfInvalidatingIndicators(pathToIndicatorPath) {
+		log.Warnf("Indicator file (%s) of %d cached paths is part of the cache itself.", indicator, dependents)
+		log.Warnf("If the build rewrites it, the cache will be invalidated on every build, consider using an indicator outside of the cached paths.")
+	}
+
+	log.Donef("Done in %s\n", time.Since(startTime))
+	report.addTiming(groupDiag, "clean_paths", time.Since(startTime))
+	stats.checkPhase(phaseWalk, time.Since(startTime))
+
+	report.Paths, err = pathSummaries(pathToIndicatorPath, roots)
+	if err != nil {
+		return report, fmt.Errorf("failed to summarize cache paths: %w", err)
+	}
+
+	if len(pathToIndicatorPath) == 0 {
+		log.Warnf("No path to cache, skip caching...")
+		report.SkipReason = "no path to cache"
+		return report, nil
+	}
+
+	// Check previous cache
+	startTime = time.Now()
+
+	log.Infof("Checking previous cache status")
+
+	var prevDescriptor, prevSourceDescriptor map[string]string
+	if prevCompatible && prevRelated {
+		prevDescriptor, err = cachecommon.ReadDescriptor(cachecommon.GroupPath(env.workPath(cacheInfoFileName), group))
+		if err != nil {
+			return report, fmt.Errorf("failed to read previous cache descriptor: %w", err)
+		}
+	}
+
+	if prevDescriptor != nil {
+		log.Printf("Previous cache info found at: %s", cachecommon.GroupPath(env.workPath(cacheInfoFileName), group))
+		prevDescriptor = cachecommon.LocalDescriptor(prevDescriptor, pathutil.UserHomeDir())
+		prevDescriptor, prevSourceDescriptor = splitSourceFingerprints(prevDescriptor)
+	} else {
+		log.Printf("No previous cache info found")
+	}
+
+	verifyByContent := configs.VerifyModTimeChanges && isModTimeMethod(ChangeIndicator(configs.FingerprintMethodID))
+	auditByContent := configs.AuditSamplePercent > 0 && isModTimeMethod(ChangeIndicator(configs.FingerprintMethodID))
+
+	hashes := newHashCache()
+	if configs.ExecutionMode == executionModePush {
+		hashes, err = loadHashCache(cachecommon.GroupPath(env.workPath(preparedHashesFileName), group))
+		if err != nil {
+			return report, fmt.Errorf("failed to load prepared content hashes: %w", err)
+		}
+	}
+
+	fingerprintOpts := fingerprintOptions{
+		method:       ChangeIndicator(configs.FingerprintMethodID),
+		includeMode:  configs.FingerprintIncludeMode,
+		includeOwner: configs.FingerprintIncludeOwner,
+		readers:      budget.workers(readers, hashWorkerMemory),
+	}
+	// without git the files are hashed the same way as git would hash them
+	if fingerprintOpts.method == GITBLOB && caps.hasTool("git") {
+		fingerprintOpts.git = newGitIndex()
+	}
+	var stamps map[string]fileStamp
+	if configs.ExecutionMode == executionModePrepare {
+		if stamps, err = indicatorStamps(pathToIndicatorPath); err != nil {
+			return report, fmt.Errorf("failed to stat the indicator files: %w", err)
+		}
+	}
+	// the owner is not part of the stamp, the change indicators including it are not reused
+	reusedDescriptor, pending := map[string]string{}, pathToIndicatorPath
+	if len(prepared.Fingerprints) > 0 && !fingerprintOpts.includeOwner {
+		if reusedDescriptor, pending, err = reuseFingerprints(pathToIndicatorPath, prepared.Fingerprints); err != nil {
+			return report, fmt.Errorf("failed to check the prepared change indicators: %w", err)
+		}
+		log.Printf("%d of %d change indicators are reused from the prepare invocation", len(reusedDescriptor), len(pathToIndicatorPath))
+	}
+	curDescriptor, err := cacheDescriptor(ctx, pending, fingerprintOpts, hashes)
+	// the paths of a broken indicator are left out, the rest of the cache is pushed
+	if pathToIndicatorPath, err = withoutFailedPaths(pathToIndicatorPath, err); err != nil {
+		return report, fmt.Errorf("failed to create current cache descriptor: %w", err)
+	}
+	for pth, indicator := range reusedDescriptor {
+		curDescriptor[pth] = indicator
+	}
+	if len(pathToIndicatorPath) == 0 {
+		return report, fmt.Errorf("failed to create current cache descriptor: none of the cache paths could be fingerprinted")
+	}
+	curDescriptor = withDockerVolumeIndicators(curDescriptor, volumeIndicators)
+
+	if len(readonlyPathToIndicatorPath) > 0 {
+		readonlyDescriptor, err := cacheDescriptor(ctx, readonlyPathToIndicatorPath, fingerprintOpts, hashes)
+		if _, err = withoutFailedPaths(readonlyPathToIndicatorPath, err); err != nil {
+			return report, fmt.Errorf("failed to create read-only cache descriptor: %w", err)
+		}
+
+		if prevDescriptor != nil {
+			var prevReadonlyDescriptor map[string]string
+			// a read-only path which could not be fingerprinted is reported as changed
+			prevDescriptor, prevReadonlyDescriptor = splitByPaths(prevDescriptor, readonlyPathToIndicatorPath)
+			changes := readonlyChanges(prevReadonlyDescriptor, readonlyDescriptor)
+			logReadonlyChanges(changes)
+			groupDiag.add("readonly_changes", changes)
+		}
+	}
+	if prevDescriptor != nil {
+		prevDescriptor = alignDescriptorKeys(prevDescriptor, curDescriptor, pathNormalization(configs.PathNormalization).resolve(runtime.GOOS))
+	}
+	sources, err := parseFingerprintSources(configs.FingerprintSources)
+	if err != nil {
+		return report, err
+	}
+	sourceDescriptor, err := sourceFingerprints(ctx, append(envSources(parseEnvVarNames(configs.FingerprintEnvVars)), sources...))
+	if err != nil {
+		return report, err
+	}
+	descriptor := withSourceFingerprints(curDescriptor, sourceDescriptor)
+	report.Fingerprint = descriptorFingerprint(descriptor)
+
+	groupDiag.add("descriptor_stats", descriptorStats(curDescriptor))
+	if prevDescriptor != nil {
+		groupDiag.add("previous_descriptor_stats", descriptorStats(prevDescriptor))
+	}
+
+	log.Donef("Done in %s\n", time.Since(startTime))
+	report.addTiming(groupDiag, "check_previous_cache", time.Since(startTime))
+	stats.checkPhase(phaseHash, time.Since(startTime))
+
+	if configs.ExecutionMode == executionModePrepare {
+		if err := hashes.save(cachecommon.GroupPath(env.workPath(preparedHashesFileName), group)); err != nil {
+			return report, fmt.Errorf("failed to save content hashes: %w", err)
+		}
+		state.Fingerprints = preparedFingerprints(pathToIndicatorPath, curDescriptor, stamps)
+		if err := state.save(cachecommon.GroupPath(env.workPath(preparedStateFileName), group)); err != nil {
+			return report, fmt.Errorf("failed to save the prepared state: %w", err)
+		}
+		if prevDescriptor != nil {
+			log.Printf("Changes found so far: %t", compare(prevDescriptor, curDescriptor).hasChanges() || len(changedSources(prevSourceDescriptor, sourceDescriptor)) > 0)
+		}
+		log.Donef("Prepared, the push invocation will reuse the expanded cache paths and the change indicators of the unchanged files")
+		report.SkipReason = "prepared"
+		return report, nil
+	}
+
+	// Checking file changes
+	var changes *result
+	if prevDescriptor != nil {
+		startTime = time.Now()
+
+		log.Infof("Checking for file changes")
+
+		logDebugPaths := func(paths []string) {
+			for _, pth := range paths {
+				log.Debugf("- %s", pth)
+			}
+		}
+
+		result := compare(prevDescriptor, curDescriptor)
+
+		var prevHashes map[string]string
+		if verifyByContent || auditByContent {
+			prevHashes, err = cachecommon.ReadDescriptor(cachecommon.GroupPath(env.workPath(contentHashesFileName), group))
+			if err != nil {
+				return report, fmt.Errorf("failed to read previous content hashes: %w", err)
+			}
+		}
+
+		if verifyByContent && len(result.changed) > 0 {
+			changedByModTime := len(result.changed)
+			result, err = verifyChangesByContent(result, pathToIndicatorPath, prevHashes, hashes)
+			if err != nil {
+				return report, fmt.Errorf("failed to verify changes by content: %w", err)
+			}
+			log.Printf("%d of %d files changed by mod time have identical content", changedByModTime-len(result.changed), changedByModTime)
+		}
+
+		if auditByContent {
+			if prevHashes == nil {
+				log.Warnf("No previous content hashes found, skipping audit")
+			} else {
+				changedByModTime := len(result.changed)
+				var audited int
+				result, audited, err = auditMatching(result, pathToIndicatorPath, prevHashes, hashes, configs.AuditSamplePercent, rand.New(rand.NewSource(time.Now().UnixNano())))
+				if err != nil {
+					return report, fmt.Errorf("failed to audit unchanged files: %w", err)
+				}
+
+				mismatching := len(result.changed) - changedByModTime
+				if mismatching > 0 {
+					log.Warnf("Audit: %d unchanged files (by mod time) have different content:", mismatching)
+					for _, pth := range result.changed[changedByModTime:] {
+						log.Warnf("- %s", pth)
+					}
+				} else {
+					log.Printf("Audit: %d sampled indicators match their previous content", audited)
+				}
+				groupDiag.add("audit", map[string]int{"audited": audited, "mismatching": mismatching})
+			}
+		}
+		changes = &result
+		report.Changes = &changeSummary{Changed: len(result.changed), Added: len(result.added), Removed: len(result.removed)}
+		report.addTiming(groupDiag, "check_file_changes", time.Since(startTime))
+		groupDiag.add("changes", map[string]int{
+			"removed":         len(result.removed),
+			"changed":         len(result.changed),
+			"added":           len(result.added),
+			"removed_ignored": len(result.removedIgnored),
+			"matching":        len(result.matching),
+			"added_ignored":   len(result.addedIgnored),
+		})
+
+		logChanges("%d files need to be removed", result.removed, configs.MaxChangeLogEntries)
+		logChanges("%d files have changed", result.changed, configs.MaxChangeLogEntries)
+		logChanges("%d files added", result.added, configs.MaxChangeLogEntries)
+		log.Debugf("%d ignored files removed", len(result.removedIgnored))
+		logDebugPaths(result.removedIgnored)
+		log.Debugf("%d files did not change", len(result.matching))
+		logDebugPaths(result.matching)
+		log.Debugf("%d ignored files added", len(result.addedIgnored))
+		logDebugPaths(result.addedIgnored)
+		// the matching paths are only logged, they are as many as the cached files
+		result.matching = nil
+
+		stackDiff := stackChanges(prevStack, model.ArchiveInfo{StackID: configs.StackID, OS: runtime.GOOS, Architecture: architecture})
+		if len(stackDiff) > 0 {
+			log.Warnf("The stack has changed since the previous cache:")
+			for _, change := range stackDiff {
+				log.Warnf("- %s", change)
+			}
+			groupDiag.add("stack_changes", stackDiff)
+		}
+
+		sourceChanges := changedSources(prevSourceDescriptor, sourceDescriptor)
+		if len(sourceChanges) > 0 {
+			log.Warnf("%d fingerprint sources have changed:", len(sourceChanges))
+			for _, key := range sourceChanges {
+				log.Warnf("- %s", key)
+			}
+			groupDiag.add("source_changes", sourceChanges)
+		}
+
+		if result.hasChanges() || len(sourceChanges) > 0 || len(stackDiff) > 0 {
+			log.Donef("File changes found in %s\n", time.Since(startTime))
+		} else if configs.ForceCachePush {
+			log.Warnf("No changes found in %s, pushing the cache anyway (force push)\n", time.Since(startTime))
+		} else if restoredFrom != "" {
+			log.Donef("No changes found in %s, pushing the cache with the exact key\n", time.Since(startTime))
+		} else {
+			log.Donef("No files found in %s\n", time.Since(startTime))
+			log.Printf("Total time: %s", time.Since(stepStartedAt))
+			report.SkipReason = "no changes"
+			report.Duration = time.Since(stepStartedAt)
+			return report, nil
+		}
+	}
+
+	// the previous descriptor is only needed to find the replaced roots of a layered cache
+	if ArchiveMode(configs.ArchiveMode) != LayeredArchive || LayerGranularity(configs.LayerGranularity) != RootGranularity {
+		prevDescriptor = nil
+	}
+	budget.release()
+
+	// Generate cache archive
+	startTime = time.Now()
+	archiveStartedAt := startTime
+
+	log.Infof("Generating cache archive")
+	logPathSummaries(report.Paths)
+
+	archivePth := cachecommon.GroupPath(env.workPath(cacheArchiveFileName), group)
+	features := []cachecommon.Feature{cachecommon.FeatureCompressedMetadata, cachecommon.FeatureCanonicalPaths}
+	if encryptionKey != nil {
+		features = append(features, cachecommon.FeatureEncryption)
+	}
+	if ArchiveMode(configs.ArchiveMode) == LayeredArchive {
+		features = append(features, cachecommon.FeatureLayers)
+	}
+	archiveInfo := cachecommon.NewArchiveInfo(features)
+	archiveInfo.StackID = configs.StackID
+	archiveInfo.Architecture = architecture
+	archiveInfo.OS = runtime.GOOS
+	archiveInfo.Encryption = encryptionInfo(encryptionKey)
+	archiveInfo.CacheKey = cacheKey
+	archiveInfo.Group = group
+	stackData, err := cachecommon.EncodeArchiveInfo(archiveInfo)
+	if err != nil {
+		return report, fmt.Errorf("failed to get stack version info: %w", err)
+	}
+
+	archiverSettings := newArchiverSettings(configs.ArchiverWorkers, readers, configs.ArchiverQueueSize, configs.ArchiverBlockSizeKB*1024, budget)
+	log.Debugf("Archiver: %s, %d readers, queue size: %d, block size: %d bytes", configs.Archiver, archiverSettings.workers, archiverSettings.queueSize, archiverSettings.blockSize)
+
+	content := archiveContent{
+		stackData:           stackData,
+		pathToIndicatorPath: pathToIndicatorPath,
+		descriptor:          descriptor,
+		encryptionKey:       encryptionKey,
+		group:               group,
+		workDir:             env.workDir,
+		reproducible:        configs.ReproducibleArchive,
+		archiver:            archiverKind(configs.Archiver),
+		archiverSettings:    archiverSettings,
+		trailer: func() (archiveMetadata, error) {
+			// the archive phase's duration is recorded before the archive is closed
+			archiveStats := stats.copy()
+			if !configs.StreamUpload {
+				// in streaming mode the archive phase includes the upload
+				archiveStats.record(phaseArchive, time.Since(archiveStartedAt))
+			}
+			data, err := json.Marshal(archiveStats)
+			return archiveMetadata{path: cachecommon.GroupPath(env.workPath(phaseStatsFileName), group), data: data}, err
+		},
+	}
+
+	if configs.ReproducibleArchive {
+		// the phase durations differ on every run
+		content.trailer = nil
+		if encryptionKey != nil {
+			log.Warnf("Encrypted archives differ on every run, the archive is not reproducible")
+		}
+	}
+
+	if len(volumeManifest) > 0 {
+		manifestData, err := dockerVolumesManifest(volumeManifest)
+		if err != nil {
+			return report, fmt.Errorf("failed to marshal docker volume manifest: %w", err)
+		}
+		content.metadata = append(content.metadata, archiveMetadata{path: cachecommon.GroupPath(env.workPath(dockerVolumesManifestFileName), group), data: manifestData})
+	}
+
+	if verifyByContent || auditByContent {
+		hashByIndicator, err := indicatorContentHashes(pathToIndicatorPath, hashes)
+		if err != nil {
+			return report, fmt.Errorf("failed to calculate content hashes: %w", err)
+		}
+
+		hashesData, err := json.MarshalIndent(hashByIndicator, "", " ")
+		if err != nil {
+			return report, fmt.Errorf("failed to marshal content hashes: %w", err)
+		}
+		content.metadata = append(content.metadata, archiveMetadata{path: cachecommon.GroupPath(env.workPath(contentHashesFileName), group), data: hashesData})
+	}
+
+	now := time.Now()
+	meta, err := updateCacheMeta(prevMeta, pathToIndicatorPath, configs.BuildSlug, now)
+	if err != nil {
+		return report, fmt.Errorf("failed to update cache meta: %w", err)
+	}
+
+	if prevMeta != nil && caps.reliableAtime() {
+		log.Printf("Oldest cache entries:")
+		for _, pth := range oldestCacheEntries(meta, maxCacheMetaReportEntries) {
+			entry := meta[pth]
+			log.Printf("- %s (since %s, build: %s)", pth, time.Unix(entry.FirstSeenAt, 0).Format(time.RFC3339), entry.FirstSeenBuild)
+		}
+
+		neverAccessed := neverAccessedCacheEntries(meta, now)
+		if len(neverAccessed) > 0 {
+			log.Warnf("%d cache entries were never read since they were added, consider removing them from the cache:", len(neverAccessed))
+			for i, pth := range neverAccessed {
+				if i == maxCacheMetaReportEntries {
+					log.Warnf("- ... and %d more", len(neverAccessed)-maxCacheMetaReportEntries)
+					break
+				}
+				log.Warnf("- %s", pth)
+			}
+		}
+		groupDiag.add("never_accessed_entries", neverAccessed)
+	}
+
+	if failures != nil {
+		failures.record(meta)
+		if skipped := failures.skipped(); len(skipped) > 0 {
+			log.Debugf("%d skip-listed paths are left out of the cache", len(skipped))
+			groupDiag.add("skip_listed_paths", skipped)
+		}
+	}
+
+	metaData, err := cachecommon.EncodeCacheMeta(meta)
+	if err != nil {
+		return report, fmt.Errorf("failed to marshal cache meta: %w", err)
+	}
+	if !configs.ReproducibleArchive {
+		// the cache meta records the time of the push
+		content.metadata = append(content.metadata, archiveMetadata{path: cachecommon.GroupPath(env.workPath(cacheMetaFileName), group), data: metaData})
+	}
+
+	var layerID string
+	if ArchiveMode(configs.ArchiveMode) == LayeredArchive {
+		if configs.ForceCachePush && changes != nil {
+			// the remote layers might be missing or corrupted, a new base layer does not depend on them
+			log.Printf("Force push, creating a new base layer")
+			changes = nil
+		}
+		if restoredFrom != "" && changes != nil {
+			// the layers of the restore key's cache are not uploaded with the exact key
+			log.Printf("Restored by a restore key, creating a new base layer")
+			changes = nil
+		}
+		prevLayers, err := readLayerInfo(cachecommon.GroupPath(env.workPath(cacheLayersFileName), group))
+		if err != nil {
+			return report, fmt.Errorf("failed to read previous cache layer info: %w", err)
+		}
+
+		policy := layerPolicy{
+			maxDeltaCount: configs.LayerMaxDeltaCount,
+			maxDeltaSize:  int64(configs.LayerMaxDeltaSizeMB) * 1024 * 1024,
+			maxAge:        time.Duration(configs.LayerMaxAgeDays) * 24 * time.Hour,
+		}
+
+		var replacedRoots []string
+		if LayerGranularity(configs.LayerGranularity) == RootGranularity && changes != nil {
+			replacedRoots = changedRoots(prevDescriptor, curDescriptor, roots)
+			if replacedRoots == nil {
+				replacedRoots = []string{}
+			}
+			log.Printf("%d of %d cache roots changed", len(replacedRoots), len(roots))
+			for _, root := range replacedRoots {
+				log.Debugf("- %s", root)
+			}
+			groupDiag.add("changed_roots", replacedRoots)
+		}
+
+		layers, paths, err := nextLayer(prevLayers, pathToIndicatorPath, changes, replacedRoots, policy, now)
+		if err != nil {
+			return report, fmt.Errorf("failed to determine the next cache layer: %w", err)
+		}
+
+		layerData, err := json.Marshal(layers)
+		if err != nil {
+			return report, fmt.Errorf("failed to marshal cache layer info: %w", err)
+		}
+
+		layer := layers.Layers[len(layers.Layers)-1]
+		layerID = layer.ID
+		if layer.Base {
+			log.Printf("Creating base layer: %s", layerID)
+		} else {
+			log.Printf("Creating delta layer: %s (%d files, %d removed)", layerID, len(paths), len(layer.Removed))
+		}
+
+		content.metadata = append(content.metadata, archiveMetadata{path: cachecommon.GroupPath(env.workPath(cacheLayersFileName), group), data: layerData})
+		content.pathToIndicatorPath = paths
+	}
+
+	if configs.DryRun {
+		size, err := logDryRun(content.pathToIndicatorPath)
+		if err != nil {
+			return report, err
+		}
+		groupDiag.add("dry_run_content_size", size)
+		log.Donef("Dry run, skipping the archive creation and the upload")
+		report.SkipReason = "dry run"
+		report.Duration = time.Since(stepStartedAt)
+		return report, nil
+	}
+
+	endpoints := groupEndpoints(parseEndpoints(configs.CacheAPIURL), group)
+	compress := configs.CompressArchive == "true"
+
+	report.ContentSize, err = contentSize(content.pathToIndicatorPath)
+	if err != nil {
+		return report, fmt.Errorf("failed to calculate cache content size: %w", err)
+	}
+
+	sizeLimit := int64(configs.MaxCacheSizeMB) * 1024 * 1024
+	sizeLimitPolicy := QuotaPolicy(configs.CacheSizeLimitPolicy)
+	// an uncompressed archive is never smaller than its content, so the push can be skipped before archiving
+	if !compress && (configs.StreamUpload || !caps.needsCompression(report.ContentSize)) {
+		skip, err := checkSizeLimit(report.ContentSize, sizeLimit, true, sizeLimitPolicy)
+		if err != nil {
+			return report, err
+		}
+		if skip {
+			report.SkipReason = "cache size limit exceeded"
+			report.Duration = time.Since(stepStartedAt)
+			return report, nil
+		}
+	}
+
+	var archiveSize int64
+	if configs.StreamUpload {
+		// the archive is generated while uploading it, its uncompressed content size is the estimated size
+		archiveSize = report.ContentSize
+		log.Printf("The archive will be streamed, estimated size: %d bytes", archiveSize)
+	} else {
+		if !compress && caps.needsCompression(report.ContentSize) {
+			log.Warnf("Cache content (%d bytes) does not fit on the disk (%d bytes free), compressing the archive", report.ContentSize, caps.freeDiskSpace)
+			compress = true
+		}
+		if err := checkDiskSpace(archivePth, caps.freeDiskSpace, estimateArchiveSize(content, report.ContentSize, compress)); err != nil {
+			return report, err
+		}
+
+		archiveTimeout := secondsToDuration(configs.ArchiveTimeout)
+		archiveCtx, cancelArchive := withTimeout(ctx, archiveTimeout)
+		retried, modifiedSkipped, err := createConsistentArchive(archiveCtx, archivePth, compress, budget, content, modifiedFilePolicy(configs.ModifiedFilePolicy), configs.ModifiedFileRetries)
+		err = timeoutError(ctx, archiveCtx, err, "archive generation", archiveTimeout)
+		cancelArchive()
+		if len(retried) > 0 {
+			log.Warnf("%d files were retried due to transient filesystem errors:", len(retried))
+			for _, pth := range retried {
+				log.Warnf("- %s", pth)
+			}
+		}
+		if len(modifiedSkipped) > 0 {
+			log.Warnf("%d files were left out of the cache as they were modified while archiving them", len(modifiedSkipped))
+		}
+		groupDiag.add("modified_skipped", modifiedSkipped)
+		if err != nil {
+			return report, fmt.Errorf("failed to generate cache archive: %w", err)
+		}
+
+		hashed, reused := hashes.stats()
+		log.Debugf("%d files hashed, %d hashes reused", hashed, reused)
+
+		archiveFileInfo, err := os.Stat(archivePth)
+		if err != nil {
+			return report, fmt.Errorf("failed to get cache archive size: %w", err)
+		}
+		archiveSize = archiveFileInfo.Size()
+
+		skip, err := checkSizeLimit(archiveSize, sizeLimit, false, sizeLimitPolicy)
+		if err != nil {
+			return report, err
+		}
+		if skip {
+			report.SkipReason = "cache size limit exceeded"
+			report.Duration = time.Since(stepStartedAt)
+			return report, nil
+		}
+
+		archiveInfo.Checksum, archiveInfo.ContentMD5, err = fileDigests(archivePth)
+		if err != nil {
+			return report, fmt.Errorf("failed to calculate cache archive checksum: %w", err)
+		}
+		log.Debugf("Archive checksum (SHA-256): %s", archiveInfo.Checksum)
+
+		if configs.VerifyArchive {
+			verification, err := verifyArchive(archivePth, content.without(modifiedSkipped))
+			if err != nil {
+				return report, fmt.Errorf("cache archive verification failed: %w", err)
+			}
+			log.Printf("Archive verified: %d entries, %s of content", verification.entries, formatSize(verification.size))
+		}
+
+		if configs.SimulatePullSampleSize > 0 {
+			sample, err := sampleRegularFiles(content.pathToIndicatorPath, configs.SimulatePullSampleSize, rand.New(rand.NewSource(time.Now().UnixNano())))
+			if err != nil {
+				return report, fmt.Errorf("failed to sample cached files: %w", err)
+			}
+
+			mismatching, err := simulatePull(archivePth, sample, hashes, encryptionKey)
+			if err != nil {
+				return report, fmt.Errorf("failed to simulate cache pull: %w", err)
+			}
+			if len(mismatching) > 0 {
+				for _, mismatch := range mismatching {
+					log.Errorf("- %s", mismatch)
+				}
+				return report, fmt.Errorf("%d of %d sampled files would not be restored correctly", len(mismatching), len(sample))
+			}
+			log.Printf("Simulated cache pull: %d sampled files restored correctly", len(sample))
+		}
+
+		log.Donef("Done in %s\n", time.Since(startTime))
+		report.addTiming(groupDiag, "generate_archive", time.Since(startTime))
+		stats.checkPhase(phaseArchive, time.Since(startTime))
+	}
+	report.ArchiveSize = archiveSize
+
+	// Check storage quota
+	var quota *quotaInfo
+	if QuotaPolicy(configs.QuotaPolicy) != QuotaPolicyNone {
+		startTime = time.Now()
+
+		log.Infof("Checking cache storage quota")
+
+		quota, err = queryQuotaWithFailover(ctx, endpoints)
+		if err != nil {
+			log.Warnf("Failed to query cache storage quota: %s", err)
+		} else if quota == nil {
+			log.Printf("The cache API does not report storage quota")
+		} else {
+			log.Printf("Storage used: %d of %d bytes", quota.UsedBytes, quota.QuotaBytes)
+
+			skip, err := checkQuota(*quota, archiveSize, QuotaPolicy(configs.QuotaPolicy))
+			if err != nil {
+				return report, fmt.Errorf("storage quota exceeded: %w", err)
+			}
+			if skip {
+				if err := exportOutput(remainingQuotaEnvKey, fmt.Sprintf("%d", quota.remaining(0))); err != nil {
+					log.Warnf("Failed to export %s: %s", remainingQuotaEnvKey, err)
+				}
+				report.SkipReason = "storage quota exceeded"
+				report.Duration = time.Since(stepStartedAt)
+				return report, nil
+			}
+		}
+
+		log.Donef("Done in %s\n", time.Since(startTime))
+		report.addTiming(groupDiag, "check_quota", time.Since(startTime))
+	}
+
+	// Upload cache archive
+	startTime = time.Now()
+
+	uploadTimeout := secondsToDuration(configs.UploadTimeout)
+	uploadCtx, cancelUpload := withTimeout(ctx, uploadTimeout)
+	defer cancelUpload()
+	retry := uploadRetry{count: configs.UploadRetryCount, backoff: time.Duration(configs.UploadRetryBackoff * float64(time.Second))}
+
+	if configs.StreamUpload {
+		log.Infof("Streaming cache archive")
+
+		err = streamArchiveWithFailover(uploadCtx, endpoints, configs.BuildSlug, layerID, archiveInfo, archiveSize, compress, budget, content, retry)
+	} else {
+		log.Infof("Uploading cache archive")
+
+		if configs.ChunkedUpload {
+			archiveInfo.Chunked = true
+			if compress || encryptionKey != nil {
+				log.Warnf("Compressed or encrypted archives change completely between builds, chunked upload can not deduplicate them")
+			}
+		}
+
+		err = uploadArchiveWithFailover(uploadCtx, archivePth, endpoints, configs.BuildSlug, layerID, archiveInfo, retry)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to upload archive: %w", timeoutError(ctx, uploadCtx, err, "upload", uploadTimeout))
+		if configs.FailOnUploadError || ctx.Err() != nil {
+			return report, err
+		}
+
+		fmt.Println()
+		log.Warnf("!!! The cache was NOT pushed: %s", err)
+		log.Warnf("!!! The build continues as fail_on_upload_error is disabled, the next build starts with the previous cache.")
+		fmt.Println()
+		report.SkipReason = "upload failed"
+		report.UploadError = err.Error()
+		report.Duration = time.Since(stepStartedAt)
+		return report, nil
+	}
+	// in streaming mode the upload phase includes the archive
+	stats.checkPhase(phaseUpload, time.Since(startTime))
+	if configs.UploadMetadataSidecar {
+		var sidecarMetadata []archiveMetadata
+		if content.trailer != nil {
+			data, err := json.Marshal(stats)
+			if err != nil {
+				return report, fmt.Errorf("failed to encode phase stats: %w", err)
+			}
+			sidecarMetadata = append(sidecarMetadata, archiveMetadata{path: cachecommon.GroupPath(env.workPath(phaseStatsFileName), group), data: data})
+		}
+		if err := uploadMetadataSidecar(uploadCtx, endpoints, configs.BuildSlug, layerID, archiveInfo, budget, content, sidecarMetadata, retry); err != nil {
+			// the cache is usable without the sidecar
+			log.Warnf("Failed to upload metadata sidecar: %s", err)
+		} else {
+			log.Printf("Metadata sidecar uploaded")
+		}
+	}
+	if quota != nil {
+		if err := exportOutput(remainingQuotaEnvKey, fmt.Sprintf("%d", quota.remaining(archiveSize))); err != nil {
+			log.Warnf("Failed to export %s: %s", remainingQuotaEnvKey, err)
+		}
+	}
+	log.Donef("Done in %s\n", time.Since(startTime))
+	report.addTiming(groupDiag, "upload_archive", time.Since(startTime))
+	log.Donef("Total time: %s", time.Since(stepStartedAt))
+	report.addTiming(groupDiag, "total", time.Since(stepStartedAt))
+
+	report.Pushed = true
+	report.Duration = time.Since(stepStartedAt)
+	return report, nil
+}
@@ -0,0 +1,86 @@
+// Per-run environment related functions.
+//
+// The settings used by every request and intermediate file of a push (the working directory, the HTTP transport,
+// the cache API and ssh authentication and the local backend retention) are built from the Config when the push starts,
+// and travel with the push's context. Pushes embedded in the same process (or running concurrently) do not share them.
+package cachepush
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// runEnv is the environment of a push, see newRunEnv.
+type runEnv struct {
+	// workDir is the directory of the intermediate files.
+	workDir             string
+	transport           *http.Transport
+	cacheAPI            cacheAPIConfig
+	sshAuth             sshAuthConfig
+	localCacheRetention int
+}
+
+// defaultRunEnv is the environment of the helpers called without a push's context, it uses the default settings.
+var defaultRunEnv = &runEnv{workDir: defaultWorkDir(), transport: mustHTTPTransport(httpSettings{})}
+
+// newRunEnv returns the environment of the push configured by the inputs, the working directory is created.
+func newRunEnv(configs Config) (*runEnv, error) {
+	dir, err := resolveWorkDir(configs.WorkingDirectory)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newHTTPTransport(httpSettings{proxyURL: string(configs.ProxyURL), caBundle: configs.TLSCABundle, connectTimeout: secondsToDuration(configs.ConnectTimeout)})
+	if err != nil {
+		return nil, err
+	}
+	api, err := newCacheAPIConfig(string(configs.CacheAPIToken), configs.CacheAPITokenHeader, string(configs.CacheAPIHeaders))
+	if err != nil {
+		return nil, err
+	}
+	return &runEnv{
+		workDir:             dir,
+		transport:           transport,
+		cacheAPI:            api,
+		sshAuth:             newSSHAuthConfig(string(configs.SSHPrivateKey), configs.SSHKnownHosts),
+		localCacheRetention: configs.LocalCacheRetention,
+	}, nil
+}
+
+// workPath returns the path of the intermediate file in the working directory.
+func (e *runEnv) workPath(name string) string {
+	return filepath.Join(e.workDir, name)
+}
+
+type runEnvKey struct{}
+
+// withRunEnv returns the context of the push using the environment.
+func withRunEnv(ctx context.Context, env *runEnv) context.Context {
+	return context.WithValue(ctx, runEnvKey{}, env)
+}
+
+// envOf returns the environment of the push, the default one if the context does not belong to a push.
+func envOf(ctx context.Context) *runEnv {
+	if env, ok := ctx.Value(runEnvKey{}).(*runEnv); ok {
+		return env
+	}
+	return defaultRunEnv
+}
+
+// startRun prepares a push configured by the inputs: it returns the push's context with its environment,
+// adds the secret inputs to the redacted log values and applies the memory budget.
+// The returned function restores the runtime settings changed for the push.
+func startRun(ctx context.Context, configs Config) (context.Context, func(), error) {
+	env, err := newRunEnv(configs)
+	if err != nil {
+		return nil, nil, ConfigError(err)
+	}
+
+	stepLog.addSecrets(logSecrets(configs))
+	log.SetOutWriter(stepLog)
+
+	restore := memoryBudget{maxMB: configs.MaxMemoryMB}.apply()
+	return withRunEnv(ctx, env), restore, nil
+}
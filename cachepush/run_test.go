@@ -0,0 +1,286 @@
+package cachepush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file"): "content"})
+
+	t.Log("no path to cache")
+	{
+		report, err := Run(context.Background(), Config{})
+		require.NoError(t, err)
+		require.False(t, report.Pushed)
+		require.Equal(t, "no path to cache", report.SkipReason)
+	}
+
+	t.Log("cancelled")
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := Run(ctx, Config{Paths: tmpDir, FingerprintMethodID: string(MD5)})
+		require.True(t, errors.Is(err, context.Canceled))
+	}
+
+	t.Log("prepare")
+	{
+		defer func() {
+			require.NoError(t, os.RemoveAll(workPath(preparedHashesFileName)))
+			require.NoError(t, os.RemoveAll(workPath(preparedStateFileName)))
+		}()
+
+		report, err := Run(context.Background(), Config{Paths: tmpDir, FingerprintMethodID: string(MD5), ExecutionMode: executionModePrepare})
+		require.NoError(t, err)
+		require.False(t, report.Pushed)
+		require.Equal(t, "prepared", report.SkipReason)
+
+		hashes, err := loadHashCache(workPath(preparedHashesFileName))
+		require.NoError(t, err)
+		_, err = hashes.contentHash(filepath.Join(tmpDir, "file"))
+		require.NoError(t, err)
+		_, reused := hashes.stats()
+		require.Equal(t, 1, reused)
+
+		key, err := preparedStateKey(Config{Paths: tmpDir, FingerprintMethodID: string(MD5)})
+		require.NoError(t, err)
+		state, err := readPreparedState(workPath(preparedStateFileName), key)
+		require.NoError(t, err)
+		require.NotNil(t, state)
+		require.Contains(t, state.Items[tmpDir].Paths, filepath.Join(tmpDir, "file"))
+		require.Contains(t, state.Fingerprints, filepath.Join(tmpDir, "file"))
+	}
+}
+
+func TestRun_forcePush(t *testing.T) {
+	const group = "force-push-test"
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	file := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{file: "content"})
+
+	// the previous cache info reports no changes
+	descriptor, err := json.Marshal(map[string]string{tmpDir: "-", file: "9a0364b9e99bb480dd25e1f0284c8555"})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(cachecommon.GroupPath(workPath(cacheInfoFileName), group), descriptor, 0600))
+	defer func() {
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheInfoFileName), group)))
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+	}()
+
+	var uploaded bool
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload"}`))
+			require.NoError(t, err)
+			return
+		}
+		uploaded = true
+	}))
+	defer server.Close()
+
+	configs := Config{
+		Paths:               tmpDir,
+		CacheAPIURL:         server.URL,
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+		QuotaPolicy:         string(QuotaPolicyNone),
+	}
+
+	report, err := runCache(context.Background(), configs, group)
+	require.NoError(t, err)
+	require.False(t, report.Pushed)
+	require.Equal(t, "no changes", report.SkipReason)
+	require.False(t, uploaded)
+
+	configs.ForceCachePush = true
+	report, err = runCache(context.Background(), configs, group)
+	require.NoError(t, err)
+	require.True(t, report.Pushed)
+	require.True(t, uploaded)
+}
+
+func TestRun_uploadError(t *testing.T) {
+	const group = "upload-error-test"
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file"): "content"})
+	defer func() {
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	configs := Config{
+		Paths:               tmpDir,
+		CacheAPIURL:         server.URL,
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+		QuotaPolicy:         string(QuotaPolicyNone),
+		FailOnUploadError:   true,
+	}
+
+	_, err = runCache(context.Background(), configs, group)
+	require.EqualError(t, err, "failed to upload archive: upload failed to every cache API endpoint:\n"+server.URL+": failed to generate upload url: upload url was rejected with status code: 502")
+
+	configs.FailOnUploadError = false
+	report, err := runCache(context.Background(), configs, group)
+	require.NoError(t, err)
+	require.False(t, report.Pushed)
+	require.Equal(t, "upload failed", report.SkipReason)
+	require.Equal(t, "failed to upload archive: upload failed to every cache API endpoint:\n"+server.URL+": failed to generate upload url: upload url was rejected with status code: 502", report.UploadError)
+	require.Equal(t, pushStatusFailed, reportOutputs(report)[pushStatusEnvKey])
+}
+
+func TestRun_accessTimeOnlyChanges(t *testing.T) {
+	const group = "access-time-test"
+
+	for _, method := range []ChangeIndicator{MD5, MODTIME} {
+		t.Run(string(method), func(t *testing.T) {
+			tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+			require.NoError(t, err)
+			defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+			file := filepath.Join(tmpDir, "file")
+			createDirStruct(t, map[string]string{file: "content"})
+			defer func() {
+				require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheInfoFileName), group)))
+				require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+			}()
+
+			var uploads int
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload"}`))
+					require.NoError(t, err)
+					return
+				}
+				uploads++
+			}))
+			defer server.Close()
+
+			configs := Config{
+				Paths:               tmpDir,
+				CacheAPIURL:         server.URL,
+				FingerprintMethodID: string(method),
+				CompressArchive:     "false",
+				QuotaPolicy:         string(QuotaPolicyNone),
+			}
+
+			report, err := runCache(context.Background(), configs, group)
+			require.NoError(t, err)
+			require.True(t, report.Pushed)
+			require.Equal(t, 1, uploads)
+
+			// the pushed archive becomes the previous cache, as if it was pulled
+			require.NoError(t, extractMetadata(t, cachecommon.GroupPath(workPath(cacheArchiveFileName), group), cachecommon.GroupPath(workPath(cacheInfoFileName), group)))
+
+			// reading the file only updates its access time, which is recorded in the cache meta
+			info, err := os.Stat(file)
+			require.NoError(t, err)
+			require.NoError(t, os.Chtimes(file, time.Now().Add(time.Hour), info.ModTime()))
+
+			report, err = runCache(context.Background(), configs, group)
+			require.NoError(t, err)
+			require.False(t, report.Pushed)
+			require.Equal(t, "no changes", report.SkipReason)
+			require.Equal(t, 1, uploads)
+		})
+	}
+}
+
+// extractMetadata extracts the named metadata file of the archive to its path.
+func extractMetadata(t *testing.T, archivePth, name string) error {
+	for _, entry := range readArchiveEntries(t, archivePth) {
+		if entry.name == name {
+			return ioutil.WriteFile(name, []byte(entry.content), 0600)
+		}
+	}
+	return os.ErrNotExist
+}
+
+func TestRun_restoreKeys(t *testing.T) {
+	const group = "restore-keys-test"
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	file := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{file: "content"})
+
+	// the previous cache descriptor reports no changes
+	descriptor, err := json.Marshal(map[string]string{tmpDir: "-", file: "9a0364b9e99bb480dd25e1f0284c8555"})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(cachecommon.GroupPath(workPath(cacheInfoFileName), group), descriptor, 0600))
+	defer func() {
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheInfoFileName), group)))
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(stackVersionsFileName), group)))
+		require.NoError(t, os.RemoveAll(cachecommon.GroupPath(workPath(cacheArchiveFileName), group)))
+	}()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/upload"}`))
+			require.NoError(t, err)
+		}
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		prevKey    string
+		wantPushed bool
+	}{
+		{prevKey: "key-feature-1", wantPushed: false},
+		{prevKey: "key-feature-0", wantPushed: true},
+		{prevKey: "key-master-1", wantPushed: true},
+		{prevKey: "other-1", wantPushed: true},
+	} {
+		info := cachecommon.NewArchiveInfo(nil)
+		info.CacheKey = tt.prevKey
+		infoData, err := cachecommon.EncodeArchiveInfo(info)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(cachecommon.GroupPath(workPath(stackVersionsFileName), group), infoData, 0600))
+
+		report, err := runCache(context.Background(), Config{
+			Paths:               tmpDir,
+			CacheAPIURL:         server.URL,
+			FingerprintMethodID: string(MD5),
+			CompressArchive:     "false",
+			QuotaPolicy:         string(QuotaPolicyNone),
+			CacheKey:            "key-feature-1",
+			RestoreKeys:         "key-feature-\nkey-master-",
+		}, group)
+		require.NoError(t, err)
+		require.Equal(t, tt.wantPushed, report.Pushed, tt.prevKey)
+	}
+}
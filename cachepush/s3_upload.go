@@ -0,0 +1,287 @@
+// S3 compatible storage related functions.
+//
+// The cache archive can be uploaded to an S3 compatible storage (AWS S3, MinIO, ...) by using an
+// `s3://bucket/key` destination. The requests are sent and signed by the AWS SDK's S3 client, the credentials and
+// the region are resolved by its session (see aws_session.go). A custom endpoint (for example a MinIO server) is read
+// from AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL), and addressed in path style.
+//
+// Archives larger than the multipart threshold are uploaded in parts (like the upload manager of the AWS SDKs),
+// so archives over the 5 GB single PUT limit are supported, and a failed part is retried without re-uploading the others.
+package cachepush
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	s3Scheme       = "s3://"
+	maxS3PartCount = 10000
+)
+
+var (
+	// s3MultipartThreshold is the archive size above which the archive is uploaded in parts.
+	s3MultipartThreshold int64 = 100 * 1024 * 1024
+	// s3MinPartSize is the smallest part size, the part size grows with the archive to keep the part count under the limit.
+	s3MinPartSize int64 = 64 * 1024 * 1024
+)
+
+// s3Destination is a parsed s3:// destination.
+type s3Destination struct {
+	bucket string
+	key    string
+}
+
+// parseS3Destination parses an s3://bucket/key destination, in layered mode the layer's ID is added to the key.
+func parseS3Destination(destination, layerID string) (s3Destination, error) {
+	split := strings.SplitN(strings.TrimPrefix(destination, s3Scheme), "/", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return s3Destination{}, fmt.Errorf("invalid s3 destination, expected format: s3://bucket/key")
+	}
+	return s3Destination{bucket: split[0], key: withLayerID(split[1], layerID)}, nil
+}
+
+// newS3Client returns the S3 client of the upload. The SDK does not retry the requests, the upload's retry policy does.
+// The payload is not signed, so the archive is not read an extra time to hash it, the storage verifies the SHA-256
+// checksums instead if they are enabled.
+func newS3Client(ctx context.Context, sess *session.Session) *s3.S3 {
+	client := s3.New(sess, &aws.Config{
+		HTTPClient:                     newHTTPClient(ctx, 0),
+		MaxRetries:                     aws.Int(0),
+		S3ForcePathStyle:               aws.Bool(awsEndpoint(s3.EndpointsID) != ""),
+		DisableRestProtocolURICleaning: aws.Bool(true),
+	})
+	client.Handlers.Sign.Swap(v4.SignRequestHandler.Name, v4.BuildNamedHandler(v4.SignRequestHandler.Name, func(s *v4.Signer) {
+		s.DisableURIPathEscaping = true
+	}, v4.WithUnsignedPayload))
+	client.Handlers.Complete.PushBack(func(r *request.Request) {
+		diag.addResponse(r.HTTPResponse, nil)
+	})
+	return client
+}
+
+// uploadArchiveToS3 uploads the archive file to an s3:// destination, failed uploads are retried based on the retry policy.
+// If the checksum is set, the storage verifies the uploaded object's SHA-256 checksum.
+func uploadArchiveToS3(ctx context.Context, pth, destination, layerID, checksum string, retry uploadRetry) error {
+	dst, err := parseS3Destination(destination, layerID)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(pth)
+	if err != nil {
+		return fmt.Errorf("failed to get file info (%s): %s", pth, err)
+	}
+
+	var encodedChecksum string
+	if checksum != "" {
+		if encodedChecksum, err = base64Checksum(checksum); err != nil {
+			return fmt.Errorf("invalid archive checksum: %s", err)
+		}
+	}
+
+	sess, err := newAWSSession(ctx)
+	if err != nil {
+		return err
+	}
+	client := newS3Client(ctx, sess)
+	log.Printf("Uploading archive to: %s", redactURL(destination))
+
+	if fi.Size() > s3MultipartThreshold {
+		return uploadS3Multipart(ctx, client, dst, pth, fi.Size(), checksum != "", retry)
+	}
+
+	err = putS3Object(ctx, client, dst, pth, encodedChecksum)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for attempt := 1; err != nil && attempt <= retry.count; attempt++ {
+		delay := retry.delay(attempt, rnd)
+		log.Warnf("Upload attempt %d/%d failed: %s, retrying in %s...", attempt, retry.count+1, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		err = putS3Object(ctx, client, dst, pth, encodedChecksum)
+	}
+	return err
+}
+
+func putS3Object(ctx context.Context, client *s3.S3, dst s3Destination, pth, checksum string) error {
+	file, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file for upload (%s): %s", pth, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close archive file (%s): %s", pth, err)
+		}
+	}()
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(dst.bucket),
+		Key:         aws.String(dst.key),
+		Body:        file,
+		ContentType: aws.String("application/x-tar"),
+	}
+	if checksum != "" {
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+	if _, err := client.PutObjectWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload: %s", err)
+	}
+	return nil
+}
+
+// s3PartSize returns the part size of the archive: the minimal part size, or larger if the archive would have too many parts.
+func s3PartSize(size int64) int64 {
+	partSize := s3MinPartSize
+	if minSize := (size + maxS3PartCount - 1) / maxS3PartCount; minSize > partSize {
+		partSize = minSize
+	}
+	return partSize
+}
+
+// uploadS3Multipart uploads the archive in parts, every part is retried based on the retry policy.
+// With checksums enabled every part's SHA-256 checksum is verified by the storage.
+// A failed upload is aborted, so its parts are not kept (and billed) by the storage.
+func uploadS3Multipart(ctx context.Context, client *s3.S3, dst s3Destination, pth string, size int64, withChecksum bool, retry uploadRetry) error {
+	file, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file for upload (%s): %s", pth, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close archive file (%s): %s", pth, err)
+		}
+	}()
+
+	uploadID, err := createS3MultipartUpload(ctx, client, dst, withChecksum)
+	if err != nil {
+		return err
+	}
+
+	partSize := s3PartSize(size)
+	log.Printf("Uploading archive in %d parts", (size+partSize-1)/partSize)
+
+	var parts []*s3.CompletedPart
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for offset, number := int64(0), int64(1); offset < size; offset, number = offset+partSize, number+1 {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		section := io.NewSectionReader(file, offset, length)
+
+		var checksum string
+		if withChecksum {
+			h := sha256.New()
+			if _, err := io.Copy(h, section); err != nil {
+				abortS3MultipartUpload(client, dst, uploadID)
+				return fmt.Errorf("failed to read archive part: %s", err)
+			}
+			checksum = base64.StdEncoding.EncodeToString(h.Sum(nil))
+		}
+
+		part, err := putS3Part(ctx, client, dst, uploadID, number, section, checksum)
+		for attempt := 1; err != nil && attempt <= retry.count; attempt++ {
+			delay := retry.delay(attempt, rnd)
+			log.Warnf("Part %d upload attempt %d/%d failed: %s, retrying in %s...", number, attempt, retry.count+1, err, delay)
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(delay):
+				part, err = putS3Part(ctx, client, dst, uploadID, number, section, checksum)
+			}
+		}
+		if err != nil {
+			abortS3MultipartUpload(client, dst, uploadID)
+			return err
+		}
+		parts = append(parts, part)
+	}
+
+	if _, err := client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dst.bucket),
+		Key:             aws.String(dst.key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		abortS3MultipartUpload(client, dst, uploadID)
+		return fmt.Errorf("failed to complete multipart upload: %s", err)
+	}
+	return nil
+}
+
+// createS3MultipartUpload starts a multipart upload and returns its ID.
+func createS3MultipartUpload(ctx context.Context, client *s3.S3, dst s3Destination, withChecksum bool) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(dst.bucket),
+		Key:         aws.String(dst.key),
+		ContentType: aws.String("application/x-tar"),
+	}
+	if withChecksum {
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+
+	output, err := client.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %s", err)
+	}
+	if aws.StringValue(output.UploadId) == "" {
+		return "", fmt.Errorf("failed to start multipart upload: no upload ID received")
+	}
+	return aws.StringValue(output.UploadId), nil
+}
+
+// putS3Part uploads a part of the multipart upload.
+func putS3Part(ctx context.Context, client *s3.S3, dst s3Destination, uploadID string, number int64, section *io.SectionReader, checksum string) (*s3.CompletedPart, error) {
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	input := &s3.UploadPartInput{
+		Bucket:     aws.String(dst.bucket),
+		Key:        aws.String(dst.key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(number),
+		Body:       section,
+	}
+	if checksum != "" {
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+
+	output, err := client.UploadPartWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload part %d: %s", number, err)
+	}
+	return &s3.CompletedPart{PartNumber: aws.Int64(number), ETag: output.ETag, ChecksumSHA256: input.ChecksumSHA256}, nil
+}
+
+// abortS3MultipartUpload aborts the multipart upload, the failure is only logged.
+// It does not use the upload's cancellation, so a canceled upload is aborted too.
+func abortS3MultipartUpload(client *s3.S3, dst s3Destination, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheAPIRequestTimeout)
+	defer cancel()
+
+	if _, err := client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(dst.bucket),
+		Key:      aws.String(dst.key),
+		UploadId: aws.String(uploadID),
+	}); err != nil {
+		log.Warnf("Failed to abort multipart upload: %s", err)
+	}
+}
@@ -0,0 +1,170 @@
+package cachepush
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func setenvForTest(t *testing.T, key, value string) {
+	orig, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			require.NoError(t, os.Setenv(key, orig))
+		} else {
+			require.NoError(t, os.Unsetenv(key))
+		}
+	})
+}
+
+func Test_parseS3Destination(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		layerID     string
+		want        s3Destination
+		wantErr     bool
+	}{
+		{name: "object", destination: "s3://bucket/cache/archive.tar", want: s3Destination{bucket: "bucket", key: "cache/archive.tar"}},
+		{name: "layer", destination: "s3://bucket/archive.tar", layerID: "delta-1", want: s3Destination{bucket: "bucket", key: "archive.delta-1.tar"}},
+		{name: "missing key", destination: "s3://bucket", wantErr: true},
+		{name: "missing bucket", destination: "s3:///key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseS3Destination(tt.destination, tt.layerID)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_uploadArchiveToS3(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	archive := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{archive: "archive"})
+
+	var uploadedPath, auth, checksum string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploadedPath = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		checksum = r.Header.Get("X-Amz-Checksum-Sha256")
+	}))
+	defer server.Close()
+
+	isolateAWSEnvForTest(t, tmpDir)
+	setenvForTest(t, "AWS_ACCESS_KEY_ID", "AKID")
+	setenvForTest(t, "AWS_SECRET_ACCESS_KEY", "secret")
+	setenvForTest(t, "AWS_ENDPOINT_URL_S3", server.URL)
+
+	info := model.ArchiveInfo{Checksum: "0de1c1ad0a7bbb6fd1fb1aee4bb4ce22ba0b1e70d4c8e8e1e0e0e0e0e0e0e0e0"}
+	require.NoError(t, uploadArchive(context.Background(), archive, "s3://bucket/cache.tar", "", "", info, uploadRetry{}))
+	require.Equal(t, "/bucket/cache.tar", uploadedPath)
+	require.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/"), auth)
+	require.Contains(t, auth, "x-amz-checksum-sha256")
+	require.Equal(t, "DeHBrQp7u2/R+xruS7TOIroLHnDUyOjh4ODg4ODg4OA=", checksum)
+
+	t.Log("key with reserved characters")
+	{
+		require.NoError(t, uploadArchive(context.Background(), archive, "s3://bucket/cache/archive file+1=(a).tar", "", "", model.ArchiveInfo{}, uploadRetry{}))
+		require.Equal(t, "/bucket/cache/archive file+1=(a).tar", uploadedPath)
+	}
+}
+
+func Test_uploadArchiveToS3_multipart(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	archive := filepath.Join(tmpDir, "cache.tar")
+	createDirStruct(t, map[string]string{archive: "0123456789"})
+
+	defer func(threshold, partSize int64) { s3MultipartThreshold, s3MinPartSize = threshold, partSize }(s3MultipartThreshold, s3MinPartSize)
+	s3MultipartThreshold, s3MinPartSize = 4, 4
+
+	var mu sync.Mutex
+	parts := map[string]string{}
+	var completed string
+	aborted := false
+	failPart := "2"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		query := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && query["uploads"] != nil:
+			require.Equal(t, "SHA256", r.Header.Get("X-Amz-Checksum-Algorithm"))
+			_, err := w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`))
+			require.NoError(t, err)
+		case r.Method == http.MethodPut:
+			require.Equal(t, "upload-1", query.Get("uploadId"))
+			require.NotEmpty(t, r.Header.Get("X-Amz-Checksum-Sha256"))
+			if query.Get("partNumber") == failPart {
+				failPart = ""
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			parts[query.Get("partNumber")] = string(body)
+			w.Header().Set("ETag", `"etag-`+query.Get("partNumber")+`"`)
+		case r.Method == http.MethodPost:
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			completed = string(body)
+			_, err = w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`))
+			require.NoError(t, err)
+		case r.Method == http.MethodDelete:
+			aborted = true
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	isolateAWSEnvForTest(t, tmpDir)
+	setenvForTest(t, "AWS_ACCESS_KEY_ID", "AKID")
+	setenvForTest(t, "AWS_SECRET_ACCESS_KEY", "secret")
+	setenvForTest(t, "AWS_ENDPOINT_URL_S3", server.URL)
+
+	info := model.ArchiveInfo{Checksum: "0de1c1ad0a7bbb6fd1fb1aee4bb4ce22ba0b1e70d4c8e8e1e0e0e0e0e0e0e0e0"}
+	require.NoError(t, uploadArchive(context.Background(), archive, "s3://bucket/cache.tar", "", "", info, uploadRetry{count: 1, backoff: time.Millisecond}))
+	require.Equal(t, map[string]string{"1": "0123", "2": "4567", "3": "89"}, parts)
+	var complete struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(completed), &complete))
+	require.Len(t, complete.Parts, 3)
+	require.Equal(t, 2, complete.Parts[1].PartNumber)
+	require.Equal(t, `"etag-2"`, complete.Parts[1].ETag)
+	require.False(t, aborted)
+
+	t.Log("a failing part aborts the upload")
+	{
+		failPart = "3"
+		err := uploadArchive(context.Background(), archive, "s3://bucket/cache.tar", "", "", info, uploadRetry{})
+		require.Error(t, err)
+		require.True(t, aborted)
+	}
+}
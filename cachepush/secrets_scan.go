@@ -0,0 +1,176 @@
+// Secrets scan related functions.
+//
+// The cache archive is shared by the builds of the app (including pull request builds), so credentials
+// accidentally left in a cached directory (like an .npmrc with an auth token in the home directory) leak through it.
+// With secrets_scan enabled the cached files are checked against well-known credential files before archiving:
+// private keys, .netrc files, keystores, and the package manager configs which contain an auth token.
+// The matching files are reported, and left out of the cache with the exclude policy.
+package cachepush
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// SecretsScanPolicy ...
+type SecretsScanPolicy string
+
+const (
+	// SecretsScanOff ...
+	SecretsScanOff = SecretsScanPolicy("off")
+	// SecretsScanWarn ...
+	SecretsScanWarn = SecretsScanPolicy("warn")
+	// SecretsScanExclude ...
+	SecretsScanExclude = SecretsScanPolicy("exclude")
+)
+
+// secretScanReadLimit is the number of bytes read from the files checked by their content.
+const secretScanReadLimit = 64 * 1024
+
+// secretFinding is a cached file looking like a credential.
+type secretFinding struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// secretRule matches the credential files by their name, and optionally by their content.
+type secretRule struct {
+	// name is a filepath.Match pattern of the file name.
+	name   string
+	reason string
+	// content, if set, has to match the beginning of the file.
+	content *regexp.Regexp
+}
+
+var privateKeyContent = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+
+// secretRules are the credential files, the first matching rule is reported.
+var secretRules = []secretRule{
+	{name: "id_rsa", reason: "SSH private key"},
+	{name: "id_dsa", reason: "SSH private key"},
+	{name: "id_ecdsa", reason: "SSH private key"},
+	{name: "id_ed25519", reason: "SSH private key"},
+	{name: "*.pem", reason: "private key", content: privateKeyContent},
+	{name: "*.key", reason: "private key", content: privateKeyContent},
+	{name: ".netrc", reason: "netrc credentials"},
+	{name: "_netrc", reason: "netrc credentials"},
+	{name: ".git-credentials", reason: "git credentials"},
+	{name: "*.keystore", reason: "Java keystore"},
+	{name: "*.jks", reason: "Java keystore"},
+	{name: "*.p12", reason: "PKCS #12 certificate bundle"},
+	{name: "*.pfx", reason: "PKCS #12 certificate bundle"},
+	{name: ".npmrc", reason: "npm auth token", content: regexp.MustCompile(`(?m)(_authToken|_auth|_password)\s*=`)},
+	{name: ".yarnrc.yml", reason: "yarn auth token", content: regexp.MustCompile(`(?m)npmAuth(Token|Ident)\s*:`)},
+	{name: ".pypirc", reason: "PyPI password", content: regexp.MustCompile(`(?m)^\s*password\s*[=:]`)},
+	{name: ".gem_credentials", reason: "RubyGems API key"},
+	{name: "credentials", reason: "AWS credentials", content: regexp.MustCompile(`(?m)aws_secret_access_key\s*=`)},
+}
+
+// matchSecretRule returns the reason of the first rule matching the file, an empty string if no rule matches.
+func matchSecretRule(pth string) (string, error) {
+	name := filepath.Base(pth)
+	var head []byte
+	for _, rule := range secretRules {
+		if ok, err := filepath.Match(rule.name, name); err != nil || !ok {
+			continue
+		}
+		if rule.content == nil {
+			return rule.reason, nil
+		}
+
+		if head == nil {
+			var err error
+			if head, err = readFileHead(pth, secretScanReadLimit); err != nil {
+				return "", err
+			}
+		}
+		if rule.content.Match(head) {
+			return rule.reason, nil
+		}
+	}
+	return "", nil
+}
+
+// readFileHead returns at most the first limit bytes of the file.
+func readFileHead(pth string, limit int64) ([]byte, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debugf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(file, limit)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scanForSecrets returns the cached regular files looking like credentials, sorted by path.
+func scanForSecrets(pathToIndicatorPath map[string]string) ([]secretFinding, error) {
+	var findings []secretFinding
+	for pth := range pathToIndicatorPath {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		reason, err := matchSecretRule(pth)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			findings = append(findings, secretFinding{Path: pth, Reason: reason})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Path < findings[j].Path })
+	return findings, nil
+}
+
+// excludeSecrets removes the files of the findings from the cache.
+func excludeSecrets(pathToIndicatorPath map[string]string, findings []secretFinding) map[string]string {
+	if len(findings) == 0 {
+		return pathToIndicatorPath
+	}
+
+	excluded := map[string]bool{}
+	for _, finding := range findings {
+		excluded[finding.Path] = true
+	}
+	kept := map[string]string{}
+	for pth, indicator := range pathToIndicatorPath {
+		if !excluded[pth] {
+			kept[pth] = indicator
+		}
+	}
+	return kept
+}
+
+// logSecretFindings warns about the findings, telling whether they are left out of the cache.
+func logSecretFindings(findings []secretFinding, policy SecretsScanPolicy) {
+	if len(findings) == 0 {
+		return
+	}
+
+	if policy == SecretsScanExclude {
+		log.Warnf("%d files looking like credentials are left out of the cache:", len(findings))
+	} else {
+		log.Warnf("%d files looking like credentials are cached, remove them from the cache paths or set secrets_scan to exclude:", len(findings))
+	}
+	for _, finding := range findings {
+		log.Warnf("- %s (%s)", finding.Path, finding.Reason)
+	}
+}
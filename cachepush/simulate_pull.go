@@ -0,0 +1,188 @@
+// Simulated cache pull related functions.
+//
+// After the archive is generated, a random sample of the cached files is extracted into a scratch directory
+// and compared to the originals, proving that cache-pull will restore the same content.
+package cachepush
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// sampleRegularFiles returns at most n randomly selected regular files of the cached paths.
+func sampleRegularFiles(pathToIndicatorPath map[string]string, n int, rnd *rand.Rand) ([]string, error) {
+	var files []string
+	for pth := range pathToIndicatorPath {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, pth)
+		}
+	}
+
+	sort.Strings(files)
+	rnd.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+	if len(files) > n {
+		files = files[:n]
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// simulatePull extracts the sampled files from the archive into a scratch directory and compares them
+// (size and content hash) to the originals. It returns the description of the mismatching files.
+// The key decrypts the archive if it is encrypted.
+func simulatePull(archivePth string, sample []string, hashes *hashCache, key []byte) ([]string, error) {
+	scratchDir, err := ioutil.TempDir("", "cache-push-simulate-pull")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(scratchDir); err != nil {
+			log.Debugf("Failed to remove scratch directory (%s): %s", scratchDir, err)
+		}
+	}()
+
+	sampled := map[string]bool{}
+	for _, pth := range sample {
+		sampled[pth] = true
+	}
+
+	extracted, linkTargets, err := extractEntries(archivePth, key, sampled, scratchDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// hard linked files are archived as links to the first archived file with the same content
+	targets := map[string]bool{}
+	for _, target := range linkTargets {
+		if _, ok := extracted[target]; !ok {
+			targets[target] = true
+		}
+	}
+	if len(targets) > 0 {
+		extractedTargets, _, err := extractEntries(archivePth, key, targets, scratchDir)
+		if err != nil {
+			return nil, err
+		}
+		for target, dst := range extractedTargets {
+			extracted[target] = dst
+		}
+	}
+	for name, target := range linkTargets {
+		if dst, ok := extracted[target]; ok {
+			extracted[name] = dst
+		}
+	}
+
+	var mismatching []string
+	for _, pth := range sample {
+		dst, ok := extracted[pth]
+		if !ok {
+			mismatching = append(mismatching, fmt.Sprintf("%s: missing from the archive", pth))
+			continue
+		}
+
+		if reason, err := compareExtractedFile(pth, dst, hashes); err != nil {
+			return nil, err
+		} else if reason != "" {
+			mismatching = append(mismatching, fmt.Sprintf("%s: %s", pth, reason))
+		}
+	}
+	return mismatching, nil
+}
+
+// extractEntries extracts the listed regular files of the archive into the scratch directory.
+// It returns the extracted files and the link target of the listed hard links.
+func extractEntries(archivePth string, key []byte, names map[string]bool, scratchDir string) (map[string]string, map[string]string, error) {
+	tarReader, closeArchive, err := openArchiveReader(archivePth, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeArchive()
+
+	extracted := map[string]string{}
+	linkTargets := map[string]string{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read archive: %s", err)
+		}
+		if !names[header.Name] {
+			continue
+		}
+		if header.Typeflag == tar.TypeLink {
+			linkTargets[header.Name] = header.Linkname
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		dst := filepath.Join(scratchDir, header.Name)
+		if err := extractFile(tarReader, dst); err != nil {
+			return nil, nil, fmt.Errorf("failed to extract %s: %s", header.Name, err)
+		}
+		extracted[header.Name] = dst
+	}
+	return extracted, linkTargets, nil
+}
+
+func extractFile(r io.Reader, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		if cerr := file.Close(); cerr != nil {
+			log.Debugf("Failed to close extracted file (%s): %s", dst, cerr)
+		}
+		return err
+	}
+	return file.Close()
+}
+
+// compareExtractedFile returns why the extracted file differs from the original, empty if they match.
+func compareExtractedFile(original, extracted string, hashes *hashCache) (string, error) {
+	originalInfo, err := os.Stat(original)
+	if err != nil {
+		return "", err
+	}
+	extractedInfo, err := os.Stat(extracted)
+	if err != nil {
+		return "", err
+	}
+	if originalInfo.Size() != extractedInfo.Size() {
+		return fmt.Sprintf("size mismatch (%d != %d bytes)", extractedInfo.Size(), originalInfo.Size()), nil
+	}
+
+	originalHash, err := hashes.contentHash(original)
+	if err != nil {
+		return "", err
+	}
+	extractedHash, err := fileContentHash(extracted)
+	if err != nil {
+		return "", err
+	}
+	if originalHash != extractedHash {
+		return "content mismatch", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,45 @@
+package cachepush
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_simulatePull(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	archived := filepath.Join(tmpDir, "archived")
+	changed := filepath.Join(tmpDir, "changed")
+	notArchived := filepath.Join(tmpDir, "not-archived")
+	createDirStruct(t, map[string]string{archived: "content", changed: "content", notArchived: "content"})
+
+	pth := filepath.Join(tmpDir, "cache.tar")
+	archive, err := NewArchive(pth, true, memoryBudget{})
+	require.NoError(t, err)
+	require.NoError(t, writeArchive(context.Background(), archive, archiveContent{
+		pathToIndicatorPath: map[string]string{archived: "", changed: ""},
+	}))
+
+	createDirStruct(t, map[string]string{changed: "modified"})
+
+	sample, err := sampleRegularFiles(map[string]string{archived: "", changed: "", notArchived: "", tmpDir: "-"}, 10, rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+	require.Equal(t, []string{archived, changed, notArchived}, sample)
+
+	mismatching, err := simulatePull(pth, sample, newHashCache(), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		changed + ": size mismatch (7 != 8 bytes)",
+		notArchived + ": missing from the archive",
+	}, mismatching)
+
+	sample, err = sampleRegularFiles(map[string]string{archived: "", changed: ""}, 1, rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+	require.Len(t, sample, 1)
+}
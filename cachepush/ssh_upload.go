@@ -0,0 +1,238 @@
+// SSH remote storage related functions.
+//
+// The cache archive can be uploaded to a file server reachable over SSH by using an `ssh://[user@]host[:port]/path` destination.
+// The archive is transferred with rsync over ssh if rsync is installed, with sftp otherwise. Both write the archive
+// to a temporary file renamed once complete, so readers never see a partial archive.
+// Only key-based authentication is supported: the key is read from the ssh_private_key input,
+// or the ssh client's own configuration (default keys, ssh-agent) is used.
+package cachepush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	sshScheme         = "ssh://"
+	sshConnectTimeout = 30
+)
+
+// sshAuthConfig holds the private key and the known hosts of the ssh destinations, both empty if the ssh client's configuration is used.
+type sshAuthConfig struct {
+	privateKey string
+	knownHosts string
+}
+
+// newSSHAuthConfig returns the authentication of the ssh destinations.
+func newSSHAuthConfig(privateKey, knownHosts string) sshAuthConfig {
+	return sshAuthConfig{privateKey: strings.TrimSpace(privateKey), knownHosts: strings.TrimSpace(knownHosts)}
+}
+
+// sshDestination is a parsed ssh:// destination.
+type sshDestination struct {
+	// host is the [user@]host of the server.
+	host string
+	port string
+	path string
+}
+
+// parseSSHDestination parses an ssh://[user@]host[:port]/path destination, in layered mode the layer's ID is added to the path.
+func parseSSHDestination(destination, layerID string) (sshDestination, error) {
+	u, err := url.Parse(destination)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return sshDestination{}, fmt.Errorf("invalid ssh destination, expected format: ssh://[user@]host[:port]/path/to/archive.tar")
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		return sshDestination{}, fmt.Errorf("invalid ssh destination: passwords are not supported, use key-based authentication")
+	}
+
+	host := u.Hostname()
+	if user := u.User.Username(); user != "" {
+		host = user + "@" + host
+	}
+	return sshDestination{host: host, port: u.Port(), path: withLayerID(u.Path, layerID)}, nil
+}
+
+// sshOptions returns the options of the ssh client, using the given private key and known hosts files if set.
+func sshOptions(keyFile, knownHostsFile string) []string {
+	options := []string{"-o", "BatchMode=yes", "-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout)}
+	if keyFile != "" {
+		options = append(options, "-i", keyFile, "-o", "IdentitiesOnly=yes")
+	}
+	if knownHostsFile != "" {
+		options = append(options, "-o", "UserKnownHostsFile="+knownHostsFile, "-o", "StrictHostKeyChecking=yes")
+	}
+	return options
+}
+
+// shellQuote quotes the string for the remote shell (and for rsync's ssh command).
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// sshTransfer runs the transfer commands of a destination with the given ssh options.
+type sshTransfer struct {
+	dst     sshDestination
+	options []string
+}
+
+// sshArgs returns the arguments of an ssh command running the remote command.
+func (t sshTransfer) sshArgs(remoteCommand string) []string {
+	args := append([]string{}, t.options...)
+	if t.dst.port != "" {
+		args = append(args, "-p", t.dst.port)
+	}
+	return append(args, t.dst.host, remoteCommand)
+}
+
+// rsyncArgs returns the arguments of an rsync command copying the local file to the remote path,
+// the remote path is protected from the remote shell by rsync itself (--protect-args).
+func (t sshTransfer) rsyncArgs(pth, remotePath string) []string {
+	shell := []string{"ssh"}
+	for _, option := range t.options {
+		shell = append(shell, shellQuote(option))
+	}
+	if t.dst.port != "" {
+		shell = append(shell, "-p", t.dst.port)
+	}
+	return []string{"--protect-args", "-e", strings.Join(shell, " "), pth, t.dst.host + ":" + remotePath}
+}
+
+// sftpArgs returns the arguments of an sftp command running the batch read from its stdin.
+func (t sshTransfer) sftpArgs() []string {
+	args := append([]string{"-b", "-"}, t.options...)
+	if t.dst.port != "" {
+		args = append(args, "-P", t.dst.port)
+	}
+	return append(args, t.dst.host)
+}
+
+// sftpBatch returns the sftp batch uploading the local file to a temporary remote file, then renaming it to the remote path.
+func sftpBatch(pth, remotePath string) string {
+	tmp := remotePath + ".tmp"
+	return fmt.Sprintf("put %s %s\n-rm %s\nrename %s %s\n",
+		quoteSFTP(pth), quoteSFTP(tmp), quoteSFTP(remotePath), quoteSFTP(tmp), quoteSFTP(remotePath))
+}
+
+// quoteSFTP quotes the path for an sftp batch.
+func quoteSFTP(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// runSSHCommand runs the command and returns its error with the command's stderr.
+func runSSHCommand(ctx context.Context, name string, args []string, stdin string) error {
+	var stderr bytes.Buffer
+	cmd := command.NewWithCmd(exec.CommandContext(ctx, name, args...)).SetStderr(&stderr)
+	if stdin != "" {
+		cmd.SetStdin(strings.NewReader(stdin))
+	}
+	log.Debugf("$ %s", cmd.PrintableCommandArgs())
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// upload copies the local files to the remote paths: the remote directories are created,
+// then the files are transferred with rsync if it is installed, with sftp otherwise.
+func (t sshTransfer) upload(ctx context.Context, files map[string]string) error {
+	dirs := map[string]bool{}
+	var mkdirs []string
+	for _, remotePath := range files {
+		if dir := path.Dir(remotePath); !dirs[dir] {
+			dirs[dir] = true
+			mkdirs = append(mkdirs, shellQuote(dir))
+		}
+	}
+	if err := runSSHCommand(ctx, "ssh", t.sshArgs("mkdir -p "+strings.Join(mkdirs, " ")), ""); err != nil {
+		return err
+	}
+
+	_, err := exec.LookPath("rsync")
+	useRsync := err == nil
+	for _, pth := range sortedKeys(files) {
+		if useRsync {
+			err = runSSHCommand(ctx, "rsync", t.rsyncArgs(pth, files[pth]), "")
+		} else {
+			err = runSSHCommand(ctx, "sftp", t.sftpArgs(), sftpBatch(pth, files[pth]))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadArchiveToSSH uploads the archive file to an ssh:// destination, failed uploads are retried based on the retry policy.
+// If the checksum is set, it is uploaded next to the archive in the format of `sha256sum`.
+func uploadArchiveToSSH(ctx context.Context, pth, destination, layerID, checksum string, retry uploadRetry) error {
+	dst, err := parseSSHDestination(destination, layerID)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "cache-push-ssh")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Debugf("Failed to remove ssh temp dir (%s): %s", tmpDir, err)
+		}
+	}()
+
+	sshAuth := envOf(ctx).sshAuth
+	var keyFile, knownHostsFile string
+	if sshAuth.privateKey != "" {
+		keyFile = filepath.Join(tmpDir, "id")
+		if err := ioutil.WriteFile(keyFile, []byte(sshAuth.privateKey+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+	if sshAuth.knownHosts != "" {
+		knownHostsFile = filepath.Join(tmpDir, "known_hosts")
+		if err := ioutil.WriteFile(knownHostsFile, []byte(sshAuth.knownHosts+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	files := map[string]string{pth: dst.path}
+	if checksum != "" {
+		// the checksum file names the archive by its remote name
+		checksumPth := filepath.Join(tmpDir, path.Base(dst.path))
+		if err := writeChecksumFile(checksumPth, checksum); err != nil {
+			return err
+		}
+		files[checksumPth+checksumFileExt] = dst.path + checksumFileExt
+	}
+
+	transfer := sshTransfer{dst: dst, options: sshOptions(keyFile, knownHostsFile)}
+	log.Printf("Uploading archive to: %s", redactURL(destination))
+
+	err = transfer.upload(ctx, files)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for attempt := 1; err != nil && attempt <= retry.count; attempt++ {
+		delay := retry.delay(attempt, rnd)
+		log.Warnf("Upload attempt %d/%d failed: %s, retrying in %s...", attempt, retry.count+1, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		err = transfer.upload(ctx, files)
+	}
+	return err
+}
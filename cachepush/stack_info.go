@@ -0,0 +1,42 @@
+// Stack info related functions.
+package cachepush
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// detectArchitecture returns the architecture of the machine and whether the step runs translated by Rosetta.
+// A translated (amd64) process reports the architecture of the Apple Silicon machine (arm64),
+// so moving between Intel and Apple Silicon stacks regenerates the cache regardless of how the step runs.
+func detectArchitecture() (string, bool) {
+	if runtime.GOARCH == "amd64" && isTranslated() {
+		return "arm64", true
+	}
+	return runtime.GOARCH, false
+}
+
+// stackChanges returns the differences of the stack the previous cache was generated on and the current one.
+// Fields unknown on either side (for example the OS of archives generated by older step versions) are not compared.
+func stackChanges(prev *model.ArchiveInfo, cur model.ArchiveInfo) []string {
+	if prev == nil {
+		return nil
+	}
+
+	var changes []string
+	for _, field := range []struct {
+		name      string
+		prev, cur string
+	}{
+		{"stack", prev.StackID, cur.StackID},
+		{"os", prev.OS, cur.OS},
+		{"architecture", prev.Architecture, cur.Architecture},
+	} {
+		if field.prev != "" && field.cur != "" && field.prev != field.cur {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", field.name, field.prev, field.cur))
+		}
+	}
+	return changes
+}
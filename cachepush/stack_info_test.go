@@ -0,0 +1,34 @@
+package cachepush
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_stackChanges(t *testing.T) {
+	cur := model.ArchiveInfo{StackID: "osx-xcode-15", OS: "darwin", Architecture: "arm64"}
+
+	require.Nil(t, stackChanges(nil, cur))
+	require.Nil(t, stackChanges(&cur, cur))
+	// archives of older step versions do not store the OS
+	require.Nil(t, stackChanges(&model.ArchiveInfo{StackID: "osx-xcode-15", Architecture: "arm64"}, cur))
+
+	prev := model.ArchiveInfo{StackID: "osx-xcode-14", OS: "linux", Architecture: "amd64"}
+	require.Equal(t, []string{
+		"stack: osx-xcode-14 -> osx-xcode-15",
+		"os: linux -> darwin",
+		"architecture: amd64 -> arm64",
+	}, stackChanges(&prev, cur))
+}
+
+func Test_detectArchitecture(t *testing.T) {
+	arch, translated := detectArchitecture()
+	if translated {
+		require.Equal(t, "arm64", arch)
+	} else {
+		require.Equal(t, runtime.GOARCH, arch)
+	}
+}
@@ -0,0 +1,143 @@
+// Package cachepush keeps the project's cache in sync with the project's current state based on the defined files to be cached and ignored.
+//
+// Files to be cached are described by a path and an optional descriptor file path.
+// Files to be cached can be referred by direct file path while multiple files can be selected by referring the container directory.
+// Optional indicator represents a files, based on which the step synchronizes the given file(s).
+// Syntax: file/path/to/cache, dir/to/cache, file/path/to/cache -> based/on/this/file, dir/to/cache -> based/on/this/file
+//
+// Ignore items are used to ignore certain file(s) from a directory to be cached or to mark that certain file(s) not relevant in cache synchronization.
+// Syntax: not/relevant/file/or/pattern, !file/or/pattern/to/remove/from/cache
+//
+// The Cache Push step's main package is a thin wrapper of RunStep, other tools can embed the cache push
+// by calling Run (or RunGroups) with their own Config and context.
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/cachecommon"
+)
+
+const (
+	cacheInfoFileName         = cachecommon.CacheInfoFileName
+	cacheLayersFileName       = cachecommon.CacheLayersFileName
+	contentHashesFileName     = cachecommon.ContentHashesFileName
+	cacheMetaFileName         = cachecommon.CacheMetaFileName
+	metadataChecksumsFileName = cachecommon.MetadataChecksumsFileName
+	fileChecksumsFileName     = cachecommon.FileChecksumsFileName
+	preparedHashesFileName    = "cache-push-prepared-hashes.json"
+	preparedStateFileName     = "cache-push-prepared-state.json"
+	cacheArchiveFileName      = "cache-archive.tar"
+	stackVersionsFileName     = cachecommon.StackVersionsFileName
+	stepID                    = "cache-push"
+)
+
+// ExitCode is the exit status of the step.
+type ExitCode int
+
+const (
+	// ExitCodeSuccess ...
+	ExitCodeSuccess ExitCode = 0
+	// ExitCodeFailure is returned if the cache push failed.
+	ExitCodeFailure ExitCode = 1
+	// ExitCodeInvalidConfig is returned if the inputs are invalid, nothing was pushed.
+	ExitCodeInvalidConfig ExitCode = 2
+	// ExitCodeInterrupted is returned if the step was interrupted (SIGINT or SIGTERM), like a shell does.
+	ExitCodeInterrupted ExitCode = 130
+)
+
+// stepError is a failure of the step with its exit code.
+type stepError struct {
+	code ExitCode
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// ConfigError returns the error of invalid inputs.
+func ConfigError(err error) error {
+	return &stepError{code: ExitCodeInvalidConfig, err: err}
+}
+
+// ExitCodeOf returns the exit code of the step's error, errors without an exit code are failures.
+func ExitCodeOf(err error) ExitCode {
+	if err == nil {
+		return ExitCodeSuccess
+	}
+	var stepErr *stepError
+	if errors.As(err, &stepErr) {
+		return stepErr.code
+	}
+	return ExitCodeFailure
+}
+
+// WriteDiagnostics writes the diagnostic bundle, if enabled.
+func WriteDiagnostics() {
+	if pth, err := diag.write(); err != nil {
+		log.Warnf("Failed to write diagnostic bundle: %s", err)
+	} else if pth != "" {
+		log.Printf("Diagnostic bundle written to: %s", pth)
+	}
+}
+
+// RunStep pushes the cache configured by the inputs, like the step does:
+// it is canceled by SIGINT and SIGTERM, writes the summary report and exports the step outputs.
+func RunStep(configs Config) error {
+	configs.Print()
+	fmt.Printf("- architecture: %s", runtime.GOARCH)
+	fmt.Println()
+
+	log.SetEnableDebugLog(configs.DebugMode)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	stepTimeout := secondsToDuration(configs.StepTimeout)
+	stepCtx, cancelStep := withTimeout(ctx, stepTimeout)
+	defer cancelStep()
+
+	var report Report
+	var reports map[string]Report
+	var err error
+	if configs.CacheGroups != "" {
+		reports, err = RunGroups(stepCtx, configs)
+		report = mergeReports(reports)
+	} else {
+		report, err = Run(stepCtx, configs)
+		reports = map[string]Report{"": report}
+	}
+	err = timeoutError(ctx, stepCtx, err, "cache push", stepTimeout)
+
+	if configs.SummaryReportPath != "" {
+		if err := writeSummaryReport(configs.SummaryReportPath, reports); err != nil {
+			log.Warnf("Failed to write summary report: %s", err)
+		} else {
+			log.Printf("Summary report written to: %s", configs.SummaryReportPath)
+		}
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return &stepError{code: ExitCodeInterrupted, err: err}
+		}
+		return err
+	}
+
+	if err := exportReport(report); err != nil {
+		log.Warnf("Failed to export step outputs: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Step output related functions.
+package cachepush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+const (
+	pushStatusEnvKey  = "BITRISE_CACHE_PUSH_STATUS"
+	archiveSizeEnvKey = "BITRISE_CACHE_ARCHIVE_SIZE_BYTES"
+	fingerprintEnvKey = "BITRISE_CACHE_FINGERPRINT"
+	cacheHitEnvKey    = "BITRISE_CACHE_HIT"
+	uploadErrorEnvKey = "BITRISE_CACHE_UPLOAD_ERROR"
+
+	pushStatusPushed  = "pushed"
+	pushStatusSkipped = "skipped"
+	pushStatusFailed  = "failed"
+)
+
+// exportOutput exports the given step output for the subsequent steps via envman.
+func exportOutput(key, value string) error {
+	return command.New("envman", "add", "--key", key, "--value", value).Run()
+}
+
+// reportOutputs returns the step outputs describing the report.
+func reportOutputs(report Report) map[string]string {
+	// the archive size is known before the upload is skipped (for example because of the quota)
+	status, size := pushStatusSkipped, int64(0)
+	if report.Pushed {
+		status, size = pushStatusPushed, report.ArchiveSize
+	} else if report.UploadError != "" {
+		status = pushStatusFailed
+	}
+	return map[string]string{
+		pushStatusEnvKey:  status,
+		archiveSizeEnvKey: fmt.Sprintf("%d", size),
+		fingerprintEnvKey: report.Fingerprint,
+		cacheHitEnvKey:    fmt.Sprintf("%t", report.CacheHit),
+		uploadErrorEnvKey: report.UploadError,
+	}
+}
+
+// exportReport exports the step outputs describing the report.
+func exportReport(report Report) error {
+	outputs := reportOutputs(report)
+	keys := make([]string, 0, len(outputs))
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := exportOutput(key, outputs[key]); err != nil {
+			return fmt.Errorf("failed to export %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// mergeReports combines the reports of the cache groups: the merged cache is pushed if any of the groups is pushed,
+// its archive size is the total size of the pushed archives and its fingerprint identifies every group's content.
+// The merged cache is a hit if every group's cache was restored.
+// The upload errors of the groups are listed in the merged upload error.
+func mergeReports(reports map[string]Report) Report {
+	merged := Report{CacheHit: len(reports) > 0}
+	groups := make([]string, 0, len(reports))
+	for group := range reports {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	h := sha256.New()
+	var uploadErrors []string
+	for _, group := range groups {
+		report := reports[group]
+		if report.Pushed {
+			merged.Pushed = true
+			merged.ArchiveSize += report.ArchiveSize
+		}
+		if !report.CacheHit {
+			merged.CacheHit = false
+		}
+		if report.UploadError != "" {
+			if group == "" {
+				uploadErrors = append(uploadErrors, "default cache: "+report.UploadError)
+			} else {
+				uploadErrors = append(uploadErrors, group+": "+report.UploadError)
+			}
+		}
+		if report.Duration > merged.Duration {
+			merged.Duration = report.Duration
+		}
+		h.Write([]byte(group + "\x00" + report.Fingerprint + "\n"))
+	}
+	if len(groups) > 0 {
+		merged.Fingerprint = hex.EncodeToString(h.Sum(nil))
+	}
+	merged.UploadError = strings.Join(uploadErrors, "\n")
+	return merged
+}
@@ -0,0 +1,59 @@
+package cachepush
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_reportOutputs(t *testing.T) {
+	require.Equal(t, map[string]string{
+		"BITRISE_CACHE_PUSH_STATUS":        "pushed",
+		"BITRISE_CACHE_ARCHIVE_SIZE_BYTES": "1024",
+		"BITRISE_CACHE_FINGERPRINT":        "abc",
+		"BITRISE_CACHE_HIT":                "false",
+		"BITRISE_CACHE_UPLOAD_ERROR":       "",
+	}, reportOutputs(Report{Pushed: true, ArchiveSize: 1024, Fingerprint: "abc"}))
+
+	require.Equal(t, map[string]string{
+		"BITRISE_CACHE_PUSH_STATUS":        "skipped",
+		"BITRISE_CACHE_ARCHIVE_SIZE_BYTES": "0",
+		"BITRISE_CACHE_FINGERPRINT":        "abc",
+		"BITRISE_CACHE_HIT":                "true",
+		"BITRISE_CACHE_UPLOAD_ERROR":       "",
+	}, reportOutputs(Report{SkipReason: "cache hit", ArchiveSize: 1024, Fingerprint: "abc", CacheHit: true}))
+
+	require.Equal(t, map[string]string{
+		"BITRISE_CACHE_PUSH_STATUS":        "failed",
+		"BITRISE_CACHE_ARCHIVE_SIZE_BYTES": "0",
+		"BITRISE_CACHE_FINGERPRINT":        "abc",
+		"BITRISE_CACHE_HIT":                "false",
+		"BITRISE_CACHE_UPLOAD_ERROR":       "failed to upload archive",
+	}, reportOutputs(Report{SkipReason: "upload failed", UploadError: "failed to upload archive", ArchiveSize: 1024, Fingerprint: "abc"}))
+}
+
+func Test_mergeReports(t *testing.T) {
+	require.Equal(t, Report{}, mergeReports(nil))
+
+	reports := map[string]Report{
+		"gradle": {Pushed: true, ArchiveSize: 10, Duration: time.Second, Fingerprint: "a"},
+		"pods":   {SkipReason: "no changes", Duration: 2 * time.Second, Fingerprint: "b"},
+	}
+	merged := mergeReports(reports)
+	require.True(t, merged.Pushed)
+	require.Equal(t, int64(10), merged.ArchiveSize)
+	require.Equal(t, 2*time.Second, merged.Duration)
+	require.Len(t, merged.Fingerprint, 64)
+	require.False(t, merged.CacheHit)
+
+	require.True(t, mergeReports(map[string]Report{"gradle": {CacheHit: true}, "pods": {CacheHit: true}}).CacheHit)
+
+	reports["pods"] = Report{SkipReason: "no changes", Fingerprint: "changed"}
+	require.NotEqual(t, merged.Fingerprint, mergeReports(reports).Fingerprint)
+	require.Empty(t, mergeReports(reports).UploadError)
+
+	reports[""] = Report{UploadError: "status code: 502"}
+	reports["pods"] = Report{UploadError: "status code: 503"}
+	require.Equal(t, "default cache: status code: 502\npods: status code: 503", mergeReports(reports).UploadError)
+}
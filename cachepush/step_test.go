@@ -0,0 +1,33 @@
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExitCodeOf(t *testing.T) {
+	require.Equal(t, ExitCodeSuccess, ExitCodeOf(nil))
+	require.Equal(t, ExitCodeFailure, ExitCodeOf(errors.New("upload failed")))
+	require.Equal(t, ExitCodeInvalidConfig, ExitCodeOf(ConfigError(errors.New("invalid input"))))
+
+	interrupted := &stepError{code: ExitCodeInterrupted, err: context.Canceled}
+	require.Equal(t, ExitCodeInterrupted, ExitCodeOf(fmt.Errorf("cache push: %w", interrupted)))
+	require.True(t, errors.Is(interrupted, context.Canceled))
+	require.Equal(t, "context canceled", interrupted.Error())
+}
+
+func TestRunStep_invalidConfig(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("work")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	err = RunStep(Config{WorkingDirectory: tmpDir, CacheAPIHeaders: "invalid header"})
+	require.EqualError(t, err, "invalid cache API header (invalid header), expected format: Name: value")
+	require.Equal(t, ExitCodeInvalidConfig, ExitCodeOf(err))
+}
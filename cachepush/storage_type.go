@@ -0,0 +1,113 @@
+// Storage type detection related functions.
+//
+// The number of files read in parallel is tuned to the storage of the cached paths:
+// network volumes and rotational disks thrash with many parallel readers, while SSDs are underutilized with few.
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// storageType describes the medium of a volume.
+type storageType string
+
+const (
+	storageSSD        storageType = "ssd"
+	storageRotational storageType = "rotational"
+	storageNetwork    storageType = "network"
+	storageUnknown    storageType = "unknown"
+)
+
+const (
+	networkReaders    = 4
+	rotationalReaders = 2
+	minSSDReaders     = 4
+	maxSSDReaders     = 32
+)
+
+// readers returns the number of parallel file readers suited for the storage type.
+func (t storageType) readers(numCPU int) int {
+	switch t {
+	case storageNetwork:
+		return networkReaders
+	case storageRotational:
+		return rotationalReaders
+	default:
+		readers := 2 * numCPU
+		if readers < minSSDReaders {
+			readers = minSSDReaders
+		}
+		if readers > maxSSDReaders {
+			readers = maxSSDReaders
+		}
+		return readers
+	}
+}
+
+// readerConcurrency returns the number of parallel file readers suited for the slowest of the given storage types.
+func readerConcurrency(types []storageType, numCPU int) int {
+	readers := storageSSD.readers(numCPU)
+	for _, t := range types {
+		if r := t.readers(numCPU); r < readers {
+			readers = r
+		}
+	}
+	return readers
+}
+
+// detectStorageTypes returns the storage type of the volume of every include list path.
+func detectStorageTypes(indicatorByPath map[string]string) map[string]storageType {
+	types := map[string]storageType{}
+	for pth := range indicatorByPath {
+		abs, err := pathutil.AbsPath(pth)
+		if err != nil {
+			continue
+		}
+		dir := existingParent(globFreePrefix(abs))
+		if _, ok := types[dir]; !ok && dir != "" {
+			types[dir] = probeStorageType(dir)
+		}
+	}
+	return types
+}
+
+// globFreePrefix returns the path up to its first element containing a glob pattern.
+func globFreePrefix(pth string) string {
+	if i := strings.IndexAny(pth, "*?[{"); i >= 0 {
+		return filepath.Dir(pth[:i+1])
+	}
+	return pth
+}
+
+// existingParent returns the path or its closest existing parent.
+func existingParent(pth string) string {
+	for {
+		if _, err := os.Lstat(pth); err == nil {
+			return pth
+		}
+		parent := filepath.Dir(pth)
+		if parent == pth {
+			return ""
+		}
+		pth = parent
+	}
+}
+
+// logStorageTypes prints the detected storage types.
+func logStorageTypes(types map[string]storageType) {
+	dirs := make([]string, 0, len(types))
+	for dir := range types {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		log.Debugf("- %s: %s", dir, types[dir])
+	}
+}
@@ -0,0 +1,39 @@
+//go:build darwin
+// +build darwin
+
+package cachepush
+
+import (
+	"syscall"
+)
+
+// networkFilesystems are the names of the network and FUSE file systems.
+var networkFilesystems = map[string]bool{
+	"nfs":     true,
+	"smbfs":   true,
+	"afpfs":   true,
+	"webdav":  true,
+	"osxfuse": true,
+	"macfuse": true,
+}
+
+// probeStorageType returns the storage type of the volume containing the given path.
+// Local volumes are considered SSDs, as Macs are shipped with flash storage.
+func probeStorageType(pth string) storageType {
+	var fsStat syscall.Statfs_t
+	if err := syscall.Statfs(pth, &fsStat); err != nil {
+		return storageUnknown
+	}
+
+	var name []byte
+	for _, c := range fsStat.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	if networkFilesystems[string(name)] {
+		return storageNetwork
+	}
+	return storageSSD
+}
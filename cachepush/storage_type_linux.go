@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package cachepush
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// networkFilesystemMagics are the statfs f_type values of the network and FUSE file systems.
+var networkFilesystemMagics = map[int64]bool{
+	0x6969:     true, // NFS
+	0x517B:     true, // SMB
+	0xFE534D42: true, // SMB2
+	0xFF534D42: true, // CIFS
+	0x65735546: true, // FUSE (sshfs, s3fs, ...)
+	0x564C:     true, // NCP
+	0x47504653: true, // GPFS
+	0x0BD00BD0: true, // Lustre
+}
+
+// probeStorageType returns the storage type of the volume containing the given path.
+func probeStorageType(pth string) storageType {
+	var fsStat syscall.Statfs_t
+	if err := syscall.Statfs(pth, &fsStat); err != nil {
+		return storageUnknown
+	}
+	if networkFilesystemMagics[int64(fsStat.Type)] {
+		return storageNetwork
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(pth, &stat); err != nil {
+		return storageUnknown
+	}
+
+	// /sys/dev/block/<major>:<minor> points to the disk, or to a partition inside the disk's directory
+	dev := uint64(stat.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^uint64(0xfff)
+	minor := dev&0xff | (dev>>12)&^uint64(0xff)
+	blockDir := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+
+	for _, pth := range []string{filepath.Join(blockDir, "queue", "rotational"), filepath.Join(blockDir, "..", "queue", "rotational")} {
+		data, err := ioutil.ReadFile(pth)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == "1" {
+			return storageRotational
+		}
+		return storageSSD
+	}
+	return storageUnknown
+}
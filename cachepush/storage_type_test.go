@@ -0,0 +1,43 @@
+package cachepush
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readerConcurrency(t *testing.T) {
+	tests := []struct {
+		name   string
+		types  []storageType
+		numCPU int
+		want   int
+	}{
+		{name: "no paths", types: nil, numCPU: 4, want: 8},
+		{name: "ssd", types: []storageType{storageSSD}, numCPU: 1, want: minSSDReaders},
+		{name: "many CPUs", types: []storageType{storageUnknown}, numCPU: 64, want: maxSSDReaders},
+		{name: "slowest wins", types: []storageType{storageSSD, storageNetwork, storageRotational}, numCPU: 8, want: rotationalReaders},
+		{name: "network", types: []storageType{storageSSD, storageNetwork}, numCPU: 8, want: networkReaders},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, readerConcurrency(tt.types, tt.numCPU))
+		})
+	}
+}
+
+func Test_globFreePrefix(t *testing.T) {
+	require.Equal(t, "/src/node_modules", globFreePrefix("/src/node_modules"))
+	require.Equal(t, "/src", globFreePrefix("/src/**/build"))
+	require.Equal(t, "/src/pods", globFreePrefix("/src/pods/*.lock"))
+}
+
+func Test_existingParent(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	require.Equal(t, tmpDir, existingParent(filepath.Join(tmpDir, "not", "existing")))
+	require.Equal(t, tmpDir, existingParent(tmpDir))
+}
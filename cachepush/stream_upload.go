@@ -0,0 +1,177 @@
+// Streaming upload related functions.
+//
+// In streaming mode the tar writer is piped straight into a chunked HTTP PUT request,
+// so the archive is never written to the disk: no free disk space is needed for it and the files are read only once.
+// As the stream can not be rewound, a failed upload is not retried, the archive is regenerated for the next endpoint instead.
+//
+// Presigned storage urls (like the S3 ones) reject the chunked requests of unknown length, so the archive is only streamed
+// to a cache API which asks for it: the upload url request is marked as streamed (its size is the uncompressed content size,
+// an upper bound of the archive size), and the API has to answer with a stream_upload_url accepting chunked uploads.
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+)
+
+// errStreamingNotSupported is returned if the cache API does not accept streamed uploads.
+var errStreamingNotSupported = errors.New("the cache API does not accept streamed uploads (no stream_upload_url received), disable stream_upload")
+
+// streamArchiveWithFailover streams the archive to the first healthy endpoint.
+// The estimated size is sent to the cache API instead of the (unknown) final archive size.
+func streamArchiveWithFailover(ctx context.Context, endpoints []string, buildSlug, layerID string, info model.ArchiveInfo, estimatedSize int64, compress bool, budget memoryBudget, content archiveContent, retry uploadRetry) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no cache API url provided")
+	}
+
+	var errs []string
+	for i, endpoint := range endpoints {
+		if i > 0 {
+			log.Warnf("Failing over to cache API endpoint %d/%d: %s", i+1, len(endpoints), redactURL(endpoint))
+		}
+
+		err := streamArchive(ctx, endpoint, buildSlug, layerID, info, estimatedSize, retry, func(w io.WriteCloser) error {
+			output, err := encryptOutput(w, content.encryptionKey)
+			if err != nil {
+				return err
+			}
+			archive, err := newArchive(output, compress, budget)
+			if err != nil {
+				return err
+			}
+			return writeArchive(ctx, archive, content)
+		})
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		log.Warnf("Streaming to cache API endpoint (%s) failed: %s", redactURL(endpoint), err)
+		errs = append(errs, fmt.Sprintf("%s: %s", redactURL(endpoint), err))
+	}
+
+	return fmt.Errorf("upload failed to every cache API endpoint:\n%s", strings.Join(errs, "\n"))
+}
+
+// streamArchive uploads the archive written by the given function to the destination.
+// The function has to close the writer once the archive is written.
+// Only the upload url request is retried based on the retry policy, the stream itself is not.
+func streamArchive(ctx context.Context, url, buildSlug, layerID string, info model.ArchiveInfo, estimatedSize int64, retry uploadRetry, write func(io.WriteCloser) error) error {
+	if isLocalDirectory(url) {
+		return writeLocalArchive(url, buildSlug, layerID, info, envOf(ctx).localCacheRetention, write)
+	}
+
+	if strings.HasPrefix(url, "file://") {
+		dst := fileDestination(url, layerID)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		file, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		if err := write(file); err != nil {
+			if cerr := file.Close(); cerr != nil {
+				log.Debugf("Failed to close archive file (%s): %s", dst, cerr)
+			}
+			return err
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(url, s3Scheme) {
+		return fmt.Errorf("streaming is not supported for s3 destinations")
+	}
+	if strings.HasPrefix(url, sshScheme) {
+		return fmt.Errorf("streaming is not supported for ssh destinations")
+	}
+
+	uploadURL, err := getCacheStreamURL(ctx, url, estimatedSize, layerID, &info, retry)
+	if err != nil {
+		return fmt.Errorf("failed to generate upload url: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	writeErr := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+		}
+		writeErr <- err
+	}()
+
+	uploadErr := putStream(ctx, uploadURL, pr)
+	// unblock the writer if the request ended before consuming the whole stream
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	// a failed upload closes the pipe, so the writer's error is only the consequence of it
+	writerErr := <-writeErr
+	if uploadErr != nil {
+		return uploadErr
+	}
+	if writerErr != nil {
+		return fmt.Errorf("failed to generate archive: %w", writerErr)
+	}
+	return nil
+}
+
+// getCacheStreamURL requests an url accepting the streamed archive from the cache API, see getCacheUploadTarget.
+// The estimated size is an upper bound of the archive size, the request is marked as streamed, so the API knows it is not exact.
+func getCacheStreamURL(ctx context.Context, cacheAPIURL string, estimatedSize int64, layerID string, info *model.ArchiveInfo, retry uploadRetry) (string, error) {
+	reqBody := uploadURLRequestBody(estimatedSize, layerID, info)
+	reqBody["streamed"] = true
+
+	target, err := requestCacheUploadTarget(ctx, cacheAPIURL, reqBody, retry)
+	if err != nil {
+		return "", err
+	}
+	if target.streamURL == "" {
+		return "", errStreamingNotSupported
+	}
+	return target.streamURL, nil
+}
+
+// putStream uploads the stream with a chunked PUT request.
+func putStream(ctx context.Context, uploadURL string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %s", err)
+	}
+	// unknown length, the body is sent with chunked transfer encoding
+	req.ContentLength = -1
+
+	resp, err := newHTTPClient(ctx, 0).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiagnosticsResponseBody))
+	if err != nil {
+		log.Debugf("Failed to read upload response body: %s", err)
+	}
+	diag.addResponse(resp, respBody)
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("upload failed with status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cachepush
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-cache-push/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_streamArchiveWithFailover(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	fileToArchive := filepath.Join(tmpDir, "file")
+	createDirStruct(t, map[string]string{fileToArchive: "content"})
+
+	content := archiveContent{
+		stackData:           []byte("{}"),
+		pathToIndicatorPath: map[string]string{fileToArchive: fileToArchive},
+		descriptor:          map[string]string{fileToArchive: "indicator"},
+	}
+
+	var names []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var reqBody map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&reqBody))
+			require.Equal(t, true, reqBody["streamed"])
+
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/presigned", "stream_upload_url": "` + server.URL + `/upload"}`))
+			require.NoError(t, err)
+			return
+		}
+
+		require.Equal(t, "/upload", r.URL.Path)
+		require.Equal(t, []string{"chunked"}, r.TransferEncoding)
+		reader := tar.NewReader(r.Body)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			names = append(names, header.Name)
+		}
+	}))
+	defer server.Close()
+
+	require.NoError(t, streamArchiveWithFailover(context.Background(), []string{server.URL}, "", "", model.ArchiveInfo{}, 7, false, memoryBudget{}, content, uploadRetry{}))
+	require.Equal(t, []string{workPath(stackVersionsFileName), fileToArchive, workPath(fileChecksumsFileName), workPath(cacheInfoFileName), workPath(metadataChecksumsFileName)}, names)
+
+	dst := filepath.Join(tmpDir, "local", "cache.tar")
+	require.NoError(t, streamArchiveWithFailover(context.Background(), []string{"file://" + dst}, "", "", model.ArchiveInfo{}, 7, false, memoryBudget{}, content, uploadRetry{}))
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.NotEmpty(t, data)
+
+	url := "file://" + filepath.Join(tmpDir, "local") + "/"
+	require.NoError(t, streamArchiveWithFailover(context.Background(), []string{url}, "build", "", model.ArchiveInfo{}, 7, false, memoryBudget{}, content, uploadRetry{}))
+	latest, err := ioutil.ReadFile(filepath.Join(tmpDir, "local", "cache", "latest.tar"))
+	require.NoError(t, err)
+	require.Equal(t, data, latest)
+
+	content.pathToIndicatorPath = map[string]string{filepath.Join(tmpDir, "missing"): ""}
+	err = streamArchiveWithFailover(context.Background(), []string{server.URL}, "", "", model.ArchiveInfo{}, 7, false, memoryBudget{}, content, uploadRetry{})
+	require.Error(t, err)
+}
+
+func Test_streamArchive_guards(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/presigned-only":
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/presigned"}`))
+			require.NoError(t, err)
+		case "/streaming":
+			_, err := w.Write([]byte(`{"upload_url": "` + server.URL + `/presigned", "stream_upload_url": "` + server.URL + `/rejected"}`))
+			require.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	write := func(w io.WriteCloser) error {
+		if _, err := w.Write([]byte("archive")); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	t.Log("the cache API does not accept streamed uploads")
+	{
+		err := streamArchive(context.Background(), server.URL+"/presigned-only", "", "", model.ArchiveInfo{}, 7, uploadRetry{}, write)
+		require.True(t, errors.Is(err, errStreamingNotSupported))
+	}
+
+	t.Log("the upload error is returned instead of the writer's closed pipe error")
+	{
+		err := streamArchive(context.Background(), server.URL+"/streaming", "", "", model.ArchiveInfo{}, 7, uploadRetry{}, write)
+		require.EqualError(t, err, "upload failed with status code: 501")
+	}
+}
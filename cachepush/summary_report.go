@@ -0,0 +1,175 @@
+// Summary report related functions.
+//
+// The summary report is a machine-readable json file describing the cache push
+// (the cached paths, the changes, the archive size and the phase durations), for build analytics.
+package cachepush
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// pathSummary describes the cached files under a cache path.
+type pathSummary struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// changeSummary is the number of changed files since the previous cache.
+type changeSummary struct {
+	Changed int `json:"changed"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// cacheSummary describes the push of a cache (or a cache group).
+type cacheSummary struct {
+	Group       string          `json:"group,omitempty"`
+	Pushed      bool            `json:"pushed"`
+	SkipReason  string          `json:"skip_reason,omitempty"`
+	UploadError string          `json:"upload_error,omitempty"`
+	Secrets     []secretFinding `json:"secrets,omitempty"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	Paths       []pathSummary   `json:"paths"`
+	Changes     *changeSummary  `json:"changes,omitempty"`
+	ContentSize int64           `json:"content_size"`
+	ArchiveSize int64           `json:"archive_size"`
+	// CompressionRatio is the content size divided by the archive size, 0 if no archive was generated.
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+	// PhaseDurations and Duration are in seconds.
+	PhaseDurations map[string]float64 `json:"phase_durations"`
+	Duration       float64            `json:"duration"`
+}
+
+// summaryReport is the content of the summary report file.
+type summaryReport struct {
+	Caches []cacheSummary `json:"caches"`
+}
+
+// pathSummaries returns the number and the total size of the regular files under every cache root.
+// Files outside of the roots are summarized under their own path.
+func pathSummaries(pathToIndicatorPath map[string]string, roots []string) ([]pathSummary, error) {
+	summaryByPath := map[string]*pathSummary{}
+	for pth := range pathToIndicatorPath {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		root := rootOf(pth, roots)
+		if root == "" {
+			root = pth
+		}
+		summary, ok := summaryByPath[root]
+		if !ok {
+			summary = &pathSummary{Path: root}
+			summaryByPath[root] = summary
+		}
+		summary.Files++
+		summary.Size += info.Size()
+	}
+
+	summaries := make([]pathSummary, 0, len(summaryByPath))
+	for _, summary := range summaryByPath {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Path < summaries[j].Path })
+	return summaries, nil
+}
+
+// formatSize returns the size in a human readable form.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// logPathSummaries prints the file count, the total size and the share of the content of every cache path,
+// the largest first, so a suddenly grown archive can be traced back to the path causing it.
+func logPathSummaries(summaries []pathSummary) {
+	sorted := append([]pathSummary{}, summaries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Size > sorted[j].Size })
+
+	var total int64
+	for _, summary := range sorted {
+		total += summary.Size
+	}
+
+	log.Printf("Cache content by path (%s in total):", formatSize(total))
+	log.Printf("%10s %10s %7s  %s", "SIZE", "FILES", "SHARE", "PATH")
+	for _, summary := range sorted {
+		share := 0.0
+		if total > 0 {
+			share = float64(summary.Size) / float64(total) * 100
+		}
+		log.Printf("%10s %10d %6.1f%%  %s", formatSize(summary.Size), summary.Files, share, summary.Path)
+	}
+}
+
+// newCacheSummary returns the summary of the cache group's report.
+func newCacheSummary(group string, report Report) cacheSummary {
+	summary := cacheSummary{
+		Group:          group,
+		Pushed:         report.Pushed,
+		SkipReason:     report.SkipReason,
+		UploadError:    report.UploadError,
+		Secrets:        report.SecretFindings,
+		Fingerprint:    report.Fingerprint,
+		Paths:          report.Paths,
+		Changes:        report.Changes,
+		ContentSize:    report.ContentSize,
+		ArchiveSize:    report.ArchiveSize,
+		PhaseDurations: map[string]float64{},
+		Duration:       report.Duration.Seconds(),
+	}
+	if summary.Paths == nil {
+		summary.Paths = []pathSummary{}
+	}
+	if report.ContentSize > 0 && report.ArchiveSize > 0 {
+		summary.CompressionRatio = float64(report.ContentSize) / float64(report.ArchiveSize)
+	}
+	for phase, d := range report.Phases {
+		summary.PhaseDurations[phase] = d.Round(time.Millisecond).Seconds()
+	}
+	return summary
+}
+
+// writeSummaryReport writes the summary of the reports (by cache group) into a json file.
+func writeSummaryReport(pth string, reports map[string]Report) error {
+	groups := make([]string, 0, len(reports))
+	for group := range reports {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	report := summaryReport{Caches: []cacheSummary{}}
+	for _, group := range groups {
+		report.Caches = append(report.Caches, newCacheSummary(group, reports[group]))
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary report: %s", err)
+	}
+	if err := ioutil.WriteFile(pth, data, 0600); err != nil {
+		return fmt.Errorf("failed to write summary report: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package cachepush
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pathSummaries(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	gradle := filepath.Join(tmpDir, "gradle")
+	pods := filepath.Join(tmpDir, "pods")
+	other := filepath.Join(tmpDir, "other")
+	createDirStruct(t, map[string]string{
+		filepath.Join(gradle, "a"):        "aa",
+		filepath.Join(gradle, "sub", "b"): "bbb",
+		filepath.Join(pods, "c"):          "c",
+		other:                             "other",
+	})
+
+	summaries, err := pathSummaries(map[string]string{
+		gradle:                            "",
+		filepath.Join(gradle, "a"):        "",
+		filepath.Join(gradle, "sub", "b"): "",
+		filepath.Join(pods, "c"):          "",
+		other:                             "",
+	}, []string{gradle, pods})
+	require.NoError(t, err)
+	require.Equal(t, []pathSummary{
+		{Path: gradle, Files: 2, Size: 5},
+		{Path: other, Files: 1, Size: 5},
+		{Path: pods, Files: 1, Size: 1},
+	}, summaries)
+}
+
+func Test_newCacheSummary(t *testing.T) {
+	summary := newCacheSummary("gradle", Report{
+		Pushed:      true,
+		ArchiveSize: 50,
+		ContentSize: 100,
+		Duration:    1500 * time.Millisecond,
+		Changes:     &changeSummary{Changed: 1},
+		Phases:      map[string]time.Duration{"upload_archive": 250 * time.Millisecond},
+	})
+	require.Equal(t, cacheSummary{
+		Group:            "gradle",
+		Pushed:           true,
+		Paths:            []pathSummary{},
+		Changes:          &changeSummary{Changed: 1},
+		ContentSize:      100,
+		ArchiveSize:      50,
+		CompressionRatio: 2,
+		PhaseDurations:   map[string]float64{"upload_archive": 0.25},
+		Duration:         1.5,
+	}, summary)
+
+	require.Zero(t, newCacheSummary("", Report{ContentSize: 100}).CompressionRatio)
+}
+
+func Test_writeSummaryReport(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "summary_report")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+
+	pth := filepath.Join(tmpDir, "report.json")
+	require.NoError(t, writeSummaryReport(pth, map[string]Report{
+		"pods": {SkipReason: "no changes"},
+		"":     {Pushed: true},
+	}))
+
+	data, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+
+	var report summaryReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, 2, len(report.Caches))
+	require.Equal(t, "", report.Caches[0].Group)
+	require.True(t, report.Caches[0].Pushed)
+	require.Equal(t, "pods", report.Caches[1].Group)
+	require.Equal(t, "no changes", report.Caches[1].SkipReason)
+}
+
+func Test_formatSize(t *testing.T) {
+	for size, want := range map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1024:                   "1.0 KB",
+		1536:                   "1.5 KB",
+		2 * 1024 * 1024 * 1024: "2.0 GB",
+		9 << 40:                "9.0 TB",
+		2048 << 40:             "2048.0 TB",
+	} {
+		require.Equal(t, want, formatSize(size), "size: %d", size)
+	}
+}
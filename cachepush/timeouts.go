@@ -0,0 +1,34 @@
+// Timeout related functions.
+//
+// The whole step and its long running phases (archive generation and upload) can be limited,
+// so a wedged phase fails the step with a clear error instead of consuming the whole build timeout.
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// secondsToDuration converts a timeout input (in seconds) to a duration, 0 means no timeout.
+func secondsToDuration(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// withTimeout returns a context which is cancelled after the timeout, it is never timed out if the timeout is 0.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError returns a descriptive error if the phase failed because its own timeout expired
+// (and not the timeout of the parent context), otherwise the error itself.
+func timeoutError(parent, ctx context.Context, err error, phase string, timeout time.Duration) error {
+	if err == nil || timeout <= 0 || parent.Err() != nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%s timed out after %s: %w", phase, timeout, err)
+}
@@ -0,0 +1,78 @@
+package cachepush
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), 0)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	require.False(t, ok)
+
+	ctx, cancel = withTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	require.True(t, errors.Is(ctx.Err(), context.DeadlineExceeded))
+}
+
+func Test_timeoutError(t *testing.T) {
+	err := errors.New("request failed")
+
+	parent := context.Background()
+	ctx, cancel := context.WithTimeout(parent, time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	got := timeoutError(parent, ctx, err, "upload", time.Minute)
+	require.EqualError(t, got, "upload timed out after 1m0s: request failed")
+	require.True(t, errors.Is(got, err))
+
+	require.NoError(t, timeoutError(parent, ctx, nil, "upload", time.Minute))
+	require.Equal(t, err, timeoutError(parent, context.Background(), err, "upload", time.Minute))
+	require.Equal(t, err, timeoutError(parent, ctx, err, "upload", 0))
+
+	// the parent timed out, not the phase
+	cancelledParent, cancelParent := context.WithCancel(context.Background())
+	cancelParent()
+	require.Equal(t, err, timeoutError(cancelledParent, ctx, err, "upload", time.Minute))
+}
+
+func TestRun_uploadTimeout(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(tmpDir)) }()
+	defer func() { require.NoError(t, os.RemoveAll(workPath(cacheArchiveFileName))) }()
+
+	createDirStruct(t, map[string]string{filepath.Join(tmpDir, "file"): "content"})
+
+	// a wedged cache API, released when the test is done
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	_, err = Run(context.Background(), Config{
+		Paths:               tmpDir,
+		CacheAPIURL:         server.URL,
+		FingerprintMethodID: string(MD5),
+		CompressArchive:     "false",
+		QuotaPolicy:         string(QuotaPolicyNone),
+		UploadTimeout:       1,
+		FailOnUploadError:   true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "upload timed out after 1s")
+}
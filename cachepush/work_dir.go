@@ -0,0 +1,36 @@
+// Working directory related functions.
+//
+// The step's intermediate files (the cache archive, the cache descriptor and the other metadata files
+// stored in the archive) are placed into the working directory.
+// The metadata files are restored to the same paths by the cache-pull step, so the directory
+// should not change between builds.
+package cachepush
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultWorkDir returns the BITRISE_CACHE_DIR directory if set, the OS temp directory otherwise.
+func defaultWorkDir() string {
+	if dir := os.Getenv("BITRISE_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// resolveWorkDir returns the absolute path of the working directory and creates it, the default one is used if dir is empty.
+func resolveWorkDir(dir string) (string, error) {
+	if dir == "" {
+		dir = defaultWorkDir()
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand working directory (%s): %s", dir, err)
+	}
+	if err := os.MkdirAll(absDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create working directory (%s): %s", absDir, err)
+	}
+	return absDir, nil
+}
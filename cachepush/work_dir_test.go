@@ -0,0 +1,39 @@
+package cachepush
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_defaultWorkDir(t *testing.T) {
+	setenvForTest(t, "BITRISE_CACHE_DIR", "")
+	require.Equal(t, os.TempDir(), defaultWorkDir())
+
+	setenvForTest(t, "BITRISE_CACHE_DIR", "/bitrise/cache")
+	require.Equal(t, "/bitrise/cache", defaultWorkDir())
+}
+
+func Test_resolveWorkDir(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("cache")
+	require.NoError(t, err)
+
+	dir := filepath.Join(tmpDir, "work", "dir")
+	resolved, err := resolveWorkDir(dir)
+	require.NoError(t, err)
+	require.DirExists(t, dir)
+	require.Equal(t, dir, resolved)
+
+	setenvForTest(t, "BITRISE_CACHE_DIR", tmpDir)
+	resolved, err = resolveWorkDir("")
+	require.NoError(t, err)
+	require.Equal(t, tmpDir, resolved)
+}
+
+// workPath returns the path of the intermediate file in the default working directory, which the tests push from.
+func workPath(name string) string {
+	return defaultRunEnv.workPath(name)
+}
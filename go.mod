@@ -3,9 +3,11 @@ module github.com/bitrise-steplib/steps-cache-push
 go 1.16
 
 require (
+	github.com/aws/aws-sdk-go v1.44.332
 	github.com/bitrise-io/doublestar/v3 v3.0.1
 	github.com/bitrise-io/go-steputils v0.0.0-20210507072936-92fde382fb33
 	github.com/bitrise-io/go-utils v0.0.0-20210507100250-37de47dfa6ce
 	github.com/ryanuber/go-glob v1.0.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/text v0.4.0
 )
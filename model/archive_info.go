@@ -9,9 +9,44 @@ const (
 
 // ArchiveInfo ...
 type ArchiveInfo struct {
-	Version      uint64 `json:"version,omitempty"`
-	StackID      string `json:"stack_id,omitempty"`
-	Architecture string `json:"architecture,omitempty"`
+	// Version is the format version of the writer.
+	Version uint64 `json:"version,omitempty"`
+	// MinReaderVersion is the lowest format version able to restore the archive, 0 in archives written before it was recorded.
+	MinReaderVersion uint64 `json:"min_reader_version,omitempty"`
+	// Features are the format features a reader needs to support to restore the archive.
+	Features     []string `json:"features,omitempty"`
+	StackID      string   `json:"stack_id,omitempty"`
+	Architecture string   `json:"architecture,omitempty"`
+	// OS is the operating system (GOOS) of the machine generated the archive.
+	OS string `json:"os,omitempty"`
+	// Encryption describes how the archive is encrypted, nil if it is not encrypted.
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+	// Checksum is the hex encoded SHA-256 checksum of the archive.
+	// It is only known after the archive is written, so it is set in the archive info sent with the upload,
+	// but never in the archive info stored inside the archive.
+	Checksum string `json:"checksum,omitempty"`
+	// ContentMD5 is the base64 encoded MD5 digest of the archive, sent as the Content-MD5 header of the upload
+	// so storages enforcing it (like S3 presigned urls) reject a corrupted upload. It is only set in the archive info sent with the upload.
+	ContentMD5 string `json:"content_md5,omitempty"`
+	// CacheKey is the resolved cache key, empty if no cache key is configured.
+	CacheKey string `json:"cache_key,omitempty"`
+	// Group is the name of the cache group, empty for the default cache.
+	Group string `json:"group,omitempty"`
+	// Chunked reports whether the uploaded object is a chunk manifest instead of the archive itself,
+	// it is only set in the archive info sent with the upload.
+	Chunked bool `json:"chunked,omitempty"`
+}
+
+// EncryptionInfo describes the encryption of the cache archive.
+type EncryptionInfo struct {
+	// Algorithm is the encryption scheme of the archive stream.
+	Algorithm string `json:"algorithm"`
+	// ChunkSize is the size of the plaintext chunks encrypted separately.
+	ChunkSize int `json:"chunk_size"`
+	// KDF is the derivation of the per archive key from the key and the salt stored in the archive's plaintext header.
+	KDF string `json:"kdf"`
+	// KeyID identifies the key used for encryption (the hex encoded first 8 bytes of the key's sha256 hash).
+	KeyID string `json:"key_id"`
 }
 
 // String ...
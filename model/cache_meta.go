@@ -0,0 +1,17 @@
+package model
+
+// CacheMeta stores the metadata of the cached files by path.
+type CacheMeta map[string]CacheMetaEntry
+
+// CacheMetaEntry describes the history of a cached file.
+type CacheMetaEntry struct {
+	// FirstSeenBuild is the slug of the build which added the file to the cache.
+	FirstSeenBuild string `json:"first_seen_build,omitempty"`
+	// FirstSeenAt is the Unix timestamp of the push which added the file to the cache.
+	FirstSeenAt int64 `json:"first_seen_at"`
+	// AccessTime is the Unix timestamp of the last time the file was read after it was written, 0 if never.
+	AccessTime int64 `json:"access_time,omitempty"`
+	// ConsecutiveFailures is the number of consecutive builds which failed to read the path, 0 if it was read successfully.
+	// Paths failing to be read are not cached, their entry only records the failure count.
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+}
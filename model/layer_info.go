@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// LayerInfo describes the layers of a layered cache.
+// The first layer is always a full (base) archive, every following layer is a delta
+// which needs to be applied on top of the previous layers in order.
+type LayerInfo struct {
+	Layers []Layer `json:"layers"`
+}
+
+// Layer describes a single cache archive of a layered cache.
+type Layer struct {
+	ID        string    `json:"id"`
+	Base      bool      `json:"base,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ContentSize is the uncompressed size of the files stored in the layer.
+	ContentSize int64 `json:"content_size"`
+	// Removed lists the paths (tombstones) to delete when applying a delta layer.
+	Removed []string `json:"removed,omitempty"`
+	// Roots lists the cache roots replaced by the delta layer (root granularity):
+	// their previous content is deleted before applying the layer.
+	Roots []string `json:"roots,omitempty"`
+}
+
+// Deltas returns the delta layers.
+func (i LayerInfo) Deltas() []Layer {
+	if len(i.Layers) == 0 {
+		return nil
+	}
+	return i.Layers[1:]
+}